@@ -9,6 +9,7 @@ import (
 	"strings"
 
 	"github.com/ipfs/go-ipfs/core/commands/cmdenv"
+	"github.com/ipfs/go-ipfs/pinmeta"
 
 	"github.com/cheggaaa/pb"
 	cmds "github.com/ipfs/go-ipfs-cmds"
@@ -45,6 +46,7 @@ const (
 	hashOptionName        = "hash"
 	inlineOptionName      = "inline"
 	inlineLimitOptionName = "inline-limit"
+	namespaceOptionName   = "namespace"
 )
 
 const adderOutChanSize = 8
@@ -140,6 +142,7 @@ only-hash, and progress/status related flags) will change the final hash.
 		cmds.StringOption(hashOptionName, "Hash function to use. Implies CIDv1 if not sha2-256. (experimental)").WithDefault("sha2-256"),
 		cmds.BoolOption(inlineOptionName, "Inline small blocks into CIDs. (experimental)"),
 		cmds.IntOption(inlineLimitOptionName, "Maximum block size to inline. (experimental)").WithDefault(32),
+		cmds.StringOption(namespaceOptionName, "Namespace the pin is accounted to, see 'ipfs pin add --namespace'."),
 	},
 	PreRun: func(req *cmds.Request, env cmds.Environment) error {
 		quiet, _ := req.Options[quietOptionName].(bool)
@@ -180,6 +183,7 @@ only-hash, and progress/status related flags) will change the final hash.
 		hashFunStr, _ := req.Options[hashOptionName].(string)
 		inline, _ := req.Options[inlineOptionName].(bool)
 		inlineLimit, _ := req.Options[inlineLimitOptionName].(int)
+		namespace, _ := req.Options[namespaceOptionName].(string)
 
 		hashFunCode, ok := mh.Names[strings.ToLower(hashFunStr)]
 		if !ok {
@@ -191,6 +195,20 @@ only-hash, and progress/status related flags) will change the final hash.
 			return err
 		}
 
+		// Pins in a namespace record it, so that the quota of the
+		// namespace is checked.
+		add := api.Unixfs().Add
+		if namespace != "" {
+			if !dopin {
+				return fmt.Errorf("--%s can't be used with --%s=false", namespaceOptionName, pinOptionName)
+			}
+			md := pinmeta.Metadata{Labels: map[string]string{pinmeta.NamespaceLabel: namespace}}
+			add, err = getPinMetadataAdder(api, md)
+			if err != nil {
+				return err
+			}
+		}
+
 		toadd := req.Files
 		if wrap {
 			toadd = files.NewSliceDirectory([]files.DirEntry{
@@ -238,9 +256,8 @@ only-hash, and progress/status related flags) will change the final hash.
 			opts[len(opts)-1] = options.Unixfs.Events(events)
 
 			go func() {
-				var err error
 				defer close(events)
-				_, err = api.Unixfs().Add(req.Context, addit.Node(), opts...)
+				_, err := add(req.Context, addit.Node(), opts...)
 				errCh <- err
 			}()
 
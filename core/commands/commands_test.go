@@ -141,6 +141,8 @@ func TestCommands(t *testing.T) {
 		"/key/rename",
 		"/key/rm",
 		"/key/rotate",
+		"/linker",
+		"/linker/status",
 		"/log",
 		"/log/level",
 		"/log/ls",
@@ -179,7 +181,16 @@ func TestCommands(t *testing.T) {
 		"/pin/add",
 		"/ping",
 		"/pin/ls",
+		"/pin/remote",
+		"/pin/remote/add",
+		"/pin/remote/ls",
+		"/pin/remote/rm",
+		"/pin/remote/service",
+		"/pin/remote/service/add",
+		"/pin/remote/service/ls",
+		"/pin/remote/service/rm",
 		"/pin/rm",
+		"/pin/status",
 		"/pin/update",
 		"/pin/verify",
 		"/pubsub",
@@ -192,6 +203,7 @@ func TestCommands(t *testing.T) {
 		"/repo",
 		"/repo/fsck",
 		"/repo/gc",
+		"/repo/migrate",
 		"/repo/stat",
 		"/repo/verify",
 		"/repo/version",
@@ -211,6 +223,10 @@ func TestCommands(t *testing.T) {
 		"/swarm/filters",
 		"/swarm/filters/add",
 		"/swarm/filters/rm",
+		"/swarm/peering",
+		"/swarm/peering/add",
+		"/swarm/peering/ls",
+		"/swarm/peering/rm",
 		"/swarm/peers",
 		"/tar",
 		"/tar/add",
@@ -11,7 +11,9 @@ import (
 	"strings"
 
 	"github.com/ipfs/go-ipfs/core/commands/cmdenv"
+	"github.com/ipfs/go-ipfs/pinremote"
 	"github.com/ipfs/go-ipfs/repo"
+	"github.com/ipfs/go-ipfs/repo/common"
 	"github.com/ipfs/go-ipfs/repo/fsrepo"
 
 	"github.com/elgris/jsondiff"
@@ -174,6 +176,7 @@ NOTE: For security reasons, this command will omit your private key. If you woul
 		if err != nil {
 			return err
 		}
+		scrubRemotePinServiceKeys(cfg)
 
 		return cmds.EmitOnce(res, &cfg)
 	},
@@ -190,6 +193,76 @@ NOTE: For security reasons, this command will omit your private key. If you woul
 	},
 }
 
+// remotePinServiceKeyMask replaces the access tokens of the remote pinning
+// services in the config output.
+const remotePinServiceKeyMask = "***"
+
+// remotePinServiceAPIs returns the API sections of the remote pinning services
+// of cfg, by service name.
+func remotePinServiceAPIs(cfg map[string]interface{}) map[string]map[string]interface{} {
+	apis := make(map[string]map[string]interface{})
+	pinning, _ := cfg["Pinning"].(map[string]interface{})
+	services, _ := pinning["RemoteServices"].(map[string]interface{})
+	for name, s := range services {
+		service, _ := s.(map[string]interface{})
+		if api, ok := service["API"].(map[string]interface{}); ok {
+			apis[name] = api
+		}
+	}
+	return apis
+}
+
+// scrubRemotePinServiceKeys scrubs the access tokens of the remote pinning
+// services.
+func scrubRemotePinServiceKeys(cfg map[string]interface{}) {
+	for _, api := range remotePinServiceAPIs(cfg) {
+		if _, ok := api["Key"]; ok {
+			api["Key"] = remotePinServiceKeyMask
+		}
+	}
+}
+
+// scrubConfigValue scrubs the access tokens of the remote pinning services
+// from the value of the config key.
+func scrubConfigValue(key string, value interface{}) (interface{}, error) {
+	if key != "Pinning" && !strings.HasPrefix(key, "Pinning.") {
+		return value, nil
+	}
+	cfg := make(map[string]interface{})
+	if err := common.MapSetKV(cfg, key, value); err != nil {
+		return nil, err
+	}
+	scrubRemotePinServiceKeys(cfg)
+	return common.MapGetKV(cfg, key)
+}
+
+// restoreRemotePinServiceKeys puts back the stored access tokens of the
+// remote pinning services whose key is masked in cfg, like in the output of
+// 'ipfs config show'.
+func restoreRemotePinServiceKeys(r repo.Repo, cfg map[string]interface{}) error {
+	var stored map[string]map[string]interface{}
+	for name, api := range remotePinServiceAPIs(cfg) {
+		if api["Key"] != remotePinServiceKeyMask {
+			continue
+		}
+		if stored == nil {
+			services, err := r.GetConfigKey(pinremote.ConfigKey)
+			if err != nil {
+				return fmt.Errorf("no stored key for remote pinning service %q", name)
+			}
+			stored = remotePinServiceAPIs(map[string]interface{}{
+				"Pinning": map[string]interface{}{"RemoteServices": services},
+			})
+		}
+		key, ok := stored[name]["Key"]
+		if !ok {
+			return fmt.Errorf("no stored key for remote pinning service %q", name)
+		}
+		api["Key"] = key
+	}
+	return nil
+}
+
 func scrubValue(m map[string]interface{}, key []string) error {
 	find := func(m map[string]interface{}, k string) (string, interface{}, bool) {
 		lckey := strings.ToLower(k)
@@ -436,6 +509,10 @@ func getConfig(r repo.Repo, key string) (*ConfigField, error) {
 	if err != nil {
 		return nil, fmt.Errorf("failed to get config value: %q", err)
 	}
+	value, err = scrubConfigValue(key, value)
+	if err != nil {
+		return nil, err
+	}
 	return &ConfigField{
 		Key:   key,
 		Value: value,
@@ -462,14 +539,32 @@ func editConfig(filename string) error {
 }
 
 func replaceConfig(r repo.Repo, file io.Reader) error {
+	data, err := ioutil.ReadAll(file)
+	if err != nil {
+		return err
+	}
 	var cfg config.Config
-	if err := json.NewDecoder(file).Decode(&cfg); err != nil {
+	if err := json.Unmarshal(data, &cfg); err != nil {
 		return errors.New("failed to decode file as config")
 	}
 	if len(cfg.Identity.PrivKey) != 0 {
 		return errors.New("setting private key with API is not supported")
 	}
 
+	// The keys config.Config doesn't know about, like the Pinning section
+	// or the Gateway extensions, are set on their own, by writing the
+	// sections of the file as they are. Masked service keys keep their
+	// stored value.
+	var cfgMap map[string]interface{}
+	if err := json.Unmarshal(data, &cfgMap); err != nil {
+		return errors.New("failed to decode file as config")
+	}
+	if _, ok := cfgMap["Pinning"]; ok {
+		if err := restoreRemotePinServiceKeys(r, cfgMap); err != nil {
+			return err
+		}
+	}
+
 	keyF, err := getConfig(r, config.PrivKeySelector)
 	if err != nil {
 		return errors.New("failed to get PrivKey")
@@ -482,5 +577,16 @@ func replaceConfig(r repo.Repo, file io.Reader) error {
 
 	cfg.Identity.PrivKey = pkstr
 
-	return r.SetConfig(&cfg)
+	if err := r.SetConfig(&cfg); err != nil {
+		return err
+	}
+	for section, value := range cfgMap {
+		if section == "Identity" {
+			continue
+		}
+		if err := r.SetConfigKey(section, value); err != nil {
+			return err
+		}
+	}
+	return nil
 }
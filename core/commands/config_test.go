@@ -0,0 +1,85 @@
+package commands
+
+import (
+	"testing"
+
+	"github.com/ipfs/go-ipfs/pinremote"
+	"github.com/ipfs/go-ipfs/repo"
+	"github.com/ipfs/go-ipfs/repo/common"
+)
+
+func remotePinConfig(key string) map[string]interface{} {
+	return map[string]interface{}{
+		"Pinning": map[string]interface{}{
+			"RemoteServices": map[string]interface{}{
+				"mysrv": map[string]interface{}{
+					"API": map[string]interface{}{
+						"Endpoint": "https://pins.example.com",
+						"Key":      key,
+					},
+				},
+			},
+		},
+	}
+}
+
+func TestScrubConfigValue(t *testing.T) {
+	for _, key := range []string{
+		"Pinning",
+		"Pinning.RemoteServices",
+		"Pinning.RemoteServices.mysrv",
+		"Pinning.RemoteServices.mysrv.API",
+		"Pinning.RemoteServices.mysrv.API.Key",
+	} {
+		value, err := common.MapGetKV(remotePinConfig("secret"), key)
+		if err != nil {
+			t.Fatal(err)
+		}
+		scrubbed, err := scrubConfigValue(key, value)
+		if err != nil {
+			t.Fatal(err)
+		}
+		cfg := make(map[string]interface{})
+		if err := common.MapSetKV(cfg, key, scrubbed); err != nil {
+			t.Fatal(err)
+		}
+		if k, _ := common.MapGetKV(cfg, "Pinning.RemoteServices.mysrv.API.Key"); k != remotePinServiceKeyMask {
+			t.Fatalf("%s: key is not masked: %v", key, k)
+		}
+	}
+}
+
+// remotePinRepo holds a Pinning config section, which the config struct
+// of repo.Mock can't.
+type remotePinRepo struct {
+	repo.Mock
+	cfg map[string]interface{}
+}
+
+func (r *remotePinRepo) GetConfigKey(key string) (interface{}, error) {
+	return common.MapGetKV(r.cfg, key)
+}
+
+func TestRestoreRemotePinServiceKeys(t *testing.T) {
+	r := &remotePinRepo{cfg: remotePinConfig("secret")}
+
+	replaced := remotePinConfig(remotePinServiceKeyMask)
+	if err := restoreRemotePinServiceKeys(r, replaced); err != nil {
+		t.Fatal(err)
+	}
+	if k, _ := common.MapGetKV(replaced, pinremote.ConfigKey+".mysrv.API.Key"); k != "secret" {
+		t.Fatalf("masked key should be restored, got %v", k)
+	}
+
+	replaced = remotePinConfig("new secret")
+	if err := restoreRemotePinServiceKeys(r, replaced); err != nil {
+		t.Fatal(err)
+	}
+	if k, _ := common.MapGetKV(replaced, pinremote.ConfigKey+".mysrv.API.Key"); k != "new secret" {
+		t.Fatalf("new key should be kept, got %v", k)
+	}
+
+	if err := restoreRemotePinServiceKeys(&remotePinRepo{cfg: map[string]interface{}{}}, remotePinConfig(remotePinServiceKeyMask)); err == nil {
+		t.Fatal("masked key without a stored key should fail")
+	}
+}
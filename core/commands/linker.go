@@ -0,0 +1,80 @@
+package commands
+
+import (
+	"errors"
+	"fmt"
+	"io"
+	"sort"
+
+	cmdenv "github.com/ipfs/go-ipfs/core/commands/cmdenv"
+	"github.com/ipfs/go-ipfs/linker"
+
+	cmds "github.com/ipfs/go-ipfs-cmds"
+)
+
+// ErrLinkerNotRunning is returned by the linker commands when the linker
+// plugin isn't started on the node.
+var ErrLinkerNotRunning = errors.New("the linker is not running")
+
+var LinkerCmd = &cmds.Command{
+	Helptext: cmds.HelpText{
+		Tagline: "Inspect the linker pinning the hashes of link peers.",
+	},
+
+	Subcommands: map[string]*cmds.Command{
+		"status": linkerStatusCmd,
+	},
+}
+
+var linkerStatusCmd = &cmds.Command{
+	Helptext: cmds.HelpText{
+		Tagline: "Show the progress of the linker pin jobs.",
+		ShortDescription: `
+'ipfs linker status' shows the pin jobs of the linker with the number of
+nodes fetched so far, the hashes which were not admitted with the reason
+why, and the scores of the link peers.
+`,
+	},
+	NoLocal: true,
+	Run: func(req *cmds.Request, res cmds.ResponseEmitter, env cmds.Environment) error {
+		n, err := cmdenv.GetNode(env)
+		if err != nil {
+			return err
+		}
+		l := linker.Running(n)
+		if l == nil {
+			return ErrLinkerNotRunning
+		}
+		return cmds.EmitOnce(res, l.Status())
+	},
+	Type: linker.Status{},
+	Encoders: cmds.EncoderMap{
+		cmds.Text: cmds.MakeTypedEncoder(func(req *cmds.Request, w io.Writer, out *linker.Status) error {
+			fmt.Fprintf(w, "Pins: %d\n", out.Pins)
+			for _, job := range out.Jobs {
+				fmt.Fprintf(w, "%s %s (%d nodes fetched)", job.Cid, job.State, job.Fetched)
+				if job.Error != "" {
+					fmt.Fprintf(w, ": %s", job.Error)
+				}
+				fmt.Fprintln(w)
+			}
+			hashes := make([]string, 0, len(out.Rejected))
+			for hash := range out.Rejected {
+				hashes = append(hashes, hash)
+			}
+			sort.Strings(hashes)
+			for _, hash := range hashes {
+				fmt.Fprintf(w, "%s rejected: %s\n", hash, out.Rejected[hash])
+			}
+			peers := make([]string, 0, len(out.Scores))
+			for id := range out.Scores {
+				peers = append(peers, id)
+			}
+			sort.Strings(peers)
+			for _, id := range peers {
+				fmt.Fprintf(w, "%s score %d\n", id, out.Scores[id])
+			}
+			return nil
+		}),
+	},
+}
@@ -3,6 +3,7 @@ package commands
 import (
 	"context"
 	"encoding/json"
+	"errors"
 	"fmt"
 	"io"
 	"os"
@@ -13,6 +14,7 @@ import (
 	cidenc "github.com/ipfs/go-cidutil/cidenc"
 	cmds "github.com/ipfs/go-ipfs-cmds"
 	offline "github.com/ipfs/go-ipfs-exchange-offline"
+	files "github.com/ipfs/go-ipfs-files"
 	dag "github.com/ipfs/go-merkledag"
 	verifcid "github.com/ipfs/go-verifcid"
 	coreiface "github.com/ipfs/interface-go-ipfs-core"
@@ -22,6 +24,8 @@ import (
 	core "github.com/ipfs/go-ipfs/core"
 	cmdenv "github.com/ipfs/go-ipfs/core/commands/cmdenv"
 	e "github.com/ipfs/go-ipfs/core/commands/e"
+	pinmeta "github.com/ipfs/go-ipfs/pinmeta"
+	pinqueue "github.com/ipfs/go-ipfs/pinqueue"
 )
 
 var PinCmd = &cmds.Command{
@@ -35,6 +39,8 @@ var PinCmd = &cmds.Command{
 		"ls":     listPinCmd,
 		"verify": verifyPinCmd,
 		"update": updatePinCmd,
+		"status": statusPinCmd,
+		"remote": remotePinCmd,
 	},
 }
 
@@ -44,18 +50,79 @@ type PinOutput struct {
 
 type AddPinOutput struct {
 	Pins     []string
-	Progress int `json:",omitempty"`
+	Progress int  `json:",omitempty"`
+	Queued   bool `json:",omitempty"`
 }
 
 const (
-	pinRecursiveOptionName = "recursive"
-	pinProgressOptionName  = "progress"
+	pinRecursiveOptionName  = "recursive"
+	pinProgressOptionName   = "progress"
+	pinExpireInOptionName   = "expire-in"
+	pinNameOptionName       = "name"
+	pinLabelOptionName      = "label"
+	pinBackgroundOptionName = "background"
+	pinNamespaceOptionName  = "namespace"
 )
 
+// pinMetadataAPI is implemented by the PinAPI of go-ipfs nodes, which record
+// metadata about pins.
+type pinMetadataAPI interface {
+	AddWithMetadata(ctx context.Context, p path.Path, md pinmeta.Metadata, opts ...options.PinAddOption) error
+	Metadata(ctx context.Context, c cid.Cid) (pinmeta.Metadata, error)
+	ListMetadata(ctx context.Context, filter pinmeta.Filter) (map[cid.Cid]pinmeta.Metadata, error)
+}
+
+// pinMetadataAdder is implemented by the UnixfsAPI of go-ipfs nodes, which
+// record metadata about the pins of the content they add.
+type pinMetadataAdder interface {
+	AddWithPinMetadata(ctx context.Context, f files.Node, md pinmeta.Metadata, opts ...options.UnixfsAddOption) (path.Resolved, error)
+}
+
+// getPinMetadataAdder returns the function adding content like
+// UnixfsAPI.Add which records md about the pins.
+func getPinMetadataAdder(api coreiface.CoreAPI, md pinmeta.Metadata) (func(context.Context, files.Node, ...options.UnixfsAddOption) (path.Resolved, error), error) {
+	madder, ok := api.Unixfs().(pinMetadataAdder)
+	if !ok {
+		return nil, errors.New("this node does not record pin metadata")
+	}
+	return func(ctx context.Context, f files.Node, opts ...options.UnixfsAddOption) (path.Resolved, error) {
+		return madder.AddWithPinMetadata(ctx, f, md, opts...)
+	}, nil
+}
+
+func getPinMetadataAPI(api coreiface.CoreAPI) (pinMetadataAPI, error) {
+	mapi, ok := api.Pin().(pinMetadataAPI)
+	if !ok {
+		return nil, errors.New("this node does not record pin metadata")
+	}
+	return mapi, nil
+}
+
 var addPinCmd = &cmds.Command{
 	Helptext: cmds.HelpText{
 		Tagline:          "Pin objects to local storage.",
 		ShortDescription: "Stores an IPFS object(s) from a given path locally to disk.",
+		LongDescription: `
+Stores an IPFS object(s) from a given path locally to disk.
+
+Pins can be given a name with --name and key/value labels with --label, e.g.
+--label team=web, which 'ipfs pin ls' shows and filters on. Pinning again
+replaces the name and adds the labels to the existing ones.
+
+With --namespace, the pins are accounted to a namespace, which is a shorthand
+for --label namespace=<name>. The DAG bytes pinned in a namespace are limited
+by its quota in Pinning.Namespaces, pins which don't fit fail with a quota
+exceeded error. 'ipfs repo stat --namespace' reports the usage.
+
+With --expire-in, the pins are removed once the given duration elapsed, e.g.
+--expire-in=72h. Expiring pins never shorten existing ones: objects which are
+already pinned without expiry stay pinned, and the later expiry is kept
+otherwise. Pinning without --expire-in makes an expiring pin permanent.
+
+With --background, the objects are queued to be fetched and pinned by the
+node, and the command returns right away. The queue is persisted and resumed
+when the daemon restarts; 'ipfs pin status' reports the progress.
+`,
 	},
 
 	Arguments: []cmds.Argument{
@@ -64,6 +131,11 @@ var addPinCmd = &cmds.Command{
 	Options: []cmds.Option{
 		cmds.BoolOption(pinRecursiveOptionName, "r", "Recursively pin the object linked to by the specified object(s).").WithDefault(true),
 		cmds.BoolOption(pinProgressOptionName, "Show progress"),
+		cmds.StringOption(pinExpireInOptionName, "Remove the pin once this duration elapsed, e.g. \"72h\"."),
+		cmds.StringOption(pinNameOptionName, "Name of the pin."),
+		cmds.StringsOption(pinLabelOptionName, "Label of the pin, as key=value. Can be given multiple times."),
+		cmds.StringOption(pinNamespaceOptionName, "Namespace the pin is accounted to."),
+		cmds.BoolOption(pinBackgroundOptionName, "Queue the pins and return immediately, see 'ipfs pin status'.").WithDefault(false),
 	},
 	Type: AddPinOutput{},
 	Run: func(req *cmds.Request, res cmds.ResponseEmitter, env cmds.Environment) error {
@@ -75,6 +147,15 @@ var addPinCmd = &cmds.Command{
 		// set recursive flag
 		recursive, _ := req.Options[pinRecursiveOptionName].(bool)
 		showProgress, _ := req.Options[pinProgressOptionName].(bool)
+		background, _ := req.Options[pinBackgroundOptionName].(bool)
+		if background && showProgress {
+			return fmt.Errorf("--%s and --%s can't be used together", pinBackgroundOptionName, pinProgressOptionName)
+		}
+
+		md, err := pinMetadataFromOptions(req)
+		if err != nil {
+			return err
+		}
 
 		if err := req.ParseBodyArgs(); err != nil {
 			return err
@@ -85,8 +166,27 @@ var addPinCmd = &cmds.Command{
 			return err
 		}
 
+		if background {
+			n, err := cmdenv.GetNode(env)
+			if err != nil {
+				return err
+			}
+			queued := make([]string, len(req.Arguments))
+			for i, p := range req.Arguments {
+				rp, err := api.ResolvePath(req.Context, path.New(p))
+				if err != nil {
+					return err
+				}
+				if _, err := n.PinQueue.Add(rp.Cid(), recursive, md); err != nil {
+					return err
+				}
+				queued[i] = enc.Encode(rp.Cid())
+			}
+			return cmds.EmitOnce(res, &AddPinOutput{Pins: queued, Queued: true})
+		}
+
 		if !showProgress {
-			added, err := pinAddMany(req.Context, api, enc, req.Arguments, recursive)
+			added, err := pinAddMany(req.Context, api, enc, req.Arguments, recursive, md)
 			if err != nil {
 				return err
 			}
@@ -104,7 +204,7 @@ var addPinCmd = &cmds.Command{
 
 		ch := make(chan pinResult, 1)
 		go func() {
-			added, err := pinAddMany(ctx, api, enc, req.Arguments, recursive)
+			added, err := pinAddMany(ctx, api, enc, req.Arguments, recursive, md)
 			ch <- pinResult{pins: added, err: err}
 		}()
 
@@ -145,7 +245,11 @@ var addPinCmd = &cmds.Command{
 			}
 
 			for _, k := range out.Pins {
-				fmt.Fprintf(w, "pinned %s %s\n", k, pintype)
+				if out.Queued {
+					fmt.Fprintf(w, "queued %s to be pinned %s\n", k, pintype)
+				} else {
+					fmt.Fprintf(w, "pinned %s %s\n", k, pintype)
+				}
 			}
 
 			return nil
@@ -180,7 +284,18 @@ var addPinCmd = &cmds.Command{
 	},
 }
 
-func pinAddMany(ctx context.Context, api coreiface.CoreAPI, enc cidenc.Encoder, paths []string, recursive bool) ([]string, error) {
+func pinAddMany(ctx context.Context, api coreiface.CoreAPI, enc cidenc.Encoder, paths []string, recursive bool, md pinmeta.Metadata) ([]string, error) {
+	add := api.Pin().Add
+	if !md.IsZero() {
+		mapi, err := getPinMetadataAPI(api)
+		if err != nil {
+			return nil, err
+		}
+		add = func(ctx context.Context, p path.Path, opts ...options.PinAddOption) error {
+			return mapi.AddWithMetadata(ctx, p, md, opts...)
+		}
+	}
+
 	added := make([]string, len(paths))
 	for i, b := range paths {
 		rp, err := api.ResolvePath(ctx, path.New(b))
@@ -188,7 +303,7 @@ func pinAddMany(ctx context.Context, api coreiface.CoreAPI, enc cidenc.Encoder,
 			return nil, err
 		}
 
-		if err := api.Pin().Add(ctx, rp, options.Pin.Recursive(recursive)); err != nil {
+		if err := add(ctx, rp, options.Pin.Recursive(recursive)); err != nil {
 			return nil, err
 		}
 		added[i] = enc.Encode(rp.Cid())
@@ -291,6 +406,9 @@ With arguments, the command fails if any of the arguments is not a pinned
 object. And if --type=<type> is additionally used, the command will also fail
 if any of the arguments is not of the specified type.
 
+Use --name=<name> and --label=<key>=<value> to list only the direct and
+recursive pins with the given name and labels, see 'ipfs pin add'.
+
 Example:
 	$ echo "hello" | ipfs add -q
 	QmZULkCELmmk5XNfCgTnCyFgAVxBRBXyDHGGMVoLFLiXEN
@@ -315,6 +433,8 @@ Example:
 		cmds.StringOption(pinTypeOptionName, "t", "The type of pinned keys to list. Can be \"direct\", \"indirect\", \"recursive\", or \"all\".").WithDefault("all"),
 		cmds.BoolOption(pinQuietOptionName, "q", "Write just hashes of objects."),
 		cmds.BoolOption(pinStreamOptionName, "s", "Enable streaming of pins as they are discovered."),
+		cmds.StringOption(pinNameOptionName, "List only the pins with this name."),
+		cmds.StringsOption(pinLabelOptionName, "List only the pins with this label, as key=value. Can be given multiple times."),
 	},
 	Run: func(req *cmds.Request, res cmds.ResponseEmitter, env cmds.Environment) error {
 		api, err := cmdenv.GetApi(env, req)
@@ -325,6 +445,14 @@ Example:
 		typeStr, _ := req.Options[pinTypeOptionName].(string)
 		stream, _ := req.Options[pinStreamOptionName].(bool)
 
+		var filter pinmeta.Filter
+		filter.Name, _ = req.Options[pinNameOptionName].(string)
+		labels, _ := req.Options[pinLabelOptionName].([]string)
+		filter.Labels, err = pinmeta.ParseLabels(labels)
+		if err != nil {
+			return err
+		}
+
 		switch typeStr {
 		case "all", "direct", "indirect", "recursive":
 		default:
@@ -338,15 +466,20 @@ Example:
 		if !stream {
 			emit = func(v interface{}) error {
 				obj := v.(*PinLsOutputWrapper)
-				lgcList[obj.PinLsObject.Cid] = PinLsType{Type: obj.PinLsObject.Type}
+				lgcList[obj.PinLsObject.Cid] = PinLsType{
+					Type:    obj.PinLsObject.Type,
+					Name:    obj.PinLsObject.Name,
+					Labels:  obj.PinLsObject.Labels,
+					Expires: obj.PinLsObject.Expires,
+				}
 				return nil
 			}
 		}
 
 		if len(req.Arguments) > 0 {
-			err = pinLsKeys(req, typeStr, api, emit)
+			err = pinLsKeys(req, typeStr, filter, api, emit)
 		} else {
-			err = pinLsAll(req, typeStr, api, emit)
+			err = pinLsAll(req, typeStr, filter, api, emit)
 		}
 		if err != nil {
 			return err
@@ -381,7 +514,7 @@ Example:
 				if quiet {
 					fmt.Fprintf(w, "%s\n", out.PinLsObject.Cid)
 				} else {
-					fmt.Fprintf(w, "%s %s\n", out.PinLsObject.Cid, out.PinLsObject.Type)
+					fmt.Fprintf(w, "%s %s%s\n", out.PinLsObject.Cid, out.PinLsObject.Type, formatPinMetadata(out.PinLsObject.Name, out.PinLsObject.Expires))
 				}
 				return nil
 			}
@@ -390,7 +523,7 @@ Example:
 				if quiet {
 					fmt.Fprintf(w, "%s\n", k)
 				} else {
-					fmt.Fprintf(w, "%s %s\n", k, v.Type)
+					fmt.Fprintf(w, "%s %s%s\n", k, v.Type, formatPinMetadata(v.Name, v.Expires))
 				}
 			}
 
@@ -414,16 +547,70 @@ type PinLsList struct {
 
 // PinLsType contains the type of a pin
 type PinLsType struct {
-	Type string
+	Type    string
+	Name    string            `json:",omitempty"`
+	Labels  map[string]string `json:",omitempty"`
+	Expires *time.Time        `json:",omitempty"`
 }
 
 // PinLsObject contains the description of a pin
 type PinLsObject struct {
-	Cid  string `json:",omitempty"`
-	Type string `json:",omitempty"`
+	Cid     string            `json:",omitempty"`
+	Type    string            `json:",omitempty"`
+	Name    string            `json:",omitempty"`
+	Labels  map[string]string `json:",omitempty"`
+	Expires *time.Time        `json:",omitempty"`
+}
+
+func formatPinMetadata(name string, expires *time.Time) string {
+	var s string
+	if name != "" {
+		s += " " + name
+	}
+	if expires != nil {
+		s += fmt.Sprintf(" (expires %s)", expires.Format(time.RFC3339))
+	}
+	return s
+}
+
+// pinMetadataFromOptions returns the metadata given to the pin add options.
+func pinMetadataFromOptions(req *cmds.Request) (pinmeta.Metadata, error) {
+	var md pinmeta.Metadata
+	md.Name, _ = req.Options[pinNameOptionName].(string)
+	labels, _ := req.Options[pinLabelOptionName].([]string)
+	var err error
+	md.Labels, err = pinmeta.ParseLabels(labels)
+	if err != nil {
+		return md, err
+	}
+	if ns, _ := req.Options[pinNamespaceOptionName].(string); ns != "" {
+		if md.Labels == nil {
+			md.Labels = make(map[string]string)
+		}
+		md.Labels[pinmeta.NamespaceLabel] = ns
+	}
+	if expireIn, ok := req.Options[pinExpireInOptionName].(string); ok {
+		d, err := time.ParseDuration(expireIn)
+		if err != nil {
+			return md, fmt.Errorf("invalid %s: %s", pinExpireInOptionName, err)
+		}
+		if d <= 0 {
+			return md, fmt.Errorf("%s must be positive", pinExpireInOptionName)
+		}
+		md.Expires = time.Now().Add(d).UTC().Truncate(time.Second)
+	}
+	return md, nil
+}
+
+// pinExpiry returns when the pin described by md expires, nil if it doesn't.
+func pinExpiry(md pinmeta.Metadata) *time.Time {
+	if md.Expires.IsZero() {
+		return nil
+	}
+	return &md.Expires
 }
 
-func pinLsKeys(req *cmds.Request, typeStr string, api coreiface.CoreAPI, emit func(value interface{}) error) error {
+func pinLsKeys(req *cmds.Request, typeStr string, filter pinmeta.Filter, api coreiface.CoreAPI, emit func(value interface{}) error) error {
 	enc, err := cmdenv.GetCidEncoder(req)
 	if err != nil {
 		return err
@@ -440,6 +627,12 @@ func pinLsKeys(req *cmds.Request, typeStr string, api coreiface.CoreAPI, emit fu
 		panic("unhandled pin type")
 	}
 
+	// Nodes which don't record metadata have no named or expiring pins.
+	mapi, err := getPinMetadataAPI(api)
+	if err != nil && !filter.IsZero() {
+		return err
+	}
+
 	for _, p := range req.Arguments {
 		rp, err := api.ResolvePath(req.Context, path.New(p))
 		if err != nil {
@@ -455,16 +648,30 @@ func pinLsKeys(req *cmds.Request, typeStr string, api coreiface.CoreAPI, emit fu
 			return fmt.Errorf("path '%s' is not pinned", p)
 		}
 
+		var md pinmeta.Metadata
 		switch pinType {
-		case "direct", "indirect", "recursive", "internal":
+		case "direct", "recursive":
+			if mapi != nil {
+				md, err = mapi.Metadata(req.Context, rp.Cid())
+				if err != nil {
+					return err
+				}
+			}
+		case "indirect", "internal":
 		default:
 			pinType = "indirect through " + pinType
 		}
+		if !filter.Matches(md) {
+			continue
+		}
 
 		err = emit(&PinLsOutputWrapper{
 			PinLsObject: PinLsObject{
-				Type: pinType,
-				Cid:  enc.Encode(rp.Cid()),
+				Type:    pinType,
+				Cid:     enc.Encode(rp.Cid()),
+				Name:    md.Name,
+				Labels:  md.Labels,
+				Expires: pinExpiry(md),
 			},
 		})
 		if err != nil {
@@ -475,7 +682,7 @@ func pinLsKeys(req *cmds.Request, typeStr string, api coreiface.CoreAPI, emit fu
 	return nil
 }
 
-func pinLsAll(req *cmds.Request, typeStr string, api coreiface.CoreAPI, emit func(value interface{}) error) error {
+func pinLsAll(req *cmds.Request, typeStr string, filter pinmeta.Filter, api coreiface.CoreAPI, emit func(value interface{}) error) error {
 	enc, err := cmdenv.GetCidEncoder(req)
 	if err != nil {
 		return err
@@ -493,6 +700,18 @@ func pinLsAll(req *cmds.Request, typeStr string, api coreiface.CoreAPI, emit fun
 		panic("unhandled pin type")
 	}
 
+	var metadata map[cid.Cid]pinmeta.Metadata
+	mapi, err := getPinMetadataAPI(api)
+	switch {
+	case err == nil:
+		metadata, err = mapi.ListMetadata(req.Context, filter)
+		if err != nil {
+			return err
+		}
+	case !filter.IsZero():
+		return err
+	}
+
 	pins, err := api.Pin().Ls(req.Context, opt)
 	if err != nil {
 		return err
@@ -502,10 +721,20 @@ func pinLsAll(req *cmds.Request, typeStr string, api coreiface.CoreAPI, emit fun
 		if p.Err() != nil {
 			return err
 		}
+		var md pinmeta.Metadata
+		if p.Type() != "indirect" {
+			md = metadata[p.Path().Cid()]
+		}
+		if !filter.Matches(md) {
+			continue
+		}
 		err = emit(&PinLsOutputWrapper{
 			PinLsObject: PinLsObject{
-				Type: p.Type(),
-				Cid:  enc.Encode(p.Path().Cid()),
+				Type:    p.Type(),
+				Cid:     enc.Encode(p.Path().Cid()),
+				Name:    md.Name,
+				Labels:  md.Labels,
+				Expires: pinExpiry(md),
 			},
 		})
 		if err != nil {
@@ -585,6 +814,98 @@ const (
 	pinVerboseOptionName = "verbose"
 )
 
+// PinStatusOutput is the status of a pin queued by 'ipfs pin add --background'.
+type PinStatusOutput struct {
+	Cid       string
+	State     string
+	Recursive bool
+	Fetched   int
+	Error     string `json:",omitempty"`
+	Queued    time.Time
+	Updated   time.Time
+}
+
+var statusPinCmd = &cmds.Command{
+	Helptext: cmds.HelpText{
+		Tagline: "Show the status of the pins queued in the background.",
+		ShortDescription: `
+Shows the status of the pins queued by 'ipfs pin add --background', which is
+one of:
+    * "queued": waiting to be processed.
+    * "fetching": the objects are being fetched and pinned.
+    * "pinned": the objects are pinned.
+    * "failed": the objects could not be pinned.
+
+Without arguments, the status of all the queued pins is shown. Pinned and
+failed pins are forgotten after a day.
+`,
+	},
+
+	Arguments: []cmds.Argument{
+		cmds.StringArg("ipfs-path", false, true, "Path to object(s) to show the pin status of."),
+	},
+	Type: PinStatusOutput{},
+	Run: func(req *cmds.Request, res cmds.ResponseEmitter, env cmds.Environment) error {
+		n, err := cmdenv.GetNode(env)
+		if err != nil {
+			return err
+		}
+		api, err := cmdenv.GetApi(env, req)
+		if err != nil {
+			return err
+		}
+		enc, err := cmdenv.GetCidEncoder(req)
+		if err != nil {
+			return err
+		}
+
+		var entries []pinqueue.Entry
+		if len(req.Arguments) == 0 {
+			entries, err = n.PinQueue.List()
+			if err != nil {
+				return err
+			}
+		}
+		for _, p := range req.Arguments {
+			rp, err := api.ResolvePath(req.Context, path.New(p))
+			if err != nil {
+				return err
+			}
+			entry, err := n.PinQueue.Status(rp.Cid())
+			if err != nil {
+				return fmt.Errorf("%s: %s", p, err)
+			}
+			entries = append(entries, entry)
+		}
+
+		for _, entry := range entries {
+			err := res.Emit(&PinStatusOutput{
+				Cid:       enc.Encode(entry.Cid),
+				State:     entry.State,
+				Recursive: entry.Recursive,
+				Fetched:   entry.Fetched,
+				Error:     entry.Error,
+				Queued:    entry.Queued,
+				Updated:   entry.Updated,
+			})
+			if err != nil {
+				return err
+			}
+		}
+		return nil
+	},
+	Encoders: cmds.EncoderMap{
+		cmds.Text: cmds.MakeTypedEncoder(func(req *cmds.Request, w io.Writer, out *PinStatusOutput) error {
+			fmt.Fprintf(w, "%s %s (%d nodes fetched)", out.Cid, out.State, out.Fetched)
+			if out.Error != "" {
+				fmt.Fprintf(w, ": %s", out.Error)
+			}
+			fmt.Fprintln(w)
+			return nil
+		}),
+	},
+}
+
 var verifyPinCmd = &cmds.Command{
 	Helptext: cmds.HelpText{
 		Tagline: "Verify that recursive pins are complete.",
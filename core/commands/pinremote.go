@@ -0,0 +1,554 @@
+package commands
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"net/url"
+	"sort"
+	"strings"
+	"time"
+
+	cmds "github.com/ipfs/go-ipfs-cmds"
+	"github.com/ipfs/interface-go-ipfs-core/path"
+	"github.com/libp2p/go-libp2p-core/peer"
+	ma "github.com/multiformats/go-multiaddr"
+
+	core "github.com/ipfs/go-ipfs/core"
+	cmdenv "github.com/ipfs/go-ipfs/core/commands/cmdenv"
+	"github.com/ipfs/go-ipfs/pinremote"
+	fsrepo "github.com/ipfs/go-ipfs/repo/fsrepo"
+)
+
+const (
+	pinServiceOptionName = "service"
+	pinCIDsOptionName    = "cid"
+	pinStatusOptionName  = "status"
+	pinForceOptionName   = "force"
+)
+
+// pinRemoteWaitInterval is how often 'ipfs pin remote add' checks whether the
+// service pinned the content.
+const pinRemoteWaitInterval = 2 * time.Second
+
+// pinRemoteConnectTimeout bounds the connection to the delegates of a service.
+const pinRemoteConnectTimeout = 10 * time.Second
+
+var remotePinCmd = &cmds.Command{
+	Helptext: cmds.HelpText{
+		Tagline: "Pin (and unpin) objects to remote pinning services.",
+		ShortDescription: `
+'ipfs pin remote' asks remote services implementing the IPFS Pinning Service
+API to pin content. Services are configured with 'ipfs pin remote service',
+which stores their endpoint and access token under the
+Pinning.RemoteServices config key.
+
+The node tracks the pin requests it made, and refreshes their status in the
+background while the daemon runs until they are pinned or failed.
+`,
+	},
+
+	Subcommands: map[string]*cmds.Command{
+		"add":     addRemotePinCmd,
+		"ls":      listRemotePinCmd,
+		"rm":      rmRemotePinCmd,
+		"service": remotePinServiceCmd,
+	},
+}
+
+// RemotePinOutput describes a pin request made to a remote service.
+type RemotePinOutput struct {
+	Service   string `json:",omitempty"`
+	RequestID string
+	Status    string
+	Cid       string
+	Name      string
+}
+
+func newRemotePinOutput(service string, ps pinremote.PinStatus) *RemotePinOutput {
+	return &RemotePinOutput{
+		Service:   service,
+		RequestID: ps.RequestID,
+		Status:    string(ps.Status),
+		Cid:       ps.Pin.Cid,
+		Name:      ps.Pin.Name,
+	}
+}
+
+func remotePinOutputEncoder(req *cmds.Request, w io.Writer, out *RemotePinOutput) error {
+	fmt.Fprintf(w, "%s\t%s\t%s\n", out.Cid, out.Status, out.Name)
+	return nil
+}
+
+var addRemotePinCmd = &cmds.Command{
+	Helptext: cmds.HelpText{
+		Tagline: "Pin object to remote pinning service.",
+		ShortDescription: `
+'ipfs pin remote add' asks the given service to pin an object, and waits until
+it is pinned unless --background is given. When the node is online, the
+service is told the addresses of the node to fetch the object from.
+`,
+	},
+
+	Arguments: []cmds.Argument{
+		cmds.StringArg("ipfs-path", true, false, "Path to object to be pinned."),
+	},
+	Options: []cmds.Option{
+		cmds.StringOption(pinServiceOptionName, "Name of the remote pinning service to use."),
+		cmds.StringOption(pinNameOptionName, "An optional name for the pin."),
+		cmds.BoolOption(pinBackgroundOptionName, "Add to the queue on the remote service and return immediately (does not wait for pinned status).").WithDefault(false),
+	},
+	Type: RemotePinOutput{},
+	Run: func(req *cmds.Request, res cmds.ResponseEmitter, env cmds.Environment) error {
+		n, err := cmdenv.GetNode(env)
+		if err != nil {
+			return err
+		}
+		api, err := cmdenv.GetApi(env, req)
+		if err != nil {
+			return err
+		}
+
+		serviceName, _ := req.Options[pinServiceOptionName].(string)
+		if serviceName == "" {
+			return fmt.Errorf("no remote service specified, use --%s", pinServiceOptionName)
+		}
+		service, err := pinremote.LoadService(n.Repo, serviceName)
+		if err != nil {
+			return err
+		}
+		name, _ := req.Options[pinNameOptionName].(string)
+		background, _ := req.Options[pinBackgroundOptionName].(bool)
+
+		rp, err := api.ResolvePath(req.Context, path.New(req.Arguments[0]))
+		if err != nil {
+			return err
+		}
+
+		client := service.Client()
+		ps, err := client.Add(req.Context, pinremote.Pin{
+			Cid:     rp.Cid().String(),
+			Name:    name,
+			Origins: nodeOrigins(n),
+		})
+		if err != nil {
+			return err
+		}
+		if err := n.RemotePins.Put(serviceName, ps); err != nil {
+			return err
+		}
+		connectDelegates(req.Context, n, ps.Delegates)
+
+		if !background {
+			ps, err = n.RemotePins.Wait(req.Context, client, serviceName, ps.RequestID, pinRemoteWaitInterval)
+			if err != nil {
+				return err
+			}
+			if ps.Status == pinremote.Failed {
+				return fmt.Errorf("remote service %s failed to pin %s", serviceName, ps.Pin.Cid)
+			}
+		}
+
+		return cmds.EmitOnce(res, newRemotePinOutput(serviceName, ps))
+	},
+	Encoders: cmds.EncoderMap{
+		cmds.Text: cmds.MakeTypedEncoder(remotePinOutputEncoder),
+	},
+}
+
+// nodeOrigins returns the addresses the services can fetch content from, none
+// when the node is offline.
+func nodeOrigins(n *core.IpfsNode) []string {
+	if !n.IsOnline {
+		return nil
+	}
+	addrs, err := peer.AddrInfoToP2pAddrs(&peer.AddrInfo{ID: n.Identity, Addrs: n.PeerHost.Addrs()})
+	if err != nil {
+		return nil
+	}
+	origins := make([]string, len(addrs))
+	for i, a := range addrs {
+		origins[i] = a.String()
+	}
+	return origins
+}
+
+// connectDelegates connects to the peers of a service which fetch the
+// content, so that they find it sooner.
+func connectDelegates(ctx context.Context, n *core.IpfsNode, delegates []string) {
+	if !n.IsOnline {
+		return
+	}
+	addrs := make([]ma.Multiaddr, 0, len(delegates))
+	for _, d := range delegates {
+		a, err := ma.NewMultiaddr(d)
+		if err != nil {
+			log.Debugf("ignoring invalid delegate %q: %s", d, err)
+			continue
+		}
+		addrs = append(addrs, a)
+	}
+	infos, err := peer.AddrInfosFromP2pAddrs(addrs...)
+	if err != nil {
+		log.Debugf("ignoring invalid delegates: %s", err)
+		return
+	}
+
+	ctx, cancel := context.WithTimeout(ctx, pinRemoteConnectTimeout)
+	defer cancel()
+	for _, ai := range infos {
+		if err := n.PeerHost.Connect(ctx, ai); err != nil {
+			log.Debugf("failed to connect to delegate %s: %s", ai.ID, err)
+		}
+	}
+}
+
+// remotePinListOptions returns the pin requests selected by the options of
+// the request.
+func remotePinListOptions(req *cmds.Request) (pinremote.ListOptions, error) {
+	var opts pinremote.ListOptions
+	opts.Name, _ = req.Options[pinNameOptionName].(string)
+	opts.Cids, _ = req.Options[pinCIDsOptionName].([]string)
+	statuses, _ := req.Options[pinStatusOptionName].([]string)
+	if len(statuses) == 0 {
+		statuses = []string{string(pinremote.Pinned)}
+	}
+	for _, s := range statuses {
+		status, err := pinremote.ParseStatus(s)
+		if err != nil {
+			return opts, err
+		}
+		opts.Status = append(opts.Status, status)
+	}
+	return opts, nil
+}
+
+// matchesRemotePin reports whether a tracked pin request is selected by opts,
+// like the service would.
+func matchesRemotePin(opts pinremote.ListOptions, r pinremote.Record) bool {
+	if opts.Name != "" && opts.Name != r.Pin.Name {
+		return false
+	}
+	if len(opts.Cids) > 0 && !containsString(opts.Cids, r.Pin.Cid) {
+		return false
+	}
+	for _, s := range opts.Status {
+		if s == r.Status {
+			return true
+		}
+	}
+	return false
+}
+
+func containsString(list []string, s string) bool {
+	for _, l := range list {
+		if l == s {
+			return true
+		}
+	}
+	return false
+}
+
+var listRemotePinCmd = &cmds.Command{
+	Helptext: cmds.HelpText{
+		Tagline: "List objects pinned to remote pinning service.",
+		ShortDescription: `
+Returns a list of the pin requests of the given remote service. Without
+--service, the pin requests made by the node to all the services are listed
+as last seen by the node.
+
+Only pinned objects are listed by default, use --status to list the pin
+requests with other statuses.
+`,
+	},
+
+	Options: []cmds.Option{
+		cmds.StringOption(pinServiceOptionName, "Name of the remote pinning service to use."),
+		cmds.StringOption(pinNameOptionName, "Return pins with names that match this name."),
+		cmds.StringsOption(pinCIDsOptionName, "Return pins for the specified CID. Can be given multiple times."),
+		cmds.StringsOption(pinStatusOptionName, "Return pins with the specified statuses (queued, pinning, pinned, failed). Can be given multiple times."),
+	},
+	Type: RemotePinOutput{},
+	Run: func(req *cmds.Request, res cmds.ResponseEmitter, env cmds.Environment) error {
+		n, err := cmdenv.GetNode(env)
+		if err != nil {
+			return err
+		}
+
+		opts, err := remotePinListOptions(req)
+		if err != nil {
+			return err
+		}
+
+		serviceName, _ := req.Options[pinServiceOptionName].(string)
+		if serviceName == "" {
+			records, err := n.RemotePins.List("")
+			if err != nil {
+				return err
+			}
+			for _, r := range records {
+				if !matchesRemotePin(opts, r) {
+					continue
+				}
+				if err := res.Emit(newRemotePinOutput(r.Service, r.PinStatus)); err != nil {
+					return err
+				}
+			}
+			return nil
+		}
+
+		service, err := pinremote.LoadService(n.Repo, serviceName)
+		if err != nil {
+			return err
+		}
+		pins, err := service.Client().List(req.Context, opts)
+		if err != nil {
+			return err
+		}
+		for _, ps := range pins {
+			if err := res.Emit(newRemotePinOutput(serviceName, ps)); err != nil {
+				return err
+			}
+		}
+		return nil
+	},
+	Encoders: cmds.EncoderMap{
+		cmds.Text: cmds.MakeTypedEncoder(remotePinOutputEncoder),
+	},
+}
+
+var rmRemotePinCmd = &cmds.Command{
+	Helptext: cmds.HelpText{
+		Tagline: "Remove pins from remote pinning service.",
+		ShortDescription: `
+Removes the pin requests of the given remote service which match the given
+name, CIDs and statuses. Only pinned objects are matched by default. Removing
+more than one pin request requires --force.
+`,
+	},
+
+	Options: []cmds.Option{
+		cmds.StringOption(pinServiceOptionName, "Name of the remote pinning service to use."),
+		cmds.StringOption(pinNameOptionName, "Remove pins with names that match this name."),
+		cmds.StringsOption(pinCIDsOptionName, "Remove pins for the specified CID. Can be given multiple times."),
+		cmds.StringsOption(pinStatusOptionName, "Remove pins with the specified statuses (queued, pinning, pinned, failed). Can be given multiple times."),
+		cmds.BoolOption(pinForceOptionName, "Allow removal of multiple pins matching the query without additional confirmation.").WithDefault(false),
+	},
+	Run: func(req *cmds.Request, res cmds.ResponseEmitter, env cmds.Environment) error {
+		n, err := cmdenv.GetNode(env)
+		if err != nil {
+			return err
+		}
+
+		serviceName, _ := req.Options[pinServiceOptionName].(string)
+		if serviceName == "" {
+			return fmt.Errorf("no remote service specified, use --%s", pinServiceOptionName)
+		}
+		service, err := pinremote.LoadService(n.Repo, serviceName)
+		if err != nil {
+			return err
+		}
+		opts, err := remotePinListOptions(req)
+		if err != nil {
+			return err
+		}
+		force, _ := req.Options[pinForceOptionName].(bool)
+
+		client := service.Client()
+		pins, err := client.List(req.Context, opts)
+		if err != nil {
+			return err
+		}
+		if len(pins) > 1 && !force {
+			return fmt.Errorf("multiple remote pins are matching this query, add --%s to confirm the bulk removal", pinForceOptionName)
+		}
+
+		for _, ps := range pins {
+			if err := client.Remove(req.Context, ps.RequestID); err != nil && !pinremote.IsNotFound(err) {
+				return err
+			}
+			if err := n.RemotePins.Delete(serviceName, ps.RequestID); err != nil {
+				return err
+			}
+		}
+		return nil
+	},
+}
+
+var remotePinServiceCmd = &cmds.Command{
+	Helptext: cmds.HelpText{
+		Tagline: "Configure remote pinning services.",
+	},
+
+	Subcommands: map[string]*cmds.Command{
+		"add": addRemotePinServiceCmd,
+		"ls":  lsRemotePinServiceCmd,
+		"rm":  rmRemotePinServiceCmd,
+	},
+}
+
+var addRemotePinServiceCmd = &cmds.Command{
+	Helptext: cmds.HelpText{
+		Tagline: "Add remote pinning service.",
+		ShortDescription: `
+Adds a service implementing the IPFS Pinning Service API, given its endpoint
+and the access token the node authenticates with. Both are stored in the
+Pinning.RemoteServices config key.
+`,
+	},
+
+	Arguments: []cmds.Argument{
+		cmds.StringArg("service", true, false, "Service name."),
+		cmds.StringArg("endpoint", true, false, "Service endpoint."),
+		cmds.StringArg("key", true, false, "Service key."),
+	},
+	Run: func(req *cmds.Request, res cmds.ResponseEmitter, env cmds.Environment) error {
+		name, endpoint, key := req.Arguments[0], req.Arguments[1], req.Arguments[2]
+		if name == "" || strings.ContainsAny(name, "./") {
+			return fmt.Errorf("invalid service name %q", name)
+		}
+		u, err := url.Parse(endpoint)
+		if err != nil || (u.Scheme != "http" && u.Scheme != "https") || u.Host == "" {
+			return fmt.Errorf("invalid service endpoint %q, expected an http(s) URL", endpoint)
+		}
+		if key == "" {
+			return errors.New("empty service key")
+		}
+
+		return updateRemotePinServices(env, func(services map[string]pinremote.Service) error {
+			if _, ok := services[name]; ok {
+				return fmt.Errorf("service already present: %q", name)
+			}
+			services[name] = pinremote.Service{
+				API: pinremote.ServiceAPI{Endpoint: endpoint, Key: key},
+			}
+			return nil
+		})
+	},
+}
+
+var rmRemotePinServiceCmd = &cmds.Command{
+	Helptext: cmds.HelpText{
+		Tagline: "Remove remote pinning service.",
+		ShortDescription: `
+Removes a remote pinning service from the config. The pins on the service are
+left untouched, but the node stops tracking the pin requests it made.
+`,
+	},
+
+	Arguments: []cmds.Argument{
+		cmds.StringArg("service", true, false, "Name of remote pinning service to remove."),
+	},
+	Run: func(req *cmds.Request, res cmds.ResponseEmitter, env cmds.Environment) error {
+		name := req.Arguments[0]
+		err := updateRemotePinServices(env, func(services map[string]pinremote.Service) error {
+			if _, ok := services[name]; !ok {
+				return fmt.Errorf("remote pinning service %q not found", name)
+			}
+			delete(services, name)
+			return nil
+		})
+		if err != nil {
+			return err
+		}
+		return forgetRemotePinService(env, name)
+	},
+}
+
+// RemotePinServiceDetails describes a remote pinning service.
+type RemotePinServiceDetails struct {
+	Service     string
+	ApiEndpoint string
+}
+
+// RemotePinServicesList is the list of the remote pinning services.
+type RemotePinServicesList struct {
+	RemoteServices []RemotePinServiceDetails
+}
+
+var lsRemotePinServiceCmd = &cmds.Command{
+	Helptext: cmds.HelpText{
+		Tagline: "List remote pinning services.",
+	},
+
+	Type: RemotePinServicesList{},
+	Run: func(req *cmds.Request, res cmds.ResponseEmitter, env cmds.Environment) error {
+		cfgRoot, err := cmdenv.GetConfigRoot(env)
+		if err != nil {
+			return err
+		}
+		r, err := fsrepo.Open(cfgRoot)
+		if err != nil {
+			return err
+		}
+		defer r.Close()
+
+		services, err := pinremote.LoadServices(r)
+		if err != nil {
+			return err
+		}
+		list := RemotePinServicesList{RemoteServices: make([]RemotePinServiceDetails, 0, len(services))}
+		for name, s := range services {
+			list.RemoteServices = append(list.RemoteServices, RemotePinServiceDetails{
+				Service:     name,
+				ApiEndpoint: s.API.Endpoint,
+			})
+		}
+		sort.Slice(list.RemoteServices, func(i, j int) bool {
+			return list.RemoteServices[i].Service < list.RemoteServices[j].Service
+		})
+		return cmds.EmitOnce(res, &list)
+	},
+	Encoders: cmds.EncoderMap{
+		cmds.Text: cmds.MakeTypedEncoder(func(req *cmds.Request, w io.Writer, list *RemotePinServicesList) error {
+			for _, s := range list.RemoteServices {
+				fmt.Fprintf(w, "%s\t%s\n", s.Service, s.ApiEndpoint)
+			}
+			return nil
+		}),
+	},
+}
+
+// updateRemotePinServices applies update to the configured remote pinning
+// services and saves them.
+func updateRemotePinServices(env cmds.Environment, update func(map[string]pinremote.Service) error) error {
+	cfgRoot, err := cmdenv.GetConfigRoot(env)
+	if err != nil {
+		return err
+	}
+	r, err := fsrepo.Open(cfgRoot)
+	if err != nil {
+		return err
+	}
+	defer r.Close()
+
+	services, err := pinremote.LoadServices(r)
+	if err != nil {
+		return err
+	}
+	if err := update(services); err != nil {
+		return err
+	}
+	return pinremote.SaveServices(r, services)
+}
+
+// forgetRemotePinService stops tracking the pin requests made to service,
+// which the node keeps doing while the service is only missing from the
+// config.
+func forgetRemotePinService(env cmds.Environment, service string) error {
+	cfgRoot, err := cmdenv.GetConfigRoot(env)
+	if err != nil {
+		return err
+	}
+	r, err := fsrepo.Open(cfgRoot)
+	if err != nil {
+		return err
+	}
+	defer r.Close()
+
+	tracker := pinremote.NewTracker(r.Datastore(), func() (map[string]pinremote.Service, error) {
+		return pinremote.LoadServices(r)
+	})
+	return tracker.DeleteService(service)
+}
@@ -14,9 +14,12 @@ import (
 	humanize "github.com/dustin/go-humanize"
 	cmdenv "github.com/ipfs/go-ipfs/core/commands/cmdenv"
 	corerepo "github.com/ipfs/go-ipfs/core/corerepo"
+	"github.com/ipfs/go-ipfs/gc"
 	fsrepo "github.com/ipfs/go-ipfs/repo/fsrepo"
+	migrate "github.com/ipfs/go-ipfs/repo/fsrepo/migrations"
 
 	cid "github.com/ipfs/go-cid"
+	lockfile "github.com/ipfs/go-fs-lock"
 	bstore "github.com/ipfs/go-ipfs-blockstore"
 	cmds "github.com/ipfs/go-ipfs-cmds"
 )
@@ -39,18 +42,34 @@ var RepoCmd = &cmds.Command{
 		"fsck":    repoFsckCmd,
 		"version": repoVersionCmd,
 		"verify":  repoVerifyCmd,
+		"migrate": repoMigrateCmd,
 	},
 }
 
 // GcResult is the result returned by "repo gc" command.
 type GcResult struct {
-	Key   cid.Cid
-	Error string `json:",omitempty"`
+	Key      cid.Cid
+	Size     uint64       `json:",omitempty"`
+	Error    string       `json:",omitempty"`
+	Progress *gc.Progress `json:",omitempty"`
+	Summary  *GcSummary   `json:",omitempty"`
+}
+
+// GcSummary totals the blocks removed by "repo gc", or the blocks which would
+// be removed with --dry-run.
+type GcSummary struct {
+	Removed uint64
+	Size    uint64
+	DryRun  bool `json:",omitempty"`
 }
 
 const (
 	repoStreamErrorsOptionName = "stream-errors"
 	repoQuietOptionName        = "quiet"
+	repoIncrementalOptionName  = "incremental"
+	repoBatchSizeOptionName    = "batch-size"
+	repoDryRunOptionName       = "dry-run"
+	repoProgressOptionName     = "progress"
 )
 
 var repoGcCmd = &cmds.Command{
@@ -60,11 +79,33 @@ var repoGcCmd = &cmds.Command{
 'ipfs repo gc' is a plumbing command that will sweep the local
 set of stored objects and remove ones that are not pinned in
 order to reclaim hard disk space.
+`,
+		LongDescription: `
+'ipfs repo gc' is a plumbing command that will sweep the local
+set of stored objects and remove ones that are not pinned in
+order to reclaim hard disk space.
+
+By default adds and pins are blocked for the whole garbage collection.
+With --incremental, they are only blocked while the pins are listed and
+while each batch of --batch-size blocks is removed. Blocks written or read
+in the meantime are kept until the next garbage collection. Progress is
+reported after marking and after every batch, unless --quiet is given.
+
+With --dry-run, the blocks which would be removed are listed but nothing is
+removed.
+
+A summary of the number of blocks and bytes removed, or which would be
+removed, is written at the end unless --quiet is given. Progress is only
+reported with --incremental, --dry-run or --progress.
 `,
 	},
 	Options: []cmds.Option{
 		cmds.BoolOption(repoStreamErrorsOptionName, "Stream errors."),
 		cmds.BoolOption(repoQuietOptionName, "q", "Write minimal output."),
+		cmds.BoolOption(repoIncrementalOptionName, "Only hold the GC lock for short periods, letting adds and pins proceed."),
+		cmds.IntOption(repoBatchSizeOptionName, "Number of blocks removed at once by an incremental garbage collection.").WithDefault(gc.DefaultBatchSize),
+		cmds.BoolOption(repoDryRunOptionName, "List the blocks which would be removed without removing them."),
+		cmds.BoolOption(repoProgressOptionName, "Report progress while collecting."),
 	},
 	Run: func(req *cmds.Request, re cmds.ResponseEmitter, env cmds.Environment) error {
 		n, err := cmdenv.GetNode(env)
@@ -73,9 +114,22 @@ order to reclaim hard disk space.
 		}
 
 		streamErrors, _ := req.Options[repoStreamErrorsOptionName].(bool)
+		incremental, _ := req.Options[repoIncrementalOptionName].(bool)
+		batchSize, _ := req.Options[repoBatchSizeOptionName].(int)
+		dryRun, _ := req.Options[repoDryRunOptionName].(bool)
+		quiet, _ := req.Options[repoQuietOptionName].(bool)
+		progress, _ := req.Options[repoProgressOptionName].(bool)
+		// Progress has no key, only emit it when asked for.
+		progress = progress || incremental || dryRun
+
+		var gcOutChan <-chan gc.Result
+		if incremental {
+			gcOutChan = corerepo.IncrementalGarbageCollectAsync(n, req.Context, gc.IncrementalOptions{BatchSize: batchSize, DryRun: dryRun})
+		} else {
+			gcOutChan = corerepo.GarbageCollectWithOptionsAsync(n, req.Context, gc.Options{DryRun: dryRun})
+		}
 
-		gcOutChan := corerepo.GarbageCollectAsync(n, req.Context)
-
+		summary := GcSummary{DryRun: dryRun}
 		if streamErrors {
 			errs := false
 			for res := range gcOutChan {
@@ -84,8 +138,17 @@ order to reclaim hard disk space.
 						return err
 					}
 					errs = true
+				} else if res.Progress != nil {
+					if !progress {
+						continue
+					}
+					if err := re.Emit(&GcResult{Progress: res.Progress}); err != nil {
+						return err
+					}
 				} else {
-					if err := re.Emit(&GcResult{Key: res.KeyRemoved}); err != nil {
+					summary.Removed++
+					summary.Size += res.Size
+					if err := re.Emit(&GcResult{Key: res.KeyRemoved, Size: res.Size}); err != nil {
 						return err
 					}
 				}
@@ -94,18 +157,29 @@ order to reclaim hard disk space.
 				return errors.New("encountered errors during gc run")
 			}
 		} else {
-			err := corerepo.CollectResult(req.Context, gcOutChan, func(k cid.Cid) {
+			err := corerepo.CollectResultWithProgress(req.Context, gcOutChan, func(k cid.Cid, size uint64) {
+				summary.Removed++
+				summary.Size += size
 				// Nothing to do with this error, really. This
 				// most likely means that the client is gone but
 				// we still need to let the GC finish.
-				_ = re.Emit(&GcResult{Key: k})
+				_ = re.Emit(&GcResult{Key: k, Size: size})
+			}, func(p gc.Progress) {
+				if progress {
+					_ = re.Emit(&GcResult{Progress: &p})
+				}
 			})
 			if err != nil {
 				return err
 			}
 		}
 
-		return nil
+		// Runs which removed nothing stay silent, --quiet keeps the output a
+		// list of removed blocks.
+		if quiet || (summary.Removed == 0 && !dryRun) {
+			return nil
+		}
+		return re.Emit(&GcResult{Summary: &summary})
 	},
 	Type: GcResult{},
 	Encoders: cmds.EncoderMap{
@@ -117,7 +191,42 @@ order to reclaim hard disk space.
 				return err
 			}
 
+			if gcr.Summary != nil {
+				if quiet {
+					return nil
+				}
+				verb := "removed"
+				if gcr.Summary.DryRun {
+					verb = "would remove"
+				}
+				_, err := fmt.Fprintf(w, "%s %d blocks, %s\n", verb, gcr.Summary.Removed, humanize.Bytes(gcr.Summary.Size))
+				return err
+			}
+
+			if gcr.Progress != nil {
+				if quiet {
+					return nil
+				}
+				p := gcr.Progress
+				var err error
+				switch p.Phase {
+				case gc.PhaseMark:
+					_, err = fmt.Fprintf(w, "marked %d blocks\n", p.Marked)
+				default:
+					verb := "removed"
+					if dryRun, _ := req.Options[repoDryRunOptionName].(bool); dryRun {
+						verb = "would remove"
+					}
+					_, err = fmt.Fprintf(w, "scanned %d blocks, %s %d, %s\n", p.Scanned, verb, p.Removed, humanize.Bytes(p.Size))
+				}
+				return err
+			}
+
+			dryRun, _ := req.Options[repoDryRunOptionName].(bool)
 			prefix := "removed "
+			if dryRun {
+				prefix = "would remove "
+			}
 			if quiet {
 				prefix = ""
 			}
@@ -129,8 +238,9 @@ order to reclaim hard disk space.
 }
 
 const (
-	repoSizeOnlyOptionName = "size-only"
-	repoHumanOptionName    = "human"
+	repoSizeOnlyOptionName  = "size-only"
+	repoHumanOptionName     = "human"
+	repoNamespaceOptionName = "namespace"
 )
 
 var repoStatCmd = &cmds.Command{
@@ -145,11 +255,19 @@ StorageMax      string Maximum datastore size (from configuration)
 NumObjects      int Number of objects in the local repo.
 RepoPath        string The path to the repo being currently used.
 Version         string The repo version.
+
+With --namespace, the pins of the namespace are reported instead:
+
+Namespace       string The namespace.
+NumPins         int Number of pins in the namespace.
+RepoSize        int Size in bytes of the DAGs pinned in the namespace.
+StorageMax      string Quota of the namespace (from Pinning.Namespaces).
 `,
 	},
 	Options: []cmds.Option{
 		cmds.BoolOption(repoSizeOnlyOptionName, "s", "Only report RepoSize and StorageMax."),
 		cmds.BoolOption(repoHumanOptionName, "H", "Print sizes in human readable format (e.g., 1K 234M 2G)"),
+		cmds.StringOption(repoNamespaceOptionName, "Report the pins of this namespace."),
 	},
 	Run: func(req *cmds.Request, res cmds.ResponseEmitter, env cmds.Environment) error {
 		n, err := cmdenv.GetNode(env)
@@ -157,6 +275,14 @@ Version         string The repo version.
 			return err
 		}
 
+		if namespace, _ := req.Options[repoNamespaceOptionName].(string); namespace != "" {
+			stat, err := corerepo.NamespaceStat(req.Context, n, namespace)
+			if err != nil {
+				return err
+			}
+			return cmds.EmitOnce(res, &stat)
+		}
+
 		sizeOnly, _ := req.Options[repoSizeOnlyOptionName].(bool)
 		if sizeOnly {
 			sizeStat, err := corerepo.RepoSize(req.Context, n)
@@ -193,6 +319,14 @@ Version         string The repo version.
 				fmt.Fprintf(wtr, "%s:\t%s\n", name, sizeStr)
 			}
 
+			if stat.Namespace != "" {
+				fmt.Fprintf(wtr, "Namespace:\t%s\n", stat.Namespace)
+				fmt.Fprintf(wtr, "NumPins:\t%d\n", stat.NumPins)
+				printSize("RepoSize", stat.RepoSize)
+				printSize("StorageMax", stat.StorageMax)
+				return nil
+			}
+
 			if !sizeOnly {
 				fmt.Fprintf(wtr, "NumObjects:\t%d\n", stat.NumObjects)
 			}
@@ -371,3 +505,88 @@ var repoVersionCmd = &cmds.Command{
 		}),
 	},
 }
+
+const (
+	repoMigrateToOptionName     = "to"
+	repoMigrateBinDirOptionName = "bin-dir"
+)
+
+var repoMigrateCmd = &cmds.Command{
+	Helptext: cmds.HelpText{
+		Tagline: "Migrate the repo to the version of this ipfs binary.",
+		ShortDescription: `
+'ipfs repo migrate' upgrades the repo to the version this ipfs binary uses,
+or to the version given with --to. The daemon must not be running.
+`,
+		LongDescription: `
+'ipfs repo migrate' upgrades the repo to the version this ipfs binary uses,
+or to the version given with --to. The daemon must not be running.
+
+The migrations built into ipfs run first. The files they change are backed
+up, and restored when a migration fails or was interrupted.
+
+Older repos are migrated with external binaries: fs-repo-X-to-Y binaries
+migrating a single version, or an fs-repo-migrations binary, looked for in
+the directory given with --bin-dir, then fs-repo-migrations in the PATH.
+When none is found, fs-repo-migrations is downloaded from the IPFS_DIST_PATH,
+unless --offline is given.
+`,
+	},
+	Options: []cmds.Option{
+		cmds.IntOption(repoMigrateToOptionName, "Version to migrate to.").WithDefault(fsrepo.RepoVersion),
+		cmds.StringOption(repoMigrateBinDirOptionName, "Directory of migration binaries."),
+	},
+	NoRemote: true,
+	Extra:    CreateCmdExtras(SetDoesNotUseRepo(true)),
+	Run: func(req *cmds.Request, res cmds.ResponseEmitter, env cmds.Environment) error {
+		cfgRoot, err := cmdenv.GetConfigRoot(env)
+		if err != nil {
+			return err
+		}
+
+		to, _ := req.Options[repoMigrateToOptionName].(int)
+		if to > fsrepo.RepoVersion {
+			return fmt.Errorf("this ipfs binary uses repo version %d, can't migrate to %d", fsrepo.RepoVersion, to)
+		}
+		offline, _ := req.Options[OfflineOption].(bool)
+		binDir, _ := req.Options[repoMigrateBinDirOptionName].(string)
+
+		// Hold the repo lock for the whole migration, so that no daemon
+		// starts on a repo half migrated.
+		lk, err := lockfile.Lock(cfgRoot, fsrepo.LockFile)
+		if err != nil {
+			if pe, ok := err.(*os.PathError); ok {
+				if _, ok := pe.Err.(lockfile.LockedError); ok {
+					return errors.New("the repo is in use, stop the ipfs daemon before migrating")
+				}
+			}
+			return err
+		}
+		defer lk.Close()
+
+		return migrate.Migrate(cfgRoot, to, migrate.Options{
+			Offline: offline,
+			BinDir:  binDir,
+			Out:     &messageWriter{res},
+		})
+	},
+	Type: MessageOutput{},
+	Encoders: cmds.EncoderMap{
+		cmds.Text: cmds.MakeTypedEncoder(func(req *cmds.Request, w io.Writer, out *MessageOutput) error {
+			_, err := io.WriteString(w, out.Message)
+			return err
+		}),
+	},
+}
+
+// messageWriter emits what is written to it as MessageOutputs.
+type messageWriter struct {
+	res cmds.ResponseEmitter
+}
+
+func (w *messageWriter) Write(p []byte) (int, error) {
+	if err := w.res.Emit(&MessageOutput{string(p)}); err != nil {
+		return 0, err
+	}
+	return len(p), nil
+}
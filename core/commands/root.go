@@ -131,6 +131,7 @@ var rootSubcommands = map[string]*cmds.Command{
 	"dns":       DNSCmd,
 	"id":        IDCmd,
 	"key":       KeyCmd,
+	"linker":    LinkerCmd,
 	"log":       LogCmd,
 	"ls":        LsCmd,
 	"mount":     MountCmd,
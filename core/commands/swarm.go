@@ -12,6 +12,7 @@ import (
 
 	commands "github.com/ipfs/go-ipfs/commands"
 	cmdenv "github.com/ipfs/go-ipfs/core/commands/cmdenv"
+	peering "github.com/ipfs/go-ipfs/peering"
 	repo "github.com/ipfs/go-ipfs/repo"
 	fsrepo "github.com/ipfs/go-ipfs/repo/fsrepo"
 
@@ -50,11 +51,13 @@ ipfs peers in the internet.
 		"connect":    swarmConnectCmd,
 		"disconnect": swarmDisconnectCmd,
 		"filters":    swarmFiltersCmd,
+		"peering":    swarmPeeringCmd,
 		"peers":      swarmPeersCmd,
 	},
 }
 
 const (
+	swarmPersistOptionName   = "persist"
 	swarmVerboseOptionName   = "verbose"
 	swarmStreamsOptionName   = "streams"
 	swarmLatencyOptionName   = "latency"
@@ -770,3 +773,225 @@ func filtersRemove(r repo.Repo, cfg *config.Config, toRemoveFilters []string) ([
 
 	return removed, nil
 }
+
+type peeringPeer struct {
+	ID            string
+	Addrs         []string
+	State         string
+	Failures      int       `json:",omitempty"`
+	NextReconnect time.Time `json:",omitempty"`
+}
+
+type peeringList struct {
+	Peers []peeringPeer
+}
+
+var swarmPeeringCmd = &cmds.Command{
+	Helptext: cmds.HelpText{
+		Tagline: "Modify the peering subsystem.",
+		ShortDescription: `
+'ipfs swarm peering' manages the peering subsystem. Peers in the peering
+subsystem are protected from connection trimming and are reconnected with a
+back-off whenever the connection drops.
+
+Peering defaults to the peers specified under the "Peering.Peers" config key.
+Changes only apply to the running daemon unless --persist is given.
+`,
+	},
+	Subcommands: map[string]*cmds.Command{
+		"add": swarmPeeringAddCmd,
+		"ls":  swarmPeeringLsCmd,
+		"rm":  swarmPeeringRmCmd,
+	},
+}
+
+var swarmPeeringAddCmd = &cmds.Command{
+	Helptext: cmds.HelpText{
+		Tagline: "Add peers into the peering subsystem.",
+		ShortDescription: `
+'ipfs swarm peering add' adds peers into the peering subsystem. The address
+format is an IPFS multiaddr, e.g.:
+
+ipfs swarm peering add /ip4/104.131.131.82/tcp/4001/p2p/QmaCpDMGvV2BGHeYERUEnRQAwe3N8SzbUtfsmvsqQLuvuJ
+`,
+	},
+	Arguments: []cmds.Argument{
+		cmds.StringArg("address", true, true, "Address of peer to add into the peering subsystem.").EnableStdin(),
+	},
+	Options: []cmds.Option{
+		cmds.BoolOption(swarmPersistOptionName, "Also add the peers to the Peering.Peers config key."),
+	},
+	Run: func(req *cmds.Request, res cmds.ResponseEmitter, env cmds.Environment) error {
+		n, err := cmdenv.GetNode(env)
+		if err != nil {
+			return err
+		}
+
+		if n.Peering == nil {
+			return ErrNotOnline
+		}
+
+		addrs, err := parseAddresses(req.Context, req.Arguments)
+		if err != nil {
+			return err
+		}
+
+		persist, _ := req.Options[swarmPersistOptionName].(bool)
+		if persist {
+			if err := peeringPersist(env, addrs, nil); err != nil {
+				return err
+			}
+		}
+
+		output := make([]string, 0, len(addrs))
+		for _, ai := range addrs {
+			n.Peering.AddPeer(ai)
+			output = append(output, "add "+ai.ID.Pretty()+" success")
+		}
+		return cmds.EmitOnce(res, &stringList{output})
+	},
+	Encoders: cmds.EncoderMap{
+		cmds.Text: cmds.MakeTypedEncoder(stringListEncoder),
+	},
+	Type: stringList{},
+}
+
+var swarmPeeringRmCmd = &cmds.Command{
+	Helptext: cmds.HelpText{
+		Tagline: "Remove peers from the peering subsystem.",
+		ShortDescription: `
+'ipfs swarm peering rm' removes peers from the peering subsystem. The
+connections to the peers are kept open, but no longer protected.
+`,
+	},
+	Arguments: []cmds.Argument{
+		cmds.StringArg("ID", true, true, "Peer ID to remove from the peering subsystem.").EnableStdin(),
+	},
+	Options: []cmds.Option{
+		cmds.BoolOption(swarmPersistOptionName, "Also remove the peers from the Peering.Peers config key."),
+	},
+	Run: func(req *cmds.Request, res cmds.ResponseEmitter, env cmds.Environment) error {
+		n, err := cmdenv.GetNode(env)
+		if err != nil {
+			return err
+		}
+
+		if n.Peering == nil {
+			return ErrNotOnline
+		}
+
+		ids := make([]peer.ID, 0, len(req.Arguments))
+		for _, arg := range req.Arguments {
+			id, err := peer.Decode(arg)
+			if err != nil {
+				return err
+			}
+			ids = append(ids, id)
+		}
+
+		persist, _ := req.Options[swarmPersistOptionName].(bool)
+		if persist {
+			if err := peeringPersist(env, nil, ids); err != nil {
+				return err
+			}
+		}
+
+		output := make([]string, 0, len(ids))
+		for _, id := range ids {
+			n.Peering.RemovePeer(id)
+			output = append(output, "remove "+id.Pretty()+" success")
+		}
+		return cmds.EmitOnce(res, &stringList{output})
+	},
+	Encoders: cmds.EncoderMap{
+		cmds.Text: cmds.MakeTypedEncoder(stringListEncoder),
+	},
+	Type: stringList{},
+}
+
+var swarmPeeringLsCmd = &cmds.Command{
+	Helptext: cmds.HelpText{
+		Tagline: "List peers registered in the peering subsystem.",
+		ShortDescription: `
+'ipfs swarm peering ls' lists the peers of the peering subsystem with their
+connection state and, while backing off, the time of the next reconnect.
+`,
+	},
+	Run: func(req *cmds.Request, res cmds.ResponseEmitter, env cmds.Environment) error {
+		n, err := cmdenv.GetNode(env)
+		if err != nil {
+			return err
+		}
+
+		if n.Peering == nil {
+			return ErrNotOnline
+		}
+
+		states := n.Peering.ListPeers()
+		out := peeringList{Peers: make([]peeringPeer, 0, len(states))}
+		for _, st := range states {
+			pp := peeringPeer{
+				ID:            st.ID.Pretty(),
+				Addrs:         make([]string, 0, len(st.Addrs)),
+				State:         string(st.State),
+				Failures:      st.Failures,
+				NextReconnect: st.NextReconnect,
+			}
+			for _, addr := range st.Addrs {
+				pp.Addrs = append(pp.Addrs, addr.String())
+			}
+			out.Peers = append(out.Peers, pp)
+		}
+		sort.Slice(out.Peers, func(i, j int) bool {
+			return out.Peers[i].ID < out.Peers[j].ID
+		})
+		return cmds.EmitOnce(res, &out)
+	},
+	Encoders: cmds.EncoderMap{
+		cmds.Text: cmds.MakeTypedEncoder(func(req *cmds.Request, w io.Writer, list *peeringList) error {
+			for _, p := range list.Peers {
+				state := p.State
+				if p.State == string(peering.BackingOff) {
+					state += fmt.Sprintf(", %d failures, next retry in %s", p.Failures, time.Until(p.NextReconnect).Round(time.Second))
+				}
+				fmt.Fprintf(w, "%s (%s)\n", p.ID, state)
+				for _, addr := range p.Addrs {
+					fmt.Fprintf(w, "\t%s\n", addr)
+				}
+			}
+			return nil
+		}),
+	},
+	Type: peeringList{},
+}
+
+// peeringPersist adds and removes peers from the Peering.Peers config key.
+func peeringPersist(env cmds.Environment, add []peer.AddrInfo, rm []peer.ID) error {
+	r, err := fsrepo.Open(env.(*commands.Context).ConfigRoot)
+	if err != nil {
+		return err
+	}
+	defer r.Close()
+	cfg, err := r.Config()
+	if err != nil {
+		return err
+	}
+
+	drop := make(map[peer.ID]struct{}, len(add)+len(rm))
+	for _, ai := range add {
+		drop[ai.ID] = struct{}{}
+	}
+	for _, id := range rm {
+		drop[id] = struct{}{}
+	}
+
+	peers := make([]peer.AddrInfo, 0, len(cfg.Peering.Peers)+len(add))
+	for _, ai := range cfg.Peering.Peers {
+		if _, found := drop[ai.ID]; !found {
+			peers = append(peers, ai)
+		}
+	}
+	cfg.Peering.Peers = append(peers, add...)
+
+	return r.SetConfig(cfg)
+}
@@ -44,11 +44,16 @@ import (
 	"github.com/ipfs/go-ipfs/core/bootstrap"
 	"github.com/ipfs/go-ipfs/core/node"
 	"github.com/ipfs/go-ipfs/core/node/libp2p"
+	"github.com/ipfs/go-ipfs/denylist"
 	"github.com/ipfs/go-ipfs/fuse/mount"
+	"github.com/ipfs/go-ipfs/gc"
 	"github.com/ipfs/go-ipfs/namesys"
 	ipnsrp "github.com/ipfs/go-ipfs/namesys/republisher"
 	"github.com/ipfs/go-ipfs/p2p"
 	"github.com/ipfs/go-ipfs/peering"
+	"github.com/ipfs/go-ipfs/pinmeta"
+	"github.com/ipfs/go-ipfs/pinqueue"
+	"github.com/ipfs/go-ipfs/pinremote"
 	"github.com/ipfs/go-ipfs/repo"
 )
 
@@ -64,6 +69,9 @@ type IpfsNode struct {
 
 	// Local node
 	Pinning         pin.Pinner             // the pinning manager
+	PinMetadata     *pinmeta.Store         // what is recorded about pins besides their type
+	PinQueue        *pinqueue.Queue        // the pins done in the background
+	RemotePins      *pinremote.Tracker     // the pin requests made to remote pinning services
 	Mounts          Mounts                 `optional:"true"` // current mount state, if any.
 	PrivateKey      ic.PrivKey             `optional:"true"` // the local node's private Key
 	PNetFingerprint libp2p.PNetFingerprint `optional:"true"` // fingerprint of private network
@@ -74,6 +82,8 @@ type IpfsNode struct {
 	Filestore       *filestore.Filestore      `optional:"true"` // the filestore blockstore
 	BaseBlocks      node.BaseBlocks           // the raw blockstore, no filestore wrapping
 	GCLocker        bstore.GCLocker           // the locker used to protect the blockstore during gc
+	GCWriteBarrier  *gc.WriteBarrier          // tracks the blocks used during incremental gc
+	GCAccessTracker *gc.AccessTracker         `optional:"true"` // records block accesses for the lru gc policy
 	Blocks          bserv.BlockService        // the block service, get/add blocks.
 	DAG             ipld.DAGService           // the merkle dag service, get/add objects.
 	Resolver        *resolver.Resolver        // the path resolution system
@@ -84,7 +94,8 @@ type IpfsNode struct {
 
 	// Online
 	PeerHost      p2phost.Host            `optional:"true"` // the network host (server+client)
-	Peering       peering.PeeringService  `optional:"true"`
+	Peering       *peering.PeeringService `optional:"true"`
+	Denylist      *denylist.Denylist      `optional:"true"`
 	Filters       *ma.Filters             `optional:"true"`
 	Bootstrapper  io.Closer               `optional:"true"` // the periodic bootstrapper
 	Routing       routing.Routing         `optional:"true"` // the routing system. recommend ipfs-dht
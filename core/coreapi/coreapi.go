@@ -40,6 +40,7 @@ import (
 	"github.com/ipfs/go-ipfs/core"
 	"github.com/ipfs/go-ipfs/core/node"
 	"github.com/ipfs/go-ipfs/namesys"
+	"github.com/ipfs/go-ipfs/pinmeta"
 	"github.com/ipfs/go-ipfs/repo"
 )
 
@@ -53,6 +54,7 @@ type CoreAPI struct {
 	blockstore blockstore.GCBlockstore
 	baseBlocks blockstore.Blockstore
 	pinning    pin.Pinner
+	pinMeta    *pinmeta.Store
 
 	blocks bserv.BlockService
 	dag    ipld.DAGService
@@ -164,6 +166,7 @@ func (api *CoreAPI) WithOptions(opts ...options.ApiOption) (coreiface.CoreAPI, e
 		blockstore: n.Blockstore,
 		baseBlocks: n.BaseBlocks,
 		pinning:    n.Pinning,
+		pinMeta:    n.PinMetadata,
 
 		blocks: n.Blocks,
 		dag:    n.DAG,
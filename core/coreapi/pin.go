@@ -12,11 +12,20 @@ import (
 	coreiface "github.com/ipfs/interface-go-ipfs-core"
 	caopts "github.com/ipfs/interface-go-ipfs-core/options"
 	"github.com/ipfs/interface-go-ipfs-core/path"
+
+	"github.com/ipfs/go-ipfs/pinmeta"
 )
 
 type PinAPI CoreAPI
 
 func (api *PinAPI) Add(ctx context.Context, p path.Path, opts ...caopts.PinAddOption) error {
+	return api.AddWithMetadata(ctx, p, pinmeta.Metadata{}, opts...)
+}
+
+// AddWithMetadata pins p like Add, and records md about the pin. When the
+// content was pinned already, md is merged with what is recorded as
+// described by pinmeta.Merge.
+func (api *PinAPI) AddWithMetadata(ctx context.Context, p path.Path, md pinmeta.Metadata, opts ...caopts.PinAddOption) error {
 	dagNode, err := api.core().ResolveNode(ctx, p)
 	if err != nil {
 		return fmt.Errorf("pin: %s", err)
@@ -29,7 +38,7 @@ func (api *PinAPI) Add(ctx context.Context, p path.Path, opts ...caopts.PinAddOp
 
 	defer api.blockstore.PinLock().Unlock()
 
-	err = api.pinning.Pin(ctx, dagNode, settings.Recursive)
+	err = api.pinMeta.Pin(ctx, api.pinning, dagNode, settings.Recursive, md)
 	if err != nil {
 		return fmt.Errorf("pin: %s", err)
 	}
@@ -41,6 +50,26 @@ func (api *PinAPI) Add(ctx context.Context, p path.Path, opts ...caopts.PinAddOp
 	return api.pinning.Flush(ctx)
 }
 
+// Metadata returns what is recorded about the pin of c.
+func (api *PinAPI) Metadata(ctx context.Context, c cid.Cid) (pinmeta.Metadata, error) {
+	return api.pinMeta.Get(c)
+}
+
+// ListMetadata returns what is recorded about the pins selected by filter, by
+// CID.
+func (api *PinAPI) ListMetadata(ctx context.Context, filter pinmeta.Filter) (map[cid.Cid]pinmeta.Metadata, error) {
+	all, err := api.pinMeta.List()
+	if err != nil {
+		return nil, err
+	}
+	for c, md := range all {
+		if !filter.Matches(md) {
+			delete(all, c)
+		}
+	}
+	return all, nil
+}
+
 func (api *PinAPI) Ls(ctx context.Context, opts ...caopts.PinLsOption) (<-chan coreiface.Pin, error) {
 	settings, err := caopts.PinLsOptions(opts...)
 	if err != nil {
@@ -95,6 +124,10 @@ func (api *PinAPI) Rm(ctx context.Context, p path.Path, opts ...caopts.PinRmOpti
 		return err
 	}
 
+	if err := api.pinMeta.Delete(rp.Cid()); err != nil {
+		return err
+	}
+
 	return api.pinning.Flush(ctx)
 }
 
@@ -116,11 +149,43 @@ func (api *PinAPI) Update(ctx context.Context, from path.Path, to path.Path, opt
 
 	defer api.blockstore.PinLock().Unlock()
 
+	// The new pin inherits the metadata of the old one, unless it was
+	// pinned already.
+	toPinned, err := pinmeta.PinnedExplicitly(ctx, api.pinning, tp.Cid())
+	if err != nil {
+		return err
+	}
+	md, err := api.pinMeta.Get(fp.Cid())
+	if err != nil {
+		return err
+	}
+	defer api.pinMeta.LockNamespace(md.Namespace())()
+	if !toPinned {
+		var released uint64
+		if settings.Unpin {
+			released = md.Size
+		}
+		if err := api.pinMeta.Charge(ctx, tp.Cid(), true, &md, released); err != nil {
+			return err
+		}
+	}
+
 	err = api.pinning.Update(ctx, fp.Cid(), tp.Cid(), settings.Unpin)
 	if err != nil {
 		return err
 	}
 
+	if !toPinned {
+		if err := api.pinMeta.Put(tp.Cid(), md); err != nil {
+			return err
+		}
+	}
+	if settings.Unpin {
+		if err := api.pinMeta.Delete(fp.Cid()); err != nil {
+			return err
+		}
+	}
+
 	return api.pinning.Flush(ctx)
 }
 
@@ -272,24 +337,17 @@ func (api *PinAPI) pinLsAll(ctx context.Context, typeStr string) <-chan coreifac
 			}
 		}
 		if typeStr == "all" {
-			set := cid.NewSet()
 			rkeys, err := api.pinning.RecursiveKeys(ctx)
 			if err != nil {
 				out <- &pinInfo{err: err}
 				return
 			}
-			for _, k := range rkeys {
-				err := merkledag.Walk(
-					ctx, merkledag.GetLinksWithDAG(api.dag), k,
-					set.Visit,
-					merkledag.SkipRoot(), merkledag.Concurrent(),
-				)
-				if err != nil {
-					out <- &pinInfo{err: err}
-					return
-				}
+			ikeys, err := api.indirectKeys(ctx, rkeys)
+			if err != nil {
+				out <- &pinInfo{err: err}
+				return
 			}
-			if err := AddToResultKeys(set.Keys(), "indirect"); err != nil {
+			if err := AddToResultKeys(ikeys, "indirect"); err != nil {
 				out <- &pinInfo{err: err}
 				return
 			}
@@ -312,19 +370,12 @@ func (api *PinAPI) pinLsAll(ctx context.Context, typeStr string) <-chan coreifac
 			}
 			VisitKeys(rkeys)
 
-			set := cid.NewSet()
-			for _, k := range rkeys {
-				err := merkledag.Walk(
-					ctx, merkledag.GetLinksWithDAG(api.dag), k,
-					set.Visit,
-					merkledag.SkipRoot(), merkledag.Concurrent(),
-				)
-				if err != nil {
-					out <- &pinInfo{err: err}
-					return
-				}
+			ikeys, err := api.indirectKeys(ctx, rkeys)
+			if err != nil {
+				out <- &pinInfo{err: err}
+				return
 			}
-			if err := AddToResultKeys(set.Keys(), "indirect"); err != nil {
+			if err := AddToResultKeys(ikeys, "indirect"); err != nil {
 				out <- &pinInfo{err: err}
 				return
 			}
@@ -334,6 +385,31 @@ func (api *PinAPI) pinLsAll(ctx context.Context, typeStr string) <-chan coreifac
 	return out
 }
 
+// indirectKeyLister is implemented by pinners which index the indirect pins.
+type indirectKeyLister interface {
+	IndirectKeys(ctx context.Context) ([]cid.Cid, error)
+}
+
+// indirectKeys returns the blocks of the DAGs of the recursive pins rkeys,
+// from the index of the pinner when it has one.
+func (api *PinAPI) indirectKeys(ctx context.Context, rkeys []cid.Cid) ([]cid.Cid, error) {
+	if lister, ok := api.pinning.(indirectKeyLister); ok {
+		return lister.IndirectKeys(ctx)
+	}
+	set := cid.NewSet()
+	for _, k := range rkeys {
+		err := merkledag.Walk(
+			ctx, merkledag.GetLinksWithDAG(api.dag), k,
+			set.Visit,
+			merkledag.SkipRoot(), merkledag.Concurrent(),
+		)
+		if err != nil {
+			return nil, err
+		}
+	}
+	return set.Keys(), nil
+}
+
 func (api *PinAPI) core() coreiface.CoreAPI {
 	return (*CoreAPI)(api)
 }
@@ -8,6 +8,7 @@ import (
 	"github.com/ipfs/go-ipfs/core"
 
 	"github.com/ipfs/go-ipfs/core/coreunix"
+	"github.com/ipfs/go-ipfs/pinmeta"
 
 	blockservice "github.com/ipfs/go-blockservice"
 	cid "github.com/ipfs/go-cid"
@@ -55,6 +56,13 @@ func getOrCreateNilNode() (*core.IpfsNode, error) {
 // Add builds a merkledag node from a reader, adds it to the blockstore,
 // and returns the key representing that node.
 func (api *UnixfsAPI) Add(ctx context.Context, files files.Node, opts ...options.UnixfsAddOption) (path.Resolved, error) {
+	return api.AddWithPinMetadata(ctx, files, pinmeta.Metadata{}, opts...)
+}
+
+// AddWithPinMetadata adds files like Add, and records md about the pin of
+// the root when it is pinned. The pin is made while the content is still
+// protected from GC, and is refused if the namespace of md is out of room.
+func (api *UnixfsAPI) AddWithPinMetadata(ctx context.Context, files files.Node, md pinmeta.Metadata, opts ...options.UnixfsAddOption) (path.Resolved, error) {
 	settings, prefix, err := options.UnixfsAddOptions(opts...)
 	if err != nil {
 		return nil, err
@@ -130,6 +138,10 @@ func (api *UnixfsAPI) Add(ctx context.Context, files files.Node, opts ...options
 		fileAdder.Progress = settings.Progress
 	}
 	fileAdder.Pin = settings.Pin && !settings.OnlyHash
+	if fileAdder.Pin {
+		fileAdder.PinMeta = api.pinMeta
+		fileAdder.PinMetadata = md
+	}
 	fileAdder.Silent = settings.Silent
 	fileAdder.RawLeaves = settings.RawLeaves
 	fileAdder.NoCopy = settings.NoCopy
@@ -1,6 +1,7 @@
 package corehttp
 
 import (
+	"encoding/json"
 	"fmt"
 	"net"
 	"net/http"
@@ -9,6 +10,7 @@ import (
 	version "github.com/ipfs/go-ipfs"
 	core "github.com/ipfs/go-ipfs/core"
 	coreapi "github.com/ipfs/go-ipfs/core/coreapi"
+	repo "github.com/ipfs/go-ipfs/repo"
 
 	options "github.com/ipfs/interface-go-ipfs-core/options"
 	id "github.com/libp2p/go-libp2p/p2p/protocol/identify"
@@ -18,6 +20,42 @@ type GatewayConfig struct {
 	Headers      map[string][]string
 	Writable     bool
 	PathPrefixes []string
+	// TrustlessOnly restricts the gateway to responses clients can verify
+	// themselves: raw blocks and CARs.
+	TrustlessOnly bool
+}
+
+// GatewayExtConfig holds the keys of the Gateway config section which are
+// not part of go-ipfs-config. They are read from the raw config file.
+type GatewayExtConfig struct {
+	TrustlessOnly bool
+
+	// RetrievalTimeout is how long a request may go without making progress
+	// before it is answered with 504 Gateway Timeout, e.g. "1m".
+	RetrievalTimeout string
+	// MaxConcurrentRequests caps the number of requests served at once.
+	MaxConcurrentRequests int
+	RateLimit             GatewayRateLimits
+
+	Cache GatewayCacheConfig
+}
+
+// loadGatewayExtConfig reads the extension keys of the Gateway config
+// section.
+func loadGatewayExtConfig(r repo.Repo) (GatewayExtConfig, error) {
+	var ext GatewayExtConfig
+	raw, err := r.GetConfigKey("Gateway")
+	if err != nil {
+		return ext, fmt.Errorf("failure to read Gateway config: %s", err)
+	}
+	buf, err := json.Marshal(raw)
+	if err != nil {
+		return ext, err
+	}
+	if err := json.Unmarshal(buf, &ext); err != nil {
+		return ext, fmt.Errorf("failure to decode Gateway config: %s", err)
+	}
+	return ext, nil
 }
 
 // A helper function to clean up a set of headers:
@@ -47,6 +85,11 @@ func GatewayOption(writable bool, paths ...string) ServeOption {
 			return nil, err
 		}
 
+		ext, err := loadGatewayExtConfig(n.Repo)
+		if err != nil {
+			return nil, err
+		}
+
 		api, err := coreapi.NewCoreAPI(n, options.Api.FetchBlocks(!cfg.Gateway.NoFetch))
 		if err != nil {
 			return nil, err
@@ -88,10 +131,16 @@ func GatewayOption(writable bool, paths ...string) ServeOption {
 			}, headers[ACEHeadersName]...))
 
 		gateway := newGatewayHandler(GatewayConfig{
-			Headers:      headers,
-			Writable:     writable,
-			PathPrefixes: cfg.Gateway.PathPrefixes,
+			Headers:       headers,
+			Writable:      writable,
+			PathPrefixes:  cfg.Gateway.PathPrefixes,
+			TrustlessOnly: ext.TrustlessOnly,
 		}, api)
+		gateway.cache, err = newGatewayCache(ext.Cache, n.Namesys)
+		if err != nil {
+			return nil, err
+		}
+		gateway.denylist = n.Denylist
 
 		for _, p := range paths {
 			mux.Handle(p+"/", gateway)
@@ -0,0 +1,215 @@
+package corehttp
+
+import (
+	"context"
+	"fmt"
+	"net/http"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/ipfs/go-ipfs/namesys"
+
+	lru "github.com/hashicorp/golang-lru"
+	path "github.com/ipfs/go-path"
+	ipath "github.com/ipfs/interface-go-ipfs-core/path"
+	"github.com/prometheus/client_golang/prometheus"
+)
+
+// GatewayCacheConfig configures the in-memory caches of the gateway. Both
+// caches are disabled when their size is zero.
+type GatewayCacheConfig struct {
+	// Paths is the number of resolved paths kept.
+	Paths int
+	// PathTTL bounds how long /ipns paths are cached and applies to names
+	// resolved without a TTL, like DNSLink, e.g. "1m".
+	PathTTL string
+	// Responses is the number of rendered directory listings kept.
+	Responses int
+	// MaxResponseSize is the size in bytes of the largest response cached.
+	MaxResponseSize int
+}
+
+const (
+	defaultCachePathTTL         = time.Minute
+	defaultCacheMaxResponseSize = 256 << 10
+)
+
+var gatewayCacheMetric = prometheus.NewCounterVec(prometheus.CounterOpts{
+	Namespace: "ipfs",
+	Subsystem: "http",
+	Name:      "gw_cache_requests_total",
+	Help:      "Number of gateway cache lookups by cache and result.",
+}, []string{"cache", "result"})
+
+// gatewayCache holds resolved paths and small rendered responses.
+type gatewayCache struct {
+	ns namesys.NameSystem
+
+	paths   *lru.Cache
+	pathTTL time.Duration
+
+	responses       *lru.Cache
+	maxResponseSize int
+
+	hits, misses func(cache string)
+}
+
+type cachedPath struct {
+	resolved ipath.Resolved
+	// eol is zero for immutable /ipfs paths.
+	eol time.Time
+}
+
+type cachedResponse struct {
+	header http.Header
+	body   []byte
+}
+
+// newGatewayCache returns nil when both caches are disabled.
+func newGatewayCache(cfg GatewayCacheConfig, ns namesys.NameSystem) (*gatewayCache, error) {
+	if cfg.Paths <= 0 && cfg.Responses <= 0 {
+		return nil, nil
+	}
+
+	c := &gatewayCache{
+		ns:              ns,
+		pathTTL:         defaultCachePathTTL,
+		maxResponseSize: defaultCacheMaxResponseSize,
+	}
+	if cfg.PathTTL != "" {
+		ttl, err := time.ParseDuration(cfg.PathTTL)
+		if err != nil {
+			return nil, fmt.Errorf("invalid Gateway.Cache.PathTTL: %s", err)
+		}
+		c.pathTTL = ttl
+	}
+	if cfg.MaxResponseSize > 0 {
+		c.maxResponseSize = cfg.MaxResponseSize
+	}
+
+	var err error
+	if cfg.Paths > 0 {
+		if c.paths, err = lru.New(cfg.Paths); err != nil {
+			return nil, err
+		}
+	}
+	if cfg.Responses > 0 {
+		if c.responses, err = lru.New(cfg.Responses); err != nil {
+			return nil, err
+		}
+	}
+
+	metric := gatewayCacheMetric
+	if err := prometheus.Register(metric); err != nil {
+		if are, ok := err.(prometheus.AlreadyRegisteredError); ok {
+			metric = are.ExistingCollector.(*prometheus.CounterVec)
+		} else {
+			return nil, err
+		}
+	}
+	c.hits = func(cache string) { metric.WithLabelValues(cache, "hit").Inc() }
+	c.misses = func(cache string) { metric.WithLabelValues(cache, "miss").Inc() }
+	return c, nil
+}
+
+// resolvePath resolves p through the path cache when it is enabled.
+func (i *gatewayHandler) resolvePath(ctx context.Context, p ipath.Path) (ipath.Resolved, error) {
+	c := i.cache
+	if c == nil || c.paths == nil {
+		return i.api.ResolvePath(ctx, p)
+	}
+
+	key := p.String()
+	if v, ok := c.paths.Get(key); ok {
+		entry := v.(cachedPath)
+		if entry.eol.IsZero() || time.Now().Before(entry.eol) {
+			c.hits("paths")
+			return entry.resolved, nil
+		}
+		c.paths.Remove(key)
+	}
+	c.misses("paths")
+
+	if p.Namespace() != "ipns" || c.ns == nil {
+		resolved, err := i.api.ResolvePath(ctx, p)
+		if err != nil {
+			return nil, err
+		}
+		if p.Namespace() == "ipfs" {
+			c.paths.Add(key, cachedPath{resolved: resolved})
+		}
+		return resolved, nil
+	}
+
+	// Resolve the name first, like namesys/resolve.ResolveIPNS does, to learn
+	// how long the result is valid.
+	parsed, err := path.ParsePath(key)
+	if err != nil {
+		return nil, err
+	}
+	seg := parsed.Segments()
+	if len(seg) < 2 || seg[1] == "" {
+		return nil, fmt.Errorf("invalid path %q: ipns path missing IPNS ID", key)
+	}
+	var res namesys.Result
+	for res = range c.ns.ResolveAsync(ctx, "/"+seg[0]+"/"+seg[1]) {
+	}
+	if res.Err != nil {
+		return nil, res.Err
+	}
+	if res.Path == "" {
+		return nil, namesys.ErrResolveFailed
+	}
+	respath, err := path.FromSegments("/", append(res.Path.Segments(), seg[2:]...)...)
+	if err != nil {
+		return nil, err
+	}
+	resolved, err := i.api.ResolvePath(ctx, ipath.New(respath.String()))
+	if err != nil {
+		return nil, err
+	}
+
+	ttl := c.pathTTL
+	if res.TTL > 0 && res.TTL < ttl {
+		ttl = res.TTL
+	}
+	c.paths.Add(key, cachedPath{resolved: resolved, eol: time.Now().Add(ttl)})
+	return resolved, nil
+}
+
+// responseCacheKey identifies a rendered response, etag covers the content
+// and how it is rendered, the rest the links within it. Listings omit denied
+// entries, so responses rendered before a denylist reload are not reused.
+func (i *gatewayHandler) responseCacheKey(r *http.Request, etag, urlPath string) string {
+	gwURL, _ := r.Context().Value("gw-hostname").(string)
+	gen := strconv.FormatUint(i.denylist.Generation(), 10)
+	return strings.Join([]string{etag, urlPath, r.URL.RawQuery, gwURL, gen}, "\x00")
+}
+
+// serveCachedResponse writes the response cached under key, if any.
+func (c *gatewayCache) serveCachedResponse(w http.ResponseWriter, key string) bool {
+	if c == nil || c.responses == nil {
+		return false
+	}
+	v, ok := c.responses.Get(key)
+	if !ok {
+		c.misses("responses")
+		return false
+	}
+	c.hits("responses")
+	res := v.(cachedResponse)
+	for k, vs := range res.header {
+		w.Header()[k] = vs
+	}
+	_, _ = w.Write(res.body)
+	return true
+}
+
+// addResponse caches a rendered response unless it is too large.
+func (c *gatewayCache) addResponse(key string, header http.Header, body []byte) {
+	if c == nil || c.responses == nil || len(body) > c.maxResponseSize {
+		return
+	}
+	c.responses.Add(key, cachedResponse{header: header.Clone(), body: body})
+}
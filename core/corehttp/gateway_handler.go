@@ -1,7 +1,9 @@
 package corehttp
 
 import (
+	"bytes"
 	"context"
+	"errors"
 	"fmt"
 	"io"
 	"mime"
@@ -17,9 +19,11 @@ import (
 
 	humanize "github.com/dustin/go-humanize"
 	"github.com/gabriel-vasile/mimetype"
+	lru "github.com/hashicorp/golang-lru"
 	"github.com/ipfs/go-cid"
 	files "github.com/ipfs/go-ipfs-files"
 	assets "github.com/ipfs/go-ipfs/assets"
+	"github.com/ipfs/go-ipfs/denylist"
 	dag "github.com/ipfs/go-merkledag"
 	mfs "github.com/ipfs/go-mfs"
 	path "github.com/ipfs/go-path"
@@ -34,6 +38,23 @@ const (
 	ipnsPathPrefix = "/ipns/"
 )
 
+// Response formats which can be requested with the format query parameter
+// or the matching Accept header.
+const (
+	carResponseFormat = "car"
+	carContentType    = "application/vnd.ipld.car"
+	rawResponseFormat = "raw"
+	rawContentType    = "application/vnd.ipld.raw"
+	tarResponseFormat = "tar"
+	tarContentType    = "application/x-tar"
+	zipResponseFormat = "zip"
+	zipContentType    = "application/zip"
+)
+
+var errDenied = errors.New("this content is blocked by the gateway operator")
+
+var errUnverifiableResponse = fmt.Errorf("this gateway only serves verifiable responses, request them with ?format=%s or ?format=%s", rawResponseFormat, carResponseFormat)
+
 var onlyAscii = regexp.MustCompile("[[:^ascii:]]")
 
 // gatewayHandler is a HTTP handler that serves IPFS objects (accessible by default at /ipfs/<path>)
@@ -41,6 +62,12 @@ var onlyAscii = regexp.MustCompile("[[:^ascii:]]")
 type gatewayHandler struct {
 	config GatewayConfig
 	api    coreiface.CoreAPI
+	// cache is nil unless the gateway cache is enabled.
+	cache *gatewayCache
+	// denylist is nil unless a denylist is configured.
+	denylist *denylist.Denylist
+	// listings holds sorted directory link lists for paged listings.
+	listings *lru.Cache
 }
 
 // StatusResponseWriter enables us to override HTTP Status Code passed to
@@ -63,9 +90,11 @@ func (sw *statusResponseWriter) WriteHeader(code int) {
 }
 
 func newGatewayHandler(c GatewayConfig, api coreiface.CoreAPI) *gatewayHandler {
+	listings, _ := lru.New(listingCacheSize)
 	i := &gatewayHandler{
-		config: c,
-		api:    api,
+		config:   c,
+		api:      api,
+		listings: listings,
 	}
 	return i
 }
@@ -199,15 +228,45 @@ func (i *gatewayHandler) getOrHeadHandler(w http.ResponseWriter, r *http.Request
 		return
 	}
 
+	if i.denylist.IsPathDenied(parsedPath.String()) {
+		webError(w, "failed to serve "+escapedURLPath, errDenied, http.StatusGone)
+		return
+	}
+
+	// The response depends on the Accept header, shared caches must not
+	// serve a CAR or a block for the plain URL, nor the other way around.
+	format := customResponseFormat(r)
+	w.Header().Set("Vary", "Accept")
+	switch format {
+	case "":
+		if i.config.TrustlessOnly {
+			webError(w, "failed to serve "+escapedURLPath, errUnverifiableResponse, http.StatusNotAcceptable)
+			return
+		}
+	case carResponseFormat, rawResponseFormat:
+	case tarResponseFormat, zipResponseFormat:
+		if i.config.TrustlessOnly {
+			webError(w, "failed to serve "+escapedURLPath, errUnverifiableResponse, http.StatusNotAcceptable)
+			return
+		}
+	default:
+		err := fmt.Errorf("unsupported format %q", format)
+		webError(w, "failed to serve "+escapedURLPath, err, http.StatusBadRequest)
+		return
+	}
+
 	// Resolve path to the final DAG node for the ETag
-	resolvedPath, err := i.api.ResolvePath(r.Context(), parsedPath)
+	resolvedPath, err := i.resolvePath(r.Context(), parsedPath)
 	switch err {
 	case nil:
 	case coreiface.ErrOffline:
 		webError(w, "ipfs resolve -r "+escapedURLPath, err, http.StatusServiceUnavailable)
 		return
 	default:
-		if i.servePretty404IfPresent(w, r, parsedPath) {
+		if format == "" && i.serveRedirectsIfPresent(w, r, urlPath, requestURI.Path) {
+			return
+		}
+		if format == "" && i.servePretty404IfPresent(w, r, parsedPath) {
 			return
 		}
 
@@ -215,6 +274,23 @@ func (i *gatewayHandler) getOrHeadHandler(w http.ResponseWriter, r *http.Request
 		return
 	}
 
+	if i.denylist.IsCidDenied(resolvedPath.Cid()) {
+		webError(w, "failed to serve "+escapedURLPath, errDenied, http.StatusGone)
+		return
+	}
+
+	switch format {
+	case carResponseFormat:
+		i.serveCar(w, r, resolvedPath, urlPath)
+		return
+	case rawResponseFormat:
+		i.serveRawBlock(w, r, resolvedPath, urlPath)
+		return
+	case tarResponseFormat, zipResponseFormat:
+		i.serveArchive(w, r, resolvedPath, urlPath, format)
+		return
+	}
+
 	dr, err := i.api.Unixfs().Get(r.Context(), resolvedPath)
 	if err != nil {
 		webError(w, "ipfs cat "+escapedURLPath, err, http.StatusNotFound)
@@ -230,9 +306,22 @@ func (i *gatewayHandler) getOrHeadHandler(w http.ResponseWriter, r *http.Request
 	// we need to figure out whether this is a directory before doing most of the heavy lifting below
 	_, ok := dr.(files.Directory)
 
-	if ok && assets.BindataVersionHash != "" {
-		responseEtag = `"DirIndex-` + assets.BindataVersionHash + `_CID-` + resolvedPath.Cid().String() + `"`
-	} else {
+	var listing listingParams
+	jsonListing := ok && wantsJSONListing(r)
+	if ok {
+		listing, err = parseListingParams(r.URL.Query())
+		if err != nil {
+			webError(w, "failed to list "+escapedURLPath, err, http.StatusBadRequest)
+			return
+		}
+	}
+
+	switch {
+	case jsonListing:
+		responseEtag = `"DirIndex-json_CID-` + resolvedPath.Cid().String() + listing.etagSuffix() + `"`
+	case ok && assets.BindataVersionHash != "":
+		responseEtag = `"DirIndex-` + assets.BindataVersionHash + `_CID-` + resolvedPath.Cid().String() + listing.etagSuffix() + `"`
+	default:
 		responseEtag = `"` + resolvedPath.Cid().String() + `"`
 	}
 
@@ -283,6 +372,11 @@ func (i *gatewayHandler) getOrHeadHandler(w http.ResponseWriter, r *http.Request
 		return
 	}
 
+	if jsonListing {
+		i.serveJSONListing(w, r, resolvedPath, originalUrlPath, listing, i.responseCacheKey(r, responseEtag, originalUrlPath))
+		return
+	}
+
 	idx, err := i.api.Unixfs().Get(r.Context(), ipath.Join(resolvedPath, "index.html"))
 	switch err.(type) {
 	case nil:
@@ -326,36 +420,34 @@ func (i *gatewayHandler) getOrHeadHandler(w http.ResponseWriter, r *http.Request
 		return
 	}
 
-	// storage for directory listing
-	var dirListing []directoryItem
-	dirit := dir.Entries()
-	for dirit.Next() {
-		size := "?"
-		if s, err := dirit.Node().Size(); err == nil {
-			// Size may not be defined/supported. Continue anyways.
-			size = humanize.Bytes(uint64(s))
-		}
+	cacheKey := i.responseCacheKey(r, responseEtag, originalUrlPath)
+	if i.cache.serveCachedResponse(w, cacheKey) {
+		return
+	}
 
-		hash := ""
-		if r, err := i.api.ResolvePath(r.Context(), ipath.Join(resolvedPath, dirit.Name())); err == nil {
-			// Path may not be resolved. Continue anyways.
-			hash = r.Cid().String()
-		}
+	page, nextCursor, err := i.listDirectory(r.Context(), resolvedPath, listing)
+	if err != nil {
+		listingError(w, escapedURLPath, err)
+		return
+	}
+	if nextCursor != "" {
+		w.Header().Set("Link", fmt.Sprintf("<?%s>; rel=\"next\"", nextPageQuery(r, nextCursor)))
+	}
 
+	// storage for directory listing
+	dirListing := make([]directoryItem, 0, len(page))
+	for _, e := range page {
 		// See comment above where originalUrlPath is declared.
+		hash := e.Cid.String()
 		di := directoryItem{
-			Size:      size,
-			Name:      dirit.Name(),
-			Path:      gopath.Join(originalUrlPath, dirit.Name()),
+			Size:      humanize.Bytes(e.TSize),
+			Name:      e.Name,
+			Path:      gopath.Join(originalUrlPath, e.Name),
 			Hash:      hash,
 			ShortHash: shortHash(hash),
 		}
 		dirListing = append(dirListing, di)
 	}
-	if dirit.Err() != nil {
-		internalWebError(w, dirit.Err())
-		return
-	}
 
 	// construct the correct back link
 	// https://github.com/ipfs/go-ipfs/issues/1365
@@ -409,11 +501,36 @@ func (i *gatewayHandler) getOrHeadHandler(w http.ResponseWriter, r *http.Request
 		Hash:        hash,
 	}
 
-	err = listingTemplate.Execute(w, tplData)
+	var buf bytes.Buffer
+	err = listingTemplate.Execute(&buf, tplData)
 	if err != nil {
 		internalWebError(w, err)
 		return
 	}
+	i.cache.addResponse(cacheKey, w.Header(), buf.Bytes())
+	_, _ = w.Write(buf.Bytes())
+}
+
+// customResponseFormat returns the explicitly requested response format,
+// either from the format query parameter or the Accept header. Archives can
+// only be requested through the query parameter. An empty string means the
+// regular UnixFS response.
+func customResponseFormat(r *http.Request) string {
+	if format := r.URL.Query().Get("format"); format != "" {
+		return format
+	}
+	for _, accept := range r.Header.Values("Accept") {
+		for _, spec := range strings.Split(accept, ",") {
+			mediaType := strings.TrimSpace(strings.SplitN(spec, ";", 2)[0])
+			switch mediaType {
+			case carContentType:
+				return carResponseFormat
+			case rawContentType:
+				return rawResponseFormat
+			}
+		}
+	}
+	return ""
 }
 
 func (i *gatewayHandler) serveFile(w http.ResponseWriter, req *http.Request, name string, modtime time.Time, file files.File) {
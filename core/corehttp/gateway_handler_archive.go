@@ -0,0 +1,201 @@
+package corehttp
+
+import (
+	"archive/zip"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"net/url"
+	"os"
+	gopath "path"
+	"strings"
+
+	files "github.com/ipfs/go-ipfs-files"
+	unixfile "github.com/ipfs/go-unixfs/file"
+	ipath "github.com/ipfs/interface-go-ipfs-core/path"
+)
+
+// serveArchive streams the file or directory at resolvedPath as a TAR or ZIP
+// archive, so whole directories can be downloaded in one go.
+func (i *gatewayHandler) serveArchive(w http.ResponseWriter, r *http.Request, resolvedPath ipath.Resolved, urlPath string, format string) {
+	rootCid := resolvedPath.Cid()
+	responseEtag := `"` + rootCid.String() + `.` + format + `"`
+
+	if r.Header.Get("If-None-Match") == responseEtag || r.Header.Get("If-None-Match") == `W/`+responseEtag {
+		w.WriteHeader(http.StatusNotModified)
+		return
+	}
+
+	root, err := i.api.ResolveNode(r.Context(), resolvedPath)
+	if err != nil {
+		webError(w, "ipfs get "+urlPath, err, http.StatusNotFound)
+		return
+	}
+	nd, err := unixfile.NewUnixfsFile(r.Context(), i.exportDAG(r.Context()), root)
+	if err != nil {
+		webError(w, "ipfs get "+urlPath, err, http.StatusNotFound)
+		return
+	}
+	defer nd.Close()
+
+	name := archiveName(r, urlPath, rootCid.String())
+	filename := name + "." + format
+	utf8Name := url.PathEscape(filename)
+	asciiName := url.PathEscape(onlyAscii.ReplaceAllLiteralString(filename, "_"))
+
+	i.addUserHeaders(w)
+	w.Header().Set("X-IPFS-Path", urlPath)
+	w.Header().Set("Etag", responseEtag)
+	if strings.HasPrefix(urlPath, ipfsPathPrefix) {
+		w.Header().Set("Cache-Control", "public, max-age=29030400, immutable")
+	}
+	switch format {
+	case tarResponseFormat:
+		w.Header().Set("Content-Type", tarContentType)
+	case zipResponseFormat:
+		w.Header().Set("Content-Type", zipContentType)
+	}
+	w.Header().Set("Content-Disposition", fmt.Sprintf("attachment; filename=\"%s\"; filename*=UTF-8''%s", asciiName, utf8Name))
+	w.Header().Set("X-Content-Type-Options", "nosniff")
+
+	if r.Method == http.MethodHead {
+		return
+	}
+
+	// Like CAR exports the archive is streamed, so an error after the first
+	// entry can only truncate the response.
+	switch format {
+	case tarResponseFormat:
+		err = writeTar(w, safeNode(nd), name)
+	case zipResponseFormat:
+		err = writeZip(w, safeNode(nd), name)
+	}
+	if err != nil {
+		log.Warnf("failed to write %s archive for %s: %s", format, urlPath, err)
+	}
+}
+
+// archiveName returns the name of the archive root: the filename query
+// parameter without its archive extension, the last path segment, or the CID.
+func archiveName(r *http.Request, urlPath string, rootCid string) string {
+	name := r.URL.Query().Get("filename")
+	if name != "" {
+		name = strings.TrimSuffix(strings.TrimSuffix(name, ".tar"), ".zip")
+	} else {
+		name = getFilename(urlPath)
+	}
+	if !validArchiveName(name) {
+		name = rootCid
+	}
+	return name
+}
+
+// validArchiveName reports whether name can be used as a single archive
+// path segment. Names that could escape the extraction directory when the
+// archive is unpacked are refused.
+func validArchiveName(name string) bool {
+	return name != "" && name != "." && name != ".." && !strings.ContainsAny(name, "/\\\x00")
+}
+
+// errArchiveName is returned when a directory entry can't be stored in an
+// archive without escaping its root.
+var errArchiveName = errors.New("directory entry name is not safe for archives")
+
+// safeNode wraps directories so that iterating them fails on entry names
+// that aren't valid archive path segments.
+func safeNode(nd files.Node) files.Node {
+	if dir, ok := nd.(files.Directory); ok {
+		return &safeDirectory{dir}
+	}
+	return nd
+}
+
+type safeDirectory struct {
+	files.Directory
+}
+
+func (d *safeDirectory) Entries() files.DirIterator {
+	return &safeIterator{DirIterator: d.Directory.Entries()}
+}
+
+type safeIterator struct {
+	files.DirIterator
+	err error
+}
+
+func (it *safeIterator) Next() bool {
+	if it.err != nil || !it.DirIterator.Next() {
+		return false
+	}
+	if !validArchiveName(it.Name()) {
+		it.err = fmt.Errorf("%w: %q", errArchiveName, it.Name())
+		return false
+	}
+	return true
+}
+
+func (it *safeIterator) Node() files.Node {
+	return safeNode(it.DirIterator.Node())
+}
+
+func (it *safeIterator) Err() error {
+	if it.err != nil {
+		return it.err
+	}
+	return it.DirIterator.Err()
+}
+
+// writeTar uses the same TAR writer as 'ipfs get --archive'.
+func writeTar(w io.Writer, nd files.Node, name string) error {
+	tw, err := files.NewTarWriter(w)
+	if err != nil {
+		return err
+	}
+	if err := tw.WriteFile(nd, name); err != nil {
+		return err
+	}
+	return tw.Close()
+}
+
+func writeZip(w io.Writer, nd files.Node, name string) error {
+	zw := zip.NewWriter(w)
+	if err := writeZipNode(zw, nd, name); err != nil {
+		return err
+	}
+	return zw.Close()
+}
+
+func writeZipNode(zw *zip.Writer, nd files.Node, fpath string) error {
+	switch nd := nd.(type) {
+	case *files.Symlink:
+		hdr := &zip.FileHeader{Name: fpath, Method: zip.Store}
+		hdr.SetMode(0777 | os.ModeSymlink)
+		fw, err := zw.CreateHeader(hdr)
+		if err != nil {
+			return err
+		}
+		_, err = io.WriteString(fw, nd.Target)
+		return err
+	case files.File:
+		fw, err := zw.CreateHeader(&zip.FileHeader{Name: fpath, Method: zip.Deflate})
+		if err != nil {
+			return err
+		}
+		_, err = io.Copy(fw, nd)
+		return err
+	case files.Directory:
+		if _, err := zw.Create(fpath + "/"); err != nil {
+			return err
+		}
+		it := nd.Entries()
+		for it.Next() {
+			if err := writeZipNode(zw, it.Node(), gopath.Join(fpath, it.Name())); err != nil {
+				return err
+			}
+		}
+		return it.Err()
+	default:
+		return fmt.Errorf("file type %T at %q is not supported", nd, fpath)
+	}
+}
@@ -0,0 +1,54 @@
+package corehttp
+
+import (
+	"context"
+	"fmt"
+	"net/http"
+	"strings"
+
+	"github.com/ipfs/go-cid"
+	ipld "github.com/ipfs/go-ipld-format"
+	dag "github.com/ipfs/go-merkledag"
+	ipath "github.com/ipfs/interface-go-ipfs-core/path"
+	gocar "github.com/ipld/go-car"
+)
+
+// serveCar streams the whole DAG below resolvedPath as a CARv1.
+func (i *gatewayHandler) serveCar(w http.ResponseWriter, r *http.Request, resolvedPath ipath.Resolved, urlPath string) {
+	rootCid := resolvedPath.Cid()
+	responseEtag := `"` + rootCid.String() + `.car"`
+
+	if r.Header.Get("If-None-Match") == responseEtag || r.Header.Get("If-None-Match") == `W/`+responseEtag {
+		w.WriteHeader(http.StatusNotModified)
+		return
+	}
+
+	i.addUserHeaders(w)
+	w.Header().Set("X-IPFS-Path", urlPath)
+	w.Header().Set("Etag", responseEtag)
+	if strings.HasPrefix(urlPath, ipfsPathPrefix) {
+		w.Header().Set("Cache-Control", "public, max-age=29030400, immutable")
+	}
+	w.Header().Set("Content-Type", carContentType+"; version=1")
+	w.Header().Set("Content-Disposition", fmt.Sprintf("attachment; filename=\"%s.car\"", rootCid.String()))
+	w.Header().Set("X-Content-Type-Options", "nosniff")
+
+	if r.Method == http.MethodHead {
+		return
+	}
+
+	// The status code is sent with the first block, a failure later on
+	// leaves a truncated CAR which clients detect when verifying it. Denied
+	// nodes below the root fail the export like missing ones.
+	ctx := r.Context()
+	err := gocar.WriteCar(ctx, i.exportDAG(ctx), []cid.Cid{rootCid}, w)
+	if err != nil {
+		log.Warnf("failed to write car for %s: %s", urlPath, err)
+	}
+}
+
+// exportDAG returns a read-only session DAG for streaming exports which
+// refuses the nodes denied by the gateway denylist.
+func (i *gatewayHandler) exportDAG(ctx context.Context) ipld.DAGService {
+	return i.denylist.DAGService(dag.NewReadOnlyDagService(dag.NewSession(ctx, i.api.Dag())))
+}
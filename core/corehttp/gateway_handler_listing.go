@@ -0,0 +1,352 @@
+package corehttp
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/http"
+	"net/url"
+	gopath "path"
+	"sort"
+	"strconv"
+	"strings"
+
+	"github.com/ipfs/go-cid"
+	ipld "github.com/ipfs/go-ipld-format"
+	uio "github.com/ipfs/go-unixfs/io"
+	ipath "github.com/ipfs/interface-go-ipfs-core/path"
+)
+
+const (
+	// defaultListingLimit is the number of entries of a directory listing
+	// page when no limit is requested.
+	defaultListingLimit = 1000
+	maxListingLimit     = 10000
+
+	jsonContentType = "application/json"
+)
+
+var errInvalidCursor = errors.New("cursor is not an entry of this directory")
+
+// listingParams selects a page of a directory listing.
+type listingParams struct {
+	// cursor is the name of the last entry of the previous page.
+	cursor string
+	limit  int
+	// sortBy is "name" or "size".
+	sortBy string
+	desc   bool
+}
+
+func parseListingParams(q url.Values) (listingParams, error) {
+	p := listingParams{
+		cursor: q.Get("cursor"),
+		limit:  defaultListingLimit,
+		sortBy: "name",
+	}
+	if limit := q.Get("limit"); limit != "" {
+		n, err := strconv.Atoi(limit)
+		if err != nil || n <= 0 {
+			return p, fmt.Errorf("invalid limit %q", limit)
+		}
+		if n > maxListingLimit {
+			n = maxListingLimit
+		}
+		p.limit = n
+	}
+	switch sortBy := q.Get("sort"); sortBy {
+	case "", "name":
+	case "size":
+		p.sortBy = sortBy
+	default:
+		return p, fmt.Errorf("invalid sort %q, expected name or size", sortBy)
+	}
+	switch order := q.Get("order"); order {
+	case "", "asc":
+	case "desc":
+		p.desc = true
+	default:
+		return p, fmt.Errorf("invalid order %q, expected asc or desc", order)
+	}
+	return p, nil
+}
+
+// etagSuffix distinguishes the ETags of the different pages and orders of
+// the same directory.
+func (p listingParams) etagSuffix() string {
+	if p.cursor == "" && p.limit == defaultListingLimit && p.sortBy == "name" && !p.desc {
+		return ""
+	}
+	return fmt.Sprintf("_%s-%t-%d-%s", p.sortBy, p.desc, p.limit, url.PathEscape(p.cursor))
+}
+
+// nextPageQuery returns the query string of the page following cursor.
+func nextPageQuery(r *http.Request, cursor string) string {
+	q := r.URL.Query()
+	q.Set("cursor", cursor)
+	return q.Encode()
+}
+
+// wantsJSONListing reports whether the client asked for the directory listing
+// as JSON rather than HTML.
+func wantsJSONListing(r *http.Request) bool {
+	for _, accept := range r.Header.Values("Accept") {
+		for _, spec := range strings.Split(accept, ",") {
+			if strings.TrimSpace(strings.SplitN(spec, ";", 2)[0]) == jsonContentType {
+				return true
+			}
+		}
+	}
+	return false
+}
+
+// listingEntry is a directory link, sizes are the cumulative DAG sizes
+// recorded in the link.
+type listingEntry struct {
+	Name  string
+	Cid   cid.Cid
+	TSize uint64
+}
+
+// listingCacheSize is the number of sorted directory link lists kept so that
+// paging through a large directory doesn't enumerate it for every page.
+const listingCacheSize = 16
+
+// sortedListing is the link list of a directory in listing order, with the
+// position of every entry so that pages can resume from their cursor.
+type sortedListing struct {
+	entries []listingEntry
+	index   map[string]int
+}
+
+// errPageFull stops the enumeration of the links of a directory once a
+// page is complete.
+var errPageFull = errors.New("listing page is full")
+
+// listDirectory returns the page of the directory at resolvedPath selected
+// by p, and the cursor of the next page if there is one. Denied entries are
+// left out. Only the links of the directory are enumerated, entries
+// themselves are not fetched, so that large sharded directories can be paged
+// through.
+func (i *gatewayHandler) listDirectory(ctx context.Context, resolvedPath ipath.Resolved, p listingParams) ([]listingEntry, string, error) {
+	if p.sortBy == "name" && !p.desc {
+		return i.streamDirectory(ctx, resolvedPath, p)
+	}
+
+	sorted, err := i.sortedLinks(ctx, resolvedPath, p)
+	if err != nil {
+		return nil, "", err
+	}
+	entries := sorted.entries
+
+	start := 0
+	if p.cursor != "" {
+		idx, ok := sorted.index[p.cursor]
+		if !ok {
+			return nil, "", errInvalidCursor
+		}
+		start = idx + 1
+	}
+
+	// The denylist can change while the sorted links are cached, so denied
+	// entries are skipped here rather than when sorting.
+	var pg listingPage
+	for _, e := range entries[start:] {
+		if i.addToPage(&pg, e, p.limit) {
+			break
+		}
+	}
+	return pg.entries, pg.next, nil
+}
+
+// streamDirectory returns a page of the directory at resolvedPath in the
+// order of its links, which is the name order of regular directories, only
+// enumerating the links up to the end of the page. The links are walked in
+// order rather than with EnumLinksAsync, whose order changes from one
+// enumeration of a sharded directory to the next, so that cursors can be
+// resumed from.
+func (i *gatewayHandler) streamDirectory(ctx context.Context, resolvedPath ipath.Resolved, p listingParams) ([]listingEntry, string, error) {
+	dir, err := i.directory(ctx, resolvedPath)
+	if err != nil {
+		return nil, "", err
+	}
+
+	var pg listingPage
+	started := p.cursor == ""
+	err = dir.ForEachLink(ctx, func(l *ipld.Link) error {
+		if !started {
+			started = l.Name == p.cursor
+			return nil
+		}
+		if i.addToPage(&pg, listingEntry{Name: l.Name, Cid: l.Cid, TSize: l.Size}, p.limit) {
+			return errPageFull
+		}
+		return nil
+	})
+	switch {
+	case err == errPageFull:
+	case err != nil:
+		return nil, "", err
+	case !started:
+		return nil, "", errInvalidCursor
+	}
+	return pg.entries, pg.next, nil
+}
+
+// listingPage is a page of a directory listing being filled.
+type listingPage struct {
+	entries []listingEntry
+	// next is the cursor of the next page, set once an entry after the
+	// page is found.
+	next string
+}
+
+// addToPage adds e to pg unless it is denied, and reports whether pg is
+// complete. Once the page holds limit entries, the next entry which isn't
+// denied completes it with the cursor of the next page.
+func (i *gatewayHandler) addToPage(pg *listingPage, e listingEntry, limit int) bool {
+	if i.denylist.IsCidDenied(e.Cid) {
+		return false
+	}
+	if len(pg.entries) == limit {
+		pg.next = pg.entries[len(pg.entries)-1].Name
+		return true
+	}
+	pg.entries = append(pg.entries, e)
+	return false
+}
+
+// directory returns the unixfs directory at resolvedPath.
+func (i *gatewayHandler) directory(ctx context.Context, resolvedPath ipath.Resolved) (uio.Directory, error) {
+	nd, err := i.api.ResolveNode(ctx, resolvedPath)
+	if err != nil {
+		return nil, err
+	}
+	return uio.NewDirectoryFromNode(i.api.Dag(), nd)
+}
+
+// sortedLinks returns all the links of the directory at resolvedPath in the
+// order selected by p, for the orders which can't be listed as the links
+// are enumerated. Directories are immutable, so the result is cached by CID
+// and order.
+func (i *gatewayHandler) sortedLinks(ctx context.Context, resolvedPath ipath.Resolved, p listingParams) (*sortedListing, error) {
+	key := fmt.Sprintf("%s/%s/%t", resolvedPath.Cid(), p.sortBy, p.desc)
+	if v, ok := i.listings.Get(key); ok {
+		return v.(*sortedListing), nil
+	}
+
+	dir, err := i.directory(ctx, resolvedPath)
+	if err != nil {
+		return nil, err
+	}
+
+	var entries []listingEntry
+	for res := range dir.EnumLinksAsync(ctx) {
+		if res.Err != nil {
+			return nil, res.Err
+		}
+		entries = append(entries, listingEntry{
+			Name:  res.Link.Name,
+			Cid:   res.Link.Cid,
+			TSize: res.Link.Size,
+		})
+	}
+	if err := ctx.Err(); err != nil {
+		return nil, err
+	}
+
+	less := func(a, b listingEntry) bool {
+		if p.sortBy == "size" && a.TSize != b.TSize {
+			return a.TSize < b.TSize
+		}
+		return a.Name < b.Name
+	}
+	sort.Slice(entries, func(x, y int) bool {
+		if p.desc {
+			return less(entries[y], entries[x])
+		}
+		return less(entries[x], entries[y])
+	})
+
+	sorted := &sortedListing{
+		entries: entries,
+		index:   make(map[string]int, len(entries)),
+	}
+	for idx, e := range entries {
+		sorted.index[e.Name] = idx
+	}
+	i.listings.Add(key, sorted)
+	return sorted, nil
+}
+
+// listingError writes the error of listing a directory.
+func listingError(w http.ResponseWriter, urlPath string, err error) {
+	if err == errInvalidCursor {
+		webError(w, "failed to list "+urlPath, err, http.StatusBadRequest)
+		return
+	}
+	internalWebError(w, err)
+}
+
+// serveJSONListing writes a page of the directory at resolvedPath as JSON.
+func (i *gatewayHandler) serveJSONListing(w http.ResponseWriter, r *http.Request, resolvedPath ipath.Resolved, urlPath string, p listingParams, cacheKey string) {
+	w.Header().Set("Content-Type", jsonContentType)
+	if r.Method != http.MethodHead && i.cache.serveCachedResponse(w, cacheKey) {
+		return
+	}
+
+	page, nextCursor, err := i.listDirectory(r.Context(), resolvedPath, p)
+	if err != nil {
+		listingError(w, urlPath, err)
+		return
+	}
+
+	listing := directoryListingJSON{
+		Path:       urlPath,
+		Hash:       resolvedPath.Cid().String(),
+		Entries:    make([]directoryEntryJSON, 0, len(page)),
+		NextCursor: nextCursor,
+	}
+	for _, e := range page {
+		listing.Entries = append(listing.Entries, directoryEntryJSON{
+			Name: e.Name,
+			Path: gopath.Join(urlPath, e.Name),
+			Hash: e.Cid.String(),
+			Size: e.TSize,
+		})
+	}
+
+	if nextCursor != "" {
+		w.Header().Set("Link", fmt.Sprintf("<?%s>; rel=\"next\"", nextPageQuery(r, nextCursor)))
+	}
+	if r.Method == http.MethodHead {
+		return
+	}
+	var buf bytes.Buffer
+	if err := json.NewEncoder(&buf).Encode(listing); err != nil {
+		internalWebError(w, err)
+		return
+	}
+	i.cache.addResponse(cacheKey, w.Header(), buf.Bytes())
+	_, _ = w.Write(buf.Bytes())
+}
+
+// directoryListingJSON is the JSON representation of a directory listing page.
+type directoryListingJSON struct {
+	Path    string
+	Hash    string
+	Entries []directoryEntryJSON
+	// NextCursor is the cursor of the next page, empty on the last page.
+	NextCursor string `json:",omitempty"`
+}
+
+type directoryEntryJSON struct {
+	Name string
+	Path string
+	Hash string
+	// Size is the cumulative DAG size recorded in the link, entries are
+	// not fetched.
+	Size uint64
+}
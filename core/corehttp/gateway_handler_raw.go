@@ -0,0 +1,58 @@
+package corehttp
+
+import (
+	"bytes"
+	"fmt"
+	"io/ioutil"
+	"net/http"
+	"strings"
+	"time"
+
+	ipath "github.com/ipfs/interface-go-ipfs-core/path"
+)
+
+// serveRawBlock returns the exact bytes of the block resolvedPath points to.
+func (i *gatewayHandler) serveRawBlock(w http.ResponseWriter, r *http.Request, resolvedPath ipath.Resolved, urlPath string) {
+	blockCid := resolvedPath.Cid()
+	responseEtag := `"` + blockCid.String() + `.raw"`
+
+	if r.Header.Get("If-None-Match") == responseEtag || r.Header.Get("If-None-Match") == `W/`+responseEtag {
+		w.WriteHeader(http.StatusNotModified)
+		return
+	}
+
+	blockReader, err := i.api.Block().Get(r.Context(), resolvedPath)
+	if err != nil {
+		webError(w, "ipfs block get "+blockCid.String(), err, retrievalErrorCode(r))
+		return
+	}
+	block, err := ioutil.ReadAll(blockReader)
+	if err != nil {
+		webError(w, "ipfs block get "+blockCid.String(), err, retrievalErrorCode(r))
+		return
+	}
+
+	i.addUserHeaders(w)
+	w.Header().Set("X-IPFS-Path", urlPath)
+	w.Header().Set("Etag", responseEtag)
+	modtime := time.Now()
+	if strings.HasPrefix(urlPath, ipfsPathPrefix) {
+		w.Header().Set("Cache-Control", "public, max-age=29030400, immutable")
+		modtime = time.Unix(1, 0)
+	}
+	w.Header().Set("Content-Type", rawContentType)
+	w.Header().Set("Content-Disposition", fmt.Sprintf("attachment; filename=\"%s.bin\"", blockCid.String()))
+	w.Header().Set("X-Content-Type-Options", "nosniff")
+
+	http.ServeContent(w, r, blockCid.String()+".bin", modtime, bytes.NewReader(block))
+}
+
+// retrievalErrorCode returns the status of a request which failed to
+// retrieve its content: 504 when the retrieval was cancelled or timed out,
+// 404 otherwise.
+func retrievalErrorCode(r *http.Request) int {
+	if r.Context().Err() != nil {
+		return http.StatusGatewayTimeout
+	}
+	return http.StatusNotFound
+}
@@ -0,0 +1,229 @@
+package corehttp
+
+import (
+	"bufio"
+	"fmt"
+	"io"
+	"mime"
+	"net/http"
+	gopath "path"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+
+	files "github.com/ipfs/go-ipfs-files"
+	ipath "github.com/ipfs/interface-go-ipfs-core/path"
+)
+
+// redirectsFilename is the file at the root of a website holding its
+// redirect rules, in the format used by Netlify.
+const redirectsFilename = "_redirects"
+
+// maxRedirectsFileSize bounds the size of a _redirects file read on a request.
+const maxRedirectsFileSize = 64 << 10
+
+// redirectRule is a single line of a _redirects file.
+type redirectRule struct {
+	from   string
+	to     string
+	status int
+}
+
+// parseRedirects reads the rules of a _redirects file. Every line holds the
+// source path, the target and an optional status code, defaulting to 301.
+// Empty lines and lines starting with # are ignored.
+func parseRedirects(r io.Reader) ([]redirectRule, error) {
+	var rules []redirectRule
+	scanner := bufio.NewScanner(r)
+	for n := 1; scanner.Scan(); n++ {
+		line := strings.TrimSpace(scanner.Text())
+		if line == "" || strings.HasPrefix(line, "#") {
+			continue
+		}
+		fields := strings.Fields(line)
+		if len(fields) < 2 || len(fields) > 3 {
+			return nil, fmt.Errorf("line %d: expected a source, a target and an optional status", n)
+		}
+		rule := redirectRule{from: fields[0], to: fields[1], status: http.StatusMovedPermanently}
+		if !strings.HasPrefix(rule.from, "/") {
+			return nil, fmt.Errorf("line %d: source %q must be an absolute path", n, rule.from)
+		}
+		if len(fields) == 3 {
+			status, err := strconv.Atoi(fields[2])
+			if err != nil {
+				return nil, fmt.Errorf("line %d: invalid status %q", n, fields[2])
+			}
+			switch status {
+			case http.StatusOK, http.StatusNotFound:
+				if !strings.HasPrefix(rule.to, "/") {
+					return nil, fmt.Errorf("line %d: target of a %d rule must be a path", n, status)
+				}
+			case http.StatusMovedPermanently, http.StatusFound, http.StatusSeeOther,
+				http.StatusTemporaryRedirect, http.StatusPermanentRedirect:
+			default:
+				return nil, fmt.Errorf("line %d: unsupported status %d", n, status)
+			}
+			rule.status = status
+		}
+		rules = append(rules, rule)
+	}
+	return rules, scanner.Err()
+}
+
+// match returns the target of the rule for urlPath. Segments of the source
+// starting with a colon are placeholders matching a single segment, a
+// trailing * matches the rest of the path. Both are substituted in the
+// target, the rest of the path as :splat.
+func (rule redirectRule) match(urlPath string) (string, bool) {
+	from := splitRedirectPath(rule.from)
+	segs := splitRedirectPath(urlPath)
+
+	values := make(map[string]string)
+	splat := len(from) > 0 && from[len(from)-1] == "*"
+	if splat {
+		from = from[:len(from)-1]
+		if len(segs) < len(from) {
+			return "", false
+		}
+		values["splat"] = strings.Join(segs[len(from):], "/")
+		segs = segs[:len(from)]
+	}
+	if len(segs) != len(from) {
+		return "", false
+	}
+	for n, seg := range from {
+		switch {
+		case strings.HasPrefix(seg, ":") && len(seg) > 1:
+			values[seg[1:]] = segs[n]
+		case seg != segs[n]:
+			return "", false
+		}
+	}
+
+	// Replace longer placeholders first, so :id does not clobber :ids.
+	names := make([]string, 0, len(values))
+	for name := range values {
+		names = append(names, name)
+	}
+	sort.Slice(names, func(a, b int) bool { return len(names[a]) > len(names[b]) })
+	pairs := make([]string, 0, 2*len(names))
+	for _, name := range names {
+		pairs = append(pairs, ":"+name, values[name])
+	}
+	return strings.NewReplacer(pairs...).Replace(rule.to), true
+}
+
+func splitRedirectPath(p string) []string {
+	p = strings.Trim(p, "/")
+	if p == "" {
+		return nil
+	}
+	return strings.Split(p, "/")
+}
+
+// siteRoot returns the /ipfs/<cid> or /ipns/<name> root of urlPath when the
+// request was made against a subdomain or DNSLink origin, where requestPath
+// is the path relative to the website root.
+func siteRoot(urlPath, requestPath string) (string, bool) {
+	segs := strings.SplitN(urlPath, "/", 4)
+	if len(segs) < 3 || (segs[1] != "ipfs" && segs[1] != "ipns") {
+		return "", false
+	}
+	root := "/" + segs[1] + "/" + segs[2]
+	if strings.HasPrefix(requestPath, root) || root+requestPath != urlPath {
+		return "", false
+	}
+	return root, true
+}
+
+// serveRedirectsIfPresent applies the first matching rule of the _redirects
+// file at the website root to a path which does not exist. It reports
+// whether a response was written.
+func (i *gatewayHandler) serveRedirectsIfPresent(w http.ResponseWriter, r *http.Request, urlPath, requestPath string) bool {
+	root, ok := siteRoot(urlPath, requestPath)
+	if !ok {
+		return false
+	}
+
+	nd, err := i.api.Unixfs().Get(r.Context(), ipath.Join(ipath.New(root), redirectsFilename))
+	if err != nil {
+		return false
+	}
+	defer nd.Close()
+	f, ok := nd.(files.File)
+	if !ok {
+		return false
+	}
+	rules, err := parseRedirects(io.LimitReader(f, maxRedirectsFileSize))
+	if err != nil {
+		webError(w, "failed to parse "+redirectsFilename, err, http.StatusInternalServerError)
+		return true
+	}
+
+	for _, rule := range rules {
+		to, ok := rule.match(requestPath)
+		if !ok {
+			continue
+		}
+		log.Debugf("%s rule %s -> %s matched %s", redirectsFilename, rule.from, to, urlPath)
+		switch rule.status {
+		case http.StatusOK, http.StatusNotFound:
+			if i.serveRewrite(w, r, gopath.Join(root, to), rule.status) {
+				return true
+			}
+		default:
+			if r.URL.RawQuery != "" && !strings.Contains(to, "?") {
+				to += "?" + r.URL.RawQuery
+			}
+			http.Redirect(w, r, to, rule.status)
+			return true
+		}
+	}
+	return false
+}
+
+// serveRewrite serves the file at target, or the index.html of the
+// directory at target, with the given status.
+func (i *gatewayHandler) serveRewrite(w http.ResponseWriter, r *http.Request, target string, status int) bool {
+	nd, err := i.api.Unixfs().Get(r.Context(), ipath.New(target))
+	if err != nil {
+		return false
+	}
+	defer nd.Close()
+	if _, ok := nd.(files.Directory); ok {
+		target = gopath.Join(target, "index.html")
+		idx, err := i.api.Unixfs().Get(r.Context(), ipath.New(target))
+		if err != nil {
+			return false
+		}
+		defer idx.Close()
+		nd = idx
+	}
+	f, ok := nd.(files.File)
+	if !ok {
+		return false
+	}
+
+	i.addUserHeaders(w)
+	if status == http.StatusOK {
+		i.serveFile(w, r, gopath.Base(target), time.Now(), f)
+		return true
+	}
+
+	size, err := f.Size()
+	if err != nil {
+		return false
+	}
+	ctype := mime.TypeByExtension(gopath.Ext(target))
+	if ctype == "" {
+		ctype = "text/html"
+	}
+	w.Header().Set("Content-Type", ctype)
+	w.Header().Set("Content-Length", strconv.FormatInt(size, 10))
+	w.WriteHeader(status)
+	if r.Method != http.MethodHead {
+		_, _ = io.CopyN(w, f, size)
+	}
+	return true
+}
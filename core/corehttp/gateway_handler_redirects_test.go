@@ -0,0 +1,80 @@
+package corehttp
+
+import (
+	"strings"
+	"testing"
+)
+
+func TestParseRedirects(t *testing.T) {
+	rules, err := parseRedirects(strings.NewReader(`
+# comment
+/old /new
+/blog/:year/:month/:slug /posts/:year-:month/:slug 302
+/app/* /app/index.html 200
+/missing/* /404.html 404
+`))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(rules) != 4 {
+		t.Fatalf("expected 4 rules, got %d", len(rules))
+	}
+	if rules[0].status != 301 || rules[1].status != 302 || rules[2].status != 200 || rules[3].status != 404 {
+		t.Fatalf("unexpected statuses: %v", rules)
+	}
+
+	for _, bad := range []string{
+		"/only-source",
+		"relative /target",
+		"/a /b 418",
+		"/a https://example.com 200",
+		"/a /b 301 extra",
+	} {
+		if _, err := parseRedirects(strings.NewReader(bad)); err == nil {
+			t.Errorf("expected %q to fail to parse", bad)
+		}
+	}
+}
+
+func TestRedirectRuleMatch(t *testing.T) {
+	for _, tc := range []struct {
+		from, to, path string
+		expected       string
+		match          bool
+	}{
+		{"/old", "/new", "/old", "/new", true},
+		{"/old", "/new", "/old/", "/new", true},
+		{"/old", "/new", "/older", "", false},
+		{"/blog/:year/:slug", "/posts/:year-:slug", "/blog/2020/hello", "/posts/2020-hello", true},
+		{"/blog/:year/:slug", "/posts/:year-:slug", "/blog/2020", "", false},
+		{"/:id/:ids", "/x/:ids/:id", "/a/b", "/x/b/a", true},
+		{"/app/*", "/index.html", "/app/some/route", "/index.html", true},
+		{"/app/*", "/index.html", "/app", "/index.html", true},
+		{"/docs/*", "https://example.com/:splat", "/docs/a/b", "https://example.com/a/b", true},
+		{"/docs/*", "/new/:splat", "/other/a", "", false},
+		{"/*", "/index.html", "/anything/at/all", "/index.html", true},
+	} {
+		rule := redirectRule{from: tc.from, to: tc.to}
+		to, ok := rule.match(tc.path)
+		if ok != tc.match || to != tc.expected {
+			t.Errorf("%s -> %s on %s: got %q, %t, expected %q, %t", tc.from, tc.to, tc.path, to, ok, tc.expected, tc.match)
+		}
+	}
+}
+
+func TestSiteRoot(t *testing.T) {
+	for _, tc := range []struct {
+		urlPath, requestPath, root string
+		ok                         bool
+	}{
+		{"/ipns/example.net/foo", "/foo", "/ipns/example.net", true},
+		{"/ipfs/bafy/foo/bar", "/foo/bar", "/ipfs/bafy", true},
+		{"/ipfs/bafy/foo", "/ipfs/bafy/foo", "", false},
+		{"/ipns/example.net/foo", "/bar", "", false},
+	} {
+		root, ok := siteRoot(tc.urlPath, tc.requestPath)
+		if root != tc.root || ok != tc.ok {
+			t.Errorf("siteRoot(%q, %q) = %q, %t", tc.urlPath, tc.requestPath, root, ok)
+		}
+	}
+}
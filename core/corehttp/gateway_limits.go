@@ -0,0 +1,282 @@
+package corehttp
+
+import (
+	"context"
+	"fmt"
+	"math"
+	"net"
+	"net/http"
+	"strconv"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"github.com/hashicorp/golang-lru/simplelru"
+	"github.com/ipfs/go-cid"
+	core "github.com/ipfs/go-ipfs/core"
+)
+
+// GatewayRateLimit configures a token bucket: Rate requests per second are
+// allowed on average, with bursts of up to Burst requests. A zero Rate
+// disables the limit.
+type GatewayRateLimit struct {
+	Rate  float64
+	Burst int
+}
+
+// GatewayRateLimits holds the rate limits applied to gateway requests.
+type GatewayRateLimits struct {
+	// PerIP limits the requests of every client IP.
+	PerIP GatewayRateLimit
+	// PerCID limits the requests for every /ipfs/<cid> or /ipns/<name> root.
+	PerCID GatewayRateLimit
+}
+
+// maxBuckets bounds the number of rate limit buckets kept per limiter, the
+// least recently used ones are dropped first.
+const maxBuckets = 10000
+
+// GatewayLimitsOption bounds the resources taken by gateway requests with the
+// Gateway.RetrievalTimeout, Gateway.MaxConcurrentRequests and
+// Gateway.RateLimit config keys. It has to be registered before the
+// GatewayOption it protects.
+func GatewayLimitsOption() ServeOption {
+	return func(n *core.IpfsNode, _ net.Listener, mux *http.ServeMux) (*http.ServeMux, error) {
+		ext, err := loadGatewayExtConfig(n.Repo)
+		if err != nil {
+			return nil, err
+		}
+
+		var timeout time.Duration
+		if ext.RetrievalTimeout != "" {
+			timeout, err = time.ParseDuration(ext.RetrievalTimeout)
+			if err != nil {
+				return nil, fmt.Errorf("invalid Gateway.RetrievalTimeout: %s", err)
+			}
+		}
+		if timeout == 0 && ext.MaxConcurrentRequests <= 0 &&
+			ext.RateLimit.PerIP.Rate <= 0 && ext.RateLimit.PerCID.Rate <= 0 {
+			return mux, nil
+		}
+
+		limits := &gatewayLimits{
+			timeout: timeout,
+			perIP:   newRateLimiter(ext.RateLimit.PerIP),
+			perCID:  newRateLimiter(ext.RateLimit.PerCID),
+		}
+		if ext.MaxConcurrentRequests > 0 {
+			limits.slots = make(chan struct{}, ext.MaxConcurrentRequests)
+		}
+
+		childMux := http.NewServeMux()
+		mux.Handle("/", limits.handler(childMux))
+		return childMux, nil
+	}
+}
+
+type gatewayLimits struct {
+	timeout time.Duration
+	slots   chan struct{}
+	perIP   *rateLimiter
+	perCID  *rateLimiter
+}
+
+func (l *gatewayLimits) handler(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if ip := clientIP(r); !l.perIP.allow(ip) {
+			tooManyRequests(w, l.perIP, "too many requests from "+ip)
+			return
+		}
+		if root := requestRoot(r.URL.Path); root != "" && !l.perCID.allow(root) {
+			tooManyRequests(w, l.perCID, "too many requests for "+r.URL.Path)
+			return
+		}
+
+		ctx, cancel := context.WithCancel(r.Context())
+		defer cancel()
+		tw := &timeoutWriter{ResponseWriter: w}
+		if l.timeout > 0 {
+			tw.timeout = l.timeout
+			tw.timer = time.AfterFunc(l.timeout, func() {
+				atomic.StoreInt32(&tw.expired, 1)
+				cancel()
+			})
+			defer tw.timer.Stop()
+		}
+
+		if l.slots != nil {
+			select {
+			case l.slots <- struct{}{}:
+				defer func() { <-l.slots }()
+			case <-ctx.Done():
+				if tw.timedOut() {
+					tw.gatewayTimeout()
+				}
+				return
+			}
+		}
+
+		next.ServeHTTP(tw, r.WithContext(ctx))
+		if !tw.wroteHeader && tw.timedOut() {
+			tw.gatewayTimeout()
+		}
+	})
+}
+
+func tooManyRequests(w http.ResponseWriter, rl *rateLimiter, msg string) {
+	w.Header().Set("Retry-After", strconv.Itoa(rl.retryAfter()))
+	http.Error(w, msg, http.StatusTooManyRequests)
+}
+
+// clientIP returns the IP of the client of r.
+func clientIP(r *http.Request) string {
+	host, _, err := net.SplitHostPort(r.RemoteAddr)
+	if err != nil {
+		return r.RemoteAddr
+	}
+	return host
+}
+
+// requestRoot returns the content root a gateway request is for, with CIDs
+// normalized to their multihash so all encodings share a bucket.
+func requestRoot(urlPath string) string {
+	segs := strings.SplitN(urlPath, "/", 4)
+	if len(segs) < 3 || segs[2] == "" {
+		return ""
+	}
+	switch segs[1] {
+	case "ipfs":
+		if c, err := cid.Decode(segs[2]); err == nil {
+			return "/ipfs/" + c.Hash().B58String()
+		}
+		return "/ipfs/" + segs[2]
+	case "ipns":
+		return "/ipns/" + segs[2]
+	default:
+		return ""
+	}
+}
+
+// timeoutWriter turns the response of a request which made no progress for
+// timeout into a 504. The timeout restarts on every write, so long downloads
+// are only cut off when they stall.
+type timeoutWriter struct {
+	http.ResponseWriter
+	timeout time.Duration
+	timer   *time.Timer
+	expired int32
+
+	wroteHeader bool
+	discard     bool
+}
+
+func (tw *timeoutWriter) timedOut() bool {
+	return atomic.LoadInt32(&tw.expired) == 1
+}
+
+func (tw *timeoutWriter) gatewayTimeout() {
+	tw.wroteHeader = true
+	tw.discard = true
+	http.Error(tw.ResponseWriter, "gateway timeout: the content could not be retrieved in time", http.StatusGatewayTimeout)
+}
+
+func (tw *timeoutWriter) WriteHeader(code int) {
+	if tw.wroteHeader {
+		return
+	}
+	// Errors caused by the cancelled retrieval are reported as a timeout.
+	if code >= http.StatusBadRequest && tw.timedOut() {
+		tw.gatewayTimeout()
+		return
+	}
+	tw.wroteHeader = true
+	if tw.timer != nil && !tw.timedOut() {
+		tw.timer.Reset(tw.timeout)
+	}
+	tw.ResponseWriter.WriteHeader(code)
+}
+
+func (tw *timeoutWriter) Write(b []byte) (int, error) {
+	if !tw.wroteHeader {
+		tw.WriteHeader(http.StatusOK)
+	}
+	if tw.discard {
+		return len(b), nil
+	}
+	if tw.timer != nil && !tw.timedOut() {
+		tw.timer.Reset(tw.timeout)
+	}
+	return tw.ResponseWriter.Write(b)
+}
+
+func (tw *timeoutWriter) Flush() {
+	if f, ok := tw.ResponseWriter.(http.Flusher); ok {
+		f.Flush()
+	}
+}
+
+// rateLimiter keeps a token bucket per key.
+type rateLimiter struct {
+	rate  float64
+	burst float64
+
+	lock sync.Mutex
+	// buckets holds a *bucket per key. Dropped buckets start over full, like
+	// idle ones do.
+	buckets *simplelru.LRU
+}
+
+type bucket struct {
+	tokens float64
+	last   time.Time
+}
+
+// newRateLimiter returns nil when the limit is disabled, which allows all
+// requests.
+func newRateLimiter(cfg GatewayRateLimit) *rateLimiter {
+	if cfg.Rate <= 0 {
+		return nil
+	}
+	burst := float64(cfg.Burst)
+	if burst < 1 {
+		burst = math.Max(1, cfg.Rate)
+	}
+	// NewLRU only fails for sizes below one.
+	buckets, _ := simplelru.NewLRU(maxBuckets, nil)
+	return &rateLimiter{
+		rate:    cfg.Rate,
+		burst:   burst,
+		buckets: buckets,
+	}
+}
+
+func (rl *rateLimiter) allow(key string) bool {
+	if rl == nil {
+		return true
+	}
+	now := time.Now()
+
+	rl.lock.Lock()
+	defer rl.lock.Unlock()
+
+	var b *bucket
+	if v, ok := rl.buckets.Get(key); ok {
+		b = v.(*bucket)
+	} else {
+		b = &bucket{tokens: rl.burst, last: now}
+		rl.buckets.Add(key, b)
+	}
+	b.tokens = math.Min(rl.burst, b.tokens+now.Sub(b.last).Seconds()*rl.rate)
+	b.last = now
+	if b.tokens < 1 {
+		return false
+	}
+	b.tokens--
+	return true
+}
+
+// retryAfter returns the seconds until a token is available again.
+func (rl *rateLimiter) retryAfter() int {
+	return int(math.Ceil(1 / rl.rate))
+}
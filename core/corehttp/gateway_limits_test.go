@@ -0,0 +1,105 @@
+package corehttp
+
+import (
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+)
+
+func TestRateLimiter(t *testing.T) {
+	rl := newRateLimiter(GatewayRateLimit{Rate: 1, Burst: 2})
+	if !rl.allow("a") || !rl.allow("a") {
+		t.Fatal("burst of two requests should be allowed")
+	}
+	if rl.allow("a") {
+		t.Fatal("third request should be rate limited")
+	}
+	if !rl.allow("b") {
+		t.Fatal("other keys should not be limited")
+	}
+
+	// The buckets are bounded even when none of them is idle, and the least
+	// recently used ones are dropped first.
+	busy := newRateLimiter(GatewayRateLimit{Rate: 0.001, Burst: 1})
+	for i := 0; i <= maxBuckets; i++ {
+		busy.allow(fmt.Sprintf("key-%d", i))
+	}
+	if n := busy.buckets.Len(); n != maxBuckets {
+		t.Fatalf("%d buckets kept, expected %d", n, maxBuckets)
+	}
+	if !busy.allow("key-0") {
+		t.Fatal("least recently used bucket should have been dropped")
+	}
+	if busy.allow(fmt.Sprintf("key-%d", maxBuckets)) {
+		t.Fatal("recently used bucket should have been kept")
+	}
+
+	disabled := newRateLimiter(GatewayRateLimit{})
+	for i := 0; i < 10; i++ {
+		if !disabled.allow("a") {
+			t.Fatal("disabled limiter should allow all requests")
+		}
+	}
+}
+
+func TestRequestRoot(t *testing.T) {
+	a := requestRoot("/ipfs/QmUNLLsPACCz1vLxQVkXqqLX5R1X345qqfHbsf67hvA3Nn/foo")
+	b := requestRoot("/ipfs/bafybeiczsscdsbs7ffqz55asqdf3smv6klcw3gofszvwlyarci47bgf354")
+	if a == "" || a != b {
+		t.Fatalf("CIDv0 and CIDv1 of the same content should share a root: %q, %q", a, b)
+	}
+	if root := requestRoot("/ipns/example.net/foo"); root != "/ipns/example.net" {
+		t.Fatalf("unexpected root %q", root)
+	}
+	if root := requestRoot("/api/v0/id"); root != "" {
+		t.Fatalf("unexpected root %q", root)
+	}
+}
+
+func TestGatewayLimits(t *testing.T) {
+	limits := &gatewayLimits{
+		timeout: 50 * time.Millisecond,
+		slots:   make(chan struct{}, 1),
+		perCID:  newRateLimiter(GatewayRateLimit{Rate: 0.001, Burst: 1}),
+	}
+
+	// A retrieval that never makes progress fails with its context.
+	stalled := limits.handler(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		<-r.Context().Done()
+		http.Error(w, r.Context().Err().Error(), http.StatusNotFound)
+	}))
+	rec := httptest.NewRecorder()
+	stalled.ServeHTTP(rec, httptest.NewRequest(http.MethodGet, "/ipfs/a", nil))
+	if rec.Code != http.StatusGatewayTimeout {
+		t.Fatalf("status is %d, expected 504", rec.Code)
+	}
+
+	rec = httptest.NewRecorder()
+	stalled.ServeHTTP(rec, httptest.NewRequest(http.MethodGet, "/ipfs/a", nil))
+	if rec.Code != http.StatusTooManyRequests {
+		t.Fatalf("status is %d, expected 429", rec.Code)
+	}
+	if rec.Header().Get("Retry-After") == "" {
+		t.Fatal("Retry-After header missing")
+	}
+
+	// A request waiting for a free slot times out as well.
+	limits.slots <- struct{}{}
+	ok := limits.handler(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.WriteHeader(http.StatusOK)
+	}))
+	rec = httptest.NewRecorder()
+	ok.ServeHTTP(rec, httptest.NewRequest(http.MethodGet, "/ipfs/b", nil))
+	if rec.Code != http.StatusGatewayTimeout {
+		t.Fatalf("status is %d, expected 504", rec.Code)
+	}
+	<-limits.slots
+
+	rec = httptest.NewRecorder()
+	ok.ServeHTTP(rec, httptest.NewRequest(http.MethodGet, "/ipfs/c", nil))
+	if rec.Code != http.StatusOK {
+		t.Fatalf("status is %d, expected 200", rec.Code)
+	}
+}
@@ -1,12 +1,21 @@
 package corehttp
 
 import (
+	"archive/tar"
+	"archive/zip"
+	"bytes"
 	"context"
+	"encoding/json"
 	"errors"
+	"fmt"
+	"io"
 	"io/ioutil"
 	"net/http"
 	"net/http/httptest"
+	"os"
+	"path/filepath"
 	"regexp"
+	"sort"
 	"strings"
 	"testing"
 	"time"
@@ -14,6 +23,7 @@ import (
 	version "github.com/ipfs/go-ipfs"
 	core "github.com/ipfs/go-ipfs/core"
 	"github.com/ipfs/go-ipfs/core/coreapi"
+	"github.com/ipfs/go-ipfs/denylist"
 	namesys "github.com/ipfs/go-ipfs/namesys"
 	repo "github.com/ipfs/go-ipfs/repo"
 
@@ -21,10 +31,13 @@ import (
 	syncds "github.com/ipfs/go-datastore/sync"
 	config "github.com/ipfs/go-ipfs-config"
 	files "github.com/ipfs/go-ipfs-files"
+	dag "github.com/ipfs/go-merkledag"
 	path "github.com/ipfs/go-path"
+	"github.com/ipfs/go-unixfs/hamt"
 	iface "github.com/ipfs/interface-go-ipfs-core"
 	nsopts "github.com/ipfs/interface-go-ipfs-core/options/namesys"
 	ipath "github.com/ipfs/interface-go-ipfs-core/path"
+	gocar "github.com/ipld/go-car"
 	ci "github.com/libp2p/go-libp2p-core/crypto"
 	id "github.com/libp2p/go-libp2p/p2p/protocol/identify"
 )
@@ -391,6 +404,74 @@ func TestIPNSHostnameRedirect(t *testing.T) {
 	}
 }
 
+func TestGatewayRedirectsFile(t *testing.T) {
+	ns := mockNamesys{}
+	ts, api, ctx := newTestServerAndNode(t, ns)
+
+	k, err := api.Unixfs().Add(ctx, files.NewMapDirectory(map[string]files.Node{
+		"_redirects": files.NewBytesFile([]byte(
+			"/old/:id /new/:id 302\n" +
+				"/app/* /app.html 200\n" +
+				"/* /not-found.html 404\n")),
+		"app.html":       files.NewBytesFile([]byte("app")),
+		"not-found.html": files.NewBytesFile([]byte("not found")),
+	}))
+	if err != nil {
+		t.Fatal(err)
+	}
+	ns["/ipns/example.net"] = path.FromString(k.String())
+
+	// Unlike doWithoutRedirect, keep the body of redirect responses readable.
+	client := &http.Client{
+		CheckRedirect: func(*http.Request, []*http.Request) error {
+			return http.ErrUseLastResponse
+		},
+	}
+
+	for _, tc := range []struct {
+		path, location, body string
+		status               int
+	}{
+		{"/old/42", "/new/42", "", http.StatusFound},
+		{"/app/some/route", "", "app", http.StatusOK},
+		{"/missing", "", "not found", http.StatusNotFound},
+	} {
+		req, err := http.NewRequest(http.MethodGet, ts.URL+tc.path, nil)
+		if err != nil {
+			t.Fatal(err)
+		}
+		req.Host = "example.net"
+		res, err := client.Do(req)
+		if err != nil {
+			t.Fatal(err)
+		}
+		body, err := ioutil.ReadAll(res.Body)
+		res.Body.Close()
+		if err != nil {
+			t.Fatal(err)
+		}
+		if res.StatusCode != tc.status {
+			t.Fatalf("%s: status is %d, expected %d", tc.path, res.StatusCode, tc.status)
+		}
+		if loc := res.Header.Get("Location"); loc != tc.location {
+			t.Fatalf("%s: location is %q, expected %q", tc.path, loc, tc.location)
+		}
+		if tc.body != "" && string(body) != tc.body {
+			t.Fatalf("%s: body is %q, expected %q", tc.path, body, tc.body)
+		}
+	}
+
+	// _redirects is not applied to paths on the shared /ipfs origin.
+	res, err := http.Get(ts.URL + k.String() + "/old/42")
+	if err != nil {
+		t.Fatal(err)
+	}
+	res.Body.Close()
+	if res.StatusCode != http.StatusNotFound || res.Header.Get("Location") != "" {
+		t.Fatalf("status is %d, expected a plain 404", res.StatusCode)
+	}
+}
+
 func TestIPNSHostnameBacklinks(t *testing.T) {
 	ns := mockNamesys{}
 	ts, api, ctx := newTestServerAndNode(t, ns)
@@ -646,6 +727,670 @@ func TestGoGetSupport(t *testing.T) {
 	}
 }
 
+func TestGatewayCar(t *testing.T) {
+	ts, api, ctx := newTestServerAndNode(t, nil)
+
+	k, err := api.Unixfs().Add(ctx, files.NewBytesFile([]byte("fnord")))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	for _, setup := range []func(r *http.Request){
+		func(r *http.Request) { r.URL.RawQuery = "format=car" },
+		func(r *http.Request) { r.Header.Set("Accept", "application/vnd.ipld.car") },
+	} {
+		req, err := http.NewRequest(http.MethodGet, ts.URL+k.String(), nil)
+		if err != nil {
+			t.Fatal(err)
+		}
+		setup(req)
+
+		res, err := doWithoutRedirect(req)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if res.StatusCode != http.StatusOK {
+			t.Fatalf("status is %d, expected 200", res.StatusCode)
+		}
+		if ct := res.Header.Get("Content-Type"); !strings.HasPrefix(ct, "application/vnd.ipld.car") {
+			t.Fatalf("unexpected Content-Type: %s", ct)
+		}
+		if etag := res.Header.Get("Etag"); etag != `"`+k.Cid().String()+`.car"` {
+			t.Fatalf("unexpected Etag: %s", etag)
+		}
+		if vary := res.Header.Get("Vary"); vary != "Accept" {
+			t.Fatalf("unexpected Vary: %s", vary)
+		}
+
+		car, err := gocar.NewCarReader(res.Body)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if len(car.Header.Roots) != 1 || !car.Header.Roots[0].Equals(k.Cid()) {
+			t.Fatalf("unexpected car roots: %v", car.Header.Roots)
+		}
+		blk, err := car.Next()
+		if err != nil {
+			t.Fatal(err)
+		}
+		if !blk.Cid().Equals(k.Cid()) {
+			t.Fatalf("unexpected block %s", blk.Cid())
+		}
+		res.Body.Close()
+	}
+
+	// The plain file is cached apart from the CAR.
+	req, err := http.NewRequest(http.MethodGet, ts.URL+k.String(), nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	res, err := doWithoutRedirect(req)
+	if err != nil {
+		t.Fatal(err)
+	}
+	res.Body.Close()
+	if res.StatusCode != http.StatusOK {
+		t.Fatalf("status is %d, expected 200", res.StatusCode)
+	}
+	if vary := res.Header.Get("Vary"); vary != "Accept" {
+		t.Fatalf("unexpected Vary: %s", vary)
+	}
+}
+
+func TestGatewayRaw(t *testing.T) {
+	ts, api, ctx := newTestServerAndNode(t, nil)
+
+	k, err := api.Unixfs().Add(ctx, files.NewBytesFile([]byte("fnord")))
+	if err != nil {
+		t.Fatal(err)
+	}
+	blk, err := api.Block().Get(ctx, k)
+	if err != nil {
+		t.Fatal(err)
+	}
+	expected, err := ioutil.ReadAll(blk)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	for _, setup := range []func(r *http.Request){
+		func(r *http.Request) { r.URL.RawQuery = "format=raw" },
+		func(r *http.Request) { r.Header.Set("Accept", "application/vnd.ipld.raw") },
+	} {
+		req, err := http.NewRequest(http.MethodGet, ts.URL+k.String(), nil)
+		if err != nil {
+			t.Fatal(err)
+		}
+		setup(req)
+
+		res, err := doWithoutRedirect(req)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if res.StatusCode != http.StatusOK {
+			t.Fatalf("status is %d, expected 200", res.StatusCode)
+		}
+		if ct := res.Header.Get("Content-Type"); ct != "application/vnd.ipld.raw" {
+			t.Fatalf("unexpected Content-Type: %s", ct)
+		}
+		if etag := res.Header.Get("Etag"); etag != `"`+k.Cid().String()+`.raw"` {
+			t.Fatalf("unexpected Etag: %s", etag)
+		}
+		if vary := res.Header.Get("Vary"); vary != "Accept" {
+			t.Fatalf("unexpected Vary: %s", vary)
+		}
+		body, err := ioutil.ReadAll(res.Body)
+		res.Body.Close()
+		if err != nil {
+			t.Fatal(err)
+		}
+		if !bytes.Equal(body, expected) {
+			t.Fatalf("unexpected block bytes: %x", body)
+		}
+	}
+
+	// Blocks which can't be retrieved are not found, not server errors.
+	missing := dag.NodeWithData([]byte("missing")).Cid()
+	req, err := http.NewRequest(http.MethodGet, ts.URL+"/ipfs/"+missing.String()+"?format=raw", nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	res, err := doWithoutRedirect(req)
+	if err != nil {
+		t.Fatal(err)
+	}
+	res.Body.Close()
+	if res.StatusCode != http.StatusNotFound {
+		t.Fatalf("status is %d, expected 404", res.StatusCode)
+	}
+}
+
+func TestGatewayJSONListing(t *testing.T) {
+	ts, api, ctx := newTestServerAndNode(t, nil)
+
+	k, err := api.Unixfs().Add(ctx, files.NewMapDirectory(map[string]files.Node{
+		"a": files.NewBytesFile([]byte("fnord fnord")),
+		"b": files.NewBytesFile([]byte("fnord")),
+		"c": files.NewBytesFile([]byte("fnord fnord fnord")),
+	}))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	list := func(query string) (directoryListingJSON, *http.Response) {
+		req, err := http.NewRequest(http.MethodGet, ts.URL+k.String()+"/?"+query, nil)
+		if err != nil {
+			t.Fatal(err)
+		}
+		req.Header.Set("Accept", "application/json")
+		res, err := doWithoutRedirect(req)
+		if err != nil {
+			t.Fatal(err)
+		}
+		defer res.Body.Close()
+		if res.StatusCode != http.StatusOK {
+			t.Fatalf("%s: status is %d, expected 200", query, res.StatusCode)
+		}
+		if ct := res.Header.Get("Content-Type"); ct != "application/json" {
+			t.Fatalf("%s: unexpected Content-Type: %s", query, ct)
+		}
+		var listing directoryListingJSON
+		if err := json.NewDecoder(res.Body).Decode(&listing); err != nil {
+			t.Fatal(err)
+		}
+		return listing, res
+	}
+	names := func(listing directoryListingJSON) string {
+		var names []string
+		for _, e := range listing.Entries {
+			names = append(names, e.Name)
+		}
+		return strings.Join(names, ",")
+	}
+
+	first, res := list("limit=2")
+	if names(first) != "a,b" || first.NextCursor != "b" {
+		t.Fatalf("unexpected first page %s, next %q", names(first), first.NextCursor)
+	}
+	if link := res.Header.Get("Link"); !strings.Contains(link, "cursor=b") {
+		t.Fatalf("unexpected Link header: %s", link)
+	}
+	// Sizes are the DAG sizes of the links, entries are not fetched.
+	b, err := api.ResolveNode(ctx, ipath.Join(k, "b"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if size, _ := b.Size(); first.Entries[1].Size != size {
+		t.Fatalf("unexpected size of b: %d, expected %d", first.Entries[1].Size, size)
+	}
+
+	second, _ := list("limit=2&cursor=b")
+	if names(second) != "c" || second.NextCursor != "" {
+		t.Fatalf("unexpected second page %s, next %q", names(second), second.NextCursor)
+	}
+
+	bySize, _ := list("sort=size&order=desc")
+	if names(bySize) != "c,a,b" {
+		t.Fatalf("unexpected order by size %s", names(bySize))
+	}
+
+	res, err = http.Get(ts.URL + k.String() + "/?cursor=missing")
+	if err != nil {
+		t.Fatal(err)
+	}
+	res.Body.Close()
+	if res.StatusCode != http.StatusBadRequest {
+		t.Fatalf("status is %d for unknown cursor, expected 400", res.StatusCode)
+	}
+}
+
+func TestGatewayListingSharded(t *testing.T) {
+	_, api, ctx := newTestServerAndNode(t, nil)
+
+	file, err := api.Unixfs().Add(ctx, files.NewBytesFile([]byte("fnord")))
+	if err != nil {
+		t.Fatal(err)
+	}
+	fileNode, err := api.ResolveNode(ctx, file)
+	if err != nil {
+		t.Fatal(err)
+	}
+	shard, err := hamt.NewShard(api.Dag(), 256)
+	if err != nil {
+		t.Fatal(err)
+	}
+	const count = 1000
+	var expected []string
+	for n := 0; n < count; n++ {
+		name := fmt.Sprintf("entry-%04d", n)
+		if err := shard.Set(ctx, name, fileNode); err != nil {
+			t.Fatal(err)
+		}
+		expected = append(expected, name)
+	}
+	dir, err := shard.Node()
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	handler := newGatewayHandler(GatewayConfig{}, api)
+	ts := httptest.NewServer(handler)
+	defer ts.Close()
+
+	var names []string
+	cursor := ""
+	for pages := 0; ; pages++ {
+		if pages > count/100 {
+			t.Fatal("listing does not terminate")
+		}
+		req, err := http.NewRequest(http.MethodGet, ts.URL+"/ipfs/"+dir.Cid().String()+"/?limit=100&cursor="+cursor, nil)
+		if err != nil {
+			t.Fatal(err)
+		}
+		req.Header.Set("Accept", "application/json")
+		res, err := http.DefaultClient.Do(req)
+		if err != nil {
+			t.Fatal(err)
+		}
+		var listing directoryListingJSON
+		err = json.NewDecoder(res.Body).Decode(&listing)
+		res.Body.Close()
+		if err != nil {
+			t.Fatal(err)
+		}
+		for _, e := range listing.Entries {
+			names = append(names, e.Name)
+		}
+		if listing.NextCursor == "" {
+			break
+		}
+		cursor = listing.NextCursor
+	}
+
+	// Sharded directories are listed in the order of their links, page by
+	// page without enumerating them all.
+	sort.Strings(names)
+	if strings.Join(names, ",") != strings.Join(expected, ",") {
+		t.Fatalf("listed %d entries with duplicates or missing ones", len(names))
+	}
+	if n := handler.listings.Len(); n != 0 {
+		t.Fatalf("%d sorted listings cached, expected none", n)
+	}
+
+	// Sorting by size enumerates the directory once for all the pages.
+	for _, cursor := range []string{"", "entry-0099"} {
+		res, err := http.Get(ts.URL + "/ipfs/" + dir.Cid().String() + "/?sort=size&limit=100&cursor=" + cursor)
+		if err != nil {
+			t.Fatal(err)
+		}
+		res.Body.Close()
+		if res.StatusCode != http.StatusOK {
+			t.Fatalf("status is %d, expected 200", res.StatusCode)
+		}
+	}
+	if n := handler.listings.Len(); n != 1 {
+		t.Fatalf("%d sorted listings cached, expected 1", n)
+	}
+}
+
+func TestGatewayArchive(t *testing.T) {
+	ts, api, ctx := newTestServerAndNode(t, nil)
+
+	k, err := api.Unixfs().Add(ctx, files.NewMapDirectory(map[string]files.Node{
+		"a.txt": files.NewBytesFile([]byte("fnord")),
+		"sub": files.NewMapDirectory(map[string]files.Node{
+			"b.txt": files.NewBytesFile([]byte("fnord")),
+		}),
+	}))
+	if err != nil {
+		t.Fatal(err)
+	}
+	root := k.Cid().String()
+
+	for _, tc := range []struct {
+		query, contentType string
+		entries            func(body []byte) []string
+	}{
+		{"format=tar", "application/x-tar", func(body []byte) []string {
+			var names []string
+			tr := tar.NewReader(bytes.NewReader(body))
+			for {
+				hdr, err := tr.Next()
+				if err == io.EOF {
+					return names
+				}
+				if err != nil {
+					t.Fatal(err)
+				}
+				names = append(names, hdr.Name)
+			}
+		}},
+		{"format=zip", "application/zip", func(body []byte) []string {
+			var names []string
+			zr, err := zip.NewReader(bytes.NewReader(body), int64(len(body)))
+			if err != nil {
+				t.Fatal(err)
+			}
+			for _, f := range zr.File {
+				names = append(names, f.Name)
+			}
+			return names
+		}},
+	} {
+		res, err := http.Get(ts.URL + k.String() + "?download=true&" + tc.query)
+		if err != nil {
+			t.Fatal(err)
+		}
+		body, err := ioutil.ReadAll(res.Body)
+		res.Body.Close()
+		if err != nil {
+			t.Fatal(err)
+		}
+		if res.StatusCode != http.StatusOK {
+			t.Fatalf("%s: status is %d, expected 200", tc.query, res.StatusCode)
+		}
+		if ct := res.Header.Get("Content-Type"); ct != tc.contentType {
+			t.Fatalf("%s: unexpected Content-Type: %s", tc.query, ct)
+		}
+		if cd := res.Header.Get("Content-Disposition"); !strings.HasPrefix(cd, "attachment; filename=\""+root+".") {
+			t.Fatalf("%s: unexpected Content-Disposition: %s", tc.query, cd)
+		}
+
+		names := tc.entries(body)
+		for _, expected := range []string{root + "/a.txt", root + "/sub/b.txt"} {
+			found := false
+			for _, name := range names {
+				found = found || name == expected
+			}
+			if !found {
+				t.Fatalf("%s: %s missing from archive entries %v", tc.query, expected, names)
+			}
+		}
+	}
+}
+
+func TestGatewayArchiveNames(t *testing.T) {
+	ts, api, ctx := newTestServerAndNode(t, nil)
+
+	k, err := api.Unixfs().Add(ctx, files.NewMapDirectory(map[string]files.Node{
+		"a.txt": files.NewBytesFile([]byte("fnord")),
+	}))
+	if err != nil {
+		t.Fatal(err)
+	}
+	root := k.Cid().String()
+
+	res, err := http.Get(ts.URL + k.String() + "?format=zip&filename=../../evil.zip")
+	if err != nil {
+		t.Fatal(err)
+	}
+	res.Body.Close()
+	if cd := res.Header.Get("Content-Disposition"); !strings.HasPrefix(cd, "attachment; filename=\""+root+".zip\"") {
+		t.Fatalf("unexpected Content-Disposition: %s", cd)
+	}
+
+	req, err := http.NewRequest(http.MethodGet, ts.URL+k.String(), nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	req.Header.Set("Accept", "application/zip")
+	res, err = http.DefaultClient.Do(req)
+	if err != nil {
+		t.Fatal(err)
+	}
+	res.Body.Close()
+	if ct := res.Header.Get("Content-Type"); ct == "application/zip" {
+		t.Fatal("archive served without an explicit format parameter")
+	}
+
+	for _, name := range []string{"..", "../evil", "/evil", "a\\..\\evil"} {
+		dir := files.NewMapDirectory(map[string]files.Node{
+			name: files.NewBytesFile([]byte("fnord")),
+		})
+		if err := writeZip(ioutil.Discard, safeNode(dir), "root"); !errors.Is(err, errArchiveName) {
+			t.Fatalf("zip entry %q: expected errArchiveName, got %v", name, err)
+		}
+		if err := writeTar(ioutil.Discard, safeNode(dir), "root"); !errors.Is(err, errArchiveName) {
+			t.Fatalf("tar entry %q: expected errArchiveName, got %v", name, err)
+		}
+	}
+}
+
+func TestGatewayCache(t *testing.T) {
+	ns := mockNamesys{}
+	_, api, ctx := newTestServerAndNode(t, ns)
+
+	first, err := api.Unixfs().Add(ctx, files.NewMapDirectory(map[string]files.Node{
+		"first": files.NewBytesFile([]byte("fnord")),
+	}))
+	if err != nil {
+		t.Fatal(err)
+	}
+	second, err := api.Unixfs().Add(ctx, files.NewMapDirectory(map[string]files.Node{
+		"second": files.NewBytesFile([]byte("fnord")),
+	}))
+	if err != nil {
+		t.Fatal(err)
+	}
+	ns["/ipns/example.net"] = path.FromString(first.String())
+
+	cache, err := newGatewayCache(GatewayCacheConfig{Paths: 10, Responses: 10, PathTTL: "1h"}, ns)
+	if err != nil {
+		t.Fatal(err)
+	}
+	gateway := newGatewayHandler(GatewayConfig{}, api)
+	gateway.cache = cache
+	ts := httptest.NewServer(gateway)
+	t.Cleanup(func() { ts.Close() })
+
+	list := func() string {
+		res, err := http.Get(ts.URL + "/ipns/example.net/")
+		if err != nil {
+			t.Fatal(err)
+		}
+		defer res.Body.Close()
+		body, err := ioutil.ReadAll(res.Body)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if res.StatusCode != http.StatusOK {
+			t.Fatalf("status is %d, expected 200", res.StatusCode)
+		}
+		return string(body)
+	}
+
+	body := list()
+	if !strings.Contains(body, "first") {
+		t.Fatalf("expected the first directory to be listed:\n%s", body)
+	}
+	if cache.responses.Len() != 1 {
+		t.Fatalf("expected the listing to be cached, got %d responses", cache.responses.Len())
+	}
+
+	// The name is cached until its TTL expires.
+	ns["/ipns/example.net"] = path.FromString(second.String())
+	if cached := list(); cached != body {
+		t.Fatal("expected the cached listing")
+	}
+
+	cache.paths.Purge()
+	if body := list(); !strings.Contains(body, "second") {
+		t.Fatalf("expected the second directory to be listed:\n%s", body)
+	}
+}
+
+func TestGatewayTrustlessOnly(t *testing.T) {
+	_, api, ctx := newTestServerAndNode(t, nil)
+
+	k, err := api.Unixfs().Add(ctx, files.NewBytesFile([]byte("fnord")))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	ts := httptest.NewServer(newGatewayHandler(GatewayConfig{TrustlessOnly: true}, api))
+	t.Cleanup(func() { ts.Close() })
+
+	for query, status := range map[string]int{
+		"":           http.StatusNotAcceptable,
+		"format=raw": http.StatusOK,
+		"format=car": http.StatusOK,
+	} {
+		res, err := http.Get(ts.URL + k.String() + "?" + query)
+		if err != nil {
+			t.Fatal(err)
+		}
+		res.Body.Close()
+		if res.StatusCode != status {
+			t.Fatalf("query %q: status is %d, expected %d", query, res.StatusCode, status)
+		}
+	}
+}
+
+func TestGatewayDenylist(t *testing.T) {
+	_, api, ctx := newTestServerAndNode(t, nil)
+
+	denied, err := api.Unixfs().Add(ctx, files.NewBytesFile([]byte("denied")))
+	if err != nil {
+		t.Fatal(err)
+	}
+	allowed, err := api.Unixfs().Add(ctx, files.NewBytesFile([]byte("allowed")))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	dir, err := ioutil.TempDir("", "denylist")
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { os.RemoveAll(dir) })
+	file := filepath.Join(dir, "denylist.txt")
+	if err := ioutil.WriteFile(file, []byte(denied.Cid().String()), 0644); err != nil {
+		t.Fatal(err)
+	}
+	dl, err := denylist.New(denylist.Config{File: file})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if err := dl.Start(); err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { dl.Close() })
+	cache, err := newGatewayCache(GatewayCacheConfig{Responses: 10}, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	gw := newGatewayHandler(GatewayConfig{}, api)
+	gw.denylist = dl
+	gw.cache = cache
+	ts := httptest.NewServer(gw)
+	t.Cleanup(func() { ts.Close() })
+
+	for p, status := range map[string]int{
+		denied.String():                  http.StatusGone,
+		denied.String() + "?format=raw":  http.StatusGone,
+		"/ipfs/" + denied.Cid().String(): http.StatusGone,
+		allowed.String():                 http.StatusOK,
+		allowed.String() + "?format=raw": http.StatusOK,
+	} {
+		res, err := http.Get(ts.URL + p)
+		if err != nil {
+			t.Fatal(err)
+		}
+		res.Body.Close()
+		if res.StatusCode != status {
+			t.Fatalf("%s: status is %d, expected %d", p, res.StatusCode, status)
+		}
+	}
+
+	// Denied children of an allowed directory are neither listed nor
+	// exported.
+	parent, err := api.Unixfs().Add(ctx, files.NewMapDirectory(map[string]files.Node{
+		"allowed.txt": files.NewBytesFile([]byte("allowed")),
+		"denied.txt":  files.NewBytesFile([]byte("denied")),
+	}))
+	if err != nil {
+		t.Fatal(err)
+	}
+	get := func(query string) []byte {
+		req, err := http.NewRequest(http.MethodGet, ts.URL+parent.String()+"/?"+query, nil)
+		if err != nil {
+			t.Fatal(err)
+		}
+		req.Header.Set("Accept", "application/json")
+		res, err := http.DefaultClient.Do(req)
+		if err != nil {
+			t.Fatal(err)
+		}
+		defer res.Body.Close()
+		body, _ := ioutil.ReadAll(res.Body)
+		return body
+	}
+
+	var listing directoryListingJSON
+	if err := json.Unmarshal(get(""), &listing); err != nil {
+		t.Fatal(err)
+	}
+	if len(listing.Entries) != 1 || listing.Entries[0].Name != "allowed.txt" {
+		t.Fatalf("unexpected listing %+v", listing.Entries)
+	}
+	// Pages followed by denied entries only are the last ones.
+	var page directoryListingJSON
+	if err := json.Unmarshal(get("limit=1"), &page); err != nil {
+		t.Fatal(err)
+	}
+	if len(page.Entries) != 1 || page.NextCursor != "" {
+		t.Fatalf("unexpected listing %+v, next %q", page.Entries, page.NextCursor)
+	}
+
+	car, err := gocar.NewCarReader(bytes.NewReader(get("format=car")))
+	if err != nil {
+		t.Fatal(err)
+	}
+	for {
+		blk, err := car.Next()
+		if err != nil {
+			break
+		}
+		if blk.Cid().Equals(denied.Cid()) {
+			t.Fatal("denied block exported in CAR")
+		}
+	}
+
+	tr := tar.NewReader(bytes.NewReader(get("format=tar")))
+	for {
+		hdr, err := tr.Next()
+		if err != nil {
+			break
+		}
+		if strings.HasSuffix(hdr.Name, "/denied.txt") {
+			t.Fatal("denied file exported in TAR")
+		}
+	}
+
+	// Cached listings are not served once the denylist is reloaded.
+	gen := dl.Generation()
+	entries := denied.Cid().String() + "\n" + allowed.Cid().String()
+	if err := ioutil.WriteFile(file, []byte(entries), 0644); err != nil {
+		t.Fatal(err)
+	}
+	deadline := time.Now().Add(10 * time.Second)
+	for dl.Generation() == gen {
+		if time.Now().After(deadline) {
+			t.Fatal("denylist was not reloaded")
+		}
+		time.Sleep(50 * time.Millisecond)
+	}
+	listing = directoryListingJSON{}
+	if err := json.Unmarshal(get(""), &listing); err != nil {
+		t.Fatal(err)
+	}
+	if len(listing.Entries) != 0 {
+		t.Fatalf("unexpected listing %+v after the denylist was reloaded", listing.Entries)
+	}
+}
+
 func TestVersion(t *testing.T) {
 	version.CurrentCommit = "theshortcommithash"
 
@@ -680,3 +1425,17 @@ func TestVersion(t *testing.T) {
 		t.Fatalf("response doesn't contain protocol version:\n%s", s)
 	}
 }
+
+type brokenConfigRepo struct {
+	repo.Mock
+}
+
+func (r *brokenConfigRepo) GetConfigKey(string) (interface{}, error) {
+	return nil, errors.New("broken config")
+}
+
+func TestGatewayExtConfigError(t *testing.T) {
+	if _, err := loadGatewayExtConfig(&brokenConfigRepo{}); err == nil {
+		t.Fatal("expected an error for an unreadable Gateway config")
+	}
+}
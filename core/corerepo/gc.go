@@ -90,13 +90,52 @@ func GarbageCollect(n *core.IpfsNode, ctx context.Context) error {
 	}
 	rmed := gc.GC(ctx, n.Blockstore, n.Repo.Datastore(), n.Pinning, roots)
 
-	return CollectResult(ctx, rmed, nil)
+	var removed, size uint64
+	err = CollectResultWithProgress(ctx, rmed, func(_ cid.Cid, s uint64) {
+		removed++
+		size += s
+	}, nil)
+	log.Infof("Repo GC removed %d blocks, %s", removed, humanize.Bytes(size))
+	return err
+}
+
+// EvictLeastRecentlyUsed removes the least recently used unpinned blocks
+// until target bytes are freed, see gc.Evict. It requires the lru
+// Datastore.GCPolicy.
+func EvictLeastRecentlyUsed(n *core.IpfsNode, ctx context.Context, target uint64) error {
+	if n.GCAccessTracker == nil {
+		return errors.New("block accesses are not tracked, set Datastore.GCPolicy to \"lru\"")
+	}
+	roots, err := BestEffortRoots(n.FilesRoot)
+	if err != nil {
+		return err
+	}
+	rmed := gc.Evict(ctx, n.Blockstore, n.GCAccessTracker, n.Repo.Datastore(), n.Pinning, roots, target)
+
+	var removed, size uint64
+	err = CollectResultWithProgress(ctx, rmed, func(_ cid.Cid, s uint64) {
+		removed++
+		size += s
+	}, nil)
+	log.Infof("Repo GC evicted %d blocks, %s", removed, humanize.Bytes(size))
+	return err
 }
 
 // CollectResult collects the output of a garbage collection run and calls the
 // given callback for each object removed.  It also collects all errors into a
 // MultiError which is returned after the gc is completed.
 func CollectResult(ctx context.Context, gcOut <-chan gc.Result, cb func(cid.Cid)) error {
+	var sizeCb func(cid.Cid, uint64)
+	if cb != nil {
+		sizeCb = func(k cid.Cid, _ uint64) { cb(k) }
+	}
+	return CollectResultWithProgress(ctx, gcOut, sizeCb, nil)
+}
+
+// CollectResultWithProgress is CollectResult which also passes the size of
+// the objects removed to cb, and calls progress with the progress reports of
+// incremental runs.
+func CollectResultWithProgress(ctx context.Context, gcOut <-chan gc.Result, cb func(k cid.Cid, size uint64), progress func(gc.Progress)) error {
 	var errors []error
 loop:
 	for {
@@ -107,8 +146,12 @@ loop:
 			}
 			if res.Error != nil {
 				errors = append(errors, res.Error)
+			} else if res.Progress != nil {
+				if progress != nil {
+					progress(*res.Progress)
+				}
 			} else if res.KeyRemoved.Defined() && cb != nil {
-				cb(res.KeyRemoved)
+				cb(res.KeyRemoved, res.Size)
 			}
 		case <-ctx.Done():
 			errors = append(errors, ctx.Err())
@@ -148,15 +191,29 @@ func (e *MultiError) Error() string {
 }
 
 func GarbageCollectAsync(n *core.IpfsNode, ctx context.Context) <-chan gc.Result {
+	return GarbageCollectWithOptionsAsync(n, ctx, gc.Options{})
+}
+
+// GarbageCollectWithOptionsAsync is GarbageCollectAsync configured by opts.
+func GarbageCollectWithOptionsAsync(n *core.IpfsNode, ctx context.Context, opts gc.Options) <-chan gc.Result {
 	roots, err := BestEffortRoots(n.FilesRoot)
 	if err != nil {
-		out := make(chan gc.Result)
+		out := make(chan gc.Result, 1)
 		out <- gc.Result{Error: err}
 		close(out)
 		return out
 	}
 
-	return gc.GC(ctx, n.Blockstore, n.Repo.Datastore(), n.Pinning, roots)
+	return gc.GCWithOptions(ctx, n.Blockstore, n.Repo.Datastore(), n.Pinning, roots, opts)
+}
+
+// IncrementalGarbageCollectAsync runs a garbage collection which only holds
+// the GC lock for short periods, see gc.Incremental.
+func IncrementalGarbageCollectAsync(n *core.IpfsNode, ctx context.Context, opts gc.IncrementalOptions) <-chan gc.Result {
+	roots := func() ([]cid.Cid, error) {
+		return BestEffortRoots(n.FilesRoot)
+	}
+	return gc.Incremental(ctx, n.Blockstore, n.GCWriteBarrier, n.Repo.Datastore(), n.Pinning, roots, opts)
 }
 
 func PeriodicGC(ctx context.Context, node *core.IpfsNode) error {
@@ -215,6 +272,13 @@ func (gc *GC) maybeGC(ctx context.Context, offset uint64) error {
 			log.Warnf("pre-GC: %s", ErrMaxStorageExceeded)
 		}
 
+		if gc.Node.GCAccessTracker != nil {
+			target := storage + offset - gc.StorageGC
+			log.Infof("Watermark exceeded. Evicting %s of least recently used blocks...", humanize.Bytes(target))
+
+			return EvictLeastRecentlyUsed(gc.Node, ctx, target)
+		}
+
 		// Do GC here
 		log.Info("Watermark exceeded. Starting repo GC...")
 
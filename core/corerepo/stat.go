@@ -24,6 +24,10 @@ type Stat struct {
 	NumObjects uint64
 	RepoPath   string
 	Version    string
+	// Namespace is set for the stat of the pins of a namespace, which
+	// reports their size as RepoSize and the quota as StorageMax.
+	Namespace string `json:",omitempty"`
+	NumPins   int    `json:",omitempty"`
 }
 
 // NoLimit represents the value for unlimited storage
@@ -89,3 +93,32 @@ func RepoSize(ctx context.Context, n *core.IpfsNode) (SizeStat, error) {
 		StorageMax: storageMax,
 	}, nil
 }
+
+// NamespaceStat returns a Stat of the pins of namespace, with the Namespace,
+// NumPins, RepoSize and StorageMax fields set.
+func NamespaceStat(ctx context.Context, n *core.IpfsNode, namespace string) (Stat, error) {
+	usage, err := n.PinMetadata.Usage()
+	if err != nil {
+		return Stat{}, err
+	}
+
+	storageMax := NoLimit
+	if n.PinMetadata.Quotas != nil {
+		limits, err := n.PinMetadata.Quotas.Limits()
+		if err != nil {
+			return Stat{}, err
+		}
+		if limit, ok := limits[namespace]; ok {
+			storageMax = limit
+		}
+	}
+
+	return Stat{
+		SizeStat: SizeStat{
+			RepoSize:   usage[namespace].Size,
+			StorageMax: storageMax,
+		},
+		Namespace: namespace,
+		NumPins:   usage[namespace].Pins,
+	}, nil
+}
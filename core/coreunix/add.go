@@ -8,6 +8,8 @@ import (
 	gopath "path"
 	"strconv"
 
+	"github.com/ipfs/go-ipfs/pinmeta"
+
 	"github.com/ipfs/go-cid"
 	bstore "github.com/ipfs/go-ipfs-blockstore"
 	chunker "github.com/ipfs/go-ipfs-chunker"
@@ -79,6 +81,11 @@ type Adder struct {
 	tempRoot   cid.Cid
 	CidBuilder cid.Builder
 	liveNodes  uint64
+
+	// PinMeta, when set, records PinMetadata about the pin of the root,
+	// and checks the quota of its namespace.
+	PinMeta     *pinmeta.Store
+	PinMetadata pinmeta.Metadata
 }
 
 func (adder *Adder) mfsRoot() (*mfs.Root, error) {
@@ -182,6 +189,30 @@ func (adder *Adder) PinRoot(root ipld.Node) error {
 	return adder.pinning.Flush(adder.ctx)
 }
 
+// pinRootWithMetadata pins the final root like PinRoot, through PinMeta so
+// that PinMetadata is recorded. The roots pinned while pausing for GC are
+// temporary and don't get any.
+func (adder *Adder) pinRootWithMetadata(root ipld.Node) error {
+	err := adder.dagService.Add(adder.ctx, root)
+	if err != nil {
+		return err
+	}
+
+	if adder.tempRoot.Defined() {
+		err := adder.pinning.Unpin(adder.ctx, adder.tempRoot, true)
+		if err != nil {
+			return err
+		}
+		adder.tempRoot = cid.Undef
+	}
+
+	err = adder.PinMeta.Pin(adder.ctx, adder.pinning, root, true, adder.PinMetadata)
+	if err != nil {
+		return err
+	}
+	return adder.pinning.Flush(adder.ctx)
+}
+
 func (adder *Adder) outputDirs(path string, fsn mfs.FSNode) error {
 	switch fsn := fsn.(type) {
 	case *mfs.File:
@@ -330,6 +361,9 @@ func (adder *Adder) AddAllAndPin(file files.Node) (ipld.Node, error) {
 	if !adder.Pin {
 		return nd, nil
 	}
+	if adder.PinMeta != nil {
+		return nd, adder.pinRootWithMetadata(nd)
+	}
 	return nd, adder.PinRoot(nd)
 }
 
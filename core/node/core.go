@@ -2,8 +2,10 @@ package node
 
 import (
 	"context"
+	"encoding/json"
 	"fmt"
 
+	humanize "github.com/dustin/go-humanize"
 	"github.com/ipfs/go-bitswap"
 	"github.com/ipfs/go-bitswap/network"
 	"github.com/ipfs/go-blockservice"
@@ -14,6 +16,7 @@ import (
 	"github.com/ipfs/go-ipfs-exchange-interface"
 	"github.com/ipfs/go-ipfs-exchange-offline"
 	"github.com/ipfs/go-ipfs-pinner"
+	"github.com/ipfs/go-ipfs-provider"
 	"github.com/ipfs/go-ipld-format"
 	"github.com/ipfs/go-merkledag"
 	"github.com/ipfs/go-mfs"
@@ -23,6 +26,11 @@ import (
 	"go.uber.org/fx"
 
 	"github.com/ipfs/go-ipfs/core/node/helpers"
+	"github.com/ipfs/go-ipfs/denylist"
+	"github.com/ipfs/go-ipfs/pinindex"
+	"github.com/ipfs/go-ipfs/pinmeta"
+	"github.com/ipfs/go-ipfs/pinqueue"
+	"github.com/ipfs/go-ipfs/pinremote"
 	"github.com/ipfs/go-ipfs/repo"
 )
 
@@ -62,9 +70,132 @@ func Pinning(bstore blockstore.Blockstore, ds format.DAGService, repo repo.Repo)
 		pinning = pin.NewPinner(rootDS, syncDs, syncInternalDag)
 	}
 
+	indexed, err := PinIndexEnabled(repo)
+	if err != nil {
+		return nil, err
+	}
+	index := pinindex.New(rootDS, syncDs, syncInternalDag)
+	migrated, err := index.Initialized()
+	if err != nil {
+		return nil, err
+	}
+	switch {
+	case indexed && !migrated:
+		logger.Info("indexing the pins, this may take a while")
+		if err := index.Import(context.TODO(), pinning); err != nil {
+			// The former pins are still there, keep using them.
+			logger.Errorf("failure to index the pins, Pinning.Index is ignored: %s", err)
+			return pinning, nil
+		}
+	case !indexed && migrated:
+		logger.Info("Pinning.Index was disabled, moving the indexed pins back")
+		if err := index.Export(context.TODO(), pinning); err != nil {
+			return nil, fmt.Errorf("failure to move the indexed pins back: %s", err)
+		}
+	}
+	if indexed {
+		return index, nil
+	}
 	return pinning, nil
 }
 
+// PinIndexEnabled returns whether Pinning.Index is set, to keep the pins in
+// an indexed store instead of the default pinner.
+func PinIndexEnabled(repo repo.Repo) (bool, error) {
+	raw, err := repo.GetConfigKey("Pinning.Index")
+	if err != nil {
+		// not configured
+		return false, nil
+	}
+	enabled, ok := raw.(bool)
+	if !ok {
+		return false, fmt.Errorf("invalid Pinning.Index %v, expected a boolean", raw)
+	}
+	return enabled, nil
+}
+
+// PinMetadata creates the store of what is recorded about pins besides their
+// type, which enforces the quotas of Pinning.Namespaces
+func PinMetadata(repo repo.Repo, ds format.DAGService) *pinmeta.Store {
+	store := pinmeta.NewStore(repo.Datastore())
+	store.Quotas = &pinmeta.Quotas{
+		DAG: ds,
+		Limits: func() (map[string]uint64, error) {
+			return PinNamespaceLimits(repo)
+		},
+	}
+	return store
+}
+
+// PinNamespaceLimits returns the quotas of Pinning.Namespaces, in bytes by
+// namespace.
+func PinNamespaceLimits(repo repo.Repo) (map[string]uint64, error) {
+	raw, err := repo.GetConfigKey("Pinning.Namespaces")
+	if err != nil {
+		// not configured
+		return nil, nil
+	}
+	buf, err := json.Marshal(raw)
+	if err != nil {
+		return nil, err
+	}
+	var namespaces map[string]struct {
+		StorageMax string
+	}
+	if err := json.Unmarshal(buf, &namespaces); err != nil {
+		return nil, fmt.Errorf("failure to decode Pinning.Namespaces config: %s", err)
+	}
+
+	limits := make(map[string]uint64, len(namespaces))
+	for name, ns := range namespaces {
+		if ns.StorageMax == "" {
+			continue
+		}
+		limit, err := humanize.ParseBytes(ns.StorageMax)
+		if err != nil {
+			return nil, fmt.Errorf("invalid Pinning.Namespaces.%s.StorageMax: %s", name, err)
+		}
+		limits[name] = limit
+	}
+	return limits, nil
+}
+
+// PinExpirer removes the pins which expired while the node runs
+func PinExpirer(lc lcProcess, store *pinmeta.Store, pinner pin.Pinner, locker blockstore.GCLocker) {
+	lc.Append(pinmeta.NewExpirer(store, pinner, locker).Run)
+}
+
+// PinQueue creates the queue of the pins done in the background
+func PinQueue(repo repo.Repo, ds format.DAGService, pinner pin.Pinner, locker blockstore.GCLocker, meta *pinmeta.Store, prov provider.System) *pinqueue.Queue {
+	return pinqueue.New(repo.Datastore(), ds, pinner, locker, meta, prov)
+}
+
+// PinQueueWorkers processes the pin queue while the node runs
+func PinQueueWorkers(lc fx.Lifecycle, queue *pinqueue.Queue) {
+	lc.Append(fx.Hook{
+		OnStart: func(context.Context) error {
+			return queue.Start()
+		},
+		OnStop: func(context.Context) error {
+			return queue.Close()
+		},
+	})
+}
+
+// RemotePins creates the tracker of the pin requests made to remote pinning
+// services
+func RemotePins(repo repo.Repo) *pinremote.Tracker {
+	return pinremote.NewTracker(repo.Datastore(), func() (map[string]pinremote.Service, error) {
+		return pinremote.LoadServices(repo)
+	})
+}
+
+// RemotePinReconciler refreshes the status of the pending remote pin requests
+// while the node runs
+func RemotePinReconciler(lc lcProcess, tracker *pinremote.Tracker) {
+	lc.Append(tracker.Run)
+}
+
 var (
 	_ merkledag.SessionMaker = new(syncDagService)
 	_ format.DAGService      = new(syncDagService)
@@ -91,9 +222,14 @@ func Dag(bs blockservice.BlockService) format.DAGService {
 
 // OnlineExchange creates new LibP2P backed block exchange (BitSwap)
 func OnlineExchange(provide bool) interface{} {
-	return func(mctx helpers.MetricsCtx, lc fx.Lifecycle, host host.Host, rt routing.Routing, bs blockstore.GCBlockstore) exchange.Interface {
+	return func(mctx helpers.MetricsCtx, lc fx.Lifecycle, host host.Host, rt routing.Routing, bs blockstore.GCBlockstore, dl *denylist.Denylist) exchange.Interface {
 		bitswapNetwork := network.NewFromIpfsHost(host, rt)
-		exch := bitswap.New(helpers.LifecycleCtx(mctx, lc), bitswapNetwork, bs, bitswap.ProvideEnabled(provide))
+		var bstore blockstore.Blockstore = bs
+		if dl.AppliesToBitswap() {
+			// don't serve denied blocks to other peers
+			bstore = dl.Blockstore(bs)
+		}
+		exch := bitswap.New(helpers.LifecycleCtx(mctx, lc), bitswapNetwork, bstore, bitswap.ProvideEnabled(provide))
 		lc.Append(fx.Hook{
 			OnStop: func(ctx context.Context) error {
 				return exch.Close()
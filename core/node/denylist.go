@@ -0,0 +1,46 @@
+package node
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+
+	"github.com/ipfs/go-ipfs/denylist"
+	"github.com/ipfs/go-ipfs/repo"
+	"go.uber.org/fx"
+)
+
+// Denylist loads the denylist configured in Gateway.Denylist and reloads it
+// while the node runs. It returns nil when no denylist is configured.
+func Denylist(lc fx.Lifecycle, repo repo.Repo) (*denylist.Denylist, error) {
+	raw, err := repo.GetConfigKey("Gateway.Denylist")
+	if err != nil {
+		// not configured
+		return nil, nil
+	}
+	buf, err := json.Marshal(raw)
+	if err != nil {
+		return nil, err
+	}
+	var cfg denylist.Config
+	if err := json.Unmarshal(buf, &cfg); err != nil {
+		return nil, fmt.Errorf("failure to decode Gateway.Denylist config: %s", err)
+	}
+	if cfg.File == "" {
+		return nil, nil
+	}
+
+	dl, err := denylist.New(cfg)
+	if err != nil {
+		return nil, fmt.Errorf("failure to load denylist: %s", err)
+	}
+	lc.Append(fx.Hook{
+		OnStart: func(context.Context) error {
+			return dl.Start()
+		},
+		OnStop: func(context.Context) error {
+			return dl.Close()
+		},
+	})
+	return dl, nil
+}
@@ -14,6 +14,7 @@ import (
 	pubsub "github.com/libp2p/go-libp2p-pubsub"
 
 	"github.com/ipfs/go-ipfs/core/node/libp2p"
+	"github.com/ipfs/go-ipfs/gc"
 	"github.com/ipfs/go-ipfs/p2p"
 
 	offline "github.com/ipfs/go-ipfs-exchange-offline"
@@ -169,6 +170,8 @@ func Storage(bcfg *BuildCfg, cfg *config.Config) fx.Option {
 	return fx.Options(
 		fx.Provide(RepoConfig),
 		fx.Provide(Datastore),
+		fx.Provide(gc.NewWriteBarrier),
+		fx.Provide(GCAccessTracker),
 		fx.Provide(BaseBlockstoreCtor(cacheOpts, bcfg.NilRepo, cfg.Datastore.HashOnRead)),
 		finalBstore,
 	)
@@ -269,6 +272,9 @@ func Online(bcfg *BuildCfg, cfg *config.Config) fx.Option {
 		PeerWith(cfg.Peering.Peers...),
 
 		fx.Invoke(IpnsRepublisher(repubPeriod, recordLifetime)),
+		fx.Invoke(PinExpirer),
+		fx.Invoke(RemotePinReconciler),
+		fx.Invoke(PinQueueWorkers),
 
 		fx.Provide(p2p.New),
 
@@ -289,10 +295,14 @@ func Offline(cfg *config.Config) fx.Option {
 
 // Core groups basic IPFS services
 var Core = fx.Options(
+	fx.Provide(Denylist),
 	fx.Provide(BlockService),
 	fx.Provide(Dag),
 	fx.Provide(resolver.NewBasicResolver),
 	fx.Provide(Pinning),
+	fx.Provide(PinMetadata),
+	fx.Provide(PinQueue),
+	fx.Provide(RemotePins),
 	fx.Provide(Files),
 )
 
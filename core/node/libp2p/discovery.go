@@ -38,16 +38,24 @@ func DiscoveryHandler(mctx helpers.MetricsCtx, lc fx.Lifecycle, host host.Host)
 func SetupDiscovery(mdns bool, mdnsInterval int) func(helpers.MetricsCtx, fx.Lifecycle, host.Host, *discoveryHandler) error {
 	return func(mctx helpers.MetricsCtx, lc fx.Lifecycle, host host.Host, handler *discoveryHandler) error {
 		if mdns {
-			if mdnsInterval == 0 {
-				mdnsInterval = 5
-			}
-			service, err := discovery.NewMdnsService(helpers.LifecycleCtx(mctx, lc), host, time.Duration(mdnsInterval)*time.Second, discovery.ServiceTag)
-			if err != nil {
+			if _, err := StartMdns(helpers.LifecycleCtx(mctx, lc), host, mdnsInterval, discovery.ServiceTag, handler); err != nil {
 				log.Error("mdns error: ", err)
-				return nil
 			}
-			service.RegisterNotifee(handler)
 		}
 		return nil
 	}
 }
+
+// StartMdns announces serviceTag on the local network every mdnsInterval
+// seconds and passes the peers found to notifee.
+func StartMdns(ctx context.Context, host host.Host, mdnsInterval int, serviceTag string, notifee discovery.Notifee) (discovery.Service, error) {
+	if mdnsInterval == 0 {
+		mdnsInterval = 5
+	}
+	service, err := discovery.NewMdnsService(ctx, host, time.Duration(mdnsInterval)*time.Second, serviceTag)
+	if err != nil {
+		return nil, err
+	}
+	service.RegisterNotifee(notifee)
+	return service, nil
+}
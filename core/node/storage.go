@@ -1,6 +1,9 @@
 package node
 
 import (
+	"context"
+	"fmt"
+
 	"github.com/ipfs/go-datastore"
 	blockstore "github.com/ipfs/go-ipfs-blockstore"
 	config "github.com/ipfs/go-ipfs-config"
@@ -8,6 +11,7 @@ import (
 
 	"github.com/ipfs/go-filestore"
 	"github.com/ipfs/go-ipfs/core/node/helpers"
+	"github.com/ipfs/go-ipfs/gc"
 	"github.com/ipfs/go-ipfs/repo"
 	"github.com/ipfs/go-ipfs/thirdparty/cidv0v1"
 	"github.com/ipfs/go-ipfs/thirdparty/verifbs"
@@ -23,12 +27,60 @@ func Datastore(repo repo.Repo) datastore.Datastore {
 	return repo.Datastore()
 }
 
+// Values of the Datastore.GCPolicy config key.
+const (
+	// GCPolicyAll removes every block which isn't pinned.
+	GCPolicyAll = "all"
+	// GCPolicyLRU removes the least recently used blocks which aren't
+	// pinned, until the repo is below Datastore.StorageGCWatermark.
+	GCPolicyLRU = "lru"
+)
+
+// GCPolicy returns the Datastore.GCPolicy of the repo, GCPolicyAll when it is
+// not set.
+func GCPolicy(repo repo.Repo) (string, error) {
+	raw, err := repo.GetConfigKey("Datastore.GCPolicy")
+	if err != nil {
+		// not configured
+		return GCPolicyAll, nil
+	}
+	switch policy, _ := raw.(string); policy {
+	case "", GCPolicyAll:
+		return GCPolicyAll, nil
+	case GCPolicyLRU:
+		return policy, nil
+	default:
+		return "", fmt.Errorf("invalid Datastore.GCPolicy %v, expected %q or %q", raw, GCPolicyAll, GCPolicyLRU)
+	}
+}
+
+// GCAccessTracker records when blocks are accessed for the GCPolicyLRU
+// policy. It returns nil for other policies.
+func GCAccessTracker(lc fx.Lifecycle, repo repo.Repo) (*gc.AccessTracker, error) {
+	policy, err := GCPolicy(repo)
+	if err != nil || policy != GCPolicyLRU {
+		return nil, err
+	}
+
+	at := gc.NewAccessTracker(repo.Datastore())
+	lc.Append(fx.Hook{
+		OnStart: func(context.Context) error {
+			at.Start()
+			return nil
+		},
+		OnStop: func(context.Context) error {
+			return at.Close()
+		},
+	})
+	return at, nil
+}
+
 // BaseBlocks is the lower level blockstore without GC or Filestore layers
 type BaseBlocks blockstore.Blockstore
 
 // BaseBlockstoreCtor creates cached blockstore backed by the provided datastore
-func BaseBlockstoreCtor(cacheOpts blockstore.CacheOpts, nilRepo bool, hashOnRead bool) func(mctx helpers.MetricsCtx, repo repo.Repo, lc fx.Lifecycle) (bs BaseBlocks, err error) {
-	return func(mctx helpers.MetricsCtx, repo repo.Repo, lc fx.Lifecycle) (bs BaseBlocks, err error) {
+func BaseBlockstoreCtor(cacheOpts blockstore.CacheOpts, nilRepo bool, hashOnRead bool) func(mctx helpers.MetricsCtx, repo repo.Repo, lc fx.Lifecycle, wb *gc.WriteBarrier, at *gc.AccessTracker) (bs BaseBlocks, err error) {
+	return func(mctx helpers.MetricsCtx, repo repo.Repo, lc fx.Lifecycle, wb *gc.WriteBarrier, at *gc.AccessTracker) (bs BaseBlocks, err error) {
 		// hash security
 		bs = blockstore.NewBlockstore(repo.Datastore())
 		bs = &verifbs.VerifBS{Blockstore: bs}
@@ -43,6 +95,12 @@ func BaseBlockstoreCtor(cacheOpts blockstore.CacheOpts, nilRepo bool, hashOnRead
 		bs = blockstore.NewIdStore(bs)
 		bs = cidv0v1.NewBlockstore(bs)
 
+		// the write barrier has to see every block put, including those of
+		// adds which bypass the GC blockstore
+		bs = wb.Blockstore(bs)
+		// above the cache, which would hide reads
+		bs = at.Blockstore(bs)
+
 		if hashOnRead { // TODO: review: this is how it was done originally, is there a reason we can't just pass this directly?
 			bs.HashOnRead(true)
 		}
@@ -61,12 +119,12 @@ func GcBlockstoreCtor(bb BaseBlocks) (gclocker blockstore.GCLocker, gcbs blockst
 }
 
 // GcBlockstoreCtor wraps GcBlockstore and adds Filestore support
-func FilestoreBlockstoreCtor(repo repo.Repo, bb BaseBlocks) (gclocker blockstore.GCLocker, gcbs blockstore.GCBlockstore, bs blockstore.Blockstore, fstore *filestore.Filestore) {
+func FilestoreBlockstoreCtor(repo repo.Repo, bb BaseBlocks, wb *gc.WriteBarrier) (gclocker blockstore.GCLocker, gcbs blockstore.GCBlockstore, bs blockstore.Blockstore, fstore *filestore.Filestore) {
 	gclocker = blockstore.NewGCLocker()
 
 	// hash security
 	fstore = filestore.NewFilestore(bb, repo.FileManager())
-	gcbs = blockstore.NewGCBlockstore(fstore, gclocker)
+	gcbs = blockstore.NewGCBlockstore(wb.Blockstore(fstore), gclocker)
 	gcbs = &verifbs.VerifBSGC{GCBlockstore: gcbs}
 
 	bs = gcbs
@@ -0,0 +1,39 @@
+package denylist
+
+import (
+	blocks "github.com/ipfs/go-block-format"
+	"github.com/ipfs/go-cid"
+	blockstore "github.com/ipfs/go-ipfs-blockstore"
+)
+
+// Blockstore wraps bs to hide denied blocks from readers, so bitswap does
+// not serve them to other peers. Writes are passed through.
+func (dl *Denylist) Blockstore(bs blockstore.Blockstore) blockstore.Blockstore {
+	return &deniedBlockstore{Blockstore: bs, dl: dl}
+}
+
+type deniedBlockstore struct {
+	blockstore.Blockstore
+	dl *Denylist
+}
+
+func (bs *deniedBlockstore) Has(c cid.Cid) (bool, error) {
+	if bs.dl.IsCidDenied(c) {
+		return false, nil
+	}
+	return bs.Blockstore.Has(c)
+}
+
+func (bs *deniedBlockstore) Get(c cid.Cid) (blocks.Block, error) {
+	if bs.dl.IsCidDenied(c) {
+		return nil, blockstore.ErrNotFound
+	}
+	return bs.Blockstore.Get(c)
+}
+
+func (bs *deniedBlockstore) GetSize(c cid.Cid) (int, error) {
+	if bs.dl.IsCidDenied(c) {
+		return -1, blockstore.ErrNotFound
+	}
+	return bs.Blockstore.GetSize(c)
+}
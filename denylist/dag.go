@@ -0,0 +1,59 @@
+package denylist
+
+import (
+	"context"
+	"errors"
+
+	"github.com/ipfs/go-cid"
+	ipld "github.com/ipfs/go-ipld-format"
+)
+
+// ErrDenied is returned when reading a denied node through DAGService.
+var ErrDenied = errors.New("node is denied")
+
+// DAGService wraps ds so that denied nodes can't be read through it, for
+// exports which walk a DAG below an allowed root. Writes are passed through.
+// A nil Denylist returns ds unchanged.
+func (dl *Denylist) DAGService(ds ipld.DAGService) ipld.DAGService {
+	if dl == nil {
+		return ds
+	}
+	return &deniedDAGService{DAGService: ds, dl: dl}
+}
+
+type deniedDAGService struct {
+	ipld.DAGService
+	dl *Denylist
+}
+
+func (ds *deniedDAGService) Get(ctx context.Context, c cid.Cid) (ipld.Node, error) {
+	if ds.dl.IsCidDenied(c) {
+		return nil, ErrDenied
+	}
+	return ds.DAGService.Get(ctx, c)
+}
+
+func (ds *deniedDAGService) GetMany(ctx context.Context, cids []cid.Cid) <-chan *ipld.NodeOption {
+	// Buffered so the denied nodes never block the caller.
+	out := make(chan *ipld.NodeOption, len(cids))
+	allowed := make([]cid.Cid, 0, len(cids))
+	for _, c := range cids {
+		if ds.dl.IsCidDenied(c) {
+			out <- &ipld.NodeOption{Err: ErrDenied}
+			continue
+		}
+		allowed = append(allowed, c)
+	}
+
+	go func() {
+		defer close(out)
+		for opt := range ds.DAGService.GetMany(ctx, allowed) {
+			select {
+			case out <- opt:
+			case <-ctx.Done():
+				return
+			}
+		}
+	}()
+	return out
+}
@@ -0,0 +1,270 @@
+// Package denylist blocks content from being served by the gateway and,
+// optionally, by bitswap.
+//
+// A denylist file holds one entry per line, empty lines and lines starting
+// with # are ignored:
+//
+//	QmHash                  blocks the CID, including every path below it
+//	/ipfs/QmHash            same as above
+//	/ipfs/QmHash/some/path  blocks the path and every path below it
+//	/ipns/example.com       blocks the name, including every path below it
+//	/ipns/example.com/path  blocks the path and every path below it
+//
+// CIDs are compared by multihash, so all encodings and versions of a CID are
+// blocked by a single entry.
+package denylist
+
+import (
+	"bufio"
+	"context"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/fsnotify/fsnotify"
+	"github.com/ipfs/go-cid"
+	logging "github.com/ipfs/go-log"
+)
+
+var log = logging.Logger("denylist")
+
+// reloadDelay lets writes to the denylist file settle before it is reloaded.
+const reloadDelay = 500 * time.Millisecond
+
+// Config is the Gateway.Denylist config section.
+type Config struct {
+	// File is the path of the denylist file, the denylist is disabled when
+	// it is empty.
+	File string
+	// Bitswap also refuses to serve denied blocks to other peers.
+	Bitswap bool
+}
+
+// Denylist is a set of denied CIDs, paths and names which is reloaded when
+// its file changes. A nil Denylist denies nothing.
+type Denylist struct {
+	cfg Config
+
+	lock sync.RWMutex
+	// cids holds the multihashes of the entirely denied CIDs.
+	cids map[string]struct{}
+	// paths holds the denied /ipfs and /ipns paths, normalized by
+	// splitPath.
+	paths map[string]struct{}
+	// generation is incremented by every load.
+	generation uint64
+
+	ctx    context.Context
+	cancel context.CancelFunc
+	done   chan struct{}
+}
+
+// New loads the denylist file of cfg.
+func New(cfg Config) (*Denylist, error) {
+	dl := &Denylist{cfg: cfg}
+	if err := dl.load(); err != nil {
+		return nil, err
+	}
+	return dl, nil
+}
+
+// AppliesToBitswap reports whether bitswap should refuse denied blocks.
+func (dl *Denylist) AppliesToBitswap() bool {
+	return dl != nil && dl.cfg.Bitswap
+}
+
+// Len returns the number of entries of the denylist.
+func (dl *Denylist) Len() int {
+	if dl == nil {
+		return 0
+	}
+	dl.lock.RLock()
+	defer dl.lock.RUnlock()
+	return len(dl.cids) + len(dl.paths)
+}
+
+// Generation returns a number which changes whenever the denylist is
+// reloaded, so that anything derived from it can be invalidated.
+func (dl *Denylist) Generation() uint64 {
+	if dl == nil {
+		return 0
+	}
+	dl.lock.RLock()
+	defer dl.lock.RUnlock()
+	return dl.generation
+}
+
+// IsCidDenied reports whether c is denied as a whole.
+func (dl *Denylist) IsCidDenied(c cid.Cid) bool {
+	if dl == nil || !c.Defined() {
+		return false
+	}
+	dl.lock.RLock()
+	defer dl.lock.RUnlock()
+	_, denied := dl.cids[string(c.Hash())]
+	return denied
+}
+
+// IsPathDenied reports whether the /ipfs or /ipns path p, its root or one of
+// its parents is denied.
+func (dl *Denylist) IsPathDenied(p string) bool {
+	if dl == nil {
+		return false
+	}
+	segs, c, err := splitPath(p)
+	if err != nil {
+		return false
+	}
+	if c.Defined() && dl.IsCidDenied(c) {
+		return true
+	}
+
+	dl.lock.RLock()
+	defer dl.lock.RUnlock()
+	for n := 2; n <= len(segs); n++ {
+		if _, denied := dl.paths["/"+strings.Join(segs[:n], "/")]; denied {
+			return true
+		}
+	}
+	return false
+}
+
+// Start reloads the denylist whenever its file changes, until Close is
+// called.
+func (dl *Denylist) Start() error {
+	watcher, err := fsnotify.NewWatcher()
+	if err != nil {
+		return err
+	}
+	// Watch the directory, editors and tools often replace the file.
+	if err := watcher.Add(filepath.Dir(dl.cfg.File)); err != nil {
+		watcher.Close()
+		return err
+	}
+
+	dl.ctx, dl.cancel = context.WithCancel(context.Background())
+	dl.done = make(chan struct{})
+	go dl.watch(watcher)
+	return nil
+}
+
+// Close stops reloading the denylist.
+func (dl *Denylist) Close() error {
+	if dl == nil || dl.cancel == nil {
+		return nil
+	}
+	dl.cancel()
+	<-dl.done
+	return nil
+}
+
+func (dl *Denylist) watch(watcher *fsnotify.Watcher) {
+	defer close(dl.done)
+	defer watcher.Close()
+
+	name := filepath.Clean(dl.cfg.File)
+	var reload <-chan time.Time
+	for {
+		select {
+		case <-dl.ctx.Done():
+			return
+		case e, ok := <-watcher.Events:
+			if !ok {
+				return
+			}
+			if filepath.Clean(e.Name) == name {
+				reload = time.After(reloadDelay)
+			}
+		case err, ok := <-watcher.Errors:
+			if !ok {
+				return
+			}
+			log.Errorf("watching denylist %s: %s", dl.cfg.File, err)
+		case <-reload:
+			reload = nil
+			if err := dl.load(); err != nil {
+				log.Errorf("failed to reload denylist, keeping the previous entries: %s", err)
+				continue
+			}
+			log.Infof("reloaded denylist %s with %d entries", dl.cfg.File, dl.Len())
+		}
+	}
+}
+
+func (dl *Denylist) load() error {
+	f, err := os.Open(dl.cfg.File)
+	if err != nil {
+		return err
+	}
+	defer f.Close()
+
+	cids, paths, err := parse(f)
+	if err != nil {
+		return fmt.Errorf("%s: %s", dl.cfg.File, err)
+	}
+
+	dl.lock.Lock()
+	defer dl.lock.Unlock()
+	dl.cids, dl.paths = cids, paths
+	dl.generation++
+	return nil
+}
+
+func parse(r io.Reader) (map[string]struct{}, map[string]struct{}, error) {
+	cids := make(map[string]struct{})
+	paths := make(map[string]struct{})
+
+	scanner := bufio.NewScanner(r)
+	for n := 1; scanner.Scan(); n++ {
+		line := strings.TrimSpace(scanner.Text())
+		if line == "" || strings.HasPrefix(line, "#") {
+			continue
+		}
+		if !strings.HasPrefix(line, "/") {
+			line = "/ipfs/" + line
+		}
+
+		segs, c, err := splitPath(line)
+		if err != nil {
+			return nil, nil, fmt.Errorf("line %d: %s", n, err)
+		}
+		if c.Defined() && len(segs) == 2 {
+			cids[string(c.Hash())] = struct{}{}
+			continue
+		}
+		paths["/"+strings.Join(segs, "/")] = struct{}{}
+	}
+	return cids, paths, scanner.Err()
+}
+
+// splitPath returns the normalized segments of an /ipfs or /ipns path, with
+// the CID of /ipfs paths replaced by its multihash, and that CID.
+func splitPath(p string) ([]string, cid.Cid, error) {
+	var segs []string
+	for _, seg := range strings.Split(p, "/") {
+		if seg != "" {
+			segs = append(segs, seg)
+		}
+	}
+	if len(segs) < 2 {
+		return nil, cid.Undef, fmt.Errorf("invalid path %q", p)
+	}
+
+	switch segs[0] {
+	case "ipfs":
+		c, err := cid.Decode(segs[1])
+		if err != nil {
+			return nil, cid.Undef, fmt.Errorf("invalid CID in %q: %s", p, err)
+		}
+		segs[1] = c.Hash().B58String()
+		return segs, c, nil
+	case "ipns":
+		return segs, cid.Undef, nil
+	default:
+		return nil, cid.Undef, fmt.Errorf("path %q is neither an /ipfs nor an /ipns path", p)
+	}
+}
@@ -0,0 +1,174 @@
+package denylist
+
+import (
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	blocks "github.com/ipfs/go-block-format"
+	"github.com/ipfs/go-cid"
+	ds "github.com/ipfs/go-datastore"
+	dssync "github.com/ipfs/go-datastore/sync"
+	blockstore "github.com/ipfs/go-ipfs-blockstore"
+)
+
+const (
+	deniedV0 = "QmUNLLsPACCz1vLxQVkXqqLX5R1X345qqfHbsf67hvA3Nn"
+	// the same multihash as deniedV0, as a CIDv1
+	deniedV1 = "bafybeiczsscdsbs7ffqz55asqdf3smv6klcw3gofszvwlyarci47bgf354"
+	other    = "QmfM2r8seH2GiRaC4esTjeraXEachRt8ZsSeGaWTPLyMoG"
+)
+
+func writeDenylist(t *testing.T, file, content string) {
+	t.Helper()
+	if err := ioutil.WriteFile(file, []byte(content), 0644); err != nil {
+		t.Fatal(err)
+	}
+}
+
+func newDenylist(t *testing.T, content string) (*Denylist, string) {
+	t.Helper()
+	dir, err := ioutil.TempDir("", "denylist")
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { os.RemoveAll(dir) })
+	file := filepath.Join(dir, "denylist.txt")
+	writeDenylist(t, file, content)
+
+	dl, err := New(Config{File: file})
+	if err != nil {
+		t.Fatal(err)
+	}
+	return dl, file
+}
+
+func TestDenylist(t *testing.T) {
+	dl, _ := newDenylist(t, `
+# a comment
+`+deniedV0+`
+/ipfs/`+other+`/secret
+/ipns/example.com
+`)
+	if dl.Len() != 3 {
+		t.Fatalf("expected 3 entries, got %d", dl.Len())
+	}
+
+	for p, denied := range map[string]bool{
+		"/ipfs/" + deniedV0:                  true,
+		"/ipfs/" + deniedV1 + "/index.html":  true,
+		"/ipfs/" + other:                     false,
+		"/ipfs/" + other + "/public":         false,
+		"/ipfs/" + other + "/secret":         true,
+		"/ipfs/" + other + "/secret/":        true,
+		"/ipfs/" + other + "/secret/file":    true,
+		"/ipfs/" + other + "/secretive":      false,
+		"/ipns/example.com":                  true,
+		"/ipns/example.com/page":             true,
+		"/ipns/example.net":                  false,
+		"/ipns/example.com.evil.example.org": false,
+	} {
+		if dl.IsPathDenied(p) != denied {
+			t.Errorf("expected IsPathDenied(%q) to be %t", p, denied)
+		}
+	}
+
+	if !dl.IsCidDenied(mustParse(t, deniedV1)) {
+		t.Error("expected the CIDv1 of a denied CID to be denied")
+	}
+	if dl.IsCidDenied(mustParse(t, other)) {
+		t.Error("a CID with a denied path should not be denied as a whole")
+	}
+
+	var disabled *Denylist
+	if disabled.IsPathDenied("/ipfs/"+deniedV0) || disabled.IsCidDenied(mustParse(t, deniedV0)) {
+		t.Error("a nil denylist should deny nothing")
+	}
+}
+
+func TestDenylistInvalid(t *testing.T) {
+	dir, err := ioutil.TempDir("", "denylist")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+	file := filepath.Join(dir, "denylist.txt")
+
+	for _, content := range []string{"not-a-cid", "/foo/bar", "/ipns"} {
+		writeDenylist(t, file, content)
+		if _, err := New(Config{File: file}); err == nil {
+			t.Errorf("expected %q to be rejected", content)
+		}
+	}
+}
+
+func TestDenylistReload(t *testing.T) {
+	dl, file := newDenylist(t, deniedV0)
+	if err := dl.Start(); err != nil {
+		t.Fatal(err)
+	}
+	defer dl.Close()
+
+	gen := dl.Generation()
+	writeDenylist(t, file, other)
+	deadline := time.Now().Add(10 * time.Second)
+	for !dl.IsPathDenied("/ipfs/" + other) {
+		if time.Now().After(deadline) {
+			t.Fatal("denylist was not reloaded")
+		}
+		time.Sleep(50 * time.Millisecond)
+	}
+	if dl.IsPathDenied("/ipfs/" + deniedV0) {
+		t.Fatal("expected the previous entries to be replaced")
+	}
+	if dl.Generation() == gen {
+		t.Fatal("expected the generation to change on reload")
+	}
+	gen = dl.Generation()
+
+	// An invalid file keeps the previous entries.
+	writeDenylist(t, file, "not-a-cid")
+	time.Sleep(3 * reloadDelay)
+	if !dl.IsPathDenied("/ipfs/" + other) {
+		t.Fatal("expected the previous entries to be kept")
+	}
+	if dl.Generation() != gen {
+		t.Fatal("expected the generation to be kept")
+	}
+}
+
+func TestBlockstore(t *testing.T) {
+	bs := blockstore.NewBlockstore(dssync.MutexWrap(ds.NewMapDatastore()))
+	denied := blocks.NewBlock([]byte("denied"))
+	allowed := blocks.NewBlock([]byte("allowed"))
+
+	dl, _ := newDenylist(t, denied.Cid().String())
+	dbs := dl.Blockstore(bs)
+	if err := dbs.PutMany([]blocks.Block{denied, allowed}); err != nil {
+		t.Fatal(err)
+	}
+
+	if has, _ := bs.Has(denied.Cid()); !has {
+		t.Fatal("denied blocks should still be stored")
+	}
+	if has, _ := dbs.Has(denied.Cid()); has {
+		t.Fatal("denied block should be hidden")
+	}
+	if _, err := dbs.Get(denied.Cid()); err != blockstore.ErrNotFound {
+		t.Fatalf("expected ErrNotFound, got %v", err)
+	}
+	if _, err := dbs.Get(allowed.Cid()); err != nil {
+		t.Fatal(err)
+	}
+}
+
+func mustParse(t *testing.T, s string) cid.Cid {
+	t.Helper()
+	c, err := cid.Decode(s)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return c
+}
@@ -0,0 +1,341 @@
+package gc
+
+import (
+	"context"
+	"encoding/binary"
+	"sort"
+	"sync"
+	"time"
+
+	blocks "github.com/ipfs/go-block-format"
+	bserv "github.com/ipfs/go-blockservice"
+	cid "github.com/ipfs/go-cid"
+	dstore "github.com/ipfs/go-datastore"
+	dsq "github.com/ipfs/go-datastore/query"
+	bstore "github.com/ipfs/go-ipfs-blockstore"
+	dshelp "github.com/ipfs/go-ipfs-ds-help"
+	offline "github.com/ipfs/go-ipfs-exchange-offline"
+	pin "github.com/ipfs/go-ipfs-pinner"
+	dag "github.com/ipfs/go-merkledag"
+)
+
+// accessPrefix is the datastore prefix of the persisted access times.
+var accessPrefix = dstore.NewKey("/local/gc/access")
+
+// accessFlushInterval is how often the recorded access times are persisted.
+const accessFlushInterval = time.Minute
+
+// AccessTracker records when blocks were last written or read, so that Evict
+// can remove the least recently used ones. Access times are kept in memory
+// and persisted to the datastore periodically. A nil AccessTracker records
+// nothing.
+type AccessTracker struct {
+	ds dstore.Datastore
+
+	lock sync.Mutex
+	// pending holds the access times not persisted yet, in unix seconds by
+	// multihash.
+	pending map[string]int64
+
+	ctx    context.Context
+	cancel context.CancelFunc
+	done   chan struct{}
+}
+
+// NewAccessTracker returns an AccessTracker persisting to d.
+func NewAccessTracker(d dstore.Datastore) *AccessTracker {
+	return &AccessTracker{
+		ds:      d,
+		pending: make(map[string]int64),
+	}
+}
+
+// Blockstore wraps bs so that the blocks put into or read from it are
+// recorded.
+func (t *AccessTracker) Blockstore(bs bstore.Blockstore) bstore.Blockstore {
+	if t == nil {
+		return bs
+	}
+	return &accessBlockstore{Blockstore: bs, t: t}
+}
+
+func (t *AccessTracker) touch(cids ...cid.Cid) {
+	now := time.Now().Unix()
+	t.lock.Lock()
+	defer t.lock.Unlock()
+	for _, c := range cids {
+		t.pending[string(c.Hash())] = now
+	}
+}
+
+// Start persists the access times periodically until Close is called.
+func (t *AccessTracker) Start() {
+	t.ctx, t.cancel = context.WithCancel(context.Background())
+	t.done = make(chan struct{})
+	go func() {
+		defer close(t.done)
+		ticker := time.NewTicker(accessFlushInterval)
+		defer ticker.Stop()
+		for {
+			select {
+			case <-ticker.C:
+				if err := t.Flush(); err != nil {
+					log.Errorf("failed to persist block access times: %s", err)
+				}
+			case <-t.ctx.Done():
+				return
+			}
+		}
+	}()
+}
+
+// Close stops persisting the access times periodically and persists the
+// pending ones.
+func (t *AccessTracker) Close() error {
+	if t == nil {
+		return nil
+	}
+	if t.cancel != nil {
+		t.cancel()
+		<-t.done
+	}
+	return t.Flush()
+}
+
+// Flush persists the access times recorded since the last flush.
+func (t *AccessTracker) Flush() error {
+	t.lock.Lock()
+	pending := t.pending
+	t.pending = make(map[string]int64)
+	t.lock.Unlock()
+
+	if len(pending) == 0 {
+		return nil
+	}
+	b, err := batch(t.ds)
+	if err != nil {
+		return err
+	}
+	for mh, at := range pending {
+		var buf [8]byte
+		binary.BigEndian.PutUint64(buf[:], uint64(at))
+		if err := b.Put(accessKey(mh), buf[:]); err != nil {
+			return err
+		}
+	}
+	return b.Commit()
+}
+
+// lastAccess returns the last access times of the blocks by multihash.
+func (t *AccessTracker) lastAccess() (map[string]int64, error) {
+	res, err := t.ds.Query(dsq.Query{Prefix: accessPrefix.String()})
+	if err != nil {
+		return nil, err
+	}
+	defer res.Close()
+
+	access := make(map[string]int64)
+	for e := range res.Next() {
+		if e.Error != nil {
+			return nil, e.Error
+		}
+		mh, err := dshelp.BinaryFromDsKey(dstore.NewKey(dstore.RawKey(e.Key).BaseNamespace()))
+		if err != nil || len(e.Value) != 8 {
+			log.Warnf("ignoring invalid block access record %s", e.Key)
+			continue
+		}
+		access[string(mh)] = int64(binary.BigEndian.Uint64(e.Value))
+	}
+
+	t.lock.Lock()
+	defer t.lock.Unlock()
+	for mh, at := range t.pending {
+		access[mh] = at
+	}
+	return access, nil
+}
+
+// forget drops the access times of the given multihashes.
+func (t *AccessTracker) forget(mhs []string) error {
+	t.lock.Lock()
+	for _, mh := range mhs {
+		delete(t.pending, mh)
+	}
+	t.lock.Unlock()
+
+	if len(mhs) == 0 {
+		return nil
+	}
+	b, err := batch(t.ds)
+	if err != nil {
+		return err
+	}
+	for _, mh := range mhs {
+		if err := b.Delete(accessKey(mh)); err != nil {
+			return err
+		}
+	}
+	return b.Commit()
+}
+
+func accessKey(mh string) dstore.Key {
+	return accessPrefix.Child(dshelp.NewKeyFromBinary([]byte(mh)))
+}
+
+// batch batches writes to d when it supports it.
+func batch(d dstore.Datastore) (dstore.Batch, error) {
+	if bds, ok := d.(dstore.Batching); ok {
+		return bds.Batch()
+	}
+	return &unbatched{d}, nil
+}
+
+type unbatched struct {
+	dstore.Datastore
+}
+
+func (unbatched) Commit() error {
+	return nil
+}
+
+type accessBlockstore struct {
+	bstore.Blockstore
+	t *AccessTracker
+}
+
+func (bs *accessBlockstore) Put(b blocks.Block) error {
+	bs.t.touch(b.Cid())
+	return bs.Blockstore.Put(b)
+}
+
+func (bs *accessBlockstore) PutMany(blks []blocks.Block) error {
+	cids := make([]cid.Cid, len(blks))
+	for i, b := range blks {
+		cids[i] = b.Cid()
+	}
+	bs.t.touch(cids...)
+	return bs.Blockstore.PutMany(blks)
+}
+
+func (bs *accessBlockstore) Get(c cid.Cid) (blocks.Block, error) {
+	b, err := bs.Blockstore.Get(c)
+	if err == nil {
+		bs.t.touch(c)
+	}
+	return b, err
+}
+
+// Evict removes the least recently used blocks which are neither pinned nor
+// reachable from bestEffortRoots, until the removed blocks add up to target
+// bytes or no such block is left. Blocks which were not accessed since the
+// tracker was enabled are removed first. Like GC, it holds the GC lock for
+// the whole run.
+func Evict(ctx context.Context, bs bstore.GCBlockstore, t *AccessTracker, dstor dstore.Datastore, pn pin.Pinner, bestEffortRoots []cid.Cid, target uint64) <-chan Result {
+	ctx, cancel := context.WithCancel(ctx)
+
+	unlocker := bs.GCLock()
+
+	bsrv := bserv.New(bs, offline.Exchange(bs))
+	ds := dag.NewDAGService(bsrv)
+
+	output := make(chan Result, 128)
+
+	go func() {
+		defer cancel()
+		defer close(output)
+		defer unlocker.Unlock()
+
+		send := func(res Result) bool {
+			select {
+			case output <- res:
+				return true
+			case <-ctx.Done():
+				return false
+			}
+		}
+
+		gcs, err := ColoredSet(ctx, pn, ds, bestEffortRoots, output)
+		if err != nil {
+			send(Result{Error: err})
+			return
+		}
+		access, err := t.lastAccess()
+		if err != nil {
+			send(Result{Error: err})
+			return
+		}
+		keychan, err := bs.AllKeysChan(ctx)
+		if err != nil {
+			send(Result{Error: err})
+			return
+		}
+
+		type candidate struct {
+			c  cid.Cid
+			at int64
+		}
+		var candidates []candidate
+		for k := range keychan {
+			mh := string(k.Hash())
+			at := access[mh]
+			// What is left in access once all the keys are listed
+			// are records of blocks which are gone.
+			delete(access, mh)
+			if !gcs.Has(k) {
+				candidates = append(candidates, candidate{k, at})
+			}
+		}
+		if ctx.Err() != nil {
+			return
+		}
+		sort.SliceStable(candidates, func(i, j int) bool {
+			return candidates[i].at < candidates[j].at
+		})
+
+		forgotten := make([]string, 0, len(access))
+		for mh := range access {
+			forgotten = append(forgotten, mh)
+		}
+		defer func() {
+			if err := t.forget(forgotten); err != nil {
+				log.Errorf("failed to drop block access records: %s", err)
+			}
+		}()
+
+		errors := false
+		var freed uint64
+		for _, cand := range candidates {
+			if freed >= target {
+				break
+			}
+			size := blockSize(bs, cand.c)
+			if err := bs.DeleteBlock(cand.c); err != nil {
+				errors = true
+				if !send(Result{Error: &CannotDeleteBlockError{cand.c, err}}) {
+					return
+				}
+				continue
+			}
+			freed += size
+			forgotten = append(forgotten, string(cand.c.Hash()))
+			if !send(Result{KeyRemoved: cand.c, Size: size}) {
+				return
+			}
+		}
+		if errors {
+			if !send(Result{Error: ErrCannotDeleteSomeBlocks}) {
+				return
+			}
+		}
+
+		gds, ok := dstor.(dstore.GCDatastore)
+		if !ok {
+			return
+		}
+		if err := gds.CollectGarbage(); err != nil {
+			send(Result{Error: err})
+		}
+	}()
+
+	return output
+}
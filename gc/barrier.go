@@ -0,0 +1,141 @@
+package gc
+
+import (
+	"errors"
+	"sync"
+
+	blocks "github.com/ipfs/go-block-format"
+	cid "github.com/ipfs/go-cid"
+	bstore "github.com/ipfs/go-ipfs-blockstore"
+)
+
+// ErrGCRunning is returned when an incremental garbage collection is started
+// while another one is still running.
+var ErrGCRunning = errors.New("an incremental garbage collection is already running")
+
+// WriteBarrier shades the blocks written or read while an incremental garbage
+// collection runs without holding the GC lock, so that blocks used by adds and
+// pins which start after the pins were listed are not swept.
+//
+// All the blocks of the node have to go through the blockstore returned by
+// Blockstore for the barrier to be effective.
+type WriteBarrier struct {
+	lock sync.Mutex
+	// marked is the coloured set of the running collection, nil when no
+	// collection is running.
+	marked *cid.Set
+	// shaded holds the other blocks used while the collection runs. Their
+	// descendants are only walked once, right before sweeping.
+	shaded *cid.Set
+}
+
+// NewWriteBarrier returns an inactive write barrier.
+func NewWriteBarrier() *WriteBarrier {
+	return &WriteBarrier{}
+}
+
+// start activates the barrier with an empty coloured set.
+func (wb *WriteBarrier) start() error {
+	wb.lock.Lock()
+	defer wb.lock.Unlock()
+	if wb.marked != nil {
+		return ErrGCRunning
+	}
+	wb.marked = cid.NewSet()
+	wb.shaded = cid.NewSet()
+	return nil
+}
+
+func (wb *WriteBarrier) stop() {
+	wb.lock.Lock()
+	defer wb.lock.Unlock()
+	wb.marked = nil
+	wb.shaded = nil
+}
+
+// visit marks c, it returns false if c was already marked.
+func (wb *WriteBarrier) visit(c cid.Cid) bool {
+	wb.lock.Lock()
+	defer wb.lock.Unlock()
+	wb.shaded.Remove(c)
+	return wb.marked.Visit(c)
+}
+
+// shadedKeys returns the blocks shaded so far.
+func (wb *WriteBarrier) shadedKeys() []cid.Cid {
+	wb.lock.Lock()
+	defer wb.lock.Unlock()
+	return wb.shaded.Keys()
+}
+
+func (wb *WriteBarrier) isMarked(c cid.Cid) bool {
+	wb.lock.Lock()
+	defer wb.lock.Unlock()
+	return wb.marked.Has(c) || wb.shaded.Has(c)
+}
+
+func (wb *WriteBarrier) len() int {
+	wb.lock.Lock()
+	defer wb.lock.Unlock()
+	return wb.marked.Len() + wb.shaded.Len()
+}
+
+// shade keeps cids from being swept when a collection is running.
+func (wb *WriteBarrier) shade(cids ...cid.Cid) {
+	wb.lock.Lock()
+	defer wb.lock.Unlock()
+	if wb.marked == nil {
+		return
+	}
+	for _, c := range cids {
+		// The collection marks the blocks it reads beforehand, there is
+		// no need to keep them twice.
+		if !wb.marked.Has(c) {
+			wb.shaded.Add(c)
+		}
+	}
+}
+
+// Blockstore wraps bs so that the blocks put into, read from or looked up in
+// it are shaded during incremental garbage collections.
+func (wb *WriteBarrier) Blockstore(bs bstore.Blockstore) bstore.Blockstore {
+	return &barrierBlockstore{Blockstore: bs, wb: wb}
+}
+
+type barrierBlockstore struct {
+	bstore.Blockstore
+	wb *WriteBarrier
+}
+
+func (bs *barrierBlockstore) Put(b blocks.Block) error {
+	bs.wb.shade(b.Cid())
+	return bs.Blockstore.Put(b)
+}
+
+func (bs *barrierBlockstore) PutMany(blks []blocks.Block) error {
+	cids := make([]cid.Cid, len(blks))
+	for i, b := range blks {
+		cids[i] = b.Cid()
+	}
+	bs.wb.shade(cids...)
+	return bs.Blockstore.PutMany(blks)
+}
+
+// Get shades the blocks read, pinning content which is already stored only
+// reads it.
+func (bs *barrierBlockstore) Get(c cid.Cid) (blocks.Block, error) {
+	bs.wb.shade(c)
+	return bs.Blockstore.Get(c)
+}
+
+// Has shades the blocks looked up, linking content which is already stored
+// may only check that it is there.
+func (bs *barrierBlockstore) Has(c cid.Cid) (bool, error) {
+	bs.wb.shade(c)
+	return bs.Blockstore.Has(c)
+}
+
+func (bs *barrierBlockstore) GetSize(c cid.Cid) (int, error) {
+	bs.wb.shade(c)
+	return bs.Blockstore.GetSize(c)
+}
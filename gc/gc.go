@@ -22,10 +22,22 @@ import (
 var log = logging.Logger("gc")
 
 // Result represents an incremental output from a garbage collection
-// run.  It contains either an error, or the cid of a removed object.
+// run.  It contains either an error, the cid of a removed object, or the
+// progress of an incremental run. In dry runs, KeyRemoved is the cid of
+// an object which would be removed.
 type Result struct {
 	KeyRemoved cid.Cid
-	Error      error
+	// Size is the size in bytes of the removed object, if known.
+	Size     uint64
+	Error    error
+	Progress *Progress
+}
+
+// Options configures a garbage collection run.
+type Options struct {
+	// DryRun reports the blocks which would be removed without removing
+	// them.
+	DryRun bool
 }
 
 // GC performs a mark and sweep garbage collection of the blocks in the blockstore
@@ -38,6 +50,11 @@ type Result struct {
 // The routine then iterates over every block in the blockstore and
 // deletes any block that is not found in the marked set.
 func GC(ctx context.Context, bs bstore.GCBlockstore, dstor dstore.Datastore, pn pin.Pinner, bestEffortRoots []cid.Cid) <-chan Result {
+	return GCWithOptions(ctx, bs, dstor, pn, bestEffortRoots, Options{})
+}
+
+// GCWithOptions is GC configured by opts.
+func GCWithOptions(ctx context.Context, bs bstore.GCBlockstore, dstor dstore.Datastore, pn pin.Pinner, bestEffortRoots []cid.Cid, opts Options) <-chan Result {
 	ctx, cancel := context.WithCancel(ctx)
 
 	unlocker := bs.GCLock()
@@ -80,6 +97,15 @@ func GC(ctx context.Context, bs bstore.GCBlockstore, dstor dstore.Datastore, pn
 					break loop
 				}
 				if !gcs.Has(k) {
+					size := blockSize(bs, k)
+					if opts.DryRun {
+						select {
+						case output <- Result{KeyRemoved: k, Size: size}:
+						case <-ctx.Done():
+							break loop
+						}
+						continue loop
+					}
 					err := bs.DeleteBlock(k)
 					removed++
 					if err != nil {
@@ -93,7 +119,7 @@ func GC(ctx context.Context, bs bstore.GCBlockstore, dstor dstore.Datastore, pn
 						continue loop
 					}
 					select {
-					case output <- Result{KeyRemoved: k}:
+					case output <- Result{KeyRemoved: k, Size: size}:
 					case <-ctx.Done():
 						break loop
 					}
@@ -111,7 +137,7 @@ func GC(ctx context.Context, bs bstore.GCBlockstore, dstor dstore.Datastore, pn
 		}
 
 		gds, ok := dstor.(dstore.GCDatastore)
-		if !ok {
+		if !ok || opts.DryRun {
 			return
 		}
 
@@ -128,10 +154,23 @@ func GC(ctx context.Context, bs bstore.GCBlockstore, dstor dstore.Datastore, pn
 	return output
 }
 
+// blockSize returns the size of the block k, or 0 when it is unknown.
+func blockSize(bs bstore.Blockstore, k cid.Cid) uint64 {
+	size, err := bs.GetSize(k)
+	if err != nil || size < 0 {
+		return 0
+	}
+	return uint64(size)
+}
+
 // Descendants recursively finds all the descendants of the given roots and
 // adds them to the given cid.Set, using the provided dag.GetLinks function
 // to walk the tree.
 func Descendants(ctx context.Context, getLinks dag.GetLinks, set *cid.Set, roots []cid.Cid) error {
+	return descendants(ctx, getLinks, set.Visit, roots)
+}
+
+func descendants(ctx context.Context, getLinks dag.GetLinks, visit func(cid.Cid) bool, roots []cid.Cid) error {
 	verifyGetLinks := func(ctx context.Context, c cid.Cid) ([]*ipld.Link, error) {
 		err := verifcid.ValidateCid(c)
 		if err != nil {
@@ -154,7 +193,7 @@ func Descendants(ctx context.Context, getLinks dag.GetLinks, set *cid.Set, roots
 
 	for _, c := range roots {
 		// Walk recursively walks the dag and adds the keys to the given set
-		err := dag.Walk(ctx, verifyGetLinks, c, set.Visit, dag.Concurrent())
+		err := dag.Walk(ctx, verifyGetLinks, c, visit, dag.Concurrent())
 
 		if err != nil {
 			err = verboseCidError(err)
@@ -170,8 +209,43 @@ func Descendants(ctx context.Context, getLinks dag.GetLinks, set *cid.Set, roots
 func ColoredSet(ctx context.Context, pn pin.Pinner, ng ipld.NodeGetter, bestEffortRoots []cid.Cid, output chan<- Result) (*cid.Set, error) {
 	// KeySet currently implemented in memory, in the future, may be bloom filter or
 	// disk backed to conserve memory.
-	errors := false
 	gcs := cid.NewSet()
+	roots, err := listRoots(ctx, pn, bestEffortRoots)
+	if err != nil {
+		return nil, err
+	}
+	if err := colour(ctx, ng, gcs.Visit, roots, output); err != nil {
+		return nil, err
+	}
+	return gcs, nil
+}
+
+// gcRoots are the roots the coloured set is computed from.
+type gcRoots struct {
+	recursive  []cid.Cid
+	direct     []cid.Cid
+	internal   []cid.Cid
+	bestEffort []cid.Cid
+}
+
+func listRoots(ctx context.Context, pn pin.Pinner, bestEffortRoots []cid.Cid) (gcRoots, error) {
+	roots := gcRoots{bestEffort: bestEffortRoots}
+	var err error
+	if roots.recursive, err = pn.RecursiveKeys(ctx); err != nil {
+		return roots, err
+	}
+	if roots.direct, err = pn.DirectKeys(ctx); err != nil {
+		return roots, err
+	}
+	if roots.internal, err = pn.InternalPins(ctx); err != nil {
+		return roots, err
+	}
+	return roots, nil
+}
+
+// colour calls visit on all the blocks reachable from roots.
+func colour(ctx context.Context, ng ipld.NodeGetter, visit func(cid.Cid) bool, roots gcRoots, output chan<- Result) error {
+	errors := false
 	getLinks := func(ctx context.Context, cid cid.Cid) ([]*ipld.Link, error) {
 		links, err := ipld.GetLinks(ctx, ng, cid)
 		if err != nil {
@@ -184,17 +258,13 @@ func ColoredSet(ctx context.Context, pn pin.Pinner, ng ipld.NodeGetter, bestEffo
 		}
 		return links, nil
 	}
-	rkeys, err := pn.RecursiveKeys(ctx)
-	if err != nil {
-		return nil, err
-	}
-	err = Descendants(ctx, getLinks, gcs, rkeys)
+	err := descendants(ctx, getLinks, visit, roots.recursive)
 	if err != nil {
 		errors = true
 		select {
 		case output <- Result{Error: err}:
 		case <-ctx.Done():
-			return nil, ctx.Err()
+			return ctx.Err()
 		}
 	}
 
@@ -210,43 +280,35 @@ func ColoredSet(ctx context.Context, pn pin.Pinner, ng ipld.NodeGetter, bestEffo
 		}
 		return links, nil
 	}
-	err = Descendants(ctx, bestEffortGetLinks, gcs, bestEffortRoots)
+	err = descendants(ctx, bestEffortGetLinks, visit, roots.bestEffort)
 	if err != nil {
 		errors = true
 		select {
 		case output <- Result{Error: err}:
 		case <-ctx.Done():
-			return nil, ctx.Err()
+			return ctx.Err()
 		}
 	}
 
-	dkeys, err := pn.DirectKeys(ctx)
-	if err != nil {
-		return nil, err
-	}
-	for _, k := range dkeys {
-		gcs.Add(k)
+	for _, k := range roots.direct {
+		visit(k)
 	}
 
-	ikeys, err := pn.InternalPins(ctx)
-	if err != nil {
-		return nil, err
-	}
-	err = Descendants(ctx, getLinks, gcs, ikeys)
+	err = descendants(ctx, getLinks, visit, roots.internal)
 	if err != nil {
 		errors = true
 		select {
 		case output <- Result{Error: err}:
 		case <-ctx.Done():
-			return nil, ctx.Err()
+			return ctx.Err()
 		}
 	}
 
 	if errors {
-		return nil, ErrCannotFetchAllLinks
+		return ErrCannotFetchAllLinks
 	}
 
-	return gcs, nil
+	return nil
 }
 
 // ErrCannotFetchAllLinks is returned as the last Result in the GC output
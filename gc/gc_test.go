@@ -0,0 +1,360 @@
+package gc
+
+import (
+	"context"
+	"encoding/binary"
+	"testing"
+
+	bserv "github.com/ipfs/go-blockservice"
+	cid "github.com/ipfs/go-cid"
+	ds "github.com/ipfs/go-datastore"
+	dssync "github.com/ipfs/go-datastore/sync"
+	bstore "github.com/ipfs/go-ipfs-blockstore"
+	offline "github.com/ipfs/go-ipfs-exchange-offline"
+	pin "github.com/ipfs/go-ipfs-pinner"
+	dag "github.com/ipfs/go-merkledag"
+)
+
+// lateWriteBlockstore writes a block through the write barrier once the
+// sweep lists the keys, like an add running during marking.
+type lateWriteBlockstore struct {
+	bstore.GCBlockstore
+	write func()
+}
+
+func (bs *lateWriteBlockstore) AllKeysChan(ctx context.Context) (<-chan cid.Cid, error) {
+	bs.write()
+	return bs.GCBlockstore.AllKeysChan(ctx)
+}
+
+func TestIncremental(t *testing.T) {
+	ctx := context.Background()
+
+	dstore := dssync.MutexWrap(ds.NewMapDatastore())
+	wb := NewWriteBarrier()
+	base := wb.Blockstore(bstore.NewBlockstore(dstore))
+	gcbs := bstore.NewGCBlockstore(base, bstore.NewGCLocker())
+	dserv := dag.NewDAGService(bserv.New(gcbs, offline.Exchange(gcbs)))
+	pinner := pin.NewPinner(dstore, dserv, dserv)
+
+	child := dag.NodeWithData([]byte("child"))
+	root := dag.NodeWithData([]byte("root"))
+	if err := root.AddNodeLink("child", child); err != nil {
+		t.Fatal(err)
+	}
+	garbage := dag.NodeWithData([]byte("garbage"))
+	late := dag.NodeWithData([]byte("late"))
+	for _, nd := range []*dag.ProtoNode{child, root, garbage} {
+		if err := dserv.Add(ctx, nd); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if err := pinner.Pin(ctx, root, true); err != nil {
+		t.Fatal(err)
+	}
+	if err := pinner.Flush(ctx); err != nil {
+		t.Fatal(err)
+	}
+
+	bs := &lateWriteBlockstore{GCBlockstore: gcbs, write: func() {
+		if err := dserv.Add(ctx, late); err != nil {
+			t.Error(err)
+		}
+	}}
+
+	var removed []cid.Cid
+	var progress []Progress
+	for res := range Incremental(ctx, bs, wb, dstore, pinner, nil, IncrementalOptions{BatchSize: 1}) {
+		switch {
+		case res.Error != nil:
+			t.Fatal(res.Error)
+		case res.Progress != nil:
+			progress = append(progress, *res.Progress)
+		default:
+			removed = append(removed, res.KeyRemoved)
+		}
+	}
+
+	if len(removed) != 1 || !removed[0].Equals(garbage.Cid()) {
+		t.Fatalf("expected only %s to be removed, got %v", garbage.Cid(), removed)
+	}
+	for _, nd := range []*dag.ProtoNode{root, child, late} {
+		if has, _ := gcbs.Has(nd.Cid()); !has {
+			t.Errorf("%s should have been kept", nd.Cid())
+		}
+	}
+
+	if len(progress) == 0 || progress[0].Phase != PhaseMark {
+		t.Fatalf("expected a progress report after marking, got %v", progress)
+	}
+	last := progress[len(progress)-1]
+	if last.Phase != PhaseSweep || last.Removed != 1 || last.Scanned == 0 {
+		t.Fatalf("unexpected final progress %+v", last)
+	}
+}
+
+// pinDuringMarkPinner runs pin once the pins were listed for the marking, like
+// a pin of already stored content starting right after the GC lock is
+// released.
+type pinDuringMarkPinner struct {
+	pin.Pinner
+	pin func()
+}
+
+func (p *pinDuringMarkPinner) RecursiveKeys(ctx context.Context) ([]cid.Cid, error) {
+	keys, err := p.Pinner.RecursiveKeys(ctx)
+	if p.pin != nil {
+		p.pin()
+		p.pin = nil
+	}
+	return keys, err
+}
+
+func TestIncrementalPinDuringMark(t *testing.T) {
+	ctx := context.Background()
+
+	dstore := dssync.MutexWrap(ds.NewMapDatastore())
+	wb := NewWriteBarrier()
+	base := bstore.NewBlockstore(dstore)
+	gcbs := bstore.NewGCBlockstore(wb.Blockstore(base), bstore.NewGCLocker())
+	dserv := dag.NewDAGService(bserv.New(gcbs, offline.Exchange(gcbs)))
+	// The pinner reads around the barrier, so only the rescan before the
+	// sweep can see what it pins.
+	rawServ := dag.NewDAGService(bserv.New(base, offline.Exchange(base)))
+
+	child := dag.NodeWithData([]byte("child"))
+	root := dag.NodeWithData([]byte("root"))
+	if err := root.AddNodeLink("child", child); err != nil {
+		t.Fatal(err)
+	}
+	linked := dag.NodeWithData([]byte("linked"))
+	parent := dag.NodeWithData([]byte("parent"))
+	if err := parent.AddNodeLink("linked", linked); err != nil {
+		t.Fatal(err)
+	}
+	garbage := dag.NodeWithData([]byte("garbage"))
+	for _, nd := range []*dag.ProtoNode{child, root, linked, garbage} {
+		if err := rawServ.Add(ctx, nd); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	pinner := &pinDuringMarkPinner{Pinner: pin.NewPinner(dstore, rawServ, rawServ)}
+	pinner.pin = func() {
+		if err := pinner.Pinner.Pin(ctx, root, true); err != nil {
+			t.Error(err)
+		}
+		// parent is written through the barrier and links content
+		// which was already stored.
+		if err := dserv.Add(ctx, parent); err != nil {
+			t.Error(err)
+		}
+	}
+
+	var removed []cid.Cid
+	for res := range Incremental(ctx, gcbs, wb, dstore, pinner, nil, IncrementalOptions{}) {
+		if res.Error != nil {
+			t.Fatal(res.Error)
+		}
+		if res.KeyRemoved.Defined() {
+			removed = append(removed, res.KeyRemoved)
+		}
+	}
+
+	if len(removed) != 1 || !removed[0].Equals(garbage.Cid()) {
+		t.Fatalf("expected only %s to be removed, got %v", garbage.Cid(), removed)
+	}
+	for _, nd := range []*dag.ProtoNode{root, child, parent, linked} {
+		if has, _ := base.Has(nd.Cid()); !has {
+			t.Errorf("%s should have been kept", nd.Cid())
+		}
+	}
+}
+
+func TestGCDryRun(t *testing.T) {
+	ctx := context.Background()
+
+	dstore := dssync.MutexWrap(ds.NewMapDatastore())
+	gcbs := bstore.NewGCBlockstore(bstore.NewBlockstore(dstore), bstore.NewGCLocker())
+	dserv := dag.NewDAGService(bserv.New(gcbs, offline.Exchange(gcbs)))
+	pinner := pin.NewPinner(dstore, dserv, dserv)
+
+	garbage := dag.NodeWithData([]byte("garbage"))
+	if err := dserv.Add(ctx, garbage); err != nil {
+		t.Fatal(err)
+	}
+	if err := pinner.Flush(ctx); err != nil {
+		t.Fatal(err)
+	}
+
+	collect := func(out <-chan Result) []Result {
+		var removed []Result
+		for res := range out {
+			if res.Error != nil {
+				t.Fatal(res.Error)
+			}
+			if res.KeyRemoved.Defined() {
+				removed = append(removed, res)
+			}
+		}
+		return removed
+	}
+	expectGarbage := func(removed []Result) {
+		t.Helper()
+		if len(removed) != 1 || !removed[0].KeyRemoved.Equals(garbage.Cid()) {
+			t.Fatalf("expected only %s to be removed, got %v", garbage.Cid(), removed)
+		}
+		if removed[0].Size != uint64(len(garbage.RawData())) {
+			t.Fatalf("expected a size of %d, got %d", len(garbage.RawData()), removed[0].Size)
+		}
+	}
+
+	expectGarbage(collect(GCWithOptions(ctx, gcbs, dstore, pinner, nil, Options{DryRun: true})))
+	expectGarbage(collect(Incremental(ctx, gcbs, NewWriteBarrier(), dstore, pinner, nil, IncrementalOptions{DryRun: true})))
+	if has, _ := gcbs.Has(garbage.Cid()); !has {
+		t.Fatal("dry runs should not remove anything")
+	}
+
+	expectGarbage(collect(GC(ctx, gcbs, dstore, pinner, nil)))
+	if has, _ := gcbs.Has(garbage.Cid()); has {
+		t.Fatal("garbage should have been removed")
+	}
+}
+
+func TestIncrementalAlreadyRunning(t *testing.T) {
+	wb := NewWriteBarrier()
+	if err := wb.start(); err != nil {
+		t.Fatal(err)
+	}
+	defer wb.stop()
+
+	dstore := dssync.MutexWrap(ds.NewMapDatastore())
+	gcbs := bstore.NewGCBlockstore(bstore.NewBlockstore(dstore), bstore.NewGCLocker())
+	dserv := dag.NewDAGService(bserv.New(gcbs, offline.Exchange(gcbs)))
+	pinner := pin.NewPinner(dstore, dserv, dserv)
+
+	res := <-Incremental(context.Background(), gcbs, wb, dstore, pinner, nil, IncrementalOptions{})
+	if res.Error != ErrGCRunning {
+		t.Fatalf("expected %s, got %v", ErrGCRunning, res.Error)
+	}
+}
+
+func TestWriteBarrierShading(t *testing.T) {
+	wb := NewWriteBarrier()
+	bs := wb.Blockstore(bstore.NewBlockstore(dssync.MutexWrap(ds.NewMapDatastore())))
+
+	before := dag.NodeWithData([]byte("before"))
+	if err := bs.Put(before); err != nil {
+		t.Fatal(err)
+	}
+
+	if err := wb.start(); err != nil {
+		t.Fatal(err)
+	}
+	if wb.isMarked(before.Cid()) {
+		t.Fatal("blocks written before the collection should not be shaded")
+	}
+	if _, err := bs.Get(before.Cid()); err != nil {
+		t.Fatal(err)
+	}
+	if !wb.isMarked(before.Cid()) {
+		t.Fatal("blocks read during the collection should be shaded")
+	}
+	for name, lookup := range map[string]func(cid.Cid){
+		"has":  func(c cid.Cid) { bs.Has(c) },
+		"size": func(c cid.Cid) { bs.GetSize(c) },
+	} {
+		c := dag.NodeWithData([]byte(name)).Cid()
+		lookup(c)
+		if !wb.isMarked(c) {
+			t.Fatalf("%s: blocks looked up during the collection should be shaded", name)
+		}
+	}
+	// Shaded blocks still have to be walked.
+	if !wb.visit(before.Cid()) {
+		t.Fatal("shaded blocks should still be visited")
+	}
+	wb.stop()
+
+	if err := bs.Put(dag.NodeWithData([]byte("after"))); err != nil {
+		t.Fatal(err)
+	}
+}
+
+func TestEvict(t *testing.T) {
+	ctx := context.Background()
+
+	dstore := dssync.MutexWrap(ds.NewMapDatastore())
+	at := NewAccessTracker(dstore)
+	gcbs := bstore.NewGCBlockstore(at.Blockstore(bstore.NewBlockstore(dstore)), bstore.NewGCLocker())
+	dserv := dag.NewDAGService(bserv.New(gcbs, offline.Exchange(gcbs)))
+	pinner := pin.NewPinner(dstore, dserv, dserv)
+
+	pinned := dag.NodeWithData([]byte("pinned"))
+	cold := dag.NodeWithData([]byte("cold"))
+	warm := dag.NodeWithData([]byte("warm"))
+	hot := dag.NodeWithData([]byte("hot"))
+	for _, nd := range []*dag.ProtoNode{pinned, cold, warm, hot} {
+		if err := dserv.Add(ctx, nd); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if err := pinner.Pin(ctx, pinned, true); err != nil {
+		t.Fatal(err)
+	}
+	if err := pinner.Flush(ctx); err != nil {
+		t.Fatal(err)
+	}
+
+	// Access times have a resolution of a second, set them explicitly.
+	setAccess := func(nd *dag.ProtoNode, at int64) {
+		var buf [8]byte
+		binary.BigEndian.PutUint64(buf[:], uint64(at))
+		if err := dstore.Put(accessKey(string(nd.Cid().Hash())), buf[:]); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if err := at.Flush(); err != nil {
+		t.Fatal(err)
+	}
+	setAccess(cold, 1)
+	setAccess(warm, 2)
+	setAccess(hot, 3)
+	if _, err := gcbs.Get(hot.Cid()); err != nil {
+		t.Fatal(err)
+	}
+
+	evict := func(target uint64) []cid.Cid {
+		var removed []cid.Cid
+		for res := range Evict(ctx, gcbs, at, dstore, pinner, nil, target) {
+			if res.Error != nil {
+				t.Fatal(res.Error)
+			}
+			removed = append(removed, res.KeyRemoved)
+		}
+		return removed
+	}
+
+	removed := evict(uint64(len(cold.RawData()) + 1))
+	if len(removed) != 2 || !removed[0].Equals(cold.Cid()) || !removed[1].Equals(warm.Cid()) {
+		t.Fatalf("expected %s and %s to be evicted, got %v", cold.Cid(), warm.Cid(), removed)
+	}
+	if has, _ := gcbs.Has(hot.Cid()); !has {
+		t.Fatal("recently used block should be kept")
+	}
+	access, err := at.lastAccess()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, ok := access[string(cold.Cid().Hash())]; ok {
+		t.Fatal("access time of evicted block should be dropped")
+	}
+
+	removed = evict(1 << 20)
+	if len(removed) != 1 || !removed[0].Equals(hot.Cid()) {
+		t.Fatalf("expected only %s to be evicted, got %v", hot.Cid(), removed)
+	}
+	if has, _ := gcbs.Has(pinned.Cid()); !has {
+		t.Fatal("pinned block should never be evicted")
+	}
+}
@@ -0,0 +1,241 @@
+package gc
+
+import (
+	"context"
+
+	bserv "github.com/ipfs/go-blockservice"
+	cid "github.com/ipfs/go-cid"
+	dstore "github.com/ipfs/go-datastore"
+	bstore "github.com/ipfs/go-ipfs-blockstore"
+	offline "github.com/ipfs/go-ipfs-exchange-offline"
+	pin "github.com/ipfs/go-ipfs-pinner"
+	ipld "github.com/ipfs/go-ipld-format"
+	dag "github.com/ipfs/go-merkledag"
+)
+
+// DefaultBatchSize is the number of blocks an incremental garbage collection
+// deletes while holding the GC lock.
+const DefaultBatchSize = 1000
+
+// Phases of an incremental garbage collection.
+const (
+	PhaseMark  = "mark"
+	PhaseSweep = "sweep"
+)
+
+// IncrementalOptions configures an incremental garbage collection.
+type IncrementalOptions struct {
+	// BatchSize is the number of blocks deleted while holding the GC lock,
+	// DefaultBatchSize when zero.
+	BatchSize int
+	// DryRun reports the blocks which would be removed without removing
+	// them.
+	DryRun bool
+}
+
+// Progress reports how far an incremental garbage collection got.
+type Progress struct {
+	Phase string
+	// Marked is the number of blocks kept, including those shaded by the
+	// write barrier.
+	Marked int
+	// Scanned is the number of blocks of the blockstore checked so far.
+	Scanned uint64
+	// Removed is the number of blocks removed so far.
+	Removed uint64
+	// Size is the total size in bytes of the blocks removed so far.
+	Size uint64
+}
+
+// Incremental performs the same garbage collection as GC without holding the
+// GC lock for the whole run, so that adds and pins can proceed meanwhile.
+//
+// The pins and the roots returned by bestEffortRoots are listed under the GC
+// lock, at the same time the write barrier starts shading the blocks used
+// through its blockstore. The marked set is then computed without the lock.
+// Before sweeping, the roots are listed again under the GC lock and the
+// descendants of new roots and of shaded blocks are marked, so that content
+// which was already stored and got pinned or linked meanwhile is kept. The
+// blockstore is then swept in batches of opts.BatchSize blocks, each under
+// the GC lock. Blocks shaded by the barrier before their batch is swept are
+// kept.
+//
+// Progress results are sent once marking is done and after every batch.
+func Incremental(ctx context.Context, bs bstore.GCBlockstore, wb *WriteBarrier, dstor dstore.Datastore, pn pin.Pinner, bestEffortRoots func() ([]cid.Cid, error), opts IncrementalOptions) <-chan Result {
+	ctx, cancel := context.WithCancel(ctx)
+
+	batchSize := opts.BatchSize
+	if batchSize <= 0 {
+		batchSize = DefaultBatchSize
+	}
+
+	output := make(chan Result, 128)
+
+	go func() {
+		defer cancel()
+		defer close(output)
+
+		send := func(res Result) bool {
+			select {
+			case output <- res:
+				return true
+			case <-ctx.Done():
+				return false
+			}
+		}
+
+		unlocker := bs.GCLock()
+		if err := wb.start(); err != nil {
+			unlocker.Unlock()
+			send(Result{Error: err})
+			return
+		}
+		defer wb.stop()
+		roots, err := listIncrementalRoots(ctx, pn, bestEffortRoots)
+		unlocker.Unlock()
+		if err != nil {
+			send(Result{Error: err})
+			return
+		}
+
+		bsrv := bserv.New(bs, offline.Exchange(bs))
+		ds := dag.NewDAGService(bsrv)
+
+		if err := colour(ctx, ds, wb.visit, roots, output); err != nil {
+			send(Result{Error: err})
+			return
+		}
+
+		unlocker = bs.GCLock()
+		err = rescan(ctx, ds, wb, pn, bestEffortRoots, output)
+		unlocker.Unlock()
+		if err != nil {
+			send(Result{Error: err})
+			return
+		}
+		if !send(Result{Progress: &Progress{Phase: PhaseMark, Marked: wb.len()}}) {
+			return
+		}
+
+		keychan, err := bs.AllKeysChan(ctx)
+		if err != nil {
+			send(Result{Error: err})
+			return
+		}
+
+		progress := Progress{Phase: PhaseSweep}
+		errors := false
+		batch := make([]cid.Cid, 0, batchSize)
+
+		sweep := func() bool {
+			var removed []Result
+			var failed []Result
+
+			unlocker := bs.GCLock()
+			for _, k := range batch {
+				// The barrier may have shaded k since it was scanned.
+				if wb.isMarked(k) {
+					continue
+				}
+				size := blockSize(bs, k)
+				if !opts.DryRun {
+					if err := bs.DeleteBlock(k); err != nil {
+						failed = append(failed, Result{Error: &CannotDeleteBlockError{k, err}})
+						continue
+					}
+				}
+				removed = append(removed, Result{KeyRemoved: k, Size: size})
+			}
+			unlocker.Unlock()
+			batch = batch[:0]
+
+			for _, res := range failed {
+				errors = true
+				if !send(res) {
+					return false
+				}
+			}
+			for _, res := range removed {
+				if !send(res) {
+					return false
+				}
+				progress.Removed++
+				progress.Size += res.Size
+			}
+			progress.Marked = wb.len()
+			p := progress
+			return send(Result{Progress: &p})
+		}
+
+	loop:
+		for ctx.Err() == nil {
+			select {
+			case k, ok := <-keychan:
+				if !ok {
+					break loop
+				}
+				progress.Scanned++
+				if wb.isMarked(k) {
+					continue
+				}
+				batch = append(batch, k)
+				if len(batch) == batchSize && !sweep() {
+					return
+				}
+			case <-ctx.Done():
+				break loop
+			}
+		}
+		if ctx.Err() != nil || !sweep() {
+			return
+		}
+		if errors {
+			if !send(Result{Error: ErrCannotDeleteSomeBlocks}) {
+				return
+			}
+		}
+
+		gds, ok := dstor.(dstore.GCDatastore)
+		if !ok || opts.DryRun {
+			return
+		}
+		if err := gds.CollectGarbage(); err != nil {
+			send(Result{Error: err})
+		}
+	}()
+
+	return output
+}
+
+func listIncrementalRoots(ctx context.Context, pn pin.Pinner, bestEffortRoots func() ([]cid.Cid, error)) (gcRoots, error) {
+	var bestEffort []cid.Cid
+	if bestEffortRoots != nil {
+		var err error
+		if bestEffort, err = bestEffortRoots(); err != nil {
+			return gcRoots{}, err
+		}
+	}
+	return listRoots(ctx, pn, bestEffort)
+}
+
+// rescan marks the descendants of the roots added and of the blocks shaded
+// since the marking started. It has to be called under the GC lock.
+func rescan(ctx context.Context, ng ipld.NodeGetter, wb *WriteBarrier, pn pin.Pinner, bestEffortRoots func() ([]cid.Cid, error), output chan<- Result) error {
+	roots, err := listIncrementalRoots(ctx, pn, bestEffortRoots)
+	if err != nil {
+		return err
+	}
+	// Roots which were already marked are not walked again.
+	if err := colour(ctx, ng, wb.visit, roots, output); err != nil {
+		return err
+	}
+
+	// Shaded blocks may be missing, like those only looked up, or use a
+	// format without links, their descendants are walked on a best effort
+	// basis.
+	getLinks := func(ctx context.Context, c cid.Cid) ([]*ipld.Link, error) {
+		links, _ := ipld.GetLinks(ctx, ng, c)
+		return links, nil
+	}
+	return descendants(ctx, getLinks, wb.visit, wb.shadedKeys())
+}
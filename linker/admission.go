@@ -0,0 +1,305 @@
+package linker
+
+import (
+	"context"
+	"encoding/binary"
+	"errors"
+	"sync"
+
+	"github.com/dustin/go-humanize"
+	lru "github.com/hashicorp/golang-lru"
+	"github.com/ipfs/go-cid"
+	ds "github.com/ipfs/go-datastore"
+	"github.com/ipfs/go-datastore/query"
+	"github.com/ipfs/go-ipfs/core/corerepo"
+	"github.com/ipfs/go-ipfs/linker/config"
+	ipld "github.com/ipfs/go-ipld-format"
+	mdag "github.com/ipfs/go-merkledag"
+	"github.com/ipfs/go-merkledag/traverse"
+	"github.com/libp2p/go-libp2p-core/peer"
+)
+
+var (
+	// ErrStorageMaxExceeded is returned when a dag can never fit into the repo.
+	ErrStorageMaxExceeded = errors.New("dag is larger than the repo storage max")
+	// ErrPeerQuotaExceeded is returned when a peer used up its pinning quota.
+	ErrPeerQuotaExceeded = errors.New("peer pinning quota exceeded")
+	// ErrTotalQuotaExceeded is returned when the linker used up its pinning quota.
+	ErrTotalQuotaExceeded = errors.New("total pinning quota exceeded")
+	// ErrPinDeferred is returned when a pin does not fit right now but may
+	// fit once running pins finish or the repo gets garbage collected.
+	ErrPinDeferred = errors.New("pin deferred until storage is available")
+
+	// errOverBudget stops measuring a dag once it can't be admitted.
+	errOverBudget = errors.New("dag is larger than the admission budget")
+)
+
+const quotaPrefix = "/link/quota"
+
+// admission decides whether a pin received from a peer may be pinned
+// without exceeding the repo storage or the configured quotas.
+type admission struct {
+	ds         ds.Datastore
+	dag        ipld.NodeGetter
+	storageMax uint64
+	perPeer    uint64
+	total      uint64
+
+	lock sync.Mutex
+	// pins holds the size of the pins accounted to each peer.
+	pins     map[peer.ID]map[cid.Cid]uint64
+	used     map[peer.ID]uint64
+	usedAll  uint64
+	reserved uint64
+	// rejected holds the hashes which didn't fit in the quotas, until a pin
+	// is released or up to maxRejected of them.
+	rejected *lru.Cache
+}
+
+type rejection struct {
+	from peer.ID
+	c    cid.Cid
+}
+
+func newAdmission(d ds.Datastore, dag ipld.NodeGetter, storageMax uint64, cfg config.Quota) (*admission, error) {
+	perPeer, err := parseQuota(cfg.PerPeer)
+	if err != nil {
+		return nil, err
+	}
+	total, err := parseQuota(cfg.Total)
+	if err != nil {
+		return nil, err
+	}
+	rejected, _ := lru.New(maxRejected)
+	a := &admission{
+		ds:         d,
+		dag:        dag,
+		storageMax: storageMax,
+		perPeer:    perPeer,
+		total:      total,
+		pins:       make(map[peer.ID]map[cid.Cid]uint64),
+		used:       make(map[peer.ID]uint64),
+		rejected:   rejected,
+	}
+	if err := a.load(); err != nil {
+		return nil, err
+	}
+	return a, nil
+}
+
+func parseQuota(v string) (uint64, error) {
+	if v == "" {
+		return corerepo.NoLimit, nil
+	}
+	return humanize.ParseBytes(v)
+}
+
+func quotaKey(id peer.ID, c cid.Cid) ds.Key {
+	return ds.NewKey(quotaPrefix).ChildString(peer.Encode(id)).ChildString(c.String())
+}
+
+func (a *admission) load() error {
+	results, err := a.ds.Query(query.Query{Prefix: quotaPrefix})
+	if err != nil {
+		return err
+	}
+	defer results.Close()
+	for r := range results.Next() {
+		if r.Error != nil {
+			return r.Error
+		}
+		k := ds.RawKey(r.Key)
+		id, err := peer.Decode(k.Parent().BaseNamespace())
+		if err != nil {
+			log.Warnw("skip invalid quota entry", "key", r.Key, "error", err)
+			continue
+		}
+		c, err := cid.Decode(k.BaseNamespace())
+		if err != nil || len(r.Value) != 8 {
+			log.Warnw("skip invalid quota entry", "key", r.Key)
+			continue
+		}
+		a.account(id, c, binary.BigEndian.Uint64(r.Value))
+	}
+	return nil
+}
+
+// account records a pin of size bytes accounted to id.
+func (a *admission) account(id peer.ID, c cid.Cid, size uint64) {
+	pins, ok := a.pins[id]
+	if !ok {
+		pins = make(map[cid.Cid]uint64)
+		a.pins[id] = pins
+	}
+	if old, ok := pins[c]; ok {
+		a.used[id] -= old
+		a.usedAll -= old
+	}
+	pins[c] = size
+	a.used[id] += size
+	a.usedAll += size
+}
+
+// budget returns how many bytes a pin requested by from may use. usage is
+// the current repo size.
+func (a *admission) budget(from peer.ID, usage uint64) uint64 {
+	a.lock.Lock()
+	defer a.lock.Unlock()
+
+	budget := remaining(usage+a.reserved, a.storageMax)
+	if b := remaining(a.used[from], a.perPeer); b < budget {
+		budget = b
+	}
+	if b := remaining(a.usedAll+a.reserved, a.total); b < budget {
+		budget = b
+	}
+	return budget
+}
+
+func remaining(used, limit uint64) uint64 {
+	if used >= limit {
+		return 0
+	}
+	return limit - used
+}
+
+// dagSize fetches the dag below c and returns its size like `ipfs dag stat`.
+// It stops with errOverBudget as soon as the size goes over budget, so that
+// at most budget bytes of a dag which can't be admitted are fetched. fetched
+// is called with the size of every block visited.
+func (a *admission) dagSize(ctx context.Context, c cid.Cid, budget uint64, fetched func(size int)) (uint64, error) {
+	getter := mdag.NewSession(ctx, a.dag)
+	root, err := getter.Get(ctx, c)
+	if err != nil {
+		return 0, err
+	}
+	var size uint64
+	err = traverse.Traverse(root, traverse.Options{
+		DAG:   getter,
+		Order: traverse.DFSPre,
+		Func: func(current traverse.State) error {
+			n := len(current.Node.RawData())
+			size += uint64(n)
+			fetched(n)
+			if size > budget {
+				return errOverBudget
+			}
+			return nil
+		},
+		SkipDuplicates: true,
+	})
+	return size, err
+}
+
+// Admit reserves size bytes for the pin of c requested by from. usage is the
+// repo size measured before the dag was fetched. The pins which can never be
+// admitted are remembered until a pin is released.
+func (a *admission) Admit(from peer.ID, c cid.Cid, usage, size uint64) error {
+	a.lock.Lock()
+	defer a.lock.Unlock()
+
+	err := a.admit(from, usage, size)
+	switch err {
+	case nil:
+		a.reserved += size
+	case ErrStorageMaxExceeded, ErrPeerQuotaExceeded, ErrTotalQuotaExceeded:
+		a.rejected.Add(rejection{from, c}, nil)
+	}
+	return err
+}
+
+func (a *admission) admit(from peer.ID, usage, size uint64) error {
+	if size > a.storageMax {
+		return ErrStorageMaxExceeded
+	}
+	if exceeds(a.used[from], size, a.perPeer) {
+		return ErrPeerQuotaExceeded
+	}
+	if exceeds(a.usedAll, size, a.total) {
+		return ErrTotalQuotaExceeded
+	}
+	if exceeds(a.usedAll+a.reserved, size, a.total) || exceeds(usage+a.reserved, size, a.storageMax) {
+		return ErrPinDeferred
+	}
+	return nil
+}
+
+// Rejected reports whether the pin of c requested by from was rejected since
+// the last pin was released.
+func (a *admission) Rejected(from peer.ID, c cid.Cid) bool {
+	return a.rejected.Contains(rejection{from, c})
+}
+
+// exceeds reports whether used+size is above limit without overflowing
+// on NoLimit.
+func exceeds(used, size, limit uint64) bool {
+	return size > limit || used > limit-size
+}
+
+// Release returns a reservation made by Admit, accounting the pin of c to
+// from when it succeeded.
+func (a *admission) Release(from peer.ID, c cid.Cid, size uint64, pinned bool) {
+	a.lock.Lock()
+	defer a.lock.Unlock()
+
+	a.reserved -= size
+	if !pinned {
+		return
+	}
+	a.account(from, c, size)
+
+	buf := make([]byte, 8)
+	binary.BigEndian.PutUint64(buf, size)
+	if err := a.ds.Put(quotaKey(from, c), buf); err != nil {
+		log.Errorw("failed to store pinning quota", "peer", from, "error", err)
+	}
+}
+
+// reconcile stops accounting the pins which were removed since they were
+// admitted, and reports whether that freed any quota.
+func (a *admission) reconcile(pinned func(c cid.Cid) (bool, error)) (bool, error) {
+	a.lock.Lock()
+	var accounted []rejection
+	for id, pins := range a.pins {
+		for c := range pins {
+			accounted = append(accounted, rejection{id, c})
+		}
+	}
+	a.lock.Unlock()
+
+	freed := false
+	for _, r := range accounted {
+		ok, err := pinned(r.c)
+		if err != nil {
+			return freed, err
+		}
+		if ok {
+			continue
+		}
+		if err := a.forget(r.from, r.c); err != nil {
+			return freed, err
+		}
+		freed = true
+	}
+	return freed, nil
+}
+
+// forget stops accounting the pin of c to from. The rejected pins may fit
+// in the room it makes.
+func (a *admission) forget(from peer.ID, c cid.Cid) error {
+	a.lock.Lock()
+	defer a.lock.Unlock()
+
+	size, ok := a.pins[from][c]
+	if !ok {
+		return nil
+	}
+	delete(a.pins[from], c)
+	if len(a.pins[from]) == 0 {
+		delete(a.pins, from)
+	}
+	a.used[from] -= size
+	a.usedAll -= size
+	a.rejected.Purge()
+	return a.ds.Delete(quotaKey(from, c))
+}
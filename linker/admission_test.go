@@ -0,0 +1,140 @@
+package linker
+
+import (
+	"context"
+	"testing"
+
+	bserv "github.com/ipfs/go-blockservice"
+	"github.com/ipfs/go-cid"
+	ds "github.com/ipfs/go-datastore"
+	dssync "github.com/ipfs/go-datastore/sync"
+	bstore "github.com/ipfs/go-ipfs-blockstore"
+	offline "github.com/ipfs/go-ipfs-exchange-offline"
+	"github.com/ipfs/go-ipfs/linker/config"
+	ipld "github.com/ipfs/go-ipld-format"
+	mdag "github.com/ipfs/go-merkledag"
+	"github.com/libp2p/go-libp2p-core/test"
+)
+
+func newTestDAG() (ds.Batching, ipld.DAGService) {
+	dstore := dssync.MutexWrap(ds.NewMapDatastore())
+	bs := bstore.NewBlockstore(dstore)
+	return dstore, mdag.NewDAGService(bserv.New(bs, offline.Exchange(bs)))
+}
+
+// addChain adds a chain of n nodes and returns its root and size.
+func addChain(t *testing.T, dserv ipld.DAGService, name string, n int) (cid.Cid, uint64) {
+	var size uint64
+	var prev *mdag.ProtoNode
+	for i := 0; i < n; i++ {
+		nd := mdag.NodeWithData([]byte(name))
+		if prev != nil {
+			if err := nd.AddNodeLink("next", prev); err != nil {
+				t.Fatal(err)
+			}
+		}
+		if err := dserv.Add(context.Background(), nd); err != nil {
+			t.Fatal(err)
+		}
+		size += uint64(len(nd.RawData()))
+		prev = nd
+	}
+	return prev.Cid(), size
+}
+
+func newTestAdmission(t *testing.T, dstore ds.Datastore, dserv ipld.DAGService, storageMax uint64, quota config.Quota) *admission {
+	a, err := newAdmission(dstore, dserv, storageMax, quota)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return a
+}
+
+func TestDagSizeBudget(t *testing.T) {
+	ctx := context.Background()
+	dstore, dserv := newTestDAG()
+	a := newTestAdmission(t, dstore, dserv, 1<<20, config.Quota{})
+	root, size := addChain(t, dserv, "chain", 10)
+
+	fetched := 0
+	got, err := a.dagSize(ctx, root, size, func(int) { fetched++ })
+	if err != nil || got != size || fetched != 10 {
+		t.Fatalf("expected the whole dag of %d bytes, got %d bytes in %d blocks, %v", size, got, fetched, err)
+	}
+
+	fetched = 0
+	got, err = a.dagSize(ctx, root, size/2, func(int) { fetched++ })
+	if err != errOverBudget {
+		t.Fatalf("expected %s, got %v", errOverBudget, err)
+	}
+	if got <= size/2 || fetched >= 10 {
+		t.Fatalf("expected the walk to stop past the budget, got %d bytes in %d blocks", got, fetched)
+	}
+}
+
+func TestAdmission(t *testing.T) {
+	dstore, dserv := newTestDAG()
+	a := newTestAdmission(t, dstore, dserv, 1000, config.Quota{PerPeer: "300B", Total: "500B"})
+	alice, bob, carol := test.RandPeerIDFatal(t), test.RandPeerIDFatal(t), test.RandPeerIDFatal(t)
+	c1, _ := addChain(t, dserv, "1", 1)
+	c2, _ := addChain(t, dserv, "2", 1)
+	c3, _ := addChain(t, dserv, "3", 1)
+
+	if err := a.Admit(alice, c1, 0, 2000); err != ErrStorageMaxExceeded {
+		t.Fatalf("expected %s, got %v", ErrStorageMaxExceeded, err)
+	}
+	if err := a.Admit(alice, c1, 0, 200); err != nil {
+		t.Fatal(err)
+	}
+	if b := a.budget(alice, 0); b != 300 {
+		t.Fatalf("expected the reservation to use the total quota, got a budget of %d", b)
+	}
+	a.Release(alice, c1, 200, true)
+	if b := a.budget(alice, 0); b != 100 {
+		t.Fatalf("expected 100 bytes left to alice, got %d", b)
+	}
+
+	if err := a.Admit(alice, c2, 0, 200); err != ErrPeerQuotaExceeded {
+		t.Fatalf("expected %s, got %v", ErrPeerQuotaExceeded, err)
+	}
+	if !a.Rejected(alice, c2) || a.Rejected(bob, c2) {
+		t.Fatal("the rejection should be remembered for alice only")
+	}
+	if err := a.Admit(bob, c2, 900, 200); err != ErrPinDeferred {
+		t.Fatalf("expected %s while the repo is full, got %v", ErrPinDeferred, err)
+	}
+	if err := a.Admit(bob, c2, 0, 200); err != nil {
+		t.Fatal(err)
+	}
+	a.Release(bob, c2, 200, true)
+	if err := a.Admit(carol, c3, 0, 200); err != ErrTotalQuotaExceeded {
+		t.Fatalf("expected %s, got %v", ErrTotalQuotaExceeded, err)
+	}
+
+	// The accounted pins survive restarts.
+	restarted := newTestAdmission(t, dstore, dserv, 1000, config.Quota{PerPeer: "300B", Total: "500B"})
+	if restarted.usedAll != 400 || restarted.used[alice] != 200 {
+		t.Fatalf("expected the pins to be loaded, got %d used, %d by alice", restarted.usedAll, restarted.used[alice])
+	}
+
+	// Removed pins release their quota.
+	freed, err := a.reconcile(func(c cid.Cid) (bool, error) {
+		return !c.Equals(c1), nil
+	})
+	if err != nil || !freed {
+		t.Fatalf("expected the removed pin to be released, got %v, %v", freed, err)
+	}
+	if a.used[alice] != 0 || a.usedAll != 200 {
+		t.Fatalf("expected alice to use nothing, got %d used, %d by alice", a.usedAll, a.used[alice])
+	}
+	if a.Rejected(alice, c2) {
+		t.Fatal("the rejections should be forgotten once a pin is released")
+	}
+	if err := a.Admit(alice, c2, 0, 200); err != nil {
+		t.Fatal(err)
+	}
+	restarted = newTestAdmission(t, dstore, dserv, 1000, config.Quota{})
+	if restarted.usedAll != 200 {
+		t.Fatalf("expected the released pin to be removed from the datastore, got %d used", restarted.usedAll)
+	}
+}
@@ -15,18 +15,42 @@ type CacheConfig struct {
 
 type Pinning struct {
 	PerSeconds int
+	// StallSeconds aborts a pin job that made no progress for that long,
+	// 0 disables the check.
+	StallSeconds int
+	Quota        Quota
+}
+
+// Quota limits how much data the linker pins on behalf of remote peers.
+// Values are human readable sizes such as "10GB", an empty value means no limit.
+type Quota struct {
+	PerPeer string
+	Total   string
+}
+
+// Discovery configures how the linker finds other link peers. Peers
+// sharing the same Network meet under the same rendezvous namespace.
+type Discovery struct {
+	Network         string
+	DHT             bool
+	MDNS            bool
+	IntervalSeconds int
 }
 
 type Config struct {
 	MaxAttempts int64
 	Pinning     Pinning
+	Discovery   Discovery
 	Hash        CacheConfig
 	Address     CacheConfig
 }
 
 //var DefaultBootstrapAddresses = []string{}
 var DefaultPinningSeconds = 30
+var DefaultStallSeconds = 300
 var DefaultConfigName = "linker"
+var DefaultNetwork = "bustlinker"
+var DefaultDiscoverySeconds = 300
 
 // Clone copies the config. Use when updating.
 func (c *Config) Clone() (*Config, error) {
@@ -98,7 +122,14 @@ func defaultConfig() *Config {
 	cfg := Config{
 		MaxAttempts: 3,
 		Pinning: Pinning{
-			PerSeconds: DefaultPinningSeconds,
+			PerSeconds:   DefaultPinningSeconds,
+			StallSeconds: DefaultStallSeconds,
+		},
+		Discovery: Discovery{
+			Network:         DefaultNetwork,
+			DHT:             true,
+			MDNS:            true,
+			IntervalSeconds: DefaultDiscoverySeconds,
 		},
 		Hash: CacheConfig{
 			BackupSeconds: 30,
@@ -0,0 +1,89 @@
+package linker
+
+import (
+	"context"
+	"time"
+
+	"github.com/ipfs/go-ipfs/core/node/libp2p"
+	"github.com/ipfs/go-ipfs/linker/config"
+	"github.com/libp2p/go-libp2p-core/network"
+	"github.com/libp2p/go-libp2p-core/peer"
+	disc "github.com/libp2p/go-libp2p-discovery"
+)
+
+// connectTimeout bounds dialing a discovered link peer.
+const connectTimeout = 30 * time.Second
+
+// Rendezvous returns the namespace link peers of network advertise under.
+func Rendezvous(network string) string {
+	return "/link/rendezvous/" + network
+}
+
+// mdnsTag returns the mDNS service tag link peers of network announce.
+func mdnsTag(network string) string {
+	return "_link-" + network + "._udp"
+}
+
+// startDiscovery advertises the linker under its rendezvous namespace on
+// the DHT and the local network and connects to the link peers found there.
+func (l *link) startDiscovery() {
+	cfg := l.cfg.Discovery
+	if cfg.Network == "" {
+		return
+	}
+	if cfg.MDNS {
+		nodeCfg, err := l.node.Repo.Config()
+		if err != nil {
+			log.Errorw("failed to read node config", "error", err)
+		} else if nodeCfg.Discovery.MDNS.Enabled {
+			_, err := libp2p.StartMdns(l.ctx, l.node.PeerHost, nodeCfg.Discovery.MDNS.Interval, mdnsTag(cfg.Network), l)
+			if err != nil {
+				log.Errorw("link mdns error", "error", err)
+			}
+		}
+	}
+	if cfg.DHT && l.node.Routing != nil {
+		rd := disc.NewRoutingDiscovery(l.node.Routing)
+		disc.Advertise(l.ctx, rd, Rendezvous(cfg.Network))
+		go l.findPeers(rd)
+	}
+}
+
+// findPeers looks up the rendezvous namespace every discovery interval.
+func (l *link) findPeers(rd *disc.RoutingDiscovery) {
+	interval := time.Duration(l.cfg.Discovery.IntervalSeconds) * time.Second
+	if interval <= 0 {
+		interval = time.Duration(config.DefaultDiscoverySeconds) * time.Second
+	}
+	ns := Rendezvous(l.cfg.Discovery.Network)
+	for {
+		peers, err := rd.FindPeers(l.ctx, ns)
+		if err != nil {
+			log.Debugw("find link peers failed", "namespace", ns, "error", err)
+		} else {
+			for info := range peers {
+				l.HandlePeerFound(info)
+			}
+		}
+		select {
+		case <-l.ctx.Done():
+			return
+		case <-time.After(interval):
+		}
+	}
+}
+
+// HandlePeerFound connects to a link peer found by discovery.
+func (l *link) HandlePeerFound(info peer.AddrInfo) {
+	if info.ID == l.node.Identity || len(info.Addrs) == 0 ||
+		l.node.PeerHost.Network().Connectedness(info.ID) == network.Connected {
+		return
+	}
+	ctx, cancel := context.WithTimeout(l.ctx, connectTimeout)
+	defer cancel()
+	if err := l.node.PeerHost.Connect(ctx, info); err != nil {
+		log.Debugw("failed to connect to discovered link peer", "peer", info.ID, "error", err)
+		return
+	}
+	log.Infow("connected to discovered link peer", "peer", info.ID)
+}
@@ -27,18 +27,32 @@ var NewLine = []byte{'\n'}
 
 type Linker interface {
 	Start(node *core.IpfsNode) error
+	Status() Status
 	//plugin.Plugin
 	//plugin.PluginDaemonInternal
 }
 
+// running holds the linkers started on nodes, for the commands to find them.
+var (
+	runningLock sync.Mutex
+	running     = make(map[*core.IpfsNode]Linker)
+)
+
+// Running returns the linker started on node, nil when none runs.
+func Running(node *core.IpfsNode) Linker {
+	runningLock.Lock()
+	defer runningLock.Unlock()
+	return running[node]
+}
+
 type link struct {
-	ctx         context.Context
-	cfg         *config.Config
-	node        *core.IpfsNode
-	failedCount map[peer.ID]int64
-	failedLock  *sync.RWMutex
-	pinning     Pinning
-	repo        string
+	ctx     context.Context
+	cfg     *config.Config
+	node    *core.IpfsNode
+	scores  *scores
+	peers   *peerLink
+	pinning Pinning
+	repo    string
 }
 
 func (l *link) newLinkPeersHandle() (protocol.ID, func(stream network.Stream)) {
@@ -97,9 +111,29 @@ func (l *link) Start(node *core.IpfsNode) error {
 	fmt.Println("Link start")
 	l.node = node
 
-	l.pinning = newPinning(l.node)
+	scores, err := newScores(node.Repo.Datastore(), node.PeerHost.ConnManager())
+	if err != nil {
+		return err
+	}
+	l.scores = scores
+	l.peers = newPeerLink(l.node)
+	scores.onBan = l.peers.Remove
+
+	pinning, err := newPinning(l.node, l.cfg, l.scores)
+	if err != nil {
+		return err
+	}
+	l.pinning = pinning
+
+	runningLock.Lock()
+	running[node] = l
+	runningLock.Unlock()
 
 	l.registerHandle()
+	if err := l.watchPeers(); err != nil {
+		return err
+	}
+	l.startDiscovery()
 	return nil
 }
 
@@ -107,17 +141,18 @@ func New(repo string, cfg interface{}) (Linker, error) {
 	v, b := cfg.(*config.Config)
 	if cfg == nil || !b {
 		v = config.InitConfig(repo)
-		err := config.StoreConfig(repo, v)
-		if err != nil {
-			return nil, err
+		// Without a repo, e.g. when plugins are loaded by tests, there
+		// is nowhere to store the config.
+		if repo != "" {
+			if err := config.StoreConfig(repo, v); err != nil {
+				return nil, err
+			}
 		}
 	}
 	return &link{
-		ctx:         context.TODO(),
-		repo:        repo,
-		cfg:         v,
-		failedCount: make(map[peer.ID]int64),
-		failedLock:  &sync.RWMutex{},
+		ctx:  context.TODO(),
+		repo: repo,
+		cfg:  v,
 	}, nil
 }
 
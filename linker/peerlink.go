@@ -1,11 +1,157 @@
 package linker
 
 import (
-	core "github.com/libp2p/go-libp2p-core"
 	"sync"
+
+	"github.com/ipfs/go-ipfs/core"
+	"github.com/ipfs/go-ipfs/peering"
+	"github.com/libp2p/go-libp2p-core/connmgr"
+	"github.com/libp2p/go-libp2p-core/event"
+	"github.com/libp2p/go-libp2p-core/peer"
+	"github.com/libp2p/go-libp2p-core/peerstore"
+	"github.com/libp2p/go-libp2p-core/protocol"
 )
 
+// linkTag protects link peers from being trimmed by the connection manager.
+const linkTag = "link"
+
+// peeringService is the part of the peering service the linker uses.
+type peeringService interface {
+	AddPeer(info peer.AddrInfo)
+	RemovePeer(id peer.ID)
+	GetPeer(id peer.ID) (peering.PeerState, bool)
+}
+
+// peerLink keeps the established link peers registered with the peering
+// service, so they are reconnected with back-off when the connection drops.
 type peerLink struct {
+	peerstore peerstore.Peerstore
+	connmgr   connmgr.ConnManager
+	peering   peeringService
+
 	lock  sync.RWMutex
-	peers map[string]core.PeerAddrInfo
+	peers map[peer.ID]peer.AddrInfo
+	// peered holds the peers the linker registered with the peering
+	// service. The others were peered by the config or the user, and are
+	// left peered when they stop being link peers.
+	peered map[peer.ID]bool
+}
+
+func newPeerLink(node *core.IpfsNode) *peerLink {
+	pl := &peerLink{
+		peerstore: node.Peerstore,
+		connmgr:   node.PeerHost.ConnManager(),
+		peers:     make(map[peer.ID]peer.AddrInfo),
+		peered:    make(map[peer.ID]bool),
+	}
+	if node.Peering != nil {
+		pl.peering = node.Peering
+	}
+	return pl
+}
+
+// Add registers id as a link peer.
+func (pl *peerLink) Add(id peer.ID) {
+	info := pl.peerstore.PeerInfo(id)
+	pl.lock.Lock()
+	defer pl.lock.Unlock()
+	if _, exist := pl.peers[id]; exist {
+		pl.peers[id] = info
+		return
+	}
+	pl.peers[id] = info
+	log.Infow("link peer added", "peer", id, "addrs", info.Addrs)
+	// The link tag is the linker's own, so protecting with it doesn't
+	// change the protections of other subsystems.
+	pl.connmgr.Protect(id, linkTag)
+	if pl.peering == nil {
+		return
+	}
+	if _, ok := pl.peering.GetPeer(id); !ok {
+		pl.peering.AddPeer(info)
+		pl.peered[id] = true
+	}
+}
+
+// Remove unregisters id as a link peer, undoing only what Add did.
+func (pl *peerLink) Remove(id peer.ID) {
+	pl.lock.Lock()
+	defer pl.lock.Unlock()
+	if _, exist := pl.peers[id]; !exist {
+		return
+	}
+	delete(pl.peers, id)
+	log.Infow("link peer removed", "peer", id)
+	pl.connmgr.Unprotect(id, linkTag)
+	if pl.peered[id] {
+		delete(pl.peered, id)
+		pl.peering.RemovePeer(id)
+	}
+}
+
+// Peers returns the registered link peers.
+func (pl *peerLink) Peers() []peer.ID {
+	pl.lock.RLock()
+	defer pl.lock.RUnlock()
+	peers := make([]peer.ID, 0, len(pl.peers))
+	for id := range pl.peers {
+		peers = append(peers, id)
+	}
+	return peers
+}
+
+// watchPeers registers peers as soon as identify reports them speaking the link
+// protocols and drops them once they stop doing so.
+func (l *link) watchPeers() error {
+	sub, err := l.node.PeerHost.EventBus().Subscribe([]interface{}{
+		new(event.EvtPeerIdentificationCompleted),
+		new(event.EvtPeerProtocolsUpdated),
+	})
+	if err != nil {
+		return err
+	}
+	go func() {
+		defer sub.Close()
+		for {
+			select {
+			case <-l.ctx.Done():
+				return
+			case e, ok := <-sub.Out():
+				if !ok {
+					return
+				}
+				switch evt := e.(type) {
+				case event.EvtPeerIdentificationCompleted:
+					if l.isLinkPeer(evt.Peer) {
+						l.peers.Add(evt.Peer)
+					}
+				case event.EvtPeerProtocolsUpdated:
+					if hasProtocol(evt.Added, LinkHash) && l.isLinkPeer(evt.Peer) {
+						l.peers.Add(evt.Peer)
+					}
+					if hasProtocol(evt.Removed, LinkHash) {
+						l.peers.Remove(evt.Peer)
+					}
+				}
+			}
+		}
+	}()
+	return nil
+}
+
+func (l *link) isLinkPeer(id peer.ID) bool {
+	if l.scores.Banned(id) {
+		return false
+	}
+	supported, err := l.node.Peerstore.SupportsProtocols(id, LinkHash)
+	return err == nil && len(supported) > 0
+}
+
+func hasProtocol(protos []protocol.ID, proto string) bool {
+	for _, p := range protos {
+		if string(p) == proto {
+			return true
+		}
+	}
+	return false
 }
@@ -0,0 +1,75 @@
+package linker
+
+import (
+	"testing"
+
+	"github.com/ipfs/go-ipfs/peering"
+	"github.com/libp2p/go-libp2p-core/connmgr"
+	"github.com/libp2p/go-libp2p-core/peer"
+	"github.com/libp2p/go-libp2p-core/test"
+	"github.com/libp2p/go-libp2p-peerstore/pstoremem"
+)
+
+type testConnMgr struct {
+	connmgr.NullConnMgr
+	protected map[peer.ID]map[string]bool
+}
+
+func (cm *testConnMgr) Protect(id peer.ID, tag string) {
+	if cm.protected[id] == nil {
+		cm.protected[id] = make(map[string]bool)
+	}
+	cm.protected[id][tag] = true
+}
+
+func (cm *testConnMgr) Unprotect(id peer.ID, tag string) bool {
+	delete(cm.protected[id], tag)
+	return len(cm.protected[id]) > 0
+}
+
+type testPeering map[peer.ID]peer.AddrInfo
+
+func (p testPeering) AddPeer(info peer.AddrInfo) { p[info.ID] = info }
+
+func (p testPeering) RemovePeer(id peer.ID) { delete(p, id) }
+
+func (p testPeering) GetPeer(id peer.ID) (peering.PeerState, bool) {
+	info, ok := p[id]
+	return peering.PeerState{ID: info.ID}, ok
+}
+
+func TestPeerLink(t *testing.T) {
+	cm := &testConnMgr{protected: make(map[peer.ID]map[string]bool)}
+	ps := testPeering{}
+	pl := &peerLink{
+		peerstore: pstoremem.NewPeerstore(),
+		connmgr:   cm,
+		peering:   ps,
+		peers:     make(map[peer.ID]peer.AddrInfo),
+		peered:    make(map[peer.ID]bool),
+	}
+	linked, configured := test.RandPeerIDFatal(t), test.RandPeerIDFatal(t)
+	ps.AddPeer(peer.AddrInfo{ID: configured})
+	cm.Protect(configured, "other")
+
+	pl.Add(linked)
+	pl.Add(configured)
+	if _, ok := ps[linked]; !ok || !cm.protected[linked][linkTag] {
+		t.Fatal("the link peer should be peered and protected")
+	}
+	if len(pl.Peers()) != 2 {
+		t.Fatalf("expected 2 link peers, got %v", pl.Peers())
+	}
+
+	pl.Remove(linked)
+	pl.Remove(configured)
+	if _, ok := ps[linked]; ok || cm.protected[linked][linkTag] {
+		t.Fatal("the peer added by the linker should be unpeered and unprotected")
+	}
+	if _, ok := ps[configured]; !ok {
+		t.Fatal("the configured peer should stay peered")
+	}
+	if !cm.protected[configured]["other"] {
+		t.Fatal("the protections of other subsystems should be kept")
+	}
+}
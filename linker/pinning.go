@@ -2,42 +2,92 @@ package linker
 
 import (
 	"context"
+	"errors"
 	"sync"
+	"time"
 
+	lru "github.com/hashicorp/golang-lru"
+	"github.com/ipfs/go-cid"
+	pin "github.com/ipfs/go-ipfs-pinner"
 	core "github.com/ipfs/go-ipfs/core"
 	coreapi "github.com/ipfs/go-ipfs/core/coreapi"
+	"github.com/ipfs/go-ipfs/core/corerepo"
+	"github.com/ipfs/go-ipfs/linker/config"
+	"github.com/ipfs/go-ipfs/pinmeta"
+	"github.com/ipfs/go-ipfs/pinqueue"
+	coreiface "github.com/ipfs/interface-go-ipfs-core"
 	"github.com/ipfs/interface-go-ipfs-core/path"
-	"go.uber.org/atomic"
+	"github.com/libp2p/go-libp2p-core/peer"
 )
 
+// handlerName is the pin queue handler of the hashes queued by the linker.
+const handlerName = "linker"
+
+// PeerLabel is the pin label telling which link peer sent a hash.
+const PeerLabel = "linker-peer"
+
+// resolveTimeout bounds resolving a path received from a link peer.
+const resolveTimeout = 30 * time.Second
+
+// maxRejected bounds the number of rejected pins remembered, the least
+// recently rejected ones are forgotten first.
+const maxRejected = 1000
+
 type Pinning interface {
 	Get() []string
 	Clear()
-	AddSync(pin string)
+	AddSync(from peer.ID, pin string)
 	Add(pin string)
 	Set(pins []string)
+	Rejected() map[string]string
+	Jobs() ([]pinqueue.Entry, error)
+}
+
+// unresolvedError is returned when the dag of a hash could not be fetched.
+type unresolvedError struct {
+	err error
+}
+
+func (e *unresolvedError) Error() string {
+	return e.err.Error()
 }
 
+// unresolved wraps err as a failure to resolve a hash, unless ctx is done,
+// like when a fetch stalls on our side, which isn't the fault of the peer.
+func unresolved(ctx context.Context, err error) error {
+	if ctx.Err() != nil {
+		return err
+	}
+	return &unresolvedError{err}
+}
+
+// pinning queues the hashes received from link peers in the node pin queue,
+// and admits them when the queue gets to them.
 type pinning struct {
-	ctx      context.Context
-	cancel   context.CancelFunc
-	running  *atomic.Bool
-	syncing  *sync.Pool
-	node     *core.IpfsNode
+	node   *core.IpfsNode
+	api    coreiface.CoreAPI
+	queue  *pinqueue.Queue
+	cfg    *config.Config
+	admit  *admission
+	scores *scores
+
 	pins     map[string]bool
 	pinsLock *sync.RWMutex
+	// rejected holds the reason why each rejected hash was not admitted.
+	rejected *lru.Cache
+	// attempts counts how many times the pin of a hash was deferred.
+	attempts map[cid.Cid]int64
+	// reserved holds the sizes admitted for the hashes being pinned.
+	reserved map[cid.Cid]uint64
 }
 
 func (p *pinning) Get() []string {
 	var pins []string
 	p.pinsLock.RLock()
-	if len(p.pins) == 0 {
-		return pins
-	}
+	defer p.pinsLock.RUnlock()
 	for pin := range p.pins {
 		pins = append(pins, pin)
 	}
-	p.pinsLock.RUnlock()
 	return pins
 }
 
@@ -47,10 +97,50 @@ func (p *pinning) Clear() {
 	p.pinsLock.Unlock()
 }
 
-func (p *pinning) AddSync(pin string) {
-	p.syncing.Put(pin)
-	if !p.running.Load() {
-		p.Resume()
+// AddSync queues the pin of a hash received from a link peer, before the
+// hashes of lower scored peers.
+func (p *pinning) AddSync(from peer.ID, pin string) {
+	pp := path.New(pin)
+	if err := pp.IsValid(); err != nil {
+		log.Debugw("unparsable hash", "hash", pin, "from", from, "error", err)
+		p.scores.Update(from, ScoreUnparsable)
+		return
+	}
+	ctx, cancel := context.WithTimeout(context.Background(), resolveTimeout)
+	defer cancel()
+	rp, err := p.api.ResolvePath(ctx, pp)
+	if err != nil {
+		p.reject(pin, from, err)
+		if ctx.Err() == nil {
+			p.scores.Update(from, ScoreUnresolved)
+		}
+		return
+	}
+	p.pinsLock.RLock()
+	pinned := p.pins[rp.Cid().String()]
+	p.pinsLock.RUnlock()
+	if pinned {
+		return
+	}
+	if p.admit.Rejected(from, rp.Cid()) {
+		log.Debugw("skip rejected pin until quotas change", "hash", pin, "from", from)
+		return
+	}
+	p.enqueue(rp.Cid(), from)
+}
+
+func (p *pinning) enqueue(c cid.Cid, from peer.ID) {
+	_, err := p.queue.Enqueue(c, pinqueue.Request{
+		Recursive: true,
+		Metadata: pinmeta.Metadata{
+			Labels: map[string]string{PeerLabel: peer.Encode(from)},
+		},
+		Priority: p.scores.Score(from),
+		Handler:  handlerName,
+		Stall:    time.Duration(p.cfg.Pinning.StallSeconds) * time.Second,
+	})
+	if err != nil {
+		log.Errorw("failed to queue pin", "hash", c, "from", from, "error", err)
 	}
 }
 
@@ -70,67 +160,179 @@ func (p *pinning) Set(pins []string) {
 	p.pinsLock.Unlock()
 }
 
-func (p *pinning) Pause() {
-	p.running.Store(false)
-	if p.running.CAS(true, false) {
-		p.cancel()
+// Rejected returns the pins that were not admitted with the reason why.
+func (p *pinning) Rejected() map[string]string {
+	rejected := make(map[string]string, p.rejected.Len())
+	for _, hash := range p.rejected.Keys() {
+		if reason, ok := p.rejected.Peek(hash); ok {
+			rejected[hash.(string)] = reason.(string)
+		}
 	}
+	return rejected
 }
 
-func (p *pinning) Resume() {
-	if p.running.CAS(false, true) {
-		go p.run()
+// Jobs returns the requests the linker queued in the pin queue.
+func (p *pinning) Jobs() ([]pinqueue.Entry, error) {
+	entries, err := p.queue.List()
+	if err != nil {
+		return nil, err
+	}
+	var jobs []pinqueue.Entry
+	for _, e := range entries {
+		if e.Handler == handlerName {
+			jobs = append(jobs, e)
+		}
 	}
+	return jobs, nil
 }
 
-func (p *pinning) run() {
-	defer p.Pause()
-	p.ctx, p.cancel = context.WithCancel(context.TODO())
-	api, err := coreapi.NewCoreAPI(p.node)
-	if err != nil {
-		log.Error("failed get core api on pinning:", err)
+func (p *pinning) reject(hash string, from peer.ID, err error) {
+	log.Warnw("pin rejected", "hash", hash, "from", from, "reason", err)
+	p.rejected.Add(hash, err.Error())
+}
+
+// deferRequest queues c again after the pinning interval, giving up after
+// MaxAttempts tries.
+func (p *pinning) deferRequest(c cid.Cid, from peer.ID, err error) {
+	p.pinsLock.Lock()
+	p.attempts[c]++
+	attempts := p.attempts[c]
+	p.pinsLock.Unlock()
+	if attempts >= p.cfg.MaxAttempts {
+		p.forget(c)
+		p.reject(c.String(), from, err)
 		return
 	}
-	var pstr string
-	var b bool
-	var newPath path.Path
-	for p.running.Load() {
-		select {
-		case <-p.ctx.Done():
-			return
-		default:
-			v := p.syncing.Get()
-			if v == nil {
-				return
-			}
-			pstr, b = v.(string)
-			if !b {
-				continue
-			}
-
-			newPath = path.New(pstr)
-			typ, b2, err := api.Pin().IsPinned(p.ctx, newPath)
-			log.Info("check pin hash", "hash", pstr, "exist", b2, "error", err)
-			if (b2 && typ == "recursive") || err != nil {
-				continue
-			}
-			err = api.Pin().Add(p.ctx, newPath)
-			if err != nil {
-				continue
-			}
-			p.Add(pstr)
+	log.Infow("pin deferred", "hash", c, "from", from, "reason", err, "attempts", attempts)
+	time.AfterFunc(time.Duration(p.cfg.Pinning.PerSeconds)*time.Second, func() {
+		p.enqueue(c, from)
+	})
+}
+
+func (p *pinning) forget(c cid.Cid) {
+	p.pinsLock.Lock()
+	delete(p.attempts, c)
+	p.pinsLock.Unlock()
+}
+
+func sender(e pinqueue.Entry) (peer.ID, error) {
+	return peer.Decode(e.Metadata.Labels[PeerLabel])
+}
+
+// Admit fetches the dag of a queued hash to measure it, and checks that it
+// fits in the repo and the quotas.
+func (p *pinning) Admit(ctx context.Context, e pinqueue.Entry, progress func()) error {
+	from, err := sender(e)
+	if err != nil {
+		return err
+	}
+	if _, pinned, err := p.node.Pinning.IsPinnedWithType(ctx, e.Cid, pin.Recursive); err != nil || pinned {
+		return err
+	}
+	var size uint64
+	for retried := false; ; retried = true {
+		usage, err := p.node.Repo.GetStorageUsage()
+		if err != nil {
+			return err
+		}
+		size, err = p.admit.dagSize(ctx, e.Cid, p.admit.budget(from, usage), func(int) { progress() })
+		if err != nil && err != errOverBudget {
+			return unresolved(ctx, err)
+		}
+		if !retried {
+			p.scores.Update(from, ScoreValidHash)
+		}
+		err = p.admit.Admit(from, e.Cid, usage, size)
+		if err == nil {
+			break
+		}
+		if retried {
+			return err
+		}
+		// Pins accounted to peers may have been removed since.
+		freed, rerr := p.admit.reconcile(func(c cid.Cid) (bool, error) {
+			_, pinned, err := p.node.Pinning.IsPinnedWithType(ctx, c, pin.Recursive)
+			return pinned, err
+		})
+		if rerr != nil {
+			log.Errorw("failed to reconcile pinning quotas", "error", rerr)
+		}
+		if !freed {
+			return err
 		}
 	}
+	p.pinsLock.Lock()
+	p.reserved[e.Cid] = size
+	p.pinsLock.Unlock()
+	return nil
+}
+
+// Done accounts the pin of a queued hash, or handles why it failed.
+func (p *pinning) Done(e pinqueue.Entry, err error) {
+	from, perr := sender(e)
+	if perr != nil {
+		log.Errorw("invalid linker pin request", "hash", e.Cid, "error", perr)
+		return
+	}
+	p.pinsLock.Lock()
+	size, admitted := p.reserved[e.Cid]
+	delete(p.reserved, e.Cid)
+	p.pinsLock.Unlock()
+	if admitted {
+		p.admit.Release(from, e.Cid, size, err == nil)
+	}
+
+	var unresolved *unresolvedError
+	switch {
+	case err == nil:
+		p.forget(e.Cid)
+		p.Add(e.Cid.String())
+	case err == ErrPinDeferred:
+		p.deferRequest(e.Cid, from, err)
+	case errors.As(err, &unresolved):
+		p.forget(e.Cid)
+		p.reject(e.Cid.String(), from, err)
+		p.scores.Update(from, ScoreUnresolved)
+	case err == ErrStorageMaxExceeded || err == ErrPeerQuotaExceeded || err == ErrTotalQuotaExceeded:
+		p.forget(e.Cid)
+		p.reject(e.Cid.String(), from, err)
+	default:
+		p.forget(e.Cid)
+	}
 }
 
-func newPinning(node *core.IpfsNode) Pinning {
+func newPinning(node *core.IpfsNode, cfg *config.Config, scores *scores) (Pinning, error) {
+	if node.PinQueue == nil {
+		return nil, errors.New("the linker needs the node pin queue")
+	}
+	api, err := coreapi.NewCoreAPI(node)
+	if err != nil {
+		return nil, err
+	}
+	gc, err := corerepo.NewGC(node)
+	if err != nil {
+		return nil, err
+	}
+	admit, err := newAdmission(node.Repo.Datastore(), node.DAG, gc.StorageMax, cfg.Pinning.Quota)
+	if err != nil {
+		return nil, err
+	}
+	rejected, _ := lru.New(maxRejected)
 	p := &pinning{
-		running:  atomic.NewBool(true),
 		node:     node,
-		syncing:  &sync.Pool{},
+		api:      api,
+		queue:    node.PinQueue,
+		cfg:      cfg,
+		admit:    admit,
+		scores:   scores,
 		pins:     make(map[string]bool),
 		pinsLock: &sync.RWMutex{},
+		rejected: rejected,
+		attempts: make(map[cid.Cid]int64),
+		reserved: make(map[cid.Cid]uint64),
 	}
-	go p.run()
-	return p
+	node.PinQueue.Handle(handlerName, p)
+	return p, nil
 }
+
+var _ pinqueue.Handler = (*pinning)(nil)
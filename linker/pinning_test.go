@@ -0,0 +1,126 @@
+package linker
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"sync"
+	"testing"
+
+	lru "github.com/hashicorp/golang-lru"
+	"github.com/ipfs/go-cid"
+	bstore "github.com/ipfs/go-ipfs-blockstore"
+	pin "github.com/ipfs/go-ipfs-pinner"
+	provider "github.com/ipfs/go-ipfs-provider"
+	"github.com/ipfs/go-ipfs/linker/config"
+	"github.com/ipfs/go-ipfs/pinmeta"
+	"github.com/ipfs/go-ipfs/pinqueue"
+	"github.com/libp2p/go-libp2p-core/connmgr"
+	"github.com/libp2p/go-libp2p-core/peer"
+	"github.com/libp2p/go-libp2p-core/test"
+)
+
+func newTestPinning(t *testing.T) *pinning {
+	dstore, dserv := newTestDAG()
+	locker := bstore.NewGCLocker()
+	queue := pinqueue.New(dstore, dserv, pin.NewPinner(dstore, dserv, dserv), locker,
+		pinmeta.NewStore(dstore), provider.NewOfflineProvider())
+	scores, err := newScores(dstore, &connmgr.NullConnMgr{})
+	if err != nil {
+		t.Fatal(err)
+	}
+	rejected, _ := lru.New(maxRejected)
+	return &pinning{
+		queue:    queue,
+		cfg:      &config.Config{MaxAttempts: 3},
+		admit:    newTestAdmission(t, dstore, dserv, 1<<20, config.Quota{}),
+		scores:   scores,
+		pins:     make(map[string]bool),
+		pinsLock: &sync.RWMutex{},
+		rejected: rejected,
+		attempts: make(map[cid.Cid]int64),
+		reserved: make(map[cid.Cid]uint64),
+	}
+}
+
+func linkerEntry(c cid.Cid, from peer.ID) pinqueue.Entry {
+	return pinqueue.Entry{
+		Cid:      c,
+		Metadata: pinmeta.Metadata{Labels: map[string]string{PeerLabel: peer.Encode(from)}},
+		Handler:  handlerName,
+	}
+}
+
+func TestPinningDone(t *testing.T) {
+	p := newTestPinning(t)
+	from := test.RandPeerIDFatal(t)
+	_, dserv := newTestDAG()
+	stalled, _ := addChain(t, dserv, "stalled", 1)
+	missing, _ := addChain(t, dserv, "missing", 1)
+	pinned, _ := addChain(t, dserv, "pinned", 1)
+
+	// Stalls are our own network problems.
+	p.Done(linkerEntry(stalled, from), pinqueue.ErrStalled)
+	if score := p.scores.Score(from); score != 0 {
+		t.Fatalf("a stalled fetch should not change the score, got %d", score)
+	}
+	ctx, cancel := context.WithCancel(context.Background())
+	cancel()
+	if err := unresolved(ctx, ctx.Err()); errors.As(err, new(*unresolvedError)) {
+		t.Fatal("a fetch cancelled on our side should not count as unresolved")
+	}
+
+	p.Done(linkerEntry(missing, from), unresolved(context.Background(), errors.New("not found")))
+	if score := p.scores.Score(from); score != ScoreUnresolved {
+		t.Fatalf("expected a score of %d, got %d", ScoreUnresolved, score)
+	}
+	if _, ok := p.Rejected()[missing.String()]; !ok {
+		t.Fatal("the unresolved hash should be rejected")
+	}
+
+	p.Done(linkerEntry(pinned, from), nil)
+	if pins := p.Get(); len(pins) != 1 || pins[0] != pinned.String() {
+		t.Fatalf("expected %s to be pinned, got %v", pinned, pins)
+	}
+}
+
+func TestPinningRejectedBounded(t *testing.T) {
+	p := newTestPinning(t)
+	from := test.RandPeerIDFatal(t)
+	for i := 0; i <= maxRejected; i++ {
+		p.reject(fmt.Sprintf("hash-%d", i), from, errors.New("rejected"))
+	}
+	rejected := p.Rejected()
+	if len(rejected) != maxRejected {
+		t.Fatalf("%d rejected pins kept, expected %d", len(rejected), maxRejected)
+	}
+	if _, ok := rejected["hash-0"]; ok {
+		t.Fatal("the oldest rejected pin should have been forgotten")
+	}
+	if reason := rejected[fmt.Sprintf("hash-%d", maxRejected)]; reason != "rejected" {
+		t.Fatalf("unexpected reason %q for the last rejected pin", reason)
+	}
+}
+
+func TestStatus(t *testing.T) {
+	p := newTestPinning(t)
+	from := test.RandPeerIDFatal(t)
+	_, dserv := newTestDAG()
+	queued, _ := addChain(t, dserv, "queued", 1)
+	other, _ := addChain(t, dserv, "other", 1)
+
+	p.enqueue(queued, from)
+	if _, err := p.queue.Add(other, true, pinmeta.Metadata{}); err != nil {
+		t.Fatal(err)
+	}
+	p.scores.Update(from, ScoreValidHash)
+
+	l := &link{pinning: p, scores: p.scores}
+	status := l.Status()
+	if len(status.Jobs) != 1 || !status.Jobs[0].Cid.Equals(queued) || status.Jobs[0].State != pinqueue.Queued {
+		t.Fatalf("expected the queued linker job only, got %+v", status.Jobs)
+	}
+	if status.Scores[peer.Encode(from)] != ScoreValidHash {
+		t.Fatalf("expected the score of %s, got %v", from, status.Scores)
+	}
+}
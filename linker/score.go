@@ -0,0 +1,173 @@
+package linker
+
+import (
+	"encoding/json"
+	"math"
+	"sync"
+	"time"
+
+	ds "github.com/ipfs/go-datastore"
+	"github.com/ipfs/go-datastore/query"
+	"github.com/libp2p/go-libp2p-core/connmgr"
+	"github.com/libp2p/go-libp2p-core/peer"
+)
+
+// Score changes applied for the behaviour of a link peer.
+const (
+	ScoreValidHash  = 1
+	ScoreUnparsable = -2
+	ScoreUnresolved = -5
+)
+
+const (
+	scorePrefix = "/link/score"
+	// scoreTag is the connection manager tag carrying the peer score.
+	scoreTag = "link-score"
+	// maxScore caps the score, which is also the weight given to the
+	// connection manager, so a single link peer does not outweigh
+	// everything else.
+	maxScore = 100
+	// banScore is the score below which a peer is banned.
+	banScore = -20
+	// scoreHalfLife is the time it takes for a score to halve, so that old
+	// behaviour weighs less than recent behaviour.
+	scoreHalfLife = 24 * time.Hour
+	// banDuration is how long a peer stays banned.
+	banDuration = 24 * time.Hour
+)
+
+// scoreRecord is the score of a peer as persisted.
+type scoreRecord struct {
+	Score   float64
+	Updated time.Time
+	// Banned is the time the ban of the peer expires.
+	Banned time.Time `json:",omitempty"`
+}
+
+// scores keeps the reputation of link peers, persisted in the datastore and
+// mirrored in the connection manager.
+type scores struct {
+	ds      ds.Datastore
+	connmgr connmgr.ConnManager
+	// onBan is called when a peer gets banned.
+	onBan func(id peer.ID)
+	now   func() time.Time
+
+	lock   sync.RWMutex
+	scores map[peer.ID]scoreRecord
+}
+
+func newScores(d ds.Datastore, cm connmgr.ConnManager) (*scores, error) {
+	s := &scores{
+		ds:      d,
+		connmgr: cm,
+		onBan:   func(peer.ID) {},
+		now:     time.Now,
+		scores:  make(map[peer.ID]scoreRecord),
+	}
+	results, err := d.Query(query.Query{Prefix: scorePrefix})
+	if err != nil {
+		return nil, err
+	}
+	defer results.Close()
+	for r := range results.Next() {
+		if r.Error != nil {
+			return nil, r.Error
+		}
+		var rec scoreRecord
+		id, err := peer.Decode(ds.RawKey(r.Key).BaseNamespace())
+		if err == nil {
+			err = json.Unmarshal(r.Value, &rec)
+		}
+		if err != nil {
+			log.Warnw("skip invalid score entry", "key", r.Key, "error", err)
+			continue
+		}
+		s.scores[id] = rec
+		s.tag(id, s.score(rec))
+	}
+	return s, nil
+}
+
+func scoreKey(id peer.ID) ds.Key {
+	return ds.NewKey(scorePrefix).ChildString(peer.Encode(id))
+}
+
+// score returns the score of rec decayed to now.
+func (s *scores) score(rec scoreRecord) int64 {
+	elapsed := s.now().Sub(rec.Updated)
+	if elapsed <= 0 {
+		return int64(math.Round(rec.Score))
+	}
+	return int64(math.Round(rec.Score * math.Exp2(-float64(elapsed)/float64(scoreHalfLife))))
+}
+
+// Score returns the current score of id.
+func (s *scores) Score(id peer.ID) int64 {
+	s.lock.RLock()
+	defer s.lock.RUnlock()
+	return s.score(s.scores[id])
+}
+
+// Banned reports whether id is banned.
+func (s *scores) Banned(id peer.ID) bool {
+	s.lock.RLock()
+	defer s.lock.RUnlock()
+	return s.now().Before(s.scores[id].Banned)
+}
+
+// All returns a copy of all known scores.
+func (s *scores) All() map[peer.ID]int64 {
+	s.lock.RLock()
+	defer s.lock.RUnlock()
+	all := make(map[peer.ID]int64, len(s.scores))
+	for id, rec := range s.scores {
+		all[id] = s.score(rec)
+	}
+	return all
+}
+
+// Update adds delta to the score of id, banning id for banDuration when the
+// score falls below banScore.
+func (s *scores) Update(id peer.ID, delta int64) {
+	now := s.now()
+	s.lock.Lock()
+	rec := s.scores[id]
+	if !rec.Banned.IsZero() && !now.Before(rec.Banned) {
+		// The ban expired, start over.
+		rec = scoreRecord{}
+	}
+	score := float64(s.score(rec) + delta)
+	if score > maxScore {
+		score = maxScore
+	}
+	banned := rec.Banned.IsZero() && score < banScore
+	if banned {
+		rec.Banned = now.Add(banDuration)
+	}
+	rec.Score, rec.Updated = score, now
+	s.scores[id] = rec
+	s.lock.Unlock()
+
+	log.Debugw("peer score updated", "peer", id, "delta", delta, "score", score)
+	s.tag(id, int64(score))
+	buf, err := json.Marshal(rec)
+	if err == nil {
+		err = s.ds.Put(scoreKey(id), buf)
+	}
+	if err != nil {
+		log.Errorw("failed to store peer score", "peer", id, "error", err)
+	}
+	if banned {
+		log.Infow("peer banned", "peer", id, "until", rec.Banned)
+		s.onBan(id)
+	}
+}
+
+func (s *scores) tag(id peer.ID, score int64) {
+	if score <= 0 {
+		s.connmgr.UntagPeer(id, scoreTag)
+		return
+	}
+	s.connmgr.TagPeer(id, scoreTag, int(score))
+}
@@ -0,0 +1,62 @@
+package linker
+
+import (
+	"testing"
+	"time"
+
+	ds "github.com/ipfs/go-datastore"
+	dssync "github.com/ipfs/go-datastore/sync"
+	"github.com/libp2p/go-libp2p-core/connmgr"
+	"github.com/libp2p/go-libp2p-core/peer"
+	"github.com/libp2p/go-libp2p-core/test"
+)
+
+func TestScores(t *testing.T) {
+	dstore := dssync.MutexWrap(ds.NewMapDatastore())
+	now := time.Now()
+	newTestScores := func() *scores {
+		s, err := newScores(dstore, &connmgr.NullConnMgr{})
+		if err != nil {
+			t.Fatal(err)
+		}
+		s.now = func() time.Time { return now }
+		return s
+	}
+	s := newTestScores()
+	good, bad := test.RandPeerIDFatal(t), test.RandPeerIDFatal(t)
+
+	for i := 0; i < 2*maxScore; i++ {
+		s.Update(good, ScoreValidHash)
+	}
+	if score := s.Score(good); score != maxScore {
+		t.Fatalf("expected the score to be capped at %d, got %d", maxScore, score)
+	}
+	now = now.Add(scoreHalfLife)
+	if score := s.Score(good); score != maxScore/2 {
+		t.Fatalf("expected the score to halve, got %d", score)
+	}
+
+	var banned []peer.ID
+	s.onBan = func(id peer.ID) { banned = append(banned, id) }
+	for i := 0; i < 5 && !s.Banned(bad); i++ {
+		s.Update(bad, ScoreUnresolved)
+	}
+	if !s.Banned(bad) || len(banned) != 1 || banned[0] != bad {
+		t.Fatalf("expected %s to be banned once, got %v", bad, banned)
+	}
+
+	// Scores and bans survive restarts.
+	s = newTestScores()
+	if !s.Banned(bad) || s.Score(good) != maxScore/2 {
+		t.Fatalf("expected the scores to be loaded, got %v", s.All())
+	}
+
+	now = now.Add(banDuration)
+	if s.Banned(bad) {
+		t.Fatal("the ban should have expired")
+	}
+	s.Update(bad, ScoreValidHash)
+	if score := s.Score(bad); score != ScoreValidHash {
+		t.Fatalf("expected the score to start over after the ban, got %d", score)
+	}
+}
@@ -0,0 +1,34 @@
+package linker
+
+import (
+	"github.com/ipfs/go-ipfs/pinqueue"
+	"github.com/libp2p/go-libp2p-core/peer"
+)
+
+// Status reports the state of the linker pinning.
+type Status struct {
+	Pins     int
+	Jobs     []pinqueue.Entry
+	Rejected map[string]string
+	Scores   map[string]int64
+}
+
+func (l *link) Status() Status {
+	if l.pinning == nil {
+		return Status{}
+	}
+	scores := make(map[string]int64)
+	for id, score := range l.scores.All() {
+		scores[peer.Encode(id)] = score
+	}
+	jobs, err := l.pinning.Jobs()
+	if err != nil {
+		log.Errorw("failed to list pin jobs", "error", err)
+	}
+	return Status{
+		Pins:     len(l.pinning.Get()),
+		Jobs:     jobs,
+		Rejected: l.pinning.Rejected(),
+		Scores:   scores,
+	}
+}
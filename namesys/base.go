@@ -47,6 +47,7 @@ func resolveAsync(ctx context.Context, r resolver, name string, options opts.Res
 	go func() {
 		defer close(outCh)
 		var subCh <-chan Result
+		var ttl time.Duration
 		var cancelSub context.CancelFunc
 		defer func() {
 			if cancelSub != nil {
@@ -67,13 +68,14 @@ func resolveAsync(ctx context.Context, r resolver, name string, options opts.Res
 					return
 				}
 				log.Debugf("resolved %s to %s", name, res.value.String())
+				ttl = res.ttl
 				if !strings.HasPrefix(res.value.String(), ipnsPrefix) {
-					emitResult(ctx, outCh, Result{Path: res.value})
+					emitResult(ctx, outCh, Result{Path: res.value, TTL: ttl})
 					break
 				}
 
 				if depth == 1 {
-					emitResult(ctx, outCh, Result{Path: res.value, Err: ErrResolveRecursion})
+					emitResult(ctx, outCh, Result{Path: res.value, TTL: ttl, Err: ErrResolveRecursion})
 					break
 				}
 
@@ -98,6 +100,12 @@ func resolveAsync(ctx context.Context, r resolver, name string, options opts.Res
 					break
 				}
 
+				// The result is only valid as long as every step of the
+				// resolution is.
+				if ttl < res.TTL {
+					res.TTL = ttl
+				}
+
 				// We don't bother returning here in case of context timeout as there is
 				// no good reason to do that, and we may still be able to emit a result
 				emitResult(ctx, outCh, res)
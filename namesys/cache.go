@@ -6,22 +6,22 @@ import (
 	path "github.com/ipfs/go-path"
 )
 
-func (ns *mpns) cacheGet(name string) (path.Path, bool) {
+func (ns *mpns) cacheGet(name string) (path.Path, time.Duration, bool) {
 	// existence of optional mapping defined via IPFS_NS_MAP is checked first
 	if ns.staticMap != nil {
 		val, ok := ns.staticMap[name]
 		if ok {
-			return val, true
+			return val, 0, true
 		}
 	}
 
 	if ns.cache == nil {
-		return "", false
+		return "", 0, false
 	}
 
 	ientry, ok := ns.cache.Get(name)
 	if !ok {
-		return "", false
+		return "", 0, false
 	}
 
 	entry, ok := ientry.(cacheEntry)
@@ -30,13 +30,13 @@ func (ns *mpns) cacheGet(name string) (path.Path, bool) {
 		log.Panicf("unexpected type %T in cache for %q.", ientry, name)
 	}
 
-	if time.Now().Before(entry.eol) {
-		return entry.val, true
+	if ttl := time.Until(entry.eol); ttl > 0 {
+		return entry.val, ttl, true
 	}
 
 	ns.cache.Remove(name)
 
-	return "", false
+	return "", 0, false
 }
 
 func (ns *mpns) cacheSet(name string, val path.Path, ttl time.Duration) {
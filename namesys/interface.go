@@ -65,7 +65,10 @@ type NameSystem interface {
 // Result is the return type for Resolver.ResolveAsync.
 type Result struct {
 	Path path.Path
-	Err  error
+	// TTL is how long the resolution may be cached, the shortest TTL of the
+	// records the name was resolved through. Zero means unknown.
+	TTL time.Duration
+	Err error
 }
 
 // Resolver is an object capable of resolving names.
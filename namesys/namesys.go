@@ -89,7 +89,7 @@ func (ns *mpns) ResolveAsync(ctx context.Context, name string, options ...opts.R
 	if strings.HasPrefix(name, "/ipfs/") {
 		p, err := path.ParsePath(name)
 		res := make(chan Result, 1)
-		res <- Result{p, err}
+		res <- Result{Path: p, Err: err}
 		close(res)
 		return res
 	}
@@ -97,7 +97,7 @@ func (ns *mpns) ResolveAsync(ctx context.Context, name string, options ...opts.R
 	if !strings.HasPrefix(name, "/") {
 		p, err := path.ParsePath("/ipfs/" + name)
 		res := make(chan Result, 1)
-		res <- Result{p, err}
+		res <- Result{Path: p, Err: err}
 		close(res)
 		return res
 	}
@@ -149,13 +149,13 @@ func (ns *mpns) resolveOnceAsync(ctx context.Context, name string, options opts.
 		cacheKey = string(ipnsKey)
 	}
 
-	if p, ok := ns.cacheGet(cacheKey); ok {
+	if p, ttl, ok := ns.cacheGet(cacheKey); ok {
 		var err error
 		if len(segments) > 3 {
 			p, err = path.FromSegments("", strings.TrimRight(p.String(), "/"), segments[3])
 		}
 
-		out <- onceResult{value: p, err: err}
+		out <- onceResult{value: p, ttl: ttl, err: err}
 		close(out)
 		return out
 	}
@@ -88,6 +88,51 @@ func TestNamesysResolution(t *testing.T) {
 	testResolution(t, r, "/ipns/bafzbeickencdqw37dpz3ha36ewrh4undfjt2do52chtcky4rxkj447qhdm", 1, "/ipns/QmbCMUZw6JFeZ7Wp9jkzbye3Fzp2GGcPgC3nmeUjfVF87n", ErrResolveRecursion)
 }
 
+type ttlResolver struct {
+	mockResolver
+	ttls map[string]time.Duration
+}
+
+func (r *ttlResolver) resolveOnceAsync(ctx context.Context, name string, options opts.ResolveOpts) <-chan onceResult {
+	p, err := path.ParsePath(r.entries[name])
+	out := make(chan onceResult, 1)
+	out <- onceResult{value: p, ttl: r.ttls[name], err: err}
+	close(out)
+	return out
+}
+
+func TestResolveAsyncTTL(t *testing.T) {
+	r := &ttlResolver{
+		mockResolver: mockResolver{entries: map[string]string{
+			"a": "/ipns/b",
+			"b": "/ipns/c",
+			"c": "/ipfs/Qmcqtw8FfrVSBaRmbWwHxt3AuySBhJLcvmFYi3Lbc4xnwj",
+		}},
+		ttls: map[string]time.Duration{
+			"a": time.Hour,
+			"b": time.Minute,
+			"c": 10 * time.Minute,
+		},
+	}
+
+	var res Result
+	for res = range resolveAsync(context.Background(), r, "a", opts.DefaultResolveOpts()) {
+	}
+	if res.Err != nil {
+		t.Fatal(res.Err)
+	}
+	if res.TTL != time.Minute {
+		t.Fatalf("expected the shortest TTL of the chain, got %s", res.TTL)
+	}
+
+	delete(r.ttls, "c")
+	for res = range resolveAsync(context.Background(), r, "a", opts.DefaultResolveOpts()) {
+	}
+	if res.TTL != 0 {
+		t.Fatalf("expected an unknown TTL, got %s", res.TTL)
+	}
+}
+
 func TestPublishWithCache0(t *testing.T) {
 	dst := dssync.MutexWrap(ds.NewMapDatastore())
 	priv, _, err := ci.GenerateKeyPair(ci.RSA, 2048)
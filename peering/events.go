@@ -0,0 +1,79 @@
+package peering
+
+import (
+	"sync"
+	"time"
+
+	"github.com/libp2p/go-libp2p-core/event"
+	"github.com/libp2p/go-libp2p-core/peer"
+)
+
+// EvtPeeringConnectionLost is emitted on the host event bus when the
+// connection to a peered peer is lost and the service starts reconnecting.
+type EvtPeeringConnectionLost struct {
+	Peer peer.ID
+}
+
+// EvtPeeringConnectionRestored is emitted on the host event bus when a lost
+// peered peer is connected again.
+type EvtPeeringConnectionRestored struct {
+	Peer peer.ID
+	// Failures is the number of failed reconnect attempts.
+	Failures int
+	// Downtime is how long the peer was disconnected.
+	Downtime time.Duration
+}
+
+// emitters publishes peering events while the service is running.
+type emitters struct {
+	mu       sync.RWMutex
+	lost     event.Emitter
+	restored event.Emitter
+}
+
+func (e *emitters) start(bus event.Bus) error {
+	lost, err := bus.Emitter(new(EvtPeeringConnectionLost))
+	if err != nil {
+		return err
+	}
+	restored, err := bus.Emitter(new(EvtPeeringConnectionRestored))
+	if err != nil {
+		_ = lost.Close()
+		return err
+	}
+
+	e.mu.Lock()
+	defer e.mu.Unlock()
+	e.lost, e.restored = lost, restored
+	return nil
+}
+
+func (e *emitters) stop() {
+	e.mu.Lock()
+	defer e.mu.Unlock()
+	if e.lost != nil {
+		_ = e.lost.Close()
+		_ = e.restored.Close()
+		e.lost, e.restored = nil, nil
+	}
+}
+
+func (e *emitters) emitLost(evt EvtPeeringConnectionLost) {
+	e.mu.RLock()
+	defer e.mu.RUnlock()
+	if e.lost != nil {
+		if err := e.lost.Emit(evt); err != nil {
+			logger.Debugw("failed to emit event", "peer", evt.Peer, "error", err)
+		}
+	}
+}
+
+func (e *emitters) emitRestored(evt EvtPeeringConnectionRestored) {
+	e.mu.RLock()
+	defer e.mu.RUnlock()
+	if e.restored != nil {
+		if err := e.restored.Emit(evt); err != nil {
+			logger.Debugw("failed to emit event", "peer", evt.Peer, "error", err)
+		}
+	}
+}
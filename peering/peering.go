@@ -44,16 +44,50 @@ const (
 type peerHandler struct {
 	peer   peer.ID
 	host   host.Host
+	events *emitters
 	ctx    context.Context
 	cancel context.CancelFunc
 
 	mu             sync.Mutex
 	addrs          []multiaddr.Multiaddr
 	reconnectTimer *time.Timer
+	nextReconnect  time.Time
+	// failures counts the consecutive failed reconnect attempts.
+	failures int
+	// connected is set once the peer has been connected, lostAt when that
+	// connection was lost.
+	connected bool
+	lostAt    time.Time
 
 	nextDelay time.Duration
 }
 
+// ConnState is the connection state of a peered peer.
+type ConnState string
+
+const (
+	// Connected means the peer is currently connected.
+	Connected ConnState = "connected"
+	// BackingOff means the peer is disconnected and the service waits for
+	// the next reconnect attempt.
+	BackingOff ConnState = "backing-off"
+	// Disconnected means the peer is disconnected and no reconnect is
+	// scheduled, e.g. because the service is not running.
+	Disconnected ConnState = "disconnected"
+)
+
+// PeerState is a snapshot of a peer of the peering service.
+type PeerState struct {
+	ID    peer.ID
+	Addrs []multiaddr.Multiaddr
+	State ConnState
+	// Failures is the number of consecutive failed reconnect attempts.
+	Failures int
+	// NextReconnect is the time of the next reconnect attempt while
+	// backing off.
+	NextReconnect time.Time
+}
+
 // setAddrs sets the addresses for this peer.
 func (ph *peerHandler) setAddrs(addrs []multiaddr.Multiaddr) {
 	// Not strictly necessary, but it helps to not trust the calling code.
@@ -72,6 +106,26 @@ func (ph *peerHandler) getAddrs() []multiaddr.Multiaddr {
 	return ph.addrs
 }
 
+// state returns a snapshot of the peer state.
+func (ph *peerHandler) state() PeerState {
+	ph.mu.Lock()
+	defer ph.mu.Unlock()
+	st := PeerState{
+		ID:       ph.peer,
+		Addrs:    ph.addrs,
+		State:    Disconnected,
+		Failures: ph.failures,
+	}
+	switch {
+	case ph.host.Network().Connectedness(ph.peer) == network.Connected:
+		st.State = Connected
+	case ph.reconnectTimer != nil:
+		st.State = BackingOff
+		st.NextReconnect = ph.nextReconnect
+	}
+	return st
+}
+
 // stop permanently stops the peer handler.
 func (ph *peerHandler) stop() {
 	ph.cancel()
@@ -112,7 +166,10 @@ func (ph *peerHandler) reconnect() {
 		if ph.reconnectTimer != nil {
 			// Only counts if the reconnectTimer still exists. If not, a
 			// connection _was_ somehow established.
-			ph.reconnectTimer.Reset(ph.nextBackoff())
+			ph.failures++
+			delay := ph.nextBackoff()
+			ph.nextReconnect = time.Now().Add(delay)
+			ph.reconnectTimer.Reset(delay)
 		}
 		// Otherwise, someone else has stopped us so we can assume that
 		// we're either connected or someone else will start us.
@@ -126,32 +183,64 @@ func (ph *peerHandler) reconnect() {
 
 func (ph *peerHandler) stopIfConnected() {
 	ph.mu.Lock()
-	defer ph.mu.Unlock()
+	if ph.host.Network().Connectedness(ph.peer) != network.Connected {
+		ph.mu.Unlock()
+		return
+	}
+	ph.connected = true
 
-	if ph.reconnectTimer != nil && ph.host.Network().Connectedness(ph.peer) == network.Connected {
+	var restored *EvtPeeringConnectionRestored
+	if ph.reconnectTimer != nil {
 		logger.Debugw("successfully reconnected", "peer", ph.peer)
 		ph.reconnectTimer.Stop()
 		ph.reconnectTimer = nil
 		ph.nextDelay = initialDelay
+		if !ph.lostAt.IsZero() {
+			restored = &EvtPeeringConnectionRestored{
+				Peer:     ph.peer,
+				Failures: ph.failures,
+				Downtime: time.Since(ph.lostAt),
+			}
+		}
+		ph.failures = 0
+		ph.lostAt = time.Time{}
+	}
+	ph.mu.Unlock()
+
+	// Emit outside of the lock, subscribers may call back into the service.
+	if restored != nil && ph.events != nil {
+		ph.events.emitRestored(*restored)
 	}
 }
 
 // startIfDisconnected is the inverse of stopIfConnected.
 func (ph *peerHandler) startIfDisconnected() {
 	ph.mu.Lock()
-	defer ph.mu.Unlock()
-
+	lost := false
 	if ph.reconnectTimer == nil && ph.host.Network().Connectedness(ph.peer) != network.Connected {
 		logger.Debugw("disconnected from peer", "peer", ph.peer)
 		// Always start with a short timeout so we can stagger things a bit.
-		ph.reconnectTimer = time.AfterFunc(ph.nextBackoff(), ph.reconnect)
+		delay := ph.nextBackoff()
+		ph.nextReconnect = time.Now().Add(delay)
+		ph.reconnectTimer = time.AfterFunc(delay, ph.reconnect)
+		// Only report peers we have been connected to before.
+		if ph.connected && ph.lostAt.IsZero() {
+			ph.lostAt = time.Now()
+			lost = true
+		}
+	}
+	ph.mu.Unlock()
+
+	if lost && ph.events != nil {
+		ph.events.emitLost(EvtPeeringConnectionLost{Peer: ph.peer})
 	}
 }
 
 // PeeringService maintains connections to specified peers, reconnecting on
 // disconnect with a back-off.
 type PeeringService struct {
-	host host.Host
+	host   host.Host
+	events *emitters
 
 	mu    sync.RWMutex
 	peers map[peer.ID]*peerHandler
@@ -161,7 +250,7 @@ type PeeringService struct {
 // NewPeeringService constructs a new peering service. Peers can be added and
 // removed immediately, but connections won't be formed until `Start` is called.
 func NewPeeringService(host host.Host) *PeeringService {
-	return &PeeringService{host: host, peers: make(map[peer.ID]*peerHandler)}
+	return &PeeringService{host: host, events: new(emitters), peers: make(map[peer.ID]*peerHandler)}
 }
 
 // Start starts the peering service, connecting and maintaining connections to
@@ -179,6 +268,9 @@ func (ps *PeeringService) Start() error {
 	case stateStopped:
 		return errors.New("already stopped")
 	}
+	if err := ps.events.start(ps.host.EventBus()); err != nil {
+		return err
+	}
 	ps.host.Network().Notify((*netNotifee)(ps))
 	ps.state = stateRunning
 	for _, handler := range ps.peers {
@@ -200,6 +292,7 @@ func (ps *PeeringService) Stop() error {
 		for _, handler := range ps.peers {
 			handler.stop()
 		}
+		ps.events.stop()
 		ps.state = stateStopped
 	}
 	return nil
@@ -224,6 +317,7 @@ func (ps *PeeringService) AddPeer(info peer.AddrInfo) {
 
 		handler = &peerHandler{
 			host:      ps.host,
+			events:    ps.events,
 			peer:      info.ID,
 			addrs:     info.Addrs,
 			nextDelay: initialDelay,
@@ -258,6 +352,31 @@ func (ps *PeeringService) RemovePeer(id peer.ID) {
 	}
 }
 
+// ListPeers returns a snapshot of the state of all peers of the peering
+// service.
+func (ps *PeeringService) ListPeers() []PeerState {
+	ps.mu.RLock()
+	defer ps.mu.RUnlock()
+
+	peers := make([]PeerState, 0, len(ps.peers))
+	for _, handler := range ps.peers {
+		peers = append(peers, handler.state())
+	}
+	return peers
+}
+
+// GetPeer returns a snapshot of the state of the peer id.
+func (ps *PeeringService) GetPeer(id peer.ID) (PeerState, bool) {
+	ps.mu.RLock()
+	defer ps.mu.RUnlock()
+
+	handler, ok := ps.peers[id]
+	if !ok {
+		return PeerState{}, false
+	}
+	return handler.state(), true
+}
+
 type netNotifee PeeringService
 
 func (nn *netNotifee) Connected(_ network.Network, c network.Conn) {
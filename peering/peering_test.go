@@ -33,6 +33,13 @@ func TestPeeringService(t *testing.T) {
 	h1 := newNode(ctx, t)
 	ps1 := NewPeeringService(h1)
 
+	sub, err := h1.EventBus().Subscribe([]interface{}{
+		new(EvtPeeringConnectionLost),
+		new(EvtPeeringConnectionRestored),
+	})
+	require.NoError(t, err)
+	defer sub.Close()
+
 	h2 := newNode(ctx, t)
 	h3 := newNode(ctx, t)
 	h4 := newNode(ctx, t)
@@ -103,6 +110,30 @@ func TestPeeringService(t *testing.T) {
 		return h1.Network().Connectedness(h2.ID()) == network.Connected
 	}, 30*time.Second, 1*time.Second)
 
+	// The loss and the reconnect should have been reported.
+	select {
+	case e := <-sub.Out():
+		lost := e.(EvtPeeringConnectionLost)
+		require.Equal(t, h2.ID(), lost.Peer)
+	case <-time.After(5 * time.Second):
+		t.Fatal("expected a lost event")
+	}
+	select {
+	case e := <-sub.Out():
+		restored := e.(EvtPeeringConnectionRestored)
+		require.Equal(t, h2.ID(), restored.Peer)
+		require.NotZero(t, restored.Downtime)
+	case <-time.After(5 * time.Second):
+		t.Fatal("expected a restored event")
+	}
+
+	// The peer should be listed as connected.
+	states := ps1.ListPeers()
+	require.Len(t, states, 1)
+	require.Equal(t, h2.ID(), states[0].ID)
+	require.Equal(t, Connected, states[0].State)
+	require.Zero(t, states[0].Failures)
+
 	// Unprotect 2 from 1.
 	ps1.RemovePeer(h2.ID())
 
@@ -114,6 +145,8 @@ func TestPeeringService(t *testing.T) {
 		return h1.Network().Connectedness(h2.ID()) != network.Connected
 	}, 5*time.Second, 10*time.Millisecond)
 
+	require.Empty(t, ps1.ListPeers())
+
 	// Should never reconnect.
 	require.Never(t, func() bool {
 		return h1.Network().Connectedness(h2.ID()) == network.Connected
@@ -0,0 +1,86 @@
+package pinindex
+
+import (
+	"context"
+
+	cid "github.com/ipfs/go-cid"
+	pin "github.com/ipfs/go-ipfs-pinner"
+)
+
+// Import replaces the pins of p with the pins of from, usually the pinner
+// of the former format, and builds the index from the local blocks. The
+// recursive pins whose blocks are not all local are imported as pending.
+func (p *Pinner) Import(ctx context.Context, from pin.Pinner) error {
+	recursive, err := from.RecursiveKeys(ctx)
+	if err != nil {
+		return err
+	}
+	direct, err := from.DirectKeys(ctx)
+	if err != nil {
+		return err
+	}
+
+	p.lock.Lock()
+	defer p.lock.Unlock()
+
+	// Drop what an interrupted import left.
+	if err := p.clear(); err != nil {
+		return err
+	}
+	for i, c := range recursive {
+		if err := p.pinWithMode(c, pin.Recursive); err != nil {
+			return err
+		}
+		log.Debugf("indexed recursive pin %d/%d: %s", i+1, len(recursive), c)
+	}
+	for _, c := range direct {
+		if err := p.pinWithMode(c, pin.Direct); err != nil {
+			return err
+		}
+	}
+	if err := p.ds.Put(versionKey, []byte(Version)); err != nil {
+		return err
+	}
+	return p.ds.Sync(indexPrefix)
+}
+
+// Export replaces the pins of to, usually the pinner of the former format,
+// with the pins of p and removes the index.
+func (p *Pinner) Export(ctx context.Context, to pin.Pinner) error {
+	p.lock.Lock()
+	defer p.lock.Unlock()
+
+	recursive, err := p.keys(recursivePrefix)
+	if err != nil {
+		return err
+	}
+	direct, err := p.keys(directPrefix)
+	if err != nil {
+		return err
+	}
+
+	for _, mode := range []pin.Mode{pin.Recursive, pin.Direct} {
+		var old []cid.Cid
+		if mode == pin.Recursive {
+			old, err = to.RecursiveKeys(ctx)
+		} else {
+			old, err = to.DirectKeys(ctx)
+		}
+		if err != nil {
+			return err
+		}
+		for _, c := range old {
+			to.RemovePinWithMode(c, mode)
+		}
+	}
+	for _, c := range recursive {
+		to.PinWithMode(c, pin.Recursive)
+	}
+	for _, c := range direct {
+		to.PinWithMode(c, pin.Direct)
+	}
+	if err := to.Flush(ctx); err != nil {
+		return err
+	}
+	return p.clear()
+}
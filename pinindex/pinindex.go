@@ -0,0 +1,606 @@
+// Package pinindex implements a pinner which keeps the pins in the datastore
+// together with an index of the indirect pins.
+//
+// The index records, for every block of the DAG of a recursive pin, a
+// reference from the block to the pin. The references are updated when pins
+// are added, removed or updated, so that listing the indirect pins and
+// finding out why a block is pinned don't need to walk the pinned DAGs. The
+// number of references of a block is the number of recursive pins keeping
+// it. The references are also kept by pin, so that removing a pin doesn't
+// walk its DAG either.
+//
+// Recursive pins whose DAG can't be walked from the local blocks when they
+// are recorded without fetching, by PinWithMode and Import, are kept as
+// pending: the blocks of their DAG which are local are referenced, so that
+// they stay pinned, and the rest once they are there.
+package pinindex
+
+import (
+	"context"
+	"fmt"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	cid "github.com/ipfs/go-cid"
+	ds "github.com/ipfs/go-datastore"
+	dsq "github.com/ipfs/go-datastore/query"
+	dshelp "github.com/ipfs/go-ipfs-ds-help"
+	pin "github.com/ipfs/go-ipfs-pinner"
+	ipld "github.com/ipfs/go-ipld-format"
+	logging "github.com/ipfs/go-log"
+	dag "github.com/ipfs/go-merkledag"
+)
+
+var log = logging.Logger("pinindex")
+
+// Version is the version of the index format.
+const Version = "1"
+
+var (
+	indexPrefix     = ds.NewKey("/local/pins/index")
+	versionKey      = indexPrefix.ChildString("version")
+	recursivePrefix = indexPrefix.ChildString("recursive")
+	directPrefix    = indexPrefix.ChildString("direct")
+	// refsPrefix holds a /<block>/<root> key for every block of the DAG of
+	// a recursive pin.
+	refsPrefix = indexPrefix.ChildString("refs")
+	// rootsPrefix holds the same references as /<root>/<block> keys.
+	rootsPrefix = indexPrefix.ChildString("roots")
+	// pendingPrefix holds the recursive pins whose references are not
+	// indexed yet.
+	pendingPrefix = indexPrefix.ChildString("pending")
+)
+
+// localWalkTimeout bounds the walks of the local blocks of a DAG.
+const localWalkTimeout = 5 * time.Minute
+
+// Pinner is a pin.Pinner maintaining an index of the indirect pins.
+type Pinner struct {
+	lock  sync.RWMutex
+	ds    ds.Batching
+	dserv ipld.DAGService
+	local ipld.DAGService
+}
+
+var _ pin.Pinner = (*Pinner)(nil)
+
+// New returns a Pinner keeping the pins and their index in d, which fetches
+// the pinned DAGs with dserv. DAGs which are pinned without fetching them
+// are read with local, which must not fetch blocks from the network.
+func New(d ds.Batching, dserv, local ipld.DAGService) *Pinner {
+	return &Pinner{ds: d, dserv: dserv, local: local}
+}
+
+func pinKey(prefix ds.Key, c cid.Cid) ds.Key {
+	return prefix.Child(dshelp.CidToDsKey(c))
+}
+
+func refKey(c, root cid.Cid) ds.Key {
+	return pinKey(refsPrefix, c).Child(dshelp.CidToDsKey(root))
+}
+
+func (p *Pinner) has(k ds.Key) (bool, error) {
+	return p.ds.Has(k)
+}
+
+// Initialized returns whether the index was built, by Import.
+func (p *Pinner) Initialized() (bool, error) {
+	return p.has(versionKey)
+}
+
+func rootRefKey(root, c cid.Cid) ds.Key {
+	return pinKey(rootsPrefix, root).Child(dshelp.CidToDsKey(c))
+}
+
+// descendants returns the blocks of the DAG of root, except root, fetching
+// the missing ones with dserv.
+func descendants(ctx context.Context, dserv ipld.DAGService, root cid.Cid) ([]cid.Cid, error) {
+	set := cid.NewSet()
+	err := dag.Walk(ctx, dag.GetLinksWithDAG(dserv), root, set.Visit, dag.SkipRoot(), dag.Concurrent())
+	if err != nil {
+		return nil, err
+	}
+	return set.Keys(), nil
+}
+
+// localDescendants returns the blocks of the DAG of root, except root,
+// reachable through local blocks. complete is false when some blocks of the
+// DAG are missing, or couldn't be walked in time.
+func (p *Pinner) localDescendants(root cid.Cid) (blocks []cid.Cid, complete bool) {
+	ctx, cancel := context.WithTimeout(context.Background(), localWalkTimeout)
+	defer cancel()
+
+	var missing int32
+	getLinks := dag.GetLinksWithDAG(p.local)
+	set := cid.NewSet()
+	err := dag.Walk(ctx, func(ctx context.Context, c cid.Cid) ([]*ipld.Link, error) {
+		links, err := getLinks(ctx, c)
+		if err == ipld.ErrNotFound {
+			atomic.StoreInt32(&missing, 1)
+			return nil, nil
+		}
+		return links, err
+	}, root, set.Visit, dag.SkipRoot(), dag.Concurrent())
+	if err != nil {
+		log.Warnf("failed to walk the local blocks of %s: %s", root, err)
+	}
+	return set.Keys(), err == nil && atomic.LoadInt32(&missing) == 0
+}
+
+// addRefs records root as a recursive pin of the given blocks.
+func (p *Pinner) addRefs(root cid.Cid, blocks []cid.Cid) error {
+	return p.putRefs(root, blocks, true)
+}
+
+// putRefs records root as a recursive pin of the given blocks, which are
+// all the blocks of its DAG when complete, or is left pending otherwise.
+func (p *Pinner) putRefs(root cid.Cid, blocks []cid.Cid, complete bool) error {
+	b, err := p.ds.Batch()
+	if err != nil {
+		return err
+	}
+	for _, c := range blocks {
+		if err := b.Put(refKey(c, root), nil); err != nil {
+			return err
+		}
+		if err := b.Put(rootRefKey(root, c), nil); err != nil {
+			return err
+		}
+	}
+	if err := b.Put(pinKey(recursivePrefix, root), nil); err != nil {
+		return err
+	}
+	if complete {
+		err = b.Delete(pinKey(pendingPrefix, root))
+	} else {
+		err = b.Put(pinKey(pendingPrefix, root), nil)
+	}
+	if err != nil {
+		return err
+	}
+	if err := b.Delete(pinKey(directPrefix, root)); err != nil {
+		return err
+	}
+	return b.Commit()
+}
+
+// pinLocal records the recursive pin of root from its local blocks. When
+// some are missing the pin is recorded as pending.
+func (p *Pinner) pinLocal(root cid.Cid) error {
+	blocks, complete := p.localDescendants(root)
+	if !complete {
+		log.Errorf("the blocks of the recursive pin %s are not all local, indexing it later", root)
+	}
+	return p.putRefs(root, blocks, complete)
+}
+
+// removeRefs removes the recursive pin of root and the references to it.
+func (p *Pinner) removeRefs(root cid.Cid) error {
+	blocks, err := p.keys(pinKey(rootsPrefix, root))
+	if err != nil {
+		return err
+	}
+
+	b, err := p.ds.Batch()
+	if err != nil {
+		return err
+	}
+	for _, c := range blocks {
+		if err := b.Delete(refKey(c, root)); err != nil {
+			return err
+		}
+		if err := b.Delete(rootRefKey(root, c)); err != nil {
+			return err
+		}
+	}
+	if err := b.Delete(pinKey(pendingPrefix, root)); err != nil {
+		return err
+	}
+	if err := b.Delete(pinKey(recursivePrefix, root)); err != nil {
+		return err
+	}
+	return b.Commit()
+}
+
+// Pending returns the recursive pins whose references are not indexed yet,
+// because the blocks of their DAG were not all local.
+func (p *Pinner) Pending(ctx context.Context) ([]cid.Cid, error) {
+	p.lock.RLock()
+	defer p.lock.RUnlock()
+	return p.keys(pendingPrefix)
+}
+
+// indexPending indexes the references of the pending recursive pins to the
+// blocks which are local by now. Those which still miss blocks are left
+// pending. The DAGs are walked without the lock, which is only taken to
+// record the result.
+func (p *Pinner) indexPending() error {
+	p.lock.RLock()
+	pending, err := p.keys(pendingPrefix)
+	p.lock.RUnlock()
+	if err != nil || len(pending) == 0 {
+		return err
+	}
+
+	for _, root := range pending {
+		blocks, complete := p.localDescendants(root)
+		if err := p.commitPending(root, blocks, complete); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// commitPending records the references of the pending recursive pin of root
+// to blocks, unless it was removed or indexed meanwhile.
+func (p *Pinner) commitPending(root cid.Cid, blocks []cid.Cid, complete bool) error {
+	p.lock.Lock()
+	defer p.lock.Unlock()
+	ok, err := p.has(pinKey(pendingPrefix, root))
+	if err != nil || !ok {
+		return err
+	}
+	return p.putRefs(root, blocks, complete)
+}
+
+// keys returns the cids under prefix.
+func (p *Pinner) keys(prefix ds.Key) ([]cid.Cid, error) {
+	res, err := p.ds.Query(dsq.Query{Prefix: prefix.String(), KeysOnly: true})
+	if err != nil {
+		return nil, err
+	}
+	defer res.Close()
+
+	var cids []cid.Cid
+	for e := range res.Next() {
+		if e.Error != nil {
+			return nil, e.Error
+		}
+		c, err := dshelp.DsKeyToCid(ds.NewKey(ds.RawKey(e.Key).BaseNamespace()))
+		if err != nil {
+			log.Warnf("ignoring pin with invalid key %s", e.Key)
+			continue
+		}
+		cids = append(cids, c)
+	}
+	return cids, nil
+}
+
+// Refs returns the recursive pins whose DAG contains c, besides c itself.
+func (p *Pinner) Refs(ctx context.Context, c cid.Cid) ([]cid.Cid, error) {
+	if err := p.indexPending(); err != nil {
+		return nil, err
+	}
+	p.lock.RLock()
+	defer p.lock.RUnlock()
+	return p.keys(pinKey(refsPrefix, c))
+}
+
+// via returns a recursive pin whose DAG contains c, if any.
+func (p *Pinner) via(c cid.Cid) (cid.Cid, bool, error) {
+	res, err := p.ds.Query(dsq.Query{Prefix: pinKey(refsPrefix, c).String(), KeysOnly: true, Limit: 1})
+	if err != nil {
+		return cid.Undef, false, err
+	}
+	defer res.Close()
+
+	for e := range res.Next() {
+		if e.Error != nil {
+			return cid.Undef, false, e.Error
+		}
+		root, err := dshelp.DsKeyToCid(ds.NewKey(ds.RawKey(e.Key).BaseNamespace()))
+		if err != nil {
+			return cid.Undef, false, err
+		}
+		return root, true, nil
+	}
+	return cid.Undef, false, nil
+}
+
+// IndirectKeys returns the blocks pinned through recursive pins, some of
+// which may be pinned directly or recursively as well.
+func (p *Pinner) IndirectKeys(ctx context.Context) ([]cid.Cid, error) {
+	if err := p.indexPending(); err != nil {
+		return nil, err
+	}
+	p.lock.RLock()
+	defer p.lock.RUnlock()
+
+	res, err := p.ds.Query(dsq.Query{Prefix: refsPrefix.String(), KeysOnly: true})
+	if err != nil {
+		return nil, err
+	}
+	defer res.Close()
+
+	set := cid.NewSet()
+	for e := range res.Next() {
+		if e.Error != nil {
+			return nil, e.Error
+		}
+		k := ds.RawKey(e.Key)
+		c, err := dshelp.DsKeyToCid(ds.NewKey(k.Parent().BaseNamespace()))
+		if err != nil {
+			log.Warnf("ignoring pin reference with invalid key %s", e.Key)
+			continue
+		}
+		set.Add(c)
+	}
+	return set.Keys(), nil
+}
+
+// Pin the given node, optionally recursively.
+func (p *Pinner) Pin(ctx context.Context, node ipld.Node, recursive bool) error {
+	if err := p.dserv.Add(ctx, node); err != nil {
+		return err
+	}
+	c := node.Cid()
+
+	if !recursive {
+		p.lock.Lock()
+		defer p.lock.Unlock()
+		if ok, err := p.has(pinKey(recursivePrefix, c)); err != nil || ok {
+			if ok {
+				err = fmt.Errorf("%s already pinned recursively", c)
+			}
+			return err
+		}
+		return p.ds.Put(pinKey(directPrefix, c), nil)
+	}
+
+	p.lock.RLock()
+	ok, err := p.has(pinKey(recursivePrefix, c))
+	p.lock.RUnlock()
+	if err != nil || ok {
+		return err
+	}
+
+	// Fetch the graph without the lock.
+	blocks, err := descendants(ctx, p.dserv, c)
+	if err != nil {
+		return err
+	}
+
+	p.lock.Lock()
+	defer p.lock.Unlock()
+	return p.addRefs(c, blocks)
+}
+
+// Unpin the given cid. If recursive is true, removes either a recursive or
+// a direct pin. If recursive is false, only removes a direct pin.
+func (p *Pinner) Unpin(ctx context.Context, c cid.Cid, recursive bool) error {
+	p.lock.Lock()
+	defer p.lock.Unlock()
+
+	ok, err := p.has(pinKey(recursivePrefix, c))
+	if err != nil {
+		return err
+	}
+	if ok {
+		if !recursive {
+			return fmt.Errorf("%s is pinned recursively", c)
+		}
+		return p.removeRefs(c)
+	}
+
+	ok, err = p.has(pinKey(directPrefix, c))
+	if err != nil {
+		return err
+	}
+	if ok {
+		return p.ds.Delete(pinKey(directPrefix, c))
+	}
+	return pin.ErrNotPinned
+}
+
+// IsPinned returns whether or not the given cid is pinned and an explanation
+// of why its pinned.
+func (p *Pinner) IsPinned(ctx context.Context, c cid.Cid) (string, bool, error) {
+	return p.IsPinnedWithType(ctx, c, pin.Any)
+}
+
+// IsPinnedWithType returns whether or not the given cid is pinned with the
+// given pin type, as well as returning the type of pin its pinned with. The
+// type of indirect pins is the recursive pin they are pinned through.
+func (p *Pinner) IsPinnedWithType(ctx context.Context, c cid.Cid, mode pin.Mode) (string, bool, error) {
+	switch mode {
+	case pin.Any, pin.Direct, pin.Indirect, pin.Recursive, pin.Internal:
+	default:
+		return "", false, fmt.Errorf("invalid Pin Mode '%d', must be one of {%d, %d, %d, %d, %d}",
+			mode, pin.Direct, pin.Indirect, pin.Recursive, pin.Internal, pin.Any)
+	}
+
+	if mode == pin.Indirect || mode == pin.Any {
+		if err := p.indexPending(); err != nil {
+			return "", false, err
+		}
+	}
+	p.lock.RLock()
+	defer p.lock.RUnlock()
+
+	if mode == pin.Recursive || mode == pin.Any {
+		ok, err := p.has(pinKey(recursivePrefix, c))
+		if err != nil || ok {
+			return "recursive", ok, err
+		}
+	}
+	if mode == pin.Direct || mode == pin.Any {
+		ok, err := p.has(pinKey(directPrefix, c))
+		if err != nil || ok {
+			return "direct", ok, err
+		}
+	}
+	if mode == pin.Indirect || mode == pin.Any {
+		root, ok, err := p.via(c)
+		if err != nil || !ok {
+			return "", false, err
+		}
+		return root.String(), true, nil
+	}
+	return "", false, nil
+}
+
+// CheckIfPinned checks if a set of keys are pinned.
+func (p *Pinner) CheckIfPinned(ctx context.Context, cids ...cid.Cid) ([]pin.Pinned, error) {
+	if err := p.indexPending(); err != nil {
+		return nil, err
+	}
+	p.lock.RLock()
+	defer p.lock.RUnlock()
+
+	pinned := make([]pin.Pinned, 0, len(cids))
+	for _, c := range cids {
+		ok, err := p.has(pinKey(recursivePrefix, c))
+		if err != nil {
+			return nil, err
+		}
+		if ok {
+			pinned = append(pinned, pin.Pinned{Key: c, Mode: pin.Recursive})
+			continue
+		}
+		ok, err = p.has(pinKey(directPrefix, c))
+		if err != nil {
+			return nil, err
+		}
+		if ok {
+			pinned = append(pinned, pin.Pinned{Key: c, Mode: pin.Direct})
+			continue
+		}
+		root, ok, err := p.via(c)
+		if err != nil {
+			return nil, err
+		}
+		if ok {
+			pinned = append(pinned, pin.Pinned{Key: c, Mode: pin.Indirect, Via: root})
+			continue
+		}
+		pinned = append(pinned, pin.Pinned{Key: c, Mode: pin.NotPinned})
+	}
+	return pinned, nil
+}
+
+// Update updates a recursive pin from one cid to another. Only the blocks of
+// to which are missing are fetched.
+func (p *Pinner) Update(ctx context.Context, from, to cid.Cid, unpin bool) error {
+	if from == to {
+		// Nothing to do, don't remove the pin.
+		return nil
+	}
+
+	p.lock.RLock()
+	ok, err := p.has(pinKey(recursivePrefix, from))
+	p.lock.RUnlock()
+	if err != nil {
+		return err
+	}
+	if !ok {
+		return fmt.Errorf("'from' cid was not recursively pinned already")
+	}
+
+	blocks, err := descendants(ctx, p.dserv, to)
+	if err != nil {
+		return err
+	}
+
+	p.lock.Lock()
+	defer p.lock.Unlock()
+	if err := p.addRefs(to, blocks); err != nil {
+		return err
+	}
+	if unpin {
+		return p.removeRefs(from)
+	}
+	return nil
+}
+
+// pinWithMode pins c without fetching it. Recursive pins are indexed from
+// the local blocks of their DAG, or left pending.
+func (p *Pinner) pinWithMode(c cid.Cid, mode pin.Mode) error {
+	switch mode {
+	case pin.Recursive:
+		return p.pinLocal(c)
+	case pin.Direct:
+		return p.ds.Put(pinKey(directPrefix, c), nil)
+	}
+	return nil
+}
+
+// PinWithMode is for manually editing the pin structure. Recursive pins are
+// indexed from the local blocks of their DAG, see Pending.
+func (p *Pinner) PinWithMode(c cid.Cid, mode pin.Mode) {
+	p.lock.Lock()
+	defer p.lock.Unlock()
+	if err := p.pinWithMode(c, mode); err != nil {
+		log.Errorf("failed to pin %s: %s", c, err)
+	}
+}
+
+// RemovePinWithMode is for manually editing the pin structure.
+func (p *Pinner) RemovePinWithMode(c cid.Cid, mode pin.Mode) {
+	p.lock.Lock()
+	defer p.lock.Unlock()
+
+	var err error
+	switch mode {
+	case pin.Direct:
+		err = p.ds.Delete(pinKey(directPrefix, c))
+	case pin.Recursive:
+		err = p.removeRefs(c)
+	default:
+		// programmer error, panic OK
+		panic("unrecognized pin type")
+	}
+	if err != nil {
+		log.Errorf("failed to unpin %s: %s", c, err)
+	}
+}
+
+// Flush writes the index to disk. The index is updated as pins change, so
+// there is nothing else to write.
+func (p *Pinner) Flush(ctx context.Context) error {
+	p.lock.Lock()
+	defer p.lock.Unlock()
+	return p.ds.Sync(indexPrefix)
+}
+
+// DirectKeys returns all directly pinned cids.
+func (p *Pinner) DirectKeys(ctx context.Context) ([]cid.Cid, error) {
+	p.lock.RLock()
+	defer p.lock.RUnlock()
+	return p.keys(directPrefix)
+}
+
+// RecursiveKeys returns all recursively pinned cids.
+func (p *Pinner) RecursiveKeys(ctx context.Context) ([]cid.Cid, error) {
+	p.lock.RLock()
+	defer p.lock.RUnlock()
+	return p.keys(recursivePrefix)
+}
+
+// InternalPins returns nothing, the index doesn't keep blocks of its own.
+func (p *Pinner) InternalPins(ctx context.Context) ([]cid.Cid, error) {
+	return nil, nil
+}
+
+// clear removes the index.
+func (p *Pinner) clear() error {
+	res, err := p.ds.Query(dsq.Query{Prefix: indexPrefix.String(), KeysOnly: true})
+	if err != nil {
+		return err
+	}
+	defer res.Close()
+
+	b, err := p.ds.Batch()
+	if err != nil {
+		return err
+	}
+	for e := range res.Next() {
+		if e.Error != nil {
+			return e.Error
+		}
+		if err := b.Delete(ds.RawKey(e.Key)); err != nil {
+			return err
+		}
+	}
+	return b.Commit()
+}
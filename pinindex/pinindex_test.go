@@ -0,0 +1,246 @@
+package pinindex
+
+import (
+	"context"
+	"testing"
+
+	bserv "github.com/ipfs/go-blockservice"
+	cid "github.com/ipfs/go-cid"
+	ds "github.com/ipfs/go-datastore"
+	dssync "github.com/ipfs/go-datastore/sync"
+	bstore "github.com/ipfs/go-ipfs-blockstore"
+	offline "github.com/ipfs/go-ipfs-exchange-offline"
+	pin "github.com/ipfs/go-ipfs-pinner"
+	ipld "github.com/ipfs/go-ipld-format"
+	dag "github.com/ipfs/go-merkledag"
+)
+
+func newTestPinner() (*Pinner, ds.Batching, ipld.DAGService) {
+	dstore := dssync.MutexWrap(ds.NewMapDatastore())
+	bs := bstore.NewBlockstore(dstore)
+	dserv := dag.NewDAGService(bserv.New(bs, offline.Exchange(bs)))
+	return New(dstore, dserv, dserv), dstore, dserv
+}
+
+// newDAG adds a root with the given children, which share a leaf.
+func newDAG(t *testing.T, dserv ipld.DAGService, name string, children ...string) (*dag.ProtoNode, []*dag.ProtoNode) {
+	ctx := context.Background()
+	leaf := dag.NodeWithData([]byte("leaf"))
+	nodes := []*dag.ProtoNode{leaf}
+	root := dag.NodeWithData([]byte(name))
+	for _, c := range children {
+		nd := dag.NodeWithData([]byte(c))
+		if err := nd.AddNodeLink("leaf", leaf); err != nil {
+			t.Fatal(err)
+		}
+		if err := root.AddNodeLink(c, nd); err != nil {
+			t.Fatal(err)
+		}
+		nodes = append(nodes, nd)
+	}
+	for _, nd := range append(nodes, root) {
+		if err := dserv.Add(ctx, nd); err != nil {
+			t.Fatal(err)
+		}
+	}
+	return root, nodes
+}
+
+func assertPinned(t *testing.T, p pin.Pinner, c cid.Cid, expected string) {
+	t.Helper()
+	reason, pinned, err := p.IsPinned(context.Background(), c)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if expected == "" && pinned {
+		t.Fatalf("%s should not be pinned, got %s", c, reason)
+	}
+	if expected != "" && reason != expected {
+		t.Fatalf("expected %s to be pinned %s, got %q", c, expected, reason)
+	}
+}
+
+func assertIndirect(t *testing.T, p *Pinner, expected int) {
+	t.Helper()
+	keys, err := p.IndirectKeys(context.Background())
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(keys) != expected {
+		t.Fatalf("expected %d indirect pins, got %d", expected, len(keys))
+	}
+}
+
+func TestPinner(t *testing.T) {
+	ctx := context.Background()
+	p, _, dserv := newTestPinner()
+
+	a, aNodes := newDAG(t, dserv, "a", "x", "y")
+	b, bNodes := newDAG(t, dserv, "b", "y", "z")
+	leaf := aNodes[0].Cid()
+
+	if err := p.Pin(ctx, a, true); err != nil {
+		t.Fatal(err)
+	}
+	if err := p.Pin(ctx, b, true); err != nil {
+		t.Fatal(err)
+	}
+	assertPinned(t, p, a.Cid(), "recursive")
+	assertPinned(t, p, aNodes[1].Cid(), a.Cid().String())
+	assertIndirect(t, p, 4)
+
+	refs, err := p.Refs(ctx, leaf)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(refs) != 2 {
+		t.Fatalf("expected the leaf to be referenced by both pins, got %v", refs)
+	}
+
+	if err := p.Pin(ctx, a, false); err == nil {
+		t.Fatal("expected an error pinning directly a recursive pin")
+	}
+	if err := p.Unpin(ctx, a.Cid(), false); err == nil {
+		t.Fatal("expected an error unpinning a recursive pin non-recursively")
+	}
+	if err := p.Unpin(ctx, a.Cid(), true); err != nil {
+		t.Fatal(err)
+	}
+	assertPinned(t, p, a.Cid(), "")
+	assertPinned(t, p, aNodes[1].Cid(), "")
+	assertPinned(t, p, leaf, b.Cid().String())
+	assertIndirect(t, p, 3)
+
+	if err := p.Update(ctx, b.Cid(), a.Cid(), true); err != nil {
+		t.Fatal(err)
+	}
+	assertPinned(t, p, bNodes[2].Cid(), "")
+	assertPinned(t, p, leaf, a.Cid().String())
+	if err := p.Unpin(ctx, b.Cid(), true); err != pin.ErrNotPinned {
+		t.Fatalf("expected %s, got %v", pin.ErrNotPinned, err)
+	}
+
+	if err := p.Pin(ctx, b, false); err != nil {
+		t.Fatal(err)
+	}
+	pinned, err := p.CheckIfPinned(ctx, a.Cid(), b.Cid(), leaf, bNodes[2].Cid())
+	if err != nil {
+		t.Fatal(err)
+	}
+	modes := []pin.Mode{pin.Recursive, pin.Direct, pin.Indirect, pin.NotPinned}
+	for i, mode := range modes {
+		if pinned[i].Mode != mode {
+			t.Errorf("expected %s to be pinned with mode %d, got %d", pinned[i].Key, mode, pinned[i].Mode)
+		}
+	}
+	if !pinned[2].Via.Equals(a.Cid()) {
+		t.Errorf("expected the leaf to be pinned via %s, got %s", a.Cid(), pinned[2].Via)
+	}
+}
+
+func TestMigrate(t *testing.T) {
+	ctx := context.Background()
+	p, dstore, dserv := newTestPinner()
+	old := pin.NewPinner(dstore, dserv, dserv)
+
+	a, aNodes := newDAG(t, dserv, "a", "x")
+	b, _ := newDAG(t, dserv, "b")
+	if err := old.Pin(ctx, a, true); err != nil {
+		t.Fatal(err)
+	}
+	if err := old.Pin(ctx, b, false); err != nil {
+		t.Fatal(err)
+	}
+
+	if ok, err := p.Initialized(); err != nil || ok {
+		t.Fatalf("the index should not be initialized, got %v, %v", ok, err)
+	}
+	if err := p.Import(ctx, old); err != nil {
+		t.Fatal(err)
+	}
+	if ok, err := p.Initialized(); err != nil || !ok {
+		t.Fatalf("the index should be initialized, got %v, %v", ok, err)
+	}
+	assertPinned(t, p, a.Cid(), "recursive")
+	assertPinned(t, p, b.Cid(), "direct")
+	assertPinned(t, p, aNodes[0].Cid(), a.Cid().String())
+
+	// Pins changed while indexed are kept when moving back.
+	if err := p.Unpin(ctx, b.Cid(), false); err != nil {
+		t.Fatal(err)
+	}
+	if err := p.Pin(ctx, b, true); err != nil {
+		t.Fatal(err)
+	}
+	if err := p.Export(ctx, old); err != nil {
+		t.Fatal(err)
+	}
+	assertPinned(t, old, a.Cid(), "recursive")
+	assertPinned(t, old, b.Cid(), "recursive")
+	if ok, err := p.Initialized(); err != nil || ok {
+		t.Fatalf("the index should have been removed, got %v, %v", ok, err)
+	}
+	assertPinned(t, p, a.Cid(), "")
+}
+
+func TestPending(t *testing.T) {
+	ctx := context.Background()
+	dstore := dssync.MutexWrap(ds.NewMapDatastore())
+	bs := bstore.NewBlockstore(dstore)
+	dserv := dag.NewDAGService(bserv.New(bs, offline.Exchange(bs)))
+	old := pin.NewPinner(dstore, dserv, dserv)
+	p := New(dstore, dserv, dserv)
+
+	a, aNodes := newDAG(t, dserv, "a", "x")
+	if err := old.Pin(ctx, a, true); err != nil {
+		t.Fatal(err)
+	}
+	// The leaf goes missing, like a block of a filestore file which was
+	// moved.
+	leaf := aNodes[0]
+	if err := bs.DeleteBlock(leaf.Cid()); err != nil {
+		t.Fatal(err)
+	}
+
+	if err := p.Import(ctx, old); err != nil {
+		t.Fatal(err)
+	}
+	assertPinned(t, p, a.Cid(), "recursive")
+	pending, err := p.Pending(ctx)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(pending) != 1 || !pending[0].Equals(a.Cid()) {
+		t.Fatalf("expected %s to be pending, got %v", a.Cid(), pending)
+	}
+	// The blocks which are there stay pinned meanwhile.
+	assertPinned(t, p, aNodes[1].Cid(), a.Cid().String())
+	assertIndirect(t, p, 2)
+
+	// Once the block is back, the pin is indexed when looked up.
+	if err := dserv.Add(ctx, leaf); err != nil {
+		t.Fatal(err)
+	}
+	assertPinned(t, p, leaf.Cid(), a.Cid().String())
+	if pending, err := p.Pending(ctx); err != nil || len(pending) != 0 {
+		t.Fatalf("expected no pending pins, got %v, %v", pending, err)
+	}
+
+	// Unpinning uses the index, not the DAG.
+	for _, nd := range aNodes {
+		if err := bs.DeleteBlock(nd.Cid()); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if err := p.Unpin(ctx, a.Cid(), true); err != nil {
+		t.Fatal(err)
+	}
+	assertIndirect(t, p, 0)
+	refs, err := p.Refs(ctx, leaf.Cid())
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(refs) != 0 {
+		t.Fatalf("expected the references to be removed, got %v", refs)
+	}
+}
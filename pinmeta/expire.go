@@ -0,0 +1,110 @@
+package pinmeta
+
+import (
+	"context"
+	"time"
+
+	cid "github.com/ipfs/go-cid"
+	bstore "github.com/ipfs/go-ipfs-blockstore"
+	pin "github.com/ipfs/go-ipfs-pinner"
+	"github.com/jbenet/goprocess"
+	gpctx "github.com/jbenet/goprocess/context"
+)
+
+// DefaultExpireInterval is how often expired pins are looked for.
+const DefaultExpireInterval = time.Minute
+
+// Expirer removes the pins which expired.
+type Expirer struct {
+	// Interval is how often expired pins are looked for.
+	Interval time.Duration
+
+	store  *Store
+	pinner pin.Pinner
+	locker bstore.GCLocker
+}
+
+// NewExpirer returns an Expirer removing the expired pins of store from
+// pinner, holding the pin lock of locker while doing so.
+func NewExpirer(store *Store, pinner pin.Pinner, locker bstore.GCLocker) *Expirer {
+	return &Expirer{
+		Interval: DefaultExpireInterval,
+		store:    store,
+		pinner:   pinner,
+		locker:   locker,
+	}
+}
+
+// Run removes the expired pins every Interval until proc closes.
+func (e *Expirer) Run(proc goprocess.Process) {
+	ctx := gpctx.OnClosingContext(proc)
+	ticker := time.NewTicker(e.Interval)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-ticker.C:
+			removed, err := e.RemoveExpired(ctx, time.Now())
+			if err != nil {
+				log.Errorf("failed to remove expired pins: %s", err)
+			}
+			for _, c := range removed {
+				log.Infof("unpinned %s: pin expired", c)
+			}
+		case <-proc.Closing():
+			return
+		}
+	}
+}
+
+// RemoveExpired unpins everything which expired at now, and returns what was
+// unpinned.
+func (e *Expirer) RemoveExpired(ctx context.Context, now time.Time) ([]cid.Cid, error) {
+	all, err := e.store.List()
+	if err != nil {
+		return nil, err
+	}
+	var expired []cid.Cid
+	for c, m := range all {
+		if m.Expired(now) {
+			expired = append(expired, c)
+		}
+	}
+	if len(expired) == 0 {
+		return nil, nil
+	}
+
+	defer e.locker.PinLock().Unlock()
+
+	var removed []cid.Cid
+	for _, c := range expired {
+		// The pin may have been renewed or removed since the records
+		// were listed.
+		m, err := e.store.Get(c)
+		if err != nil {
+			return removed, err
+		}
+		if !m.Expired(now) {
+			continue
+		}
+
+		// Whether pinned recursively or directly.
+		err = e.pinner.Unpin(ctx, c, true)
+		switch err {
+		case nil:
+			removed = append(removed, c)
+		case pin.ErrNotPinned:
+			// unpinned by hand already
+		default:
+			log.Errorf("failed to unpin expired %s: %s", c, err)
+			continue
+		}
+		if err := e.store.Delete(c); err != nil {
+			return removed, err
+		}
+	}
+	if len(removed) == 0 {
+		return nil, nil
+	}
+	return removed, e.pinner.Flush(ctx)
+}
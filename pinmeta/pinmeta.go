@@ -0,0 +1,255 @@
+// Package pinmeta records metadata about pins, like their name or when they
+// expire, next to the pinner which only knows about pin types.
+package pinmeta
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"strings"
+	"sync"
+	"time"
+
+	cid "github.com/ipfs/go-cid"
+	ds "github.com/ipfs/go-datastore"
+	dsq "github.com/ipfs/go-datastore/query"
+	dshelp "github.com/ipfs/go-ipfs-ds-help"
+	pin "github.com/ipfs/go-ipfs-pinner"
+	ipld "github.com/ipfs/go-ipld-format"
+	logging "github.com/ipfs/go-log"
+)
+
+var log = logging.Logger("pinmeta")
+
+// metaPrefix is the datastore prefix of the pin metadata records.
+var metaPrefix = ds.NewKey("/local/pins/meta")
+
+// Metadata is what is recorded about a pin besides its type.
+type Metadata struct {
+	// Name tells what the pin is for.
+	Name string `json:",omitempty"`
+	// Labels are arbitrary key/value pairs attached to the pin.
+	Labels map[string]string `json:",omitempty"`
+	// Expires is when the pin is removed, zero for pins which don't expire.
+	Expires time.Time `json:",omitempty"`
+	// Size is the number of DAG bytes of the pin accounted to its
+	// namespace, zero for pins outside of namespaces.
+	Size uint64 `json:",omitempty"`
+}
+
+// IsZero reports whether m holds nothing worth recording.
+func (m Metadata) IsZero() bool {
+	return m.Name == "" && len(m.Labels) == 0 && m.Expires.IsZero()
+}
+
+// Merge returns the metadata of a pin described by prev which is pinned again
+// with next. pinned tells whether the content was already pinned directly or
+// recursively.
+//
+// The name is replaced when next has one and the labels of next are added to
+// those of prev. An expiring pin never shortens an existing one: pinned
+// content which doesn't expire stays permanent, and the later expiry is kept
+// otherwise. Pinning without expiry makes the pin permanent.
+func Merge(prev, next Metadata, pinned bool) Metadata {
+	m := Metadata{
+		Name:    prev.Name,
+		Expires: next.Expires,
+	}
+	if next.Name != "" {
+		m.Name = next.Name
+	}
+	if len(prev.Labels)+len(next.Labels) > 0 {
+		m.Labels = make(map[string]string, len(prev.Labels)+len(next.Labels))
+		for k, v := range prev.Labels {
+			m.Labels[k] = v
+		}
+		for k, v := range next.Labels {
+			m.Labels[k] = v
+		}
+	}
+	if !m.Expires.IsZero() {
+		switch {
+		case pinned && prev.Expires.IsZero():
+			m.Expires = time.Time{}
+		case prev.Expires.After(m.Expires):
+			m.Expires = prev.Expires
+		}
+	}
+	return m
+}
+
+// Filter selects pins by their metadata. The zero Filter selects all the
+// pins.
+type Filter struct {
+	// Name selects the pins with this name when not empty.
+	Name string
+	// Labels selects the pins with all these labels.
+	Labels map[string]string
+}
+
+// IsZero reports whether f selects all the pins.
+func (f Filter) IsZero() bool {
+	return f.Name == "" && len(f.Labels) == 0
+}
+
+// Matches reports whether the pin described by m is selected by f.
+func (f Filter) Matches(m Metadata) bool {
+	if f.Name != "" && f.Name != m.Name {
+		return false
+	}
+	for k, v := range f.Labels {
+		if l, ok := m.Labels[k]; !ok || l != v {
+			return false
+		}
+	}
+	return true
+}
+
+// ParseLabels parses labels given as "key=value" strings.
+func ParseLabels(kvs []string) (map[string]string, error) {
+	if len(kvs) == 0 {
+		return nil, nil
+	}
+	labels := make(map[string]string, len(kvs))
+	for _, kv := range kvs {
+		i := strings.IndexByte(kv, '=')
+		if i <= 0 {
+			return nil, fmt.Errorf("invalid label %q, expected key=value", kv)
+		}
+		labels[kv[:i]] = kv[i+1:]
+	}
+	return labels, nil
+}
+
+// Expired reports whether the pin expired at now.
+func (m Metadata) Expired(now time.Time) bool {
+	return !m.Expires.IsZero() && !now.Before(m.Expires)
+}
+
+// Store keeps the metadata of pins by CID in a datastore.
+type Store struct {
+	// Quotas limits the size of the pins of namespaces, when set.
+	Quotas *Quotas
+
+	ds ds.Datastore
+
+	nsLock sync.Mutex
+	// nsLocks serialize the pins of each namespace, see LockNamespace.
+	nsLocks map[string]*sync.Mutex
+}
+
+// NewStore returns a Store keeping its records in d.
+func NewStore(d ds.Datastore) *Store {
+	return &Store{ds: d}
+}
+
+func metaKey(c cid.Cid) ds.Key {
+	return metaPrefix.Child(dshelp.CidToDsKey(c))
+}
+
+// Get returns the metadata of the pin of c, which is zero when nothing is
+// recorded.
+func (s *Store) Get(c cid.Cid) (Metadata, error) {
+	var m Metadata
+	buf, err := s.ds.Get(metaKey(c))
+	switch err {
+	case nil:
+	case ds.ErrNotFound:
+		return m, nil
+	default:
+		return m, err
+	}
+	err = json.Unmarshal(buf, &m)
+	return m, err
+}
+
+// Put records the metadata of the pin of c, zero metadata removes the record.
+func (s *Store) Put(c cid.Cid, m Metadata) error {
+	if m.IsZero() {
+		return s.Delete(c)
+	}
+	buf, err := json.Marshal(m)
+	if err != nil {
+		return err
+	}
+	return s.ds.Put(metaKey(c), buf)
+}
+
+// Delete removes the record of the pin of c.
+func (s *Store) Delete(c cid.Cid) error {
+	err := s.ds.Delete(metaKey(c))
+	if err == ds.ErrNotFound {
+		return nil
+	}
+	return err
+}
+
+// List returns all the records.
+func (s *Store) List() (map[cid.Cid]Metadata, error) {
+	res, err := s.ds.Query(dsq.Query{Prefix: metaPrefix.String()})
+	if err != nil {
+		return nil, err
+	}
+	defer res.Close()
+
+	all := make(map[cid.Cid]Metadata)
+	for e := range res.Next() {
+		if e.Error != nil {
+			return nil, e.Error
+		}
+		c, err := dshelp.DsKeyToCid(ds.NewKey(ds.RawKey(e.Key).BaseNamespace()))
+		if err != nil {
+			log.Warnf("ignoring pin metadata with invalid key %s", e.Key)
+			continue
+		}
+		var m Metadata
+		if err := json.Unmarshal(e.Value, &m); err != nil {
+			log.Warnf("ignoring invalid pin metadata of %s: %s", c, err)
+			continue
+		}
+		all[c] = m
+	}
+	return all, nil
+}
+
+// Pin pins nd with pinner and records md about the pin. When nd was pinned
+// already, md is merged with what is recorded as described by Merge. Pins in
+// a namespace fail with a *QuotaExceededError when the namespace has no room
+// left for them. The caller holds the pin lock and flushes the pinner.
+func (s *Store) Pin(ctx context.Context, pinner pin.Pinner, nd ipld.Node, recursive bool, md Metadata) error {
+	prev, err := s.Get(nd.Cid())
+	if err != nil {
+		return err
+	}
+	pinned, err := PinnedExplicitly(ctx, pinner, nd.Cid())
+	if err != nil {
+		return err
+	}
+
+	m := Merge(prev, md, pinned)
+	defer s.LockNamespace(m.Namespace())()
+	var released uint64
+	if prev.Namespace() == m.Namespace() {
+		// pinned again, the previous size is replaced
+		released = prev.Size
+	}
+	if err := s.Charge(ctx, nd.Cid(), recursive, &m, released); err != nil {
+		return err
+	}
+
+	if err := pinner.Pin(ctx, nd, recursive); err != nil {
+		return err
+	}
+	return s.Put(nd.Cid(), m)
+}
+
+// PinnedExplicitly reports whether c is pinned directly or recursively.
+func PinnedExplicitly(ctx context.Context, pinner pin.Pinner, c cid.Cid) (bool, error) {
+	for _, mode := range []pin.Mode{pin.Recursive, pin.Direct} {
+		_, pinned, err := pinner.IsPinnedWithType(ctx, c, mode)
+		if err != nil || pinned {
+			return pinned, err
+		}
+	}
+	return false, nil
+}
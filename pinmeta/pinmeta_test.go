@@ -0,0 +1,379 @@
+package pinmeta
+
+import (
+	"context"
+	"fmt"
+	"testing"
+	"time"
+
+	bserv "github.com/ipfs/go-blockservice"
+	ds "github.com/ipfs/go-datastore"
+	dssync "github.com/ipfs/go-datastore/sync"
+	bstore "github.com/ipfs/go-ipfs-blockstore"
+	offline "github.com/ipfs/go-ipfs-exchange-offline"
+	pin "github.com/ipfs/go-ipfs-pinner"
+	dag "github.com/ipfs/go-merkledag"
+)
+
+func TestStore(t *testing.T) {
+	s := NewStore(dssync.MutexWrap(ds.NewMapDatastore()))
+	c := dag.NodeWithData([]byte("pinned")).Cid()
+
+	m, err := s.Get(c)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !m.IsZero() {
+		t.Fatalf("expected no metadata, got %+v", m)
+	}
+
+	expires := time.Unix(1000, 0).UTC()
+	if err := s.Put(c, Metadata{Expires: expires}); err != nil {
+		t.Fatal(err)
+	}
+	m, err = s.Get(c)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !m.Expires.Equal(expires) {
+		t.Fatalf("expected expiry %s, got %s", expires, m.Expires)
+	}
+	all, err := s.List()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(all) != 1 || !all[c].Expires.Equal(expires) {
+		t.Fatalf("unexpected records %v", all)
+	}
+
+	if err := s.Put(c, Metadata{}); err != nil {
+		t.Fatal(err)
+	}
+	all, err = s.List()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(all) != 0 {
+		t.Fatalf("zero metadata should remove the record, got %v", all)
+	}
+	if err := s.Delete(c); err != nil {
+		t.Fatal(err)
+	}
+}
+
+func TestRemoveExpired(t *testing.T) {
+	ctx := context.Background()
+
+	dstore := dssync.MutexWrap(ds.NewMapDatastore())
+	gcbs := bstore.NewGCBlockstore(bstore.NewBlockstore(dstore), bstore.NewGCLocker())
+	dserv := dag.NewDAGService(bserv.New(gcbs, offline.Exchange(gcbs)))
+	pinner := pin.NewPinner(dstore, dserv, dserv)
+	store := NewStore(dstore)
+
+	now := time.Now()
+	expired := dag.NodeWithData([]byte("expired"))
+	later := dag.NodeWithData([]byte("later"))
+	unpinned := dag.NodeWithData([]byte("unpinned"))
+	for _, nd := range []*dag.ProtoNode{expired, later, unpinned} {
+		if err := dserv.Add(ctx, nd); err != nil {
+			t.Fatal(err)
+		}
+	}
+	for _, nd := range []*dag.ProtoNode{expired, later} {
+		if err := pinner.Pin(ctx, nd, true); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if err := pinner.Flush(ctx); err != nil {
+		t.Fatal(err)
+	}
+	for nd, expires := range map[*dag.ProtoNode]time.Time{
+		expired:  now.Add(-time.Minute),
+		later:    now.Add(time.Hour),
+		unpinned: now.Add(-time.Minute),
+	} {
+		if err := store.Put(nd.Cid(), Metadata{Expires: expires}); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	removed, err := NewExpirer(store, pinner, gcbs).RemoveExpired(ctx, now)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(removed) != 1 || !removed[0].Equals(expired.Cid()) {
+		t.Fatalf("expected only %s to be unpinned, got %v", expired.Cid(), removed)
+	}
+	if _, pinned, _ := pinner.IsPinned(ctx, expired.Cid()); pinned {
+		t.Fatal("expired pin should have been removed")
+	}
+	if _, pinned, _ := pinner.IsPinned(ctx, later.Cid()); !pinned {
+		t.Fatal("pin which did not expire yet should be kept")
+	}
+
+	all, err := store.List()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(all) != 1 {
+		t.Fatalf("expected only the record of %s to be left, got %v", later.Cid(), all)
+	}
+	if _, ok := all[later.Cid()]; !ok {
+		t.Fatalf("record of %s should be kept", later.Cid())
+	}
+}
+
+// renewingLocker renews a pin when the pin lock is taken, like a 'pin add'
+// which held the lock while the expired pins were listed.
+type renewingLocker struct {
+	bstore.GCLocker
+	renew func()
+}
+
+func (l *renewingLocker) PinLock() bstore.Unlocker {
+	l.renew()
+	return l.GCLocker.PinLock()
+}
+
+func TestRemoveExpiredRenewed(t *testing.T) {
+	ctx := context.Background()
+
+	dstore := dssync.MutexWrap(ds.NewMapDatastore())
+	gcbs := bstore.NewGCBlockstore(bstore.NewBlockstore(dstore), bstore.NewGCLocker())
+	dserv := dag.NewDAGService(bserv.New(gcbs, offline.Exchange(gcbs)))
+	pinner := pin.NewPinner(dstore, dserv, dserv)
+	store := NewStore(dstore)
+
+	now := time.Now()
+	nd := dag.NodeWithData([]byte("renewed"))
+	if err := dserv.Add(ctx, nd); err != nil {
+		t.Fatal(err)
+	}
+	if err := pinner.Pin(ctx, nd, true); err != nil {
+		t.Fatal(err)
+	}
+	if err := store.Put(nd.Cid(), Metadata{Expires: now.Add(-time.Minute)}); err != nil {
+		t.Fatal(err)
+	}
+
+	locker := &renewingLocker{GCLocker: gcbs, renew: func() {
+		if err := store.Put(nd.Cid(), Metadata{Expires: now.Add(time.Hour)}); err != nil {
+			t.Error(err)
+		}
+	}}
+	removed, err := NewExpirer(store, pinner, locker).RemoveExpired(ctx, now)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(removed) != 0 {
+		t.Fatalf("renewed pin should be kept, got %v removed", removed)
+	}
+	if _, pinned, _ := pinner.IsPinned(ctx, nd.Cid()); !pinned {
+		t.Fatal("renewed pin should be kept")
+	}
+}
+
+func TestMerge(t *testing.T) {
+	soon := time.Unix(1000, 0)
+	later := time.Unix(2000, 0)
+	prev := Metadata{
+		Name:    "site",
+		Labels:  map[string]string{"team": "web", "env": "prod"},
+		Expires: later,
+	}
+
+	m := Merge(prev, Metadata{Labels: map[string]string{"env": "staging"}, Expires: soon}, true)
+	if m.Name != "site" {
+		t.Errorf("name should be kept, got %q", m.Name)
+	}
+	if len(m.Labels) != 2 || m.Labels["team"] != "web" || m.Labels["env"] != "staging" {
+		t.Errorf("labels should be merged, got %v", m.Labels)
+	}
+	if !m.Expires.Equal(later) {
+		t.Errorf("expiry should not be shortened, got %s", m.Expires)
+	}
+	if prev.Labels["env"] != "prod" {
+		t.Error("merging should not modify prev")
+	}
+
+	if m := Merge(prev, Metadata{Name: "blog"}, true); m.Name != "blog" || !m.Expires.IsZero() {
+		t.Errorf("expected a permanent pin named blog, got %+v", m)
+	}
+	if m := Merge(Metadata{}, Metadata{Expires: soon}, true); !m.Expires.IsZero() {
+		t.Errorf("permanent pins should stay permanent, got %s", m.Expires)
+	}
+	if m := Merge(Metadata{}, Metadata{Expires: soon}, false); !m.Expires.Equal(soon) {
+		t.Errorf("new pins should expire, got %s", m.Expires)
+	}
+}
+
+func TestFilter(t *testing.T) {
+	m := Metadata{Name: "site", Labels: map[string]string{"team": "web", "env": "prod"}}
+	for _, tc := range []struct {
+		filter  Filter
+		matches bool
+	}{
+		{Filter{}, true},
+		{Filter{Name: "site"}, true},
+		{Filter{Name: "blog"}, false},
+		{Filter{Labels: map[string]string{"team": "web"}}, true},
+		{Filter{Name: "site", Labels: map[string]string{"team": "web", "env": "prod"}}, true},
+		{Filter{Labels: map[string]string{"team": "web", "env": "dev"}}, false},
+		{Filter{Labels: map[string]string{"owner": ""}}, false},
+	} {
+		if tc.filter.Matches(m) != tc.matches {
+			t.Errorf("%+v: expected match to be %t", tc.filter, tc.matches)
+		}
+	}
+}
+
+func TestParseLabels(t *testing.T) {
+	labels, err := ParseLabels([]string{"team=web", "query=a=b", "empty="})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(labels) != 3 || labels["team"] != "web" || labels["query"] != "a=b" || labels["empty"] != "" {
+		t.Fatalf("unexpected labels %v", labels)
+	}
+	for _, kv := range []string{"team", "=web"} {
+		if _, err := ParseLabels([]string{kv}); err == nil {
+			t.Errorf("expected %q to be rejected", kv)
+		}
+	}
+}
+
+func TestQuotas(t *testing.T) {
+	ctx := context.Background()
+
+	dstore := dssync.MutexWrap(ds.NewMapDatastore())
+	bs := bstore.NewBlockstore(dstore)
+	dserv := dag.NewDAGService(bserv.New(bs, offline.Exchange(bs)))
+	pinner := pin.NewPinner(dstore, dserv, dserv)
+	store := NewStore(dstore)
+	limits := map[string]uint64{}
+	store.Quotas = &Quotas{
+		DAG: dserv,
+		Limits: func() (map[string]uint64, error) {
+			return limits, nil
+		},
+	}
+
+	leaf := dag.NodeWithData([]byte("leaf"))
+	root := dag.NodeWithData([]byte("root"))
+	if err := root.AddNodeLink("a", leaf); err != nil {
+		t.Fatal(err)
+	}
+	if err := root.AddNodeLink("b", leaf); err != nil {
+		t.Fatal(err)
+	}
+	other := dag.NodeWithData([]byte("other"))
+	for _, nd := range []*dag.ProtoNode{leaf, root, other} {
+		if err := dserv.Add(ctx, nd); err != nil {
+			t.Fatal(err)
+		}
+	}
+	rootSize := uint64(len(root.RawData()) + len(leaf.RawData()))
+	if size, err := DAGSize(ctx, dserv, root.Cid(), true); err != nil || size != rootSize {
+		t.Fatalf("expected the DAG to be %d bytes, got %d, %v", rootSize, size, err)
+	}
+
+	team := Metadata{Labels: map[string]string{NamespaceLabel: "team"}}
+	limits["team"] = rootSize + 1
+	if err := store.Pin(ctx, pinner, root, true, team); err != nil {
+		t.Fatal(err)
+	}
+	// Pinning again replaces the size of the pin.
+	if err := store.Pin(ctx, pinner, root, true, team); err != nil {
+		t.Fatal(err)
+	}
+	err := store.Pin(ctx, pinner, other, true, team)
+	if qerr, ok := err.(*QuotaExceededError); !ok || qerr.Used != rootSize || qerr.Namespace != "team" {
+		t.Fatalf("expected the quota of team to be exceeded, got %v", err)
+	}
+	if _, pinned, _ := pinner.IsPinned(ctx, other.Cid()); pinned {
+		t.Fatal("pins exceeding the quota should not be made")
+	}
+
+	// Namespaces without quota and pins outside of namespaces are not
+	// limited.
+	if err := store.Pin(ctx, pinner, other, true, Metadata{Labels: map[string]string{NamespaceLabel: "free"}}); err != nil {
+		t.Fatal(err)
+	}
+	if err := store.Pin(ctx, pinner, leaf, false, Metadata{Name: "leaf"}); err != nil {
+		t.Fatal(err)
+	}
+
+	usage, err := store.Usage()
+	if err != nil {
+		t.Fatal(err)
+	}
+	expected := map[string]Usage{
+		"team": {Pins: 1, Size: rootSize},
+		"free": {Pins: 1, Size: uint64(len(other.RawData()))},
+	}
+	if len(usage) != len(expected) {
+		t.Fatalf("expected %v, got %v", expected, usage)
+	}
+	for ns, u := range expected {
+		if usage[ns] != u {
+			t.Errorf("expected %s to use %+v, got %+v", ns, u, usage[ns])
+		}
+	}
+}
+
+func TestQuotasConcurrentPins(t *testing.T) {
+	ctx := context.Background()
+
+	dstore := dssync.MutexWrap(ds.NewMapDatastore())
+	bs := bstore.NewBlockstore(dstore)
+	dserv := dag.NewDAGService(bserv.New(bs, offline.Exchange(bs)))
+	pinner := pin.NewPinner(dstore, dserv, dserv)
+	store := NewStore(dstore)
+
+	var nodes []*dag.ProtoNode
+	for i := 0; i < 8; i++ {
+		nd := dag.NodeWithData([]byte(fmt.Sprintf("node %d", i)))
+		if err := dserv.Add(ctx, nd); err != nil {
+			t.Fatal(err)
+		}
+		nodes = append(nodes, nd)
+	}
+	// The namespace has room for a single node.
+	limit := uint64(len(nodes[0].RawData()))
+	store.Quotas = &Quotas{
+		DAG: dserv,
+		Limits: func() (map[string]uint64, error) {
+			return map[string]uint64{"team": limit}, nil
+		},
+	}
+
+	team := Metadata{Labels: map[string]string{NamespaceLabel: "team"}}
+	errs := make(chan error, len(nodes))
+	for _, nd := range nodes {
+		go func(nd *dag.ProtoNode) {
+			errs <- store.Pin(ctx, pinner, nd, true, team)
+		}(nd)
+	}
+	var pinned int
+	for range nodes {
+		err := <-errs
+		switch err.(type) {
+		case nil:
+			pinned++
+		case *QuotaExceededError:
+		default:
+			t.Fatal(err)
+		}
+	}
+	if pinned != 1 {
+		t.Fatalf("expected a single pin to fit in the quota, got %d", pinned)
+	}
+
+	usage, err := store.Usage()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if u := usage["team"]; u.Pins != 1 || u.Size > limit {
+		t.Fatalf("expected a single pin in team, got %+v", u)
+	}
+}
@@ -0,0 +1,167 @@
+package pinmeta
+
+import (
+	"context"
+	"fmt"
+	"sync"
+	"sync/atomic"
+
+	humanize "github.com/dustin/go-humanize"
+	cid "github.com/ipfs/go-cid"
+	ipld "github.com/ipfs/go-ipld-format"
+	dag "github.com/ipfs/go-merkledag"
+)
+
+// NamespaceLabel is the label telling which namespace a pin belongs to.
+// Several teams sharing a node pin in their own namespace, and the size of
+// the pins of each namespace can be limited.
+const NamespaceLabel = "namespace"
+
+// Namespace returns the namespace of the pin described by m, empty for pins
+// outside of namespaces.
+func (m Metadata) Namespace() string {
+	return m.Labels[NamespaceLabel]
+}
+
+// Quotas limits the number of DAG bytes pinned in namespaces.
+type Quotas struct {
+	// DAG fetches the pinned content to measure it.
+	DAG ipld.NodeGetter
+	// Limits returns the maximum size of the pins of each namespace.
+	// Namespaces without a limit are accounted but not limited.
+	Limits func() (map[string]uint64, error)
+}
+
+// QuotaExceededError is returned when a pin doesn't fit in the quota of its
+// namespace.
+type QuotaExceededError struct {
+	Namespace string
+	// Limit is the quota of the namespace.
+	Limit uint64
+	// Used is the size of the pins of the namespace.
+	Used uint64
+	// Size is the size of the pin.
+	Size uint64
+}
+
+func (e *QuotaExceededError) Error() string {
+	return fmt.Sprintf("quota exceeded for namespace %q: pinning %s would use %s of %s",
+		e.Namespace, humanize.Bytes(e.Size), humanize.Bytes(e.Used+e.Size), humanize.Bytes(e.Limit))
+}
+
+// Usage is what the pins of a namespace use.
+type Usage struct {
+	Pins int
+	Size uint64
+}
+
+// Usage returns the usage of every namespace with pins.
+func (s *Store) Usage() (map[string]Usage, error) {
+	all, err := s.List()
+	if err != nil {
+		return nil, err
+	}
+	usage := make(map[string]Usage)
+	for _, m := range all {
+		ns := m.Namespace()
+		if ns == "" {
+			continue
+		}
+		u := usage[ns]
+		u.Pins++
+		u.Size += m.Size
+		usage[ns] = u
+	}
+	return usage, nil
+}
+
+// LockNamespace serializes the pins of the namespace ns, so that concurrent
+// pins can't all fit in the room left for one of them. It returns the
+// function releasing the lock. Pins outside of namespaces aren't locked.
+func (s *Store) LockNamespace(ns string) func() {
+	if ns == "" {
+		return func() {}
+	}
+	s.nsLock.Lock()
+	if s.nsLocks == nil {
+		s.nsLocks = make(map[string]*sync.Mutex)
+	}
+	l, ok := s.nsLocks[ns]
+	if !ok {
+		l = new(sync.Mutex)
+		s.nsLocks[ns] = l
+	}
+	s.nsLock.Unlock()
+
+	l.Lock()
+	return l.Unlock
+}
+
+// Charge measures the pin of c described by md when it belongs to a
+// namespace, recording its size in md, and checks that the namespace has
+// room for it. released is the size the namespace frees by the same change,
+// like the previous size of a pin which is pinned again. The caller holds
+// the lock of the namespace, see LockNamespace, until the pin is recorded.
+func (s *Store) Charge(ctx context.Context, c cid.Cid, recursive bool, md *Metadata, released uint64) error {
+	md.Size = 0
+	ns := md.Namespace()
+	if s.Quotas == nil || ns == "" {
+		return nil
+	}
+
+	size, err := DAGSize(ctx, s.Quotas.DAG, c, recursive)
+	if err != nil {
+		return err
+	}
+	md.Size = size
+
+	limits, err := s.Quotas.Limits()
+	if err != nil {
+		return err
+	}
+	limit, ok := limits[ns]
+	if !ok {
+		return nil
+	}
+	usage, err := s.Usage()
+	if err != nil {
+		return err
+	}
+	used := usage[ns].Size
+	if released > used {
+		released = used
+	}
+	used -= released
+	if used+size > limit {
+		return &QuotaExceededError{Namespace: ns, Limit: limit, Used: used, Size: size}
+	}
+	return nil
+}
+
+// DAGSize returns the number of bytes of the blocks of the DAG of c, or of c
+// alone when recursive is false, fetching the missing blocks with ng. Blocks
+// linked several times are counted once.
+func DAGSize(ctx context.Context, ng ipld.NodeGetter, c cid.Cid, recursive bool) (uint64, error) {
+	if !recursive {
+		nd, err := ng.Get(ctx, c)
+		if err != nil {
+			return 0, err
+		}
+		return uint64(len(nd.RawData())), nil
+	}
+
+	var size uint64
+	getLinks := func(ctx context.Context, c cid.Cid) ([]*ipld.Link, error) {
+		nd, err := ng.Get(ctx, c)
+		if err != nil {
+			return nil, err
+		}
+		atomic.AddUint64(&size, uint64(len(nd.RawData())))
+		return nd.Links(), nil
+	}
+	set := cid.NewSet()
+	if err := dag.Walk(ctx, getLinks, c, set.Visit, dag.Concurrent()); err != nil {
+		return 0, err
+	}
+	return size, nil
+}
@@ -0,0 +1,621 @@
+// Package pinqueue pins content in the background. The queue of pin requests
+// is persisted in the datastore, so that requests survive restarts.
+//
+// The queue is shared by the subsystems pinning in the background: pin add
+// --background queues requests directly, while the linker queues the hashes
+// of its peers through a Handler applying its admission control.
+package pinqueue
+
+import (
+	"container/heap"
+	"context"
+	"encoding/json"
+	"errors"
+	"sort"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	cid "github.com/ipfs/go-cid"
+	ds "github.com/ipfs/go-datastore"
+	dsq "github.com/ipfs/go-datastore/query"
+	bstore "github.com/ipfs/go-ipfs-blockstore"
+	dshelp "github.com/ipfs/go-ipfs-ds-help"
+	pin "github.com/ipfs/go-ipfs-pinner"
+	provider "github.com/ipfs/go-ipfs-provider"
+	ipld "github.com/ipfs/go-ipld-format"
+	logging "github.com/ipfs/go-log"
+	dag "github.com/ipfs/go-merkledag"
+
+	"github.com/ipfs/go-ipfs/pinmeta"
+)
+
+var log = logging.Logger("pinqueue")
+
+// States of pin requests.
+const (
+	Queued   = "queued"
+	Fetching = "fetching"
+	Pinned   = "pinned"
+	Failed   = "failed"
+)
+
+// DefaultWorkers is the number of requests processed at the same time.
+const DefaultWorkers = 2
+
+// DefaultRetention is how long pinned and failed requests are kept.
+const DefaultRetention = 24 * time.Hour
+
+// ErrNotQueued is returned for content which was not queued.
+var ErrNotQueued = errors.New("not in the pin queue")
+
+// ErrStalled is returned when no node of the content of a request was
+// fetched for the stall timeout of the request.
+var ErrStalled = errors.New("pin request stalled")
+
+// queuePrefix is the datastore prefix of the pin requests.
+var queuePrefix = ds.NewKey("/local/pins/queue")
+
+// Entry is a pin request.
+type Entry struct {
+	Cid       cid.Cid
+	Recursive bool
+	Metadata  pinmeta.Metadata
+	State     string
+	// Priority orders the requests, the highest first.
+	Priority int64 `json:",omitempty"`
+	// Handler is the name of the Handler controlling the request, empty for
+	// requests pinned as they are.
+	Handler string `json:",omitempty"`
+	// Stall fails the request when no node was fetched for that long, 0
+	// never does.
+	Stall time.Duration `json:",omitempty"`
+	// Fetched is the number of nodes of the DAG fetched so far.
+	Fetched int
+	Error   string `json:",omitempty"`
+	Queued  time.Time
+	Updated time.Time
+}
+
+// Request describes a pin request queued with Enqueue.
+type Request struct {
+	Recursive bool
+	Metadata  pinmeta.Metadata
+	Priority  int64
+	Handler   string
+	Stall     time.Duration
+}
+
+// Handler lets the subsystem which queued a request control how it is
+// processed.
+type Handler interface {
+	// Admit is called before the content of e is fetched, and fails the
+	// request when it returns an error. It may fetch the content itself,
+	// calling progress for every node fetched.
+	Admit(ctx context.Context, e Entry, progress func()) error
+	// Done is called once the request e was pinned, or failed with err.
+	Done(e Entry, err error)
+}
+
+// Queue pins the requested content in the background, in the order of the
+// requests.
+type Queue struct {
+	// Workers is the number of requests processed at the same time.
+	Workers int
+	// Retention is how long pinned and failed requests are kept.
+	Retention time.Duration
+
+	ds       ds.Datastore
+	dag      ipld.DAGService
+	pinner   pin.Pinner
+	locker   bstore.GCLocker
+	meta     *pinmeta.Store
+	provider provider.System
+
+	lock    sync.Mutex
+	seq     uint64
+	pending pendingHeap
+	queued  map[cid.Cid]*pendingItem
+	// parked holds the requests waiting for their handler to be registered.
+	parked   map[string][]*pendingItem
+	handlers map[string]Handler
+	// active holds the progress of the requests being fetched.
+	active map[cid.Cid]*dag.ProgressTracker
+	wake   chan struct{}
+
+	ctx    context.Context
+	cancel context.CancelFunc
+	wg     sync.WaitGroup
+}
+
+// New returns a Queue keeping its requests in d, which fetches content with
+// dserv and pins it with pinner, recording the metadata of the pins in meta.
+func New(d ds.Datastore, dserv ipld.DAGService, pinner pin.Pinner, locker bstore.GCLocker, meta *pinmeta.Store, prov provider.System) *Queue {
+	return &Queue{
+		Workers:   DefaultWorkers,
+		Retention: DefaultRetention,
+		ds:        d,
+		dag:       dserv,
+		pinner:    pinner,
+		locker:    locker,
+		meta:      meta,
+		provider:  prov,
+		queued:    make(map[cid.Cid]*pendingItem),
+		parked:    make(map[string][]*pendingItem),
+		handlers:  make(map[string]Handler),
+		active:    make(map[cid.Cid]*dag.ProgressTracker),
+		wake:      make(chan struct{}, 1),
+	}
+}
+
+// Handle registers the handler of the requests queued with the given
+// handler name. Such requests wait in the queue until it is registered.
+func (q *Queue) Handle(name string, h Handler) {
+	q.lock.Lock()
+	q.handlers[name] = h
+	for _, item := range q.parked[name] {
+		heap.Push(&q.pending, item)
+	}
+	delete(q.parked, name)
+	q.lock.Unlock()
+	q.signal()
+}
+
+// signal wakes up a worker.
+func (q *Queue) signal() {
+	select {
+	case q.wake <- struct{}{}:
+	default:
+	}
+}
+
+func queueKey(c cid.Cid) ds.Key {
+	return queuePrefix.Child(dshelp.CidToDsKey(c))
+}
+
+func (q *Queue) get(c cid.Cid) (Entry, error) {
+	var e Entry
+	buf, err := q.ds.Get(queueKey(c))
+	switch err {
+	case nil:
+	case ds.ErrNotFound:
+		return e, ErrNotQueued
+	default:
+		return e, err
+	}
+	err = json.Unmarshal(buf, &e)
+	return e, err
+}
+
+func (q *Queue) put(e Entry) error {
+	e.Updated = time.Now()
+	buf, err := json.Marshal(e)
+	if err != nil {
+		return err
+	}
+	return q.ds.Put(queueKey(e.Cid), buf)
+}
+
+// Add queues the pin of c. Requests for content which is already queued are
+// updated with the given metadata, and pin recursively when either request
+// does.
+func (q *Queue) Add(c cid.Cid, recursive bool, md pinmeta.Metadata) (Entry, error) {
+	return q.Enqueue(c, Request{Recursive: recursive, Metadata: md})
+}
+
+// Enqueue queues the pin of c. Requests for content which is already queued
+// are merged with the given metadata, keeping the labels they have, pin
+// recursively when either request does and keep the highest priority. The
+// metadata of requests of another handler is ignored.
+func (q *Queue) Enqueue(c cid.Cid, r Request) (Entry, error) {
+	q.lock.Lock()
+	defer q.lock.Unlock()
+
+	e, err := q.get(c)
+	switch {
+	case err == nil && (e.State == Queued || e.State == Fetching):
+		e.Recursive = e.Recursive || r.Recursive
+		if r.Handler == "" || r.Handler == e.Handler {
+			e.Metadata = mergeRequestMetadata(e.Metadata, r.Metadata)
+		}
+		if r.Priority > e.Priority {
+			e.Priority = r.Priority
+			if item, ok := q.queued[c]; ok {
+				item.priority = r.Priority
+				if item.index >= 0 {
+					heap.Fix(&q.pending, item.index)
+				}
+			}
+		}
+		return e, q.put(e)
+	case err != nil && err != ErrNotQueued:
+		return e, err
+	}
+
+	e = Entry{
+		Cid:       c,
+		Recursive: r.Recursive,
+		Metadata:  r.Metadata,
+		State:     Queued,
+		Priority:  r.Priority,
+		Handler:   r.Handler,
+		Stall:     r.Stall,
+		Queued:    time.Now(),
+	}
+	if err := q.put(e); err != nil {
+		return e, err
+	}
+	q.push(e)
+	q.signal()
+	return e, nil
+}
+
+// mergeRequestMetadata merges the metadata md of a request for content which
+// is queued already with the metadata prev of the queued request. Labels
+// prev has are kept, as the handler of the request may rely on them, and
+// pins without expiry stay so.
+func mergeRequestMetadata(prev, md pinmeta.Metadata) pinmeta.Metadata {
+	m := pinmeta.Merge(prev, md, true)
+	for k, v := range prev.Labels {
+		m.Labels[k] = v
+	}
+	return m
+}
+
+// push adds the request e to the pending requests.
+func (q *Queue) push(e Entry) {
+	if _, ok := q.queued[e.Cid]; ok {
+		return
+	}
+	q.seq++
+	item := &pendingItem{cid: e.Cid, priority: e.Priority, seq: q.seq, handler: e.Handler, index: -1}
+	q.queued[e.Cid] = item
+	heap.Push(&q.pending, item)
+}
+
+// Status returns the request to pin c.
+func (q *Queue) Status(c cid.Cid) (Entry, error) {
+	q.lock.Lock()
+	defer q.lock.Unlock()
+	e, err := q.get(c)
+	if err != nil {
+		return e, err
+	}
+	if tracker, ok := q.active[c]; ok {
+		e.Fetched = tracker.Value()
+	}
+	return e, nil
+}
+
+// List returns all the requests, oldest first.
+func (q *Queue) List() ([]Entry, error) {
+	q.lock.Lock()
+	defer q.lock.Unlock()
+	entries, err := q.list()
+	if err != nil {
+		return nil, err
+	}
+	for i, e := range entries {
+		if tracker, ok := q.active[e.Cid]; ok {
+			entries[i].Fetched = tracker.Value()
+		}
+	}
+	return entries, nil
+}
+
+func (q *Queue) list() ([]Entry, error) {
+	res, err := q.ds.Query(dsq.Query{Prefix: queuePrefix.String()})
+	if err != nil {
+		return nil, err
+	}
+	defer res.Close()
+
+	var entries []Entry
+	for r := range res.Next() {
+		if r.Error != nil {
+			return nil, r.Error
+		}
+		var e Entry
+		if err := json.Unmarshal(r.Value, &e); err != nil {
+			log.Warnf("ignoring invalid pin request %s: %s", r.Key, err)
+			continue
+		}
+		entries = append(entries, e)
+	}
+	sort.Slice(entries, func(i, j int) bool {
+		return entries[i].Queued.Before(entries[j].Queued)
+	})
+	return entries, nil
+}
+
+// prune drops the pinned and failed requests older than the retention.
+func (q *Queue) prune() error {
+	q.lock.Lock()
+	defer q.lock.Unlock()
+	entries, err := q.list()
+	if err != nil {
+		return err
+	}
+	for _, e := range entries {
+		if (e.State == Pinned || e.State == Failed) && time.Since(e.Updated) > q.Retention {
+			if err := q.ds.Delete(queueKey(e.Cid)); err != nil {
+				return err
+			}
+		}
+	}
+	return nil
+}
+
+// Start resumes the requests which were queued or being fetched when the
+// queue was closed, and processes requests until Close is called.
+func (q *Queue) Start() error {
+	if err := q.prune(); err != nil {
+		return err
+	}
+	q.lock.Lock()
+	entries, err := q.list()
+	if err != nil {
+		q.lock.Unlock()
+		return err
+	}
+	for _, e := range entries {
+		if e.State == Queued || e.State == Fetching {
+			q.push(e)
+		}
+	}
+	q.lock.Unlock()
+
+	q.ctx, q.cancel = context.WithCancel(context.Background())
+	for i := 0; i < q.Workers; i++ {
+		q.wg.Add(1)
+		go q.work()
+	}
+	return nil
+}
+
+// Close stops processing requests. The requests being fetched are resumed by
+// the next Start.
+func (q *Queue) Close() error {
+	if q.cancel != nil {
+		q.cancel()
+		q.wg.Wait()
+	}
+	return nil
+}
+
+// next returns the pending request with the highest priority, and marks it
+// active. Requests whose handler isn't registered are parked.
+func (q *Queue) next() (cid.Cid, bool) {
+	q.lock.Lock()
+	defer q.lock.Unlock()
+	for q.pending.Len() > 0 {
+		item := heap.Pop(&q.pending).(*pendingItem)
+		if _, ok := q.handlers[item.handler]; item.handler != "" && !ok {
+			q.parked[item.handler] = append(q.parked[item.handler], item)
+			continue
+		}
+		delete(q.queued, item.cid)
+		q.active[item.cid] = new(dag.ProgressTracker)
+		return item.cid, true
+	}
+	return cid.Undef, false
+}
+
+func (q *Queue) work() {
+	defer q.wg.Done()
+	for {
+		c, ok := q.next()
+		if !ok {
+			select {
+			case <-q.wake:
+				continue
+			case <-q.ctx.Done():
+				return
+			}
+		}
+		// Let the other workers know there may be more.
+		q.signal()
+
+		if err := q.process(c); err != nil {
+			log.Errorf("failed to update the pin request of %s: %s", c, err)
+		}
+		if q.ctx.Err() != nil {
+			return
+		}
+		if err := q.prune(); err != nil {
+			log.Errorf("failed to drop old pin requests: %s", err)
+		}
+	}
+}
+
+// update applies f to the request to pin c and persists it.
+func (q *Queue) update(c cid.Cid, f func(e *Entry)) (Entry, error) {
+	q.lock.Lock()
+	defer q.lock.Unlock()
+	e, err := q.get(c)
+	if err != nil {
+		return e, err
+	}
+	f(&e)
+	if tracker, ok := q.active[c]; ok {
+		e.Fetched = tracker.Value()
+	}
+	return e, q.put(e)
+}
+
+func (q *Queue) process(c cid.Cid) error {
+	q.lock.Lock()
+	tracker := q.active[c]
+	q.lock.Unlock()
+	defer func() {
+		q.lock.Lock()
+		delete(q.active, c)
+		q.lock.Unlock()
+	}()
+
+	e, err := q.update(c, func(e *Entry) {
+		e.State = Fetching
+		e.Error = ""
+	})
+	if err != nil {
+		return err
+	}
+	q.lock.Lock()
+	h := q.handlers[e.Handler]
+	q.lock.Unlock()
+
+	ctx, cancel := context.WithCancel(q.ctx)
+	defer cancel()
+	var stalled int32
+	if e.Stall > 0 {
+		go watch(ctx, tracker, e.Stall, func() {
+			log.Warnf("pin request of %s stalled for %s", c, e.Stall)
+			atomic.StoreInt32(&stalled, 1)
+			cancel()
+		})
+	}
+
+	ctx = tracker.DeriveContext(ctx)
+	if h != nil {
+		err = h.Admit(ctx, e, tracker.Increment)
+	}
+	if err == nil {
+		err = q.pin(ctx, c, e.Recursive)
+	}
+	if q.ctx.Err() != nil {
+		// Closing, the request is resumed by the next Start.
+		return nil
+	}
+	if err != nil && atomic.LoadInt32(&stalled) == 1 {
+		err = ErrStalled
+	}
+	e, uerr := q.update(c, func(e *Entry) {
+		if err != nil {
+			e.State = Failed
+			e.Error = err.Error()
+			return
+		}
+		e.State = Pinned
+	})
+	if err != nil {
+		log.Warnf("failed to pin %s: %s", c, err)
+	}
+	if h != nil {
+		h.Done(e, err)
+	}
+	return uerr
+}
+
+// watch calls stalled once tracker didn't move for stall, unless ctx is done
+// before.
+func watch(ctx context.Context, tracker *dag.ProgressTracker, stall time.Duration, stalled func()) {
+	ticker := time.NewTicker(stall / 10)
+	defer ticker.Stop()
+	last, since := tracker.Value(), time.Now()
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case now := <-ticker.C:
+			if current := tracker.Value(); current != last {
+				last, since = current, now
+				continue
+			}
+			if now.Sub(since) >= stall {
+				stalled()
+				return
+			}
+		}
+	}
+}
+
+func (q *Queue) pin(ctx context.Context, c cid.Cid, recursive bool) error {
+	// Fetch without holding the pin lock, so that the garbage collection
+	// isn't blocked for the whole fetch.
+	nd, err := q.dag.Get(ctx, c)
+	if err != nil {
+		return err
+	}
+	if recursive {
+		if err := dag.FetchGraph(ctx, c, q.dag); err != nil {
+			return err
+		}
+	}
+
+	defer q.locker.PinLock().Unlock()
+
+	// The metadata may have been updated while fetching.
+	q.lock.Lock()
+	e, err := q.get(c)
+	q.lock.Unlock()
+	if err != nil {
+		return err
+	}
+	// The content is local now, don't count it as fetched again.
+	ctx = q.ctx
+	pinned := false
+	if e.Handler != "" {
+		// Handlers pin on behalf of others, content pinned already keeps
+		// its metadata.
+		pinned, err = pinmeta.PinnedExplicitly(ctx, q.pinner, c)
+		if err != nil {
+			return err
+		}
+	}
+	if pinned {
+		err = q.pinner.Pin(ctx, nd, e.Recursive)
+	} else {
+		err = q.meta.Pin(ctx, q.pinner, nd, e.Recursive, e.Metadata)
+	}
+	if err != nil {
+		return err
+	}
+	if err := q.pinner.Flush(ctx); err != nil {
+		return err
+	}
+	return q.provider.Provide(c)
+}
+
+// pendingItem is a request waiting to be processed.
+type pendingItem struct {
+	cid      cid.Cid
+	priority int64
+	seq      uint64
+	handler  string
+	index    int
+}
+
+// pendingHeap orders the pending requests by priority, then in the order
+// they were queued.
+type pendingHeap []*pendingItem
+
+func (h pendingHeap) Len() int { return len(h) }
+
+func (h pendingHeap) Less(i, j int) bool {
+	if h[i].priority != h[j].priority {
+		return h[i].priority > h[j].priority
+	}
+	return h[i].seq < h[j].seq
+}
+
+func (h pendingHeap) Swap(i, j int) {
+	h[i], h[j] = h[j], h[i]
+	h[i].index = i
+	h[j].index = j
+}
+
+func (h *pendingHeap) Push(x interface{}) {
+	item := x.(*pendingItem)
+	item.index = len(*h)
+	*h = append(*h, item)
+}
+
+func (h *pendingHeap) Pop() interface{} {
+	old := *h
+	n := len(old)
+	item := old[n-1]
+	old[n-1] = nil
+	item.index = -1
+	*h = old[:n-1]
+	return item
+}
@@ -0,0 +1,362 @@
+package pinqueue
+
+import (
+	"context"
+	"errors"
+	"testing"
+	"time"
+
+	bserv "github.com/ipfs/go-blockservice"
+	cid "github.com/ipfs/go-cid"
+	ds "github.com/ipfs/go-datastore"
+	dssync "github.com/ipfs/go-datastore/sync"
+	bstore "github.com/ipfs/go-ipfs-blockstore"
+	offline "github.com/ipfs/go-ipfs-exchange-offline"
+	pin "github.com/ipfs/go-ipfs-pinner"
+	provider "github.com/ipfs/go-ipfs-provider"
+	ipld "github.com/ipfs/go-ipld-format"
+	dag "github.com/ipfs/go-merkledag"
+
+	"github.com/ipfs/go-ipfs/pinmeta"
+)
+
+type testNode struct {
+	dstore ds.Datastore
+	dserv  ipld.DAGService
+	pinner pin.Pinner
+	locker bstore.GCLocker
+	meta   *pinmeta.Store
+}
+
+func newTestNode() *testNode {
+	dstore := dssync.MutexWrap(ds.NewMapDatastore())
+	gcbs := bstore.NewGCBlockstore(bstore.NewBlockstore(dstore), bstore.NewGCLocker())
+	dserv := dag.NewDAGService(bserv.New(gcbs, offline.Exchange(gcbs)))
+	return &testNode{
+		dstore: dstore,
+		dserv:  dserv,
+		pinner: pin.NewPinner(dstore, dserv, dserv),
+		locker: gcbs,
+		meta:   pinmeta.NewStore(dstore),
+	}
+}
+
+func (n *testNode) queue() *Queue {
+	return New(n.dstore, n.dserv, n.pinner, n.locker, n.meta, provider.NewOfflineProvider())
+}
+
+// waitDone waits until the request to pin c is pinned or failed.
+func waitDone(t *testing.T, q *Queue, c cid.Cid) Entry {
+	t.Helper()
+	deadline := time.Now().Add(5 * time.Second)
+	for time.Now().Before(deadline) {
+		e, err := q.Status(c)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if e.State == Pinned || e.State == Failed {
+			return e
+		}
+		time.Sleep(10 * time.Millisecond)
+	}
+	t.Fatalf("%s was not processed", c)
+	return Entry{}
+}
+
+func TestQueue(t *testing.T) {
+	ctx := context.Background()
+	n := newTestNode()
+
+	child := dag.NodeWithData([]byte("child"))
+	root := dag.NodeWithData([]byte("root"))
+	if err := root.AddNodeLink("child", child); err != nil {
+		t.Fatal(err)
+	}
+	for _, nd := range []*dag.ProtoNode{child, root} {
+		if err := n.dserv.Add(ctx, nd); err != nil {
+			t.Fatal(err)
+		}
+	}
+	missing := dag.NodeWithData([]byte("missing"))
+
+	// Requests queued while no queue runs are processed on start.
+	if _, err := n.queue().Add(root.Cid(), true, pinmeta.Metadata{Name: "site"}); err != nil {
+		t.Fatal(err)
+	}
+
+	q := n.queue()
+	if e, err := q.Status(root.Cid()); err != nil || e.State != Queued {
+		t.Fatalf("expected %s to be queued, got %+v, %v", root.Cid(), e, err)
+	}
+	if _, err := q.Status(child.Cid()); err != ErrNotQueued {
+		t.Fatalf("expected %s, got %v", ErrNotQueued, err)
+	}
+	if err := q.Start(); err != nil {
+		t.Fatal(err)
+	}
+	defer q.Close()
+	if _, err := q.Add(missing.Cid(), true, pinmeta.Metadata{}); err != nil {
+		t.Fatal(err)
+	}
+
+	e := waitDone(t, q, root.Cid())
+	if e.State != Pinned || e.Fetched != 2 {
+		t.Fatalf("expected %s to be pinned after fetching 2 nodes, got %+v", root.Cid(), e)
+	}
+	if _, pinned, _ := n.pinner.IsPinnedWithType(ctx, root.Cid(), pin.Recursive); !pinned {
+		t.Fatal("root should be pinned recursively")
+	}
+	md, err := n.meta.Get(root.Cid())
+	if err != nil {
+		t.Fatal(err)
+	}
+	if md.Name != "site" {
+		t.Fatalf("expected the pin to be named site, got %q", md.Name)
+	}
+
+	if e := waitDone(t, q, missing.Cid()); e.State != Failed || e.Error == "" {
+		t.Fatalf("expected %s to fail, got %+v", missing.Cid(), e)
+	}
+
+	entries, err := q.List()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(entries) != 2 || !entries[0].Cid.Equals(root.Cid()) {
+		t.Fatalf("expected both requests, oldest first, got %v", entries)
+	}
+}
+
+func TestQueuePrune(t *testing.T) {
+	ctx := context.Background()
+	n := newTestNode()
+
+	nd := dag.NodeWithData([]byte("pinned"))
+	if err := n.dserv.Add(ctx, nd); err != nil {
+		t.Fatal(err)
+	}
+
+	q := n.queue()
+	q.Retention = time.Millisecond
+	if err := q.Start(); err != nil {
+		t.Fatal(err)
+	}
+	defer q.Close()
+	if _, err := q.Add(nd.Cid(), false, pinmeta.Metadata{}); err != nil {
+		t.Fatal(err)
+	}
+
+	if e := waitDone(t, q, nd.Cid()); e.State != Pinned {
+		t.Fatalf("expected %s to be pinned, got %+v", nd.Cid(), e)
+	}
+	time.Sleep(2 * q.Retention)
+	if err := q.prune(); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := q.Status(nd.Cid()); err != ErrNotQueued {
+		t.Fatalf("processed request should have been dropped, got %v", err)
+	}
+	if _, pinned, _ := n.pinner.IsPinnedWithType(ctx, nd.Cid(), pin.Direct); !pinned {
+		t.Fatal("node should be pinned directly")
+	}
+}
+
+func TestQueuePriority(t *testing.T) {
+	n := newTestNode()
+	q := n.queue()
+
+	a := dag.NodeWithData([]byte("a")).Cid()
+	b := dag.NodeWithData([]byte("b")).Cid()
+	c := dag.NodeWithData([]byte("c")).Cid()
+	for _, r := range []struct {
+		c        cid.Cid
+		priority int64
+	}{{a, 0}, {b, 5}, {c, 5}, {a, 10}, {b, 1}} {
+		if _, err := q.Enqueue(r.c, Request{Priority: r.priority}); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if q.pending.Len() != 3 {
+		t.Fatalf("requests queued again should not be added, got %d pending", q.pending.Len())
+	}
+	if e, _ := q.Status(b); e.Priority != 5 {
+		t.Fatalf("the priority should not be lowered, got %d", e.Priority)
+	}
+	for _, expected := range []cid.Cid{a, b, c} {
+		got, ok := q.next()
+		if !ok || !got.Equals(expected) {
+			t.Fatalf("expected %s, got %s", expected, got)
+		}
+	}
+	if _, ok := q.next(); ok {
+		t.Fatal("the queue should be empty")
+	}
+}
+
+type testHandler struct {
+	admit func(ctx context.Context, e Entry, progress func()) error
+	done  chan error
+}
+
+func (h *testHandler) Admit(ctx context.Context, e Entry, progress func()) error {
+	return h.admit(ctx, e, progress)
+}
+
+func (h *testHandler) Done(e Entry, err error) {
+	h.done <- err
+}
+
+func TestQueueHandler(t *testing.T) {
+	ctx := context.Background()
+	n := newTestNode()
+
+	accepted := dag.NodeWithData([]byte("accepted"))
+	rejected := dag.NodeWithData([]byte("rejected"))
+	stalled := dag.NodeWithData([]byte("stalled"))
+	for _, nd := range []*dag.ProtoNode{accepted, rejected, stalled} {
+		if err := n.dserv.Add(ctx, nd); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	q := n.queue()
+	if err := q.Start(); err != nil {
+		t.Fatal(err)
+	}
+	defer q.Close()
+
+	errRejected := errors.New("rejected")
+	h := &testHandler{
+		admit: func(ctx context.Context, e Entry, progress func()) error {
+			switch {
+			case e.Cid.Equals(rejected.Cid()):
+				return errRejected
+			case e.Cid.Equals(stalled.Cid()):
+				<-ctx.Done()
+				return ctx.Err()
+			}
+			progress()
+			return nil
+		},
+		done: make(chan error, 3),
+	}
+	for _, nd := range []*dag.ProtoNode{accepted, rejected} {
+		if _, err := q.Enqueue(nd.Cid(), Request{Handler: "test"}); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if _, err := q.Enqueue(stalled.Cid(), Request{Handler: "test", Stall: 50 * time.Millisecond}); err != nil {
+		t.Fatal(err)
+	}
+
+	// Requests wait for their handler.
+	time.Sleep(50 * time.Millisecond)
+	if e, _ := q.Status(accepted.Cid()); e.State != Queued {
+		t.Fatalf("expected the request to wait for its handler, got %+v", e)
+	}
+	q.Handle("test", h)
+
+	if e := waitDone(t, q, accepted.Cid()); e.State != Pinned {
+		t.Fatalf("expected %s to be pinned, got %+v", accepted.Cid(), e)
+	}
+	if e := waitDone(t, q, rejected.Cid()); e.State != Failed || e.Error != errRejected.Error() {
+		t.Fatalf("expected %s to be rejected, got %+v", rejected.Cid(), e)
+	}
+	if e := waitDone(t, q, stalled.Cid()); e.State != Failed || e.Error != ErrStalled.Error() {
+		t.Fatalf("expected %s to stall, got %+v", stalled.Cid(), e)
+	}
+	if _, pinned, _ := n.pinner.IsPinned(ctx, rejected.Cid()); pinned {
+		t.Fatal("rejected content should not be pinned")
+	}
+	for i := 0; i < 3; i++ {
+		select {
+		case <-h.done:
+		case <-time.After(5 * time.Second):
+			t.Fatal("the handler was not told about every request")
+		}
+	}
+}
+
+func TestQueueMergeRequests(t *testing.T) {
+	ctx := context.Background()
+	n := newTestNode()
+
+	shared := dag.NodeWithData([]byte("shared"))
+	mine := dag.NodeWithData([]byte("mine"))
+	pinned := dag.NodeWithData([]byte("pinned"))
+	for _, nd := range []*dag.ProtoNode{shared, mine, pinned} {
+		if err := n.dserv.Add(ctx, nd); err != nil {
+			t.Fatal(err)
+		}
+	}
+	user := pinmeta.Metadata{Name: "mine", Labels: map[string]string{pinmeta.NamespaceLabel: "team"}}
+	if err := n.meta.Pin(ctx, n.pinner, pinned, true, user); err != nil {
+		t.Fatal(err)
+	}
+
+	q := n.queue()
+	handler := Request{
+		Recursive: true,
+		Metadata:  pinmeta.Metadata{Labels: map[string]string{"peer": "a"}},
+		Handler:   "test",
+	}
+	other := handler
+	other.Metadata = pinmeta.Metadata{Labels: map[string]string{"peer": "b"}}
+
+	// A user request for content queued by a handler keeps its labels.
+	if _, err := q.Enqueue(shared.Cid(), handler); err != nil {
+		t.Fatal(err)
+	}
+	e, err := q.Enqueue(shared.Cid(), Request{Recursive: true, Metadata: user})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if e.Handler != "test" || e.Metadata.Name != "mine" || e.Metadata.Labels["peer"] != "a" || e.Metadata.Namespace() != "team" {
+		t.Fatalf("expected the requests to be merged, got %+v", e)
+	}
+	if e, err = q.Enqueue(shared.Cid(), other); err != nil {
+		t.Fatal(err)
+	}
+	if e.Metadata.Labels["peer"] != "a" {
+		t.Fatalf("expected the request to keep its labels, got %+v", e.Metadata)
+	}
+
+	// A handler request for content queued by the user doesn't label it.
+	if _, err := q.Enqueue(mine.Cid(), Request{Recursive: true, Metadata: user}); err != nil {
+		t.Fatal(err)
+	}
+	if e, err = q.Enqueue(mine.Cid(), handler); err != nil {
+		t.Fatal(err)
+	}
+	if _, ok := e.Metadata.Labels["peer"]; ok || e.Metadata.Name != "mine" {
+		t.Fatalf("expected the user request to be kept, got %+v", e.Metadata)
+	}
+
+	// Nor does it label content the user pinned.
+	if _, err := q.Enqueue(pinned.Cid(), handler); err != nil {
+		t.Fatal(err)
+	}
+	q.Handle("test", &testHandler{
+		admit: func(ctx context.Context, e Entry, progress func()) error { return nil },
+		done:  make(chan error, 3),
+	})
+	if err := q.Start(); err != nil {
+		t.Fatal(err)
+	}
+	defer q.Close()
+	for _, nd := range []*dag.ProtoNode{shared, mine, pinned} {
+		if e := waitDone(t, q, nd.Cid()); e.State != Pinned {
+			t.Fatalf("expected %s to be pinned, got %+v", nd.Cid(), e)
+		}
+	}
+	md, err := n.meta.Get(pinned.Cid())
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, ok := md.Labels["peer"]; ok || md.Name != "mine" {
+		t.Fatalf("expected the user pin to keep its metadata, got %+v", md)
+	}
+	if md, err = n.meta.Get(shared.Cid()); err != nil || md.Labels["peer"] != "a" || md.Name != "mine" {
+		t.Fatalf("expected the merged metadata to be recorded, got %+v, %v", md, err)
+	}
+}
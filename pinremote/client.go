@@ -0,0 +1,254 @@
+// Package pinremote implements a client of the IPFS Pinning Service API,
+// which asks remote services to pin content, and tracks the status of the
+// requests made by the node.
+package pinremote
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"net/http"
+	"net/url"
+	"strconv"
+	"strings"
+	"time"
+
+	logging "github.com/ipfs/go-log"
+)
+
+var log = logging.Logger("pinremote")
+
+// Status is the status of a pin request.
+type Status string
+
+// Statuses of pin requests.
+const (
+	Queued  Status = "queued"
+	Pinning Status = "pinning"
+	Pinned  Status = "pinned"
+	Failed  Status = "failed"
+)
+
+// AllStatuses lists all the statuses of pin requests.
+var AllStatuses = []Status{Queued, Pinning, Pinned, Failed}
+
+// ParseStatus parses a pin request status.
+func ParseStatus(s string) (Status, error) {
+	for _, st := range AllStatuses {
+		if string(st) == s {
+			return st, nil
+		}
+	}
+	return "", fmt.Errorf("invalid pin status %q, must be one of {queued, pinning, pinned, failed}", s)
+}
+
+// Done reports whether the pin request reached a final status.
+func (s Status) Done() bool {
+	return s == Pinned || s == Failed
+}
+
+// Pin is the object to pin asked to a service.
+type Pin struct {
+	Cid     string            `json:"cid"`
+	Name    string            `json:"name,omitempty"`
+	Origins []string          `json:"origins,omitempty"`
+	Meta    map[string]string `json:"meta,omitempty"`
+}
+
+// PinStatus is the status of a pin request reported by a service.
+type PinStatus struct {
+	RequestID string            `json:"requestid"`
+	Status    Status            `json:"status"`
+	Created   time.Time         `json:"created"`
+	Pin       Pin               `json:"pin"`
+	Delegates []string          `json:"delegates"`
+	Info      map[string]string `json:"info,omitempty"`
+}
+
+// ListOptions selects the pin requests returned by Client.List.
+type ListOptions struct {
+	// Cids selects the requests pinning any of these CIDs.
+	Cids []string
+	// Name selects the requests with this name.
+	Name string
+	// Status selects the requests with any of these statuses, the service
+	// only returns pinned requests when none is given.
+	Status []Status
+	// Limit caps the number of requests returned, all the requests are
+	// returned when zero.
+	Limit int
+}
+
+// APIError is an error response of a service.
+type APIError struct {
+	StatusCode int
+	Reason     string
+	Details    string
+}
+
+func (e *APIError) Error() string {
+	msg := fmt.Sprintf("remote pinning service error (%d)", e.StatusCode)
+	if e.Reason != "" {
+		msg += ": " + e.Reason
+	}
+	if e.Details != "" {
+		msg += ": " + e.Details
+	}
+	return msg
+}
+
+// IsNotFound reports whether err is the response of a service to a request
+// for a pin it doesn't know.
+func IsNotFound(err error) bool {
+	apiErr, ok := err.(*APIError)
+	return ok && apiErr.StatusCode == http.StatusNotFound
+}
+
+// listPageSize is the number of pin requests asked per page by List, the
+// maximum allowed by the API.
+const listPageSize = 1000
+
+// requestTimeout bounds every request made to a pinning service, including
+// reading the response, so that an unresponsive service can't stall
+// commands or the tracker.
+const requestTimeout = time.Minute
+
+// Client talks to a pinning service.
+type Client struct {
+	endpoint string
+	key      string
+	http     *http.Client
+}
+
+// NewClient returns a client of the service at endpoint authenticating with
+// the access token key.
+func NewClient(endpoint, key string) *Client {
+	return &Client{
+		endpoint: strings.TrimRight(endpoint, "/"),
+		key:      key,
+		http:     &http.Client{Timeout: requestTimeout},
+	}
+}
+
+// Add asks the service to pin p.
+func (c *Client) Add(ctx context.Context, p Pin) (PinStatus, error) {
+	var ps PinStatus
+	err := c.do(ctx, http.MethodPost, "/pins", nil, p, &ps)
+	return ps, err
+}
+
+// Get returns the status of the pin request with the given id.
+func (c *Client) Get(ctx context.Context, requestID string) (PinStatus, error) {
+	var ps PinStatus
+	err := c.do(ctx, http.MethodGet, "/pins/"+url.PathEscape(requestID), nil, nil, &ps)
+	return ps, err
+}
+
+// Remove asks the service to remove the pin request with the given id.
+func (c *Client) Remove(ctx context.Context, requestID string) error {
+	return c.do(ctx, http.MethodDelete, "/pins/"+url.PathEscape(requestID), nil, nil, nil)
+}
+
+// List returns the pin requests selected by opts, most recent first.
+func (c *Client) List(ctx context.Context, opts ListOptions) ([]PinStatus, error) {
+	query := url.Values{}
+	if len(opts.Cids) > 0 {
+		query.Set("cid", strings.Join(opts.Cids, ","))
+	}
+	if opts.Name != "" {
+		query.Set("name", opts.Name)
+	}
+	if len(opts.Status) > 0 {
+		statuses := make([]string, len(opts.Status))
+		for i, s := range opts.Status {
+			statuses[i] = string(s)
+		}
+		query.Set("status", strings.Join(statuses, ","))
+	}
+
+	var all []PinStatus
+	for {
+		limit := listPageSize
+		if opts.Limit > 0 && opts.Limit-len(all) < limit {
+			limit = opts.Limit - len(all)
+		}
+		query.Set("limit", strconv.Itoa(limit))
+
+		var page struct {
+			Count   int         `json:"count"`
+			Results []PinStatus `json:"results"`
+		}
+		if err := c.do(ctx, http.MethodGet, "/pins", query, nil, &page); err != nil {
+			return nil, err
+		}
+		all = append(all, page.Results...)
+
+		if len(page.Results) == 0 || len(page.Results) >= page.Count ||
+			(opts.Limit > 0 && len(all) >= opts.Limit) {
+			return all, nil
+		}
+		// Pages are sorted by creation time, newest first.
+		last := page.Results[len(page.Results)-1]
+		query.Set("before", last.Created.Format(time.RFC3339Nano))
+	}
+}
+
+func (c *Client) do(ctx context.Context, method, path string, query url.Values, in, out interface{}) error {
+	u := c.endpoint + path
+	if len(query) > 0 {
+		u += "?" + query.Encode()
+	}
+
+	var body io.Reader
+	if in != nil {
+		buf, err := json.Marshal(in)
+		if err != nil {
+			return err
+		}
+		body = bytes.NewReader(buf)
+	}
+
+	req, err := http.NewRequest(method, u, body)
+	if err != nil {
+		return err
+	}
+	req = req.WithContext(ctx)
+	req.Header.Set("Authorization", "Bearer "+c.key)
+	req.Header.Set("Accept", "application/json")
+	if in != nil {
+		req.Header.Set("Content-Type", "application/json")
+	}
+
+	resp, err := c.http.Do(req)
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode < 200 || resp.StatusCode > 299 {
+		apiErr := &APIError{StatusCode: resp.StatusCode}
+		var failure struct {
+			Error struct {
+				Reason  string `json:"reason"`
+				Details string `json:"details"`
+			} `json:"error"`
+		}
+		buf, _ := ioutil.ReadAll(io.LimitReader(resp.Body, 1<<16))
+		if json.Unmarshal(buf, &failure) == nil {
+			apiErr.Reason = failure.Error.Reason
+			apiErr.Details = failure.Error.Details
+		}
+		return apiErr
+	}
+
+	if out == nil {
+		return nil
+	}
+	if err := json.NewDecoder(resp.Body).Decode(out); err != nil {
+		return fmt.Errorf("invalid response from remote pinning service: %s", err)
+	}
+	return nil
+}
@@ -0,0 +1,75 @@
+package pinremote
+
+import (
+	"encoding/json"
+	"fmt"
+
+	"github.com/ipfs/go-ipfs/repo"
+)
+
+// ConfigKey is the config key of the remote pinning services.
+const ConfigKey = "Pinning.RemoteServices"
+
+// Service is the config of a remote pinning service.
+type Service struct {
+	API ServiceAPI
+}
+
+// ServiceAPI tells how to reach a remote pinning service.
+type ServiceAPI struct {
+	// Endpoint is the base URL of the Pinning Service API.
+	Endpoint string
+	// Key is the access token of the service.
+	Key string
+}
+
+// Client returns a client of the service.
+func (s Service) Client() *Client {
+	return NewClient(s.API.Endpoint, s.API.Key)
+}
+
+// LoadServices returns the remote pinning services configured in r, by name.
+func LoadServices(r repo.Repo) (map[string]Service, error) {
+	raw, err := r.GetConfigKey(ConfigKey)
+	if err != nil || raw == nil {
+		// not configured
+		return map[string]Service{}, nil
+	}
+	buf, err := json.Marshal(raw)
+	if err != nil {
+		return nil, err
+	}
+	services := make(map[string]Service)
+	if err := json.Unmarshal(buf, &services); err != nil {
+		return nil, fmt.Errorf("failure to decode %s config: %s", ConfigKey, err)
+	}
+	return services, nil
+}
+
+// LoadService returns the remote pinning service configured in r with the
+// given name.
+func LoadService(r repo.Repo, name string) (Service, error) {
+	services, err := LoadServices(r)
+	if err != nil {
+		return Service{}, err
+	}
+	s, ok := services[name]
+	if !ok {
+		return Service{}, fmt.Errorf("remote pinning service %q not found", name)
+	}
+	return s, nil
+}
+
+// SaveServices replaces the remote pinning services configured in r.
+func SaveServices(r repo.Repo, services map[string]Service) error {
+	// Go through JSON so that the config file holds plain maps.
+	buf, err := json.Marshal(services)
+	if err != nil {
+		return err
+	}
+	var raw map[string]interface{}
+	if err := json.Unmarshal(buf, &raw); err != nil {
+		return err
+	}
+	return r.SetConfigKey(ConfigKey, raw)
+}
@@ -0,0 +1,326 @@
+package pinremote
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	ds "github.com/ipfs/go-datastore"
+	dssync "github.com/ipfs/go-datastore/sync"
+)
+
+const testKey = "secret"
+
+// mockService is an in-memory implementation of the Pinning Service API.
+type mockService struct {
+	lock   sync.Mutex
+	seq    int
+	pins   map[string]*PinStatus
+	clock  time.Time
+	server *httptest.Server
+}
+
+func newMockService() *mockService {
+	s := &mockService{
+		pins:  make(map[string]*PinStatus),
+		clock: time.Unix(1600000000, 0).UTC(),
+	}
+	s.server = httptest.NewServer(s)
+	return s
+}
+
+func (s *mockService) fail(w http.ResponseWriter, code int, reason string) {
+	w.WriteHeader(code)
+	json.NewEncoder(w).Encode(map[string]interface{}{
+		"error": map[string]string{"reason": reason},
+	})
+}
+
+// setStatus changes the status of a pin request, like the service would
+// while processing it.
+func (s *mockService) setStatus(requestID string, status Status) {
+	s.lock.Lock()
+	defer s.lock.Unlock()
+	s.pins[requestID].Status = status
+}
+
+func (s *mockService) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	if r.Header.Get("Authorization") != "Bearer "+testKey {
+		s.fail(w, http.StatusUnauthorized, "UNAUTHORIZED")
+		return
+	}
+
+	s.lock.Lock()
+	defer s.lock.Unlock()
+
+	id := strings.TrimPrefix(r.URL.Path, "/pins/")
+	switch {
+	case r.URL.Path == "/pins" && r.Method == http.MethodPost:
+		var p Pin
+		if err := json.NewDecoder(r.Body).Decode(&p); err != nil || p.Cid == "" {
+			s.fail(w, http.StatusBadRequest, "BAD_REQUEST")
+			return
+		}
+		s.seq++
+		s.clock = s.clock.Add(time.Second)
+		ps := &PinStatus{
+			RequestID: strconv.Itoa(s.seq),
+			Status:    Queued,
+			Created:   s.clock,
+			Pin:       p,
+			Delegates: []string{},
+		}
+		s.pins[ps.RequestID] = ps
+		w.WriteHeader(http.StatusAccepted)
+		json.NewEncoder(w).Encode(ps)
+
+	case r.URL.Path == "/pins" && r.Method == http.MethodGet:
+		q := r.URL.Query()
+		statuses := []string{string(Pinned)}
+		if q.Get("status") != "" {
+			statuses = strings.Split(q.Get("status"), ",")
+		}
+		limit := 10
+		if q.Get("limit") != "" {
+			limit, _ = strconv.Atoi(q.Get("limit"))
+		}
+		var before time.Time
+		if q.Get("before") != "" {
+			before, _ = time.Parse(time.RFC3339Nano, q.Get("before"))
+		}
+
+		var matching []PinStatus
+		for _, ps := range s.pins {
+			if q.Get("name") != "" && q.Get("name") != ps.Pin.Name {
+				continue
+			}
+			if q.Get("cid") != "" && !strings.Contains(","+q.Get("cid")+",", ","+ps.Pin.Cid+",") {
+				continue
+			}
+			if !strings.Contains(","+strings.Join(statuses, ",")+",", ","+string(ps.Status)+",") {
+				continue
+			}
+			if !before.IsZero() && !ps.Created.Before(before) {
+				continue
+			}
+			matching = append(matching, *ps)
+		}
+		sort.Slice(matching, func(i, j int) bool {
+			return matching[i].Created.After(matching[j].Created)
+		})
+		count := len(matching)
+		if len(matching) > limit {
+			matching = matching[:limit]
+		}
+		json.NewEncoder(w).Encode(map[string]interface{}{
+			"count":   count,
+			"results": matching,
+		})
+
+	case r.Method == http.MethodGet:
+		ps, ok := s.pins[id]
+		if !ok {
+			s.fail(w, http.StatusNotFound, "NOT_FOUND")
+			return
+		}
+		json.NewEncoder(w).Encode(ps)
+
+	case r.Method == http.MethodDelete:
+		if _, ok := s.pins[id]; !ok {
+			s.fail(w, http.StatusNotFound, "NOT_FOUND")
+			return
+		}
+		delete(s.pins, id)
+		w.WriteHeader(http.StatusAccepted)
+
+	default:
+		s.fail(w, http.StatusBadRequest, "BAD_REQUEST")
+	}
+}
+
+func TestClient(t *testing.T) {
+	ctx := context.Background()
+	s := newMockService()
+	defer s.server.Close()
+	c := NewClient(s.server.URL+"/", testKey)
+
+	var added []PinStatus
+	for i := 0; i < 3; i++ {
+		ps, err := c.Add(ctx, Pin{Cid: fmt.Sprintf("cid%d", i), Name: "backup"})
+		if err != nil {
+			t.Fatal(err)
+		}
+		if ps.Status != Queued || ps.RequestID == "" {
+			t.Fatalf("unexpected status %+v", ps)
+		}
+		added = append(added, ps)
+	}
+
+	ps, err := c.Get(ctx, added[0].RequestID)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if ps.Pin.Cid != "cid0" {
+		t.Fatalf("expected cid0, got %s", ps.Pin.Cid)
+	}
+
+	pinned, err := c.List(ctx, ListOptions{})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(pinned) != 0 {
+		t.Fatalf("only pinned requests should be listed by default, got %v", pinned)
+	}
+	s.setStatus(added[1].RequestID, Pinned)
+	all, err := c.List(ctx, ListOptions{Name: "backup", Status: AllStatuses})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(all) != 3 || all[0].Pin.Cid != "cid2" {
+		t.Fatalf("expected the 3 requests, newest first, got %v", all)
+	}
+	pinned, err = c.List(ctx, ListOptions{Status: []Status{Pinned}})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(pinned) != 1 || pinned[0].RequestID != added[1].RequestID {
+		t.Fatalf("expected only %s to be pinned, got %v", added[1].RequestID, pinned)
+	}
+
+	if err := c.Remove(ctx, added[0].RequestID); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := c.Get(ctx, added[0].RequestID); !IsNotFound(err) {
+		t.Fatalf("expected a not found error, got %v", err)
+	}
+
+	_, err = NewClient(s.server.URL, "wrong").Get(ctx, added[1].RequestID)
+	apiErr, ok := err.(*APIError)
+	if !ok || apiErr.StatusCode != http.StatusUnauthorized || apiErr.Reason != "UNAUTHORIZED" {
+		t.Fatalf("expected an unauthorized error, got %v", err)
+	}
+}
+
+func TestClientListPages(t *testing.T) {
+	ctx := context.Background()
+	s := newMockService()
+	defer s.server.Close()
+	c := NewClient(s.server.URL, testKey)
+
+	for i := 0; i < listPageSize+5; i++ {
+		s.pins[strconv.Itoa(i)] = &PinStatus{
+			RequestID: strconv.Itoa(i),
+			Status:    Pinned,
+			Created:   s.clock.Add(time.Duration(i) * time.Second),
+			Pin:       Pin{Cid: fmt.Sprintf("cid%d", i)},
+		}
+	}
+
+	all, err := c.List(ctx, ListOptions{})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(all) != listPageSize+5 {
+		t.Fatalf("expected %d pins, got %d", listPageSize+5, len(all))
+	}
+	limited, err := c.List(ctx, ListOptions{Limit: 7})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(limited) != 7 {
+		t.Fatalf("expected 7 pins, got %d", len(limited))
+	}
+}
+
+func TestTrackerReconcile(t *testing.T) {
+	ctx := context.Background()
+	s := newMockService()
+	defer s.server.Close()
+	services := map[string]Service{
+		"mock": {API: ServiceAPI{Endpoint: s.server.URL, Key: testKey}},
+	}
+	tracker := NewTracker(dssync.MutexWrap(ds.NewMapDatastore()), func() (map[string]Service, error) {
+		return services, nil
+	})
+	c := services["mock"].Client()
+
+	var requests []PinStatus
+	for _, cid := range []string{"pinned", "failed", "removed", "pending"} {
+		ps, err := c.Add(ctx, Pin{Cid: cid})
+		if err != nil {
+			t.Fatal(err)
+		}
+		if err := tracker.Put("mock", ps); err != nil {
+			t.Fatal(err)
+		}
+		requests = append(requests, ps)
+	}
+	s.setStatus(requests[0].RequestID, Pinned)
+	s.setStatus(requests[1].RequestID, Failed)
+	if err := c.Remove(ctx, requests[2].RequestID); err != nil {
+		t.Fatal(err)
+	}
+	s.setStatus(requests[3].RequestID, Pinning)
+
+	if err := tracker.Reconcile(ctx); err != nil {
+		t.Fatal(err)
+	}
+	records, err := tracker.List("mock")
+	if err != nil {
+		t.Fatal(err)
+	}
+	statuses := make(map[string]Status)
+	for _, r := range records {
+		statuses[r.Pin.Cid] = r.Status
+	}
+	expected := map[string]Status{"pinned": Pinned, "failed": Failed, "pending": Pinning}
+	if len(statuses) != len(expected) {
+		t.Fatalf("expected %v, got %v", expected, statuses)
+	}
+	for cid, status := range expected {
+		if statuses[cid] != status {
+			t.Errorf("expected %s to be %s, got %s", cid, status, statuses[cid])
+		}
+	}
+
+	s.setStatus(requests[3].RequestID, Pinned)
+	ps, err := tracker.Wait(ctx, c, "mock", requests[3].RequestID, time.Millisecond)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if ps.Status != Pinned {
+		t.Fatalf("expected the request to be pinned, got %s", ps.Status)
+	}
+
+	// Requests of services missing from the config are kept until the
+	// service is removed.
+	delete(services, "mock")
+	if err := tracker.Reconcile(ctx); err != nil {
+		t.Fatal(err)
+	}
+	records, err = tracker.List("")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(records) != len(expected) {
+		t.Fatalf("expected %d tracked requests, got %v", len(expected), records)
+	}
+	if err := tracker.DeleteService("mock"); err != nil {
+		t.Fatal(err)
+	}
+	records, err = tracker.List("")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(records) != 0 {
+		t.Fatalf("expected no tracked requests, got %v", records)
+	}
+}
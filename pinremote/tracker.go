@@ -0,0 +1,218 @@
+package pinremote
+
+import (
+	"context"
+	"encoding/json"
+	"time"
+
+	ds "github.com/ipfs/go-datastore"
+	dsq "github.com/ipfs/go-datastore/query"
+	dshelp "github.com/ipfs/go-ipfs-ds-help"
+	"github.com/jbenet/goprocess"
+	gpctx "github.com/jbenet/goprocess/context"
+)
+
+// trackPrefix is the datastore prefix of the tracked pin requests.
+var trackPrefix = ds.NewKey("/local/pins/remote")
+
+// DefaultReconcileInterval is how often the status of the pending pin
+// requests is refreshed.
+const DefaultReconcileInterval = 30 * time.Second
+
+// Record is a pin request made by the node to a remote service.
+type Record struct {
+	Service string
+	PinStatus
+	// Updated is when the status was last refreshed.
+	Updated time.Time
+}
+
+// Tracker records the pin requests made by the node to remote services and
+// refreshes their status until they are pinned or failed.
+type Tracker struct {
+	// Interval is how often Run refreshes the pending pin requests.
+	Interval time.Duration
+
+	ds       ds.Datastore
+	services func() (map[string]Service, error)
+}
+
+// NewTracker returns a Tracker keeping its records in d, services returns
+// the currently configured services.
+func NewTracker(d ds.Datastore, services func() (map[string]Service, error)) *Tracker {
+	return &Tracker{
+		Interval: DefaultReconcileInterval,
+		ds:       d,
+		services: services,
+	}
+}
+
+func trackKey(service, requestID string) ds.Key {
+	return trackPrefix.ChildString(service).Child(dshelp.NewKeyFromBinary([]byte(requestID)))
+}
+
+// Put records the status of a pin request made to service.
+func (t *Tracker) Put(service string, ps PinStatus) error {
+	buf, err := json.Marshal(Record{Service: service, PinStatus: ps, Updated: time.Now()})
+	if err != nil {
+		return err
+	}
+	return t.ds.Put(trackKey(service, ps.RequestID), buf)
+}
+
+// Delete stops tracking a pin request.
+func (t *Tracker) Delete(service, requestID string) error {
+	err := t.ds.Delete(trackKey(service, requestID))
+	if err == ds.ErrNotFound {
+		return nil
+	}
+	return err
+}
+
+// DeleteService stops tracking the pin requests made to service.
+func (t *Tracker) DeleteService(service string) error {
+	records, err := t.List(service)
+	if err != nil {
+		return err
+	}
+	for _, r := range records {
+		if err := t.Delete(service, r.RequestID); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// List returns the tracked pin requests, of all the services when service is
+// empty.
+func (t *Tracker) List(service string) ([]Record, error) {
+	prefix := trackPrefix
+	if service != "" {
+		prefix = prefix.ChildString(service)
+	}
+	res, err := t.ds.Query(dsq.Query{Prefix: prefix.String()})
+	if err != nil {
+		return nil, err
+	}
+	defer res.Close()
+
+	var records []Record
+	for e := range res.Next() {
+		if e.Error != nil {
+			return nil, e.Error
+		}
+		var r Record
+		if err := json.Unmarshal(e.Value, &r); err != nil {
+			log.Warnf("ignoring invalid remote pin record %s: %s", e.Key, err)
+			continue
+		}
+		if service != "" && r.Service != service {
+			// prefix of another service name
+			continue
+		}
+		records = append(records, r)
+	}
+	return records, nil
+}
+
+// Refresh fetches the status of a pin request from its service and records
+// it. Requests which the service doesn't know anymore are not tracked
+// anymore.
+func (t *Tracker) Refresh(ctx context.Context, c *Client, service, requestID string) (PinStatus, error) {
+	ps, err := c.Get(ctx, requestID)
+	if err != nil {
+		if IsNotFound(err) {
+			if err := t.Delete(service, requestID); err != nil {
+				return ps, err
+			}
+		}
+		return ps, err
+	}
+	return ps, t.Put(service, ps)
+}
+
+// Wait refreshes the status of a pin request every interval until it is
+// pinned or failed, or ctx is done.
+func (t *Tracker) Wait(ctx context.Context, c *Client, service, requestID string, interval time.Duration) (PinStatus, error) {
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+	for {
+		ps, err := t.Refresh(ctx, c, service, requestID)
+		if err != nil || ps.Status.Done() {
+			return ps, err
+		}
+		select {
+		case <-ticker.C:
+		case <-ctx.Done():
+			return ps, ctx.Err()
+		}
+	}
+}
+
+// Reconcile refreshes the status of the pin requests which are neither
+// pinned nor failed. Requests which the service doesn't know anymore are not
+// tracked anymore. Requests of services missing from the config are kept
+// untouched, the config may be edited or replaced by mistake, they are only
+// forgotten by DeleteService.
+func (t *Tracker) Reconcile(ctx context.Context) error {
+	records, err := t.List("")
+	if err != nil {
+		return err
+	}
+	services, err := t.services()
+	if err != nil {
+		return err
+	}
+
+	clients := make(map[string]*Client)
+	for _, r := range records {
+		s, ok := services[r.Service]
+		if !ok {
+			log.Debugf("skipping pin request %s of unknown service %s", r.RequestID, r.Service)
+			continue
+		}
+		if r.Status.Done() {
+			continue
+		}
+		c, ok := clients[r.Service]
+		if !ok {
+			c = s.Client()
+			clients[r.Service] = c
+		}
+
+		ps, err := t.Refresh(ctx, c, r.Service, r.RequestID)
+		switch {
+		case IsNotFound(err):
+			log.Infof("pin request %s for %s was removed from %s", r.RequestID, r.Pin.Cid, r.Service)
+		case err != nil:
+			if ctx.Err() != nil {
+				return ctx.Err()
+			}
+			// Services may be unreachable for a while, try again later.
+			log.Warnf("failed to refresh pin request %s on %s: %s", r.RequestID, r.Service, err)
+		case ps.Status == Failed:
+			log.Errorf("%s failed to pin %s", r.Service, r.Pin.Cid)
+		case ps.Status == Pinned:
+			log.Infof("%s pinned %s", r.Service, r.Pin.Cid)
+		}
+	}
+	return nil
+}
+
+// Run reconciles the pin requests every Interval until proc closes.
+func (t *Tracker) Run(proc goprocess.Process) {
+	ctx := gpctx.OnClosingContext(proc)
+	ticker := time.NewTicker(t.Interval)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-ticker.C:
+			if err := t.Reconcile(ctx); err != nil && ctx.Err() == nil {
+				log.Errorf("failed to reconcile remote pins: %s", err)
+			}
+		case <-proc.Closing():
+			return
+		}
+	}
+}
@@ -7,6 +7,7 @@ import (
 	"io/ioutil"
 	"os"
 	"path/filepath"
+	"reflect"
 	"strings"
 	"sync"
 
@@ -564,7 +565,7 @@ func (r *FSRepo) setConfigUnsynced(updated *config.Config) error {
 		return err
 	}
 	for k, v := range m {
-		mapconf[k] = v
+		mapconf[k] = keepUnknownKeys(reflect.TypeOf(config.Config{}), k, mapconf[k], v)
 	}
 	if err := serialize.WriteConfigFile(configFilename, mapconf); err != nil {
 		return err
@@ -575,6 +576,62 @@ func (r *FSRepo) setConfigUnsynced(updated *config.Config) error {
 	return nil
 }
 
+// keepUnknownKeys returns the updated value of the config key named key of
+// the struct typ, carrying over the keys of its old value which aren't
+// fields of the struct, at any depth. This keeps the settings read with
+// GetConfigKey, like Gateway.Denylist, when a config.Config is written back.
+func keepUnknownKeys(typ reflect.Type, key string, old, updated interface{}) interface{} {
+	oldMap, ok := old.(map[string]interface{})
+	if !ok {
+		return updated
+	}
+	updatedMap, ok := updated.(map[string]interface{})
+	if !ok {
+		return updated
+	}
+	field, ok := configField(typ, key)
+	if !ok {
+		return updated
+	}
+	ftyp := field.Type
+	if ftyp.Kind() == reflect.Ptr {
+		ftyp = ftyp.Elem()
+	}
+	if ftyp.Kind() != reflect.Struct {
+		return updated
+	}
+	for k, v := range oldMap {
+		if _, known := configField(ftyp, k); !known {
+			if _, set := updatedMap[k]; !set {
+				updatedMap[k] = v
+			}
+			continue
+		}
+		if uv, set := updatedMap[k]; set {
+			updatedMap[k] = keepUnknownKeys(ftyp, k, v, uv)
+		}
+	}
+	return updatedMap
+}
+
+// configField returns the field of the struct typ marshalled as key.
+func configField(typ reflect.Type, key string) (reflect.StructField, bool) {
+	for i := 0; i < typ.NumField(); i++ {
+		f := typ.Field(i)
+		name := strings.Split(f.Tag.Get("json"), ",")[0]
+		if name == "-" {
+			continue
+		}
+		if name == "" {
+			name = f.Name
+		}
+		if name == key {
+			return f, true
+		}
+	}
+	return reflect.StructField{}, false
+}
+
 // SetConfig updates the FSRepo's config. The user must not modify the config
 // object after calling this method.
 func (r *FSRepo) SetConfig(updated *config.Config) error {
@@ -652,7 +709,11 @@ func (r *FSRepo) SetConfigKey(key string, value interface{}) error {
 	if err := serialize.WriteConfigFile(filename, mapconf); err != nil {
 		return err
 	}
-	return r.setConfigUnsynced(conf) // TODO roll this into this method
+	// The map was written as is, writing the struct over it would drop the
+	// keys go-ipfs-config doesn't know about, like the ones read with
+	// GetConfigKey.
+	r.config = conf
+	return nil
 }
 
 // Datastore returns a repo-owned datastore. If FSRepo is Closed, return value
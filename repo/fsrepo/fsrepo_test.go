@@ -116,3 +116,57 @@ func TestOpenMoreThanOnceInSameProcess(t *testing.T) {
 	assert.Nil(r1.Close(), t)
 	assert.Nil(r2.Close(), t)
 }
+
+func TestSetConfigKeyKeepsUnknownKeys(t *testing.T) {
+	t.Parallel()
+	path := testRepoPath("", t)
+	assert.Nil(Init(path, &config.Config{
+		Identity:  config.Identity{PrivKey: "private key"},
+		Datastore: config.DefaultDatastoreConfig(),
+	}), t)
+
+	r, err := Open(path)
+	assert.Nil(err, t, "repo should open successfully")
+	defer r.Close()
+
+	assert.Nil(r.SetConfigKey("Gateway.Extension", true), t, "setting an unknown key should succeed")
+	assert.Nil(r.SetConfigKey("Gateway.Writable", true), t, "setting a known key should succeed")
+
+	v, err := r.GetConfigKey("Gateway.Extension")
+	assert.Nil(err, t, "unknown key should survive writing the config")
+	assert.True(v == true, t, "unknown key should keep its value")
+}
+
+func TestSetConfigKeepsUnknownKeys(t *testing.T) {
+	t.Parallel()
+	path := testRepoPath("", t)
+	assert.Nil(Init(path, &config.Config{
+		Identity:  config.Identity{PrivKey: "private key"},
+		Datastore: config.DefaultDatastoreConfig(),
+	}), t)
+
+	r, err := Open(path)
+	assert.Nil(err, t, "repo should open successfully")
+	defer r.Close()
+
+	denylist := map[string]interface{}{"Path": "denylist.txt"}
+	assert.Nil(r.SetConfigKey("Gateway.Denylist", denylist), t, "setting an unknown key should succeed")
+	assert.Nil(r.SetConfigKey("Datastore.GCPolicy", "incremental"), t, "setting an unknown key should succeed")
+
+	cfg, err := r.Config()
+	assert.Nil(err, t, "config should be read")
+	updated := *cfg
+	updated.Gateway.Writable = true
+	updated.Bootstrap = nil
+	assert.Nil(r.SetConfig(&updated), t, "setting the config should succeed")
+
+	v, err := r.GetConfigKey("Gateway.Denylist.Path")
+	assert.Nil(err, t, "unknown key should survive SetConfig")
+	assert.True(v == "denylist.txt", t, "unknown key should keep its value")
+	v, err = r.GetConfigKey("Datastore.GCPolicy")
+	assert.Nil(err, t, "nested unknown key should survive SetConfig")
+	assert.True(v == "incremental", t, "unknown key should keep its value")
+	v, err = r.GetConfigKey("Gateway.Writable")
+	assert.Nil(err, t, "known key should be written")
+	assert.True(v == true, t, "known key should be updated")
+}
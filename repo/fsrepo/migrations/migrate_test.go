@@ -0,0 +1,173 @@
+package mfsr
+
+import (
+	"errors"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"runtime"
+	"strings"
+	"testing"
+)
+
+const testConfig = `{
+  "Addresses": {
+    "Swarm": ["/ip4/0.0.0.0/tcp/4001", "/ip6/::/tcp/4001", "/ip4/0.0.0.0/tcp/4002/ws"]
+  },
+  "Bootstrap": ["/ip4/104.131.131.82/tcp/4001/p2p/QmaCpDMGvV2BGHeYERUEnRQAwe3N8SzbUtfsmvsqQLuvuJ"],
+  "Pinning": {"Index": true}
+}
+`
+
+func testRepo(t *testing.T, version int) string {
+	dir, err := ioutil.TempDir("", "migrations")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := RepoPath(dir).WriteVersion(version); err != nil {
+		t.Fatal(err)
+	}
+	if err := ioutil.WriteFile(filepath.Join(dir, "config"), []byte(testConfig), 0600); err != nil {
+		t.Fatal(err)
+	}
+	if err := os.Mkdir(filepath.Join(dir, keystoreDir), 0700); err != nil {
+		t.Fatal(err)
+	}
+	if err := ioutil.WriteFile(filepath.Join(dir, keystoreDir, "my key"), []byte("key"), 0600); err != nil {
+		t.Fatal(err)
+	}
+	return dir
+}
+
+func TestMigrate(t *testing.T) {
+	dir := testRepo(t, 8)
+	defer os.RemoveAll(dir)
+
+	if err := Migrate(dir, 10, Options{Offline: true, Out: ioutil.Discard}); err != nil {
+		t.Fatal(err)
+	}
+	if err := RepoPath(dir).CheckVersion(10); err != nil {
+		t.Fatal(err)
+	}
+
+	if _, err := os.Stat(filepath.Join(dir, keystoreDir, keyFilename("my key"))); err != nil {
+		t.Fatalf("the key should have been renamed: %s", err)
+	}
+
+	buf, err := ioutil.ReadFile(filepath.Join(dir, "config"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	config := string(buf)
+	for _, s := range []string{
+		"/ip4/0.0.0.0/udp/4001/quic",
+		"/ip6/::/udp/4001/quic",
+		quicBootstrapAddr,
+		`"Index": true`,
+	} {
+		if !strings.Contains(config, s) {
+			t.Errorf("expected the config to contain %s, got %s", s, config)
+		}
+	}
+	if strings.Contains(config, "4002/quic") {
+		t.Errorf("websocket addresses should not get a QUIC address, got %s", config)
+	}
+	if _, err := os.Stat(filepath.Join(dir, backupRoot)); !os.IsNotExist(err) {
+		t.Fatalf("the backups should have been removed, got %v", err)
+	}
+}
+
+func TestMigrateRollback(t *testing.T) {
+	dir := testRepo(t, 1000)
+	defer os.RemoveAll(dir)
+
+	Register(Migration{
+		From:  1000,
+		Paths: []string{"config", "added"},
+		Apply: func(repoPath string) error {
+			if err := ioutil.WriteFile(filepath.Join(repoPath, "config"), []byte("broken"), 0600); err != nil {
+				return err
+			}
+			if err := ioutil.WriteFile(filepath.Join(repoPath, "added"), nil, 0600); err != nil {
+				return err
+			}
+			return errors.New("failed")
+		},
+	})
+	defer delete(registry, 1000)
+
+	if err := Migrate(dir, 1001, Options{Offline: true, Out: ioutil.Discard}); err == nil {
+		t.Fatal("expected the migration to fail")
+	}
+	if err := RepoPath(dir).CheckVersion(1000); err != nil {
+		t.Fatal(err)
+	}
+	buf, err := ioutil.ReadFile(filepath.Join(dir, "config"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if string(buf) != testConfig {
+		t.Fatalf("the config should have been restored, got %s", buf)
+	}
+	if _, err := os.Stat(filepath.Join(dir, "added")); !os.IsNotExist(err) {
+		t.Fatalf("files created by the migration should have been removed, got %v", err)
+	}
+
+	// A migration interrupted after the backup is rolled back.
+	if err := backup(dir, backupDir(dir, 1000), registry[1000].Paths); err != nil {
+		t.Fatal(err)
+	}
+	if err := ioutil.WriteFile(filepath.Join(dir, "config"), []byte("half migrated"), 0600); err != nil {
+		t.Fatal(err)
+	}
+	registry[1000] = Migration{
+		From:  1000,
+		Paths: []string{"config"},
+		Apply: func(repoPath string) error {
+			buf, err := ioutil.ReadFile(filepath.Join(repoPath, "config"))
+			if err != nil {
+				return err
+			}
+			if string(buf) != testConfig {
+				return errors.New("the repo was not restored")
+			}
+			return nil
+		},
+	}
+	if err := Migrate(dir, 1001, Options{Offline: true, Out: ioutil.Discard}); err != nil {
+		t.Fatal(err)
+	}
+	if err := RepoPath(dir).CheckVersion(1001); err != nil {
+		t.Fatal(err)
+	}
+}
+
+func TestMigrateBinaries(t *testing.T) {
+	dir := testRepo(t, 7)
+	defer os.RemoveAll(dir)
+	binDir, err := ioutil.TempDir("", "migration-binaries")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(binDir)
+
+	// Migrations which aren't built in are not downloaded when offline.
+	err = Migrate(dir, 10, Options{Offline: true, BinDir: binDir, Out: ioutil.Discard})
+	if err == nil || !strings.Contains(err.Error(), "no fs-repo-migrations binary found") {
+		t.Fatalf("expected no binary to be found, got %v", err)
+	}
+
+	if runtime.GOOS == "windows" {
+		t.Skip("the test binary is a shell script")
+	}
+	script := "#!/bin/sh\necho 8 > \"$2/version\"\n"
+	if err := ioutil.WriteFile(filepath.Join(binDir, "fs-repo-7-to-8"), []byte(script), 0755); err != nil {
+		t.Fatal(err)
+	}
+	if err := Migrate(dir, 10, Options{Offline: true, BinDir: binDir, Out: ioutil.Discard}); err != nil {
+		t.Fatal(err)
+	}
+	if err := RepoPath(dir).CheckVersion(10); err != nil {
+		t.Fatal(err)
+	}
+}
@@ -0,0 +1,56 @@
+package mfsr
+
+import (
+	"encoding/base32"
+	"fmt"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"strings"
+)
+
+const keystoreDir = "keystore"
+
+func init() {
+	Register(Migration{
+		From:        8,
+		Description: "encode the file names of the keystore",
+		Paths:       []string{keystoreDir},
+		Apply:       encodeKeystoreNames,
+	})
+}
+
+// keyFilename returns the file name of the key name, as the keystore of
+// version 9 encodes it.
+func keyFilename(name string) string {
+	codec := base32.StdEncoding.WithPadding(base32.NoPadding)
+	return "key_" + strings.ToLower(codec.EncodeToString([]byte(name)))
+}
+
+// encodeKeystoreNames renames the keys of the keystore, which were named
+// after the keys, to base32 encoded names which are valid on every
+// filesystem.
+func encodeKeystoreNames(repoPath string) error {
+	dir := filepath.Join(repoPath, keystoreDir)
+	entries, err := ioutil.ReadDir(dir)
+	if err != nil {
+		if os.IsNotExist(err) {
+			return nil
+		}
+		return err
+	}
+
+	for _, e := range entries {
+		if e.IsDir() {
+			continue
+		}
+		dst := filepath.Join(dir, keyFilename(e.Name()))
+		if _, err := os.Stat(dst); err == nil {
+			return fmt.Errorf("can't rename key %q, %s exists", e.Name(), dst)
+		}
+		if err := os.Rename(filepath.Join(dir, e.Name()), dst); err != nil {
+			return err
+		}
+	}
+	return nil
+}
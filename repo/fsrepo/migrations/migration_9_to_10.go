@@ -0,0 +1,76 @@
+package mfsr
+
+import (
+	"path/filepath"
+	"strings"
+
+	config "github.com/ipfs/go-ipfs-config"
+	serialize "github.com/ipfs/go-ipfs-config/serialize"
+)
+
+func init() {
+	Register(Migration{
+		From:        9,
+		Description: "add QUIC listen and bootstrap addresses",
+		Paths:       []string{config.DefaultConfigFile},
+		Apply:       addQUICAddrs,
+	})
+}
+
+// quicBootstrapPeer is the bootstrap peer reachable over QUIC, which is
+// added next to its TCP address.
+const (
+	quicBootstrapPeer = "QmaCpDMGvV2BGHeYERUEnRQAwe3N8SzbUtfsmvsqQLuvuJ"
+	quicBootstrapTCP  = "/ip4/104.131.131.82/tcp/4001/"
+	quicBootstrapAddr = "/ip4/104.131.131.82/udp/4001/quic/p2p/" + quicBootstrapPeer
+)
+
+// addQUICAddrs adds a QUIC listen address for every TCP listen address of
+// Addresses.Swarm, and the QUIC address of the bootstrap peer which has one.
+// The config is edited as JSON, so that the keys unknown to go-ipfs-config
+// are kept.
+func addQUICAddrs(repoPath string) error {
+	filename := filepath.Join(repoPath, config.DefaultConfigFile)
+	var cfg map[string]interface{}
+	if err := serialize.ReadConfigFile(filename, &cfg); err != nil {
+		return err
+	}
+
+	if addrs, ok := cfg["Addresses"].(map[string]interface{}); ok {
+		swarm, _ := addrs["Swarm"].([]interface{})
+		for _, a := range swarm {
+			s, _ := a.(string)
+			// /ip4/0.0.0.0/tcp/4001
+			parts := strings.Split(s, "/")
+			if len(parts) != 5 || (parts[1] != "ip4" && parts[1] != "ip6") || parts[3] != "tcp" {
+				continue
+			}
+			swarm = appendMissing(swarm, strings.Join([]string{"", parts[1], parts[2], "udp", parts[4], "quic"}, "/"))
+		}
+		if swarm != nil {
+			addrs["Swarm"] = swarm
+		}
+	}
+
+	if bootstrap, ok := cfg["Bootstrap"].([]interface{}); ok {
+		for _, a := range bootstrap {
+			s, _ := a.(string)
+			if strings.HasPrefix(s, quicBootstrapTCP) && strings.HasSuffix(s, "/"+quicBootstrapPeer) {
+				cfg["Bootstrap"] = appendMissing(bootstrap, quicBootstrapAddr)
+				break
+			}
+		}
+	}
+
+	return serialize.WriteConfigFile(filename, cfg)
+}
+
+// appendMissing appends s to list unless list holds it already.
+func appendMissing(list []interface{}, s string) []interface{} {
+	for _, v := range list {
+		if v == s {
+			return list
+		}
+	}
+	return append(list, s)
+}
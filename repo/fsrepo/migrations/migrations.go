@@ -34,24 +34,31 @@ func migrationsBinName() string {
 	}
 }
 
+// RunMigration migrates the repo of the IPFS_PATH to version newv with the
+// fs-repo-migrations binary from the PATH, or downloaded from DistPath.
 func RunMigration(newv int) error {
-	migrateBin := migrationsBinName()
+	return runMigrationsBinary("", newv, Options{Out: os.Stdout})
+}
 
-	fmt.Println("  => Looking for suitable fs-repo-migrations binary.")
-
-	var err error
-	migrateBin, err = exec.LookPath(migrateBin)
-	if err == nil {
-		// check to make sure migrations binary supports our target version
-		err = verifyMigrationSupportsVersion(migrateBin, newv)
-	}
+// runMigrationsBinary migrates the repo at repoPath, or of the IPFS_PATH
+// when empty, to version newv with the fs-repo-migrations binary from
+// opts.BinDir, from the PATH, or downloaded unless opts.Offline is set.
+func runMigrationsBinary(repoPath string, newv int, opts Options) error {
+	out := opts.Out
+	fmt.Fprintln(out, "  => Looking for suitable fs-repo-migrations binary.")
 
+	migrateBin, err := findMigrationsBinary(opts.BinDir, newv)
 	if err != nil {
-		fmt.Println("  => None found, downloading.")
+		if opts.Offline {
+			fmt.Fprintln(out, "  => None found, not downloading in offline mode.")
+			return fmt.Errorf("no fs-repo-migrations binary found for version %d: %s", newv, err)
+		}
+
+		fmt.Fprintln(out, "  => None found, downloading.")
 
 		loc, err := GetMigrations()
 		if err != nil {
-			fmt.Println("  => Failed to download fs-repo-migrations.")
+			fmt.Fprintln(out, "  => Failed to download fs-repo-migrations.")
 			return err
 		}
 
@@ -64,19 +71,87 @@ func RunMigration(newv int) error {
 	}
 
 	cmd := exec.Command(migrateBin, "-to", fmt.Sprint(newv), "-y")
-	cmd.Stdout = os.Stdout
+	cmd.Stdout = out
 	cmd.Stderr = os.Stderr
+	if repoPath != "" {
+		cmd.Env = append(os.Environ(), "IPFS_PATH="+repoPath)
+	}
 
-	fmt.Printf("  => Running: %s -to %d -y\n", migrateBin, newv)
+	fmt.Fprintf(out, "  => Running: %s -to %d -y\n", migrateBin, newv)
 
 	err = cmd.Run()
 	if err != nil {
-		fmt.Printf("  => Failed: %s -to %d -y\n", migrateBin, newv)
+		fmt.Fprintf(out, "  => Failed: %s -to %d -y\n", migrateBin, newv)
 		return fmt.Errorf("migration failed: %s", err)
 	}
 
-	fmt.Printf("  => Success: fs-repo has been migrated to version %d.\n", newv)
+	fmt.Fprintf(out, "  => Success: fs-repo has been migrated to version %d.\n", newv)
+
+	return nil
+}
+
+// findMigrationsBinary looks for an fs-repo-migrations binary supporting
+// version newv in binDir, then in the PATH.
+func findMigrationsBinary(binDir string, newv int) (string, error) {
+	var err error
+	if binDir != "" {
+		bin := filepath.Join(binDir, migrationsBinName())
+		if err = verifyMigrationSupportsVersion(bin, newv); err == nil {
+			return bin, nil
+		}
+	}
+
+	bin, lerr := exec.LookPath(migrationsBinName())
+	if lerr != nil {
+		if err != nil {
+			return "", err
+		}
+		return "", lerr
+	}
+	if err := verifyMigrationSupportsVersion(bin, newv); err != nil {
+		return "", err
+	}
+	return bin, nil
+}
+
+// stepBinary returns the fs-repo-X-to-Y binary of binDir migrating from
+// version v, empty when there is none.
+func stepBinary(binDir string, v int) string {
+	if binDir == "" {
+		return ""
+	}
+	name := fmt.Sprintf("fs-repo-%d-to-%d", v, v+1)
+	if runtime.GOOS == "windows" {
+		name += ".exe"
+	}
+	bin := filepath.Join(binDir, name)
+	if fi, err := os.Stat(bin); err != nil || fi.IsDir() {
+		return ""
+	}
+	return bin
+}
 
+// runStepBinary migrates the repo at repoPath from version v to v+1 with
+// the fs-repo-X-to-Y binary bin.
+func runStepBinary(repoPath, bin string, v int, out io.Writer) error {
+	cmd := exec.Command(bin, "-path", repoPath)
+	cmd.Stdout = out
+	cmd.Stderr = os.Stderr
+
+	fmt.Fprintf(out, "  => Running: %s -path %s\n", bin, repoPath)
+	if err := cmd.Run(); err != nil {
+		fmt.Fprintf(out, "  => Failed: %s -path %s\n", bin, repoPath)
+		return fmt.Errorf("migration from version %d failed: %s", v, err)
+	}
+
+	// Check the version, so that a binary doing nothing doesn't loop.
+	nv, err := RepoPath(repoPath).Version()
+	if err != nil {
+		return err
+	}
+	if nv != v+1 {
+		return fmt.Errorf("%s left the repo at version %d instead of %d", bin, nv, v+1)
+	}
 	return nil
 }
 
@@ -0,0 +1,254 @@
+package mfsr
+
+import (
+	"fmt"
+	"io"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"sort"
+)
+
+// Migration upgrades a repo from version From to From+1 within the ipfs
+// process.
+type Migration struct {
+	// From is the version the migration applies to.
+	From int
+	// Description tells what the migration changes.
+	Description string
+	// Paths are the files and directories of the repo, relative to its
+	// root, which the migration changes. They are backed up before the
+	// migration and restored when it fails.
+	Paths []string
+	// Apply migrates the repo at repoPath.
+	Apply func(repoPath string) error
+}
+
+var registry = make(map[int]Migration)
+
+// Register registers a migration, usually from an init function. It panics
+// when a migration is already registered for the same version.
+func Register(m Migration) {
+	if _, ok := registry[m.From]; ok {
+		panic(fmt.Sprintf("a migration from version %d is already registered", m.From))
+	}
+	registry[m.From] = m
+}
+
+// Registered returns the versions which can be migrated within the ipfs
+// process, in increasing order.
+func Registered() []int {
+	versions := make([]int, 0, len(registry))
+	for v := range registry {
+		versions = append(versions, v)
+	}
+	sort.Ints(versions)
+	return versions
+}
+
+// Options configures Migrate.
+type Options struct {
+	// Offline prevents downloading migration binaries, only the registered
+	// migrations and the binaries found locally are run.
+	Offline bool
+	// BinDir is a directory of migration binaries, looked into for the
+	// migrations which aren't registered. It holds either fs-repo-X-to-Y
+	// binaries migrating a single version, or an fs-repo-migrations binary.
+	BinDir string
+	// Out receives the progress of the migrations, os.Stdout by default.
+	Out io.Writer
+}
+
+// backupRoot is the directory of the repo holding the backups.
+const backupRoot = "migrations-backup"
+
+// backupDir is where the paths changed by the migration from version from
+// are backed up while it runs.
+func backupDir(repoPath string, from int) string {
+	return filepath.Join(repoPath, backupRoot, fmt.Sprintf("%d-to-%d", from, from+1))
+}
+
+// backupComplete marks a complete backup.
+const backupComplete = ".complete"
+
+// Migrate migrates the repo at repoPath to version to. The registered
+// migrations run within the process, the others run external binaries from
+// opts.BinDir, from the PATH or downloaded from DistPath, unless
+// opts.Offline is set.
+//
+// The paths changed by registered migrations are backed up, and restored
+// when a migration fails. A migration interrupted by a crash is rolled back
+// by the next Migrate.
+func Migrate(repoPath string, to int, opts Options) error {
+	if opts.Out == nil {
+		opts.Out = os.Stdout
+	}
+	rp := RepoPath(repoPath)
+
+	for {
+		v, err := rp.Version()
+		if err != nil {
+			return err
+		}
+		if v > to {
+			return fmt.Errorf("repo version %d is newer than %d, downgrading is not supported", v, to)
+		}
+		if v == to {
+			fmt.Fprintf(opts.Out, "  => Success: fs-repo is at version %d.\n", v)
+			// Drop the backup of a migration interrupted once done.
+			return os.RemoveAll(filepath.Join(repoPath, backupRoot))
+		}
+
+		if err := rollbackInterrupted(repoPath, v, opts.Out); err != nil {
+			return err
+		}
+
+		if m, ok := registry[v]; ok {
+			fmt.Fprintf(opts.Out, "  => Migrating fs-repo from version %d to %d: %s\n", v, v+1, m.Description)
+			if err := runRegistered(repoPath, m); err != nil {
+				fmt.Fprintf(opts.Out, "  => Failed, fs-repo was restored to version %d.\n", v)
+				return fmt.Errorf("migration from version %d failed: %s", v, err)
+			}
+			continue
+		}
+
+		if bin := stepBinary(opts.BinDir, v); bin != "" {
+			if err := runStepBinary(repoPath, bin, v, opts.Out); err != nil {
+				return err
+			}
+			continue
+		}
+
+		// Run the remaining migrations with fs-repo-migrations.
+		return runMigrationsBinary(repoPath, to, opts)
+	}
+}
+
+// runRegistered applies m, restoring the repo when it fails.
+func runRegistered(repoPath string, m Migration) error {
+	dir := backupDir(repoPath, m.From)
+	if err := backup(repoPath, dir, m.Paths); err != nil {
+		os.RemoveAll(dir)
+		return fmt.Errorf("backup failed: %s", err)
+	}
+
+	err := m.Apply(repoPath)
+	if err == nil {
+		err = RepoPath(repoPath).WriteVersion(m.From + 1)
+	}
+	if err != nil {
+		if rerr := restore(repoPath, dir, m.Paths); rerr != nil {
+			return fmt.Errorf("%s, and restoring the backup in %s failed: %s", err, dir, rerr)
+		}
+		return err
+	}
+	return os.RemoveAll(filepath.Dir(dir))
+}
+
+// rollbackInterrupted restores the backup of a migration from version v
+// which didn't finish.
+func rollbackInterrupted(repoPath string, v int, out io.Writer) error {
+	dir := backupDir(repoPath, v)
+	if _, err := os.Stat(dir); os.IsNotExist(err) {
+		return nil
+	}
+	m, ok := registry[v]
+	if !ok {
+		return fmt.Errorf("found the backup of an unknown migration in %s", dir)
+	}
+	if _, err := os.Stat(filepath.Join(dir, backupComplete)); err == nil {
+		fmt.Fprintf(out, "  => Restoring fs-repo from an interrupted migration from version %d.\n", v)
+		if err := restore(repoPath, dir, m.Paths); err != nil {
+			return fmt.Errorf("failed to restore the backup in %s: %s", dir, err)
+		}
+	}
+	// An incomplete backup means the migration didn't start.
+	return os.RemoveAll(dir)
+}
+
+// withVersionFile returns paths and the version file.
+func withVersionFile(paths []string) []string {
+	return append(append([]string(nil), paths...), VersionFile)
+}
+
+// backup copies the given paths of the repo and its version file to dir.
+func backup(repoPath, dir string, paths []string) error {
+	if err := os.MkdirAll(dir, 0700); err != nil {
+		return err
+	}
+	for _, p := range withVersionFile(paths) {
+		src := filepath.Join(repoPath, p)
+		if _, err := os.Lstat(src); os.IsNotExist(err) {
+			continue
+		}
+		if err := copyPath(src, filepath.Join(dir, p)); err != nil {
+			return err
+		}
+	}
+	return ioutil.WriteFile(filepath.Join(dir, backupComplete), nil, 0600)
+}
+
+// restore puts the given paths and the version file back from the backup in
+// dir. Paths which didn't exist are removed.
+func restore(repoPath, dir string, paths []string) error {
+	for _, p := range withVersionFile(paths) {
+		dst := filepath.Join(repoPath, p)
+		if err := os.RemoveAll(dst); err != nil {
+			return err
+		}
+		src := filepath.Join(dir, p)
+		if _, err := os.Lstat(src); os.IsNotExist(err) {
+			continue
+		}
+		if err := copyPath(src, dst); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// copyPath copies the file or directory src to dst.
+func copyPath(src, dst string) error {
+	fi, err := os.Lstat(src)
+	if err != nil {
+		return err
+	}
+	if err := os.MkdirAll(filepath.Dir(dst), 0700); err != nil {
+		return err
+	}
+
+	if fi.IsDir() {
+		if err := os.Mkdir(dst, fi.Mode().Perm()); err != nil {
+			return err
+		}
+		entries, err := ioutil.ReadDir(src)
+		if err != nil {
+			return err
+		}
+		for _, e := range entries {
+			if err := copyPath(filepath.Join(src, e.Name()), filepath.Join(dst, e.Name())); err != nil {
+				return err
+			}
+		}
+		return nil
+	}
+
+	in, err := os.Open(src)
+	if err != nil {
+		return err
+	}
+	defer in.Close()
+	out, err := os.OpenFile(dst, os.O_WRONLY|os.O_CREATE|os.O_TRUNC, fi.Mode().Perm())
+	if err != nil {
+		return err
+	}
+	if _, err := io.Copy(out, in); err != nil {
+		out.Close()
+		return err
+	}
+	if err := out.Sync(); err != nil {
+		out.Close()
+		return err
+	}
+	return out.Close()
+}
@@ -5,6 +5,7 @@ import (
 
 	filestore "github.com/ipfs/go-filestore"
 	keystore "github.com/ipfs/go-ipfs/keystore"
+	"github.com/ipfs/go-ipfs/repo/common"
 
 	config "github.com/ipfs/go-ipfs-config"
 	ma "github.com/multiformats/go-multiaddr"
@@ -38,7 +39,11 @@ func (m *Mock) SetConfigKey(key string, value interface{}) error {
 }
 
 func (m *Mock) GetConfigKey(key string) (interface{}, error) {
-	return nil, errTODO
+	mapconf, err := config.ToMap(&m.C)
+	if err != nil {
+		return nil, err
+	}
+	return common.MapGetKV(mapconf, key)
 }
 
 func (m *Mock) Datastore() Datastore { return m.D }
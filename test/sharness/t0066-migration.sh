@@ -49,4 +49,26 @@ test_expect_success "output looks good" '
   grep "Please get fs-repo-migrations from https://dist.ipfs.io" daemon_out > /dev/null
 '
 
+test_expect_success "'ipfs repo migrate --offline' doesn't download migrations" '
+  test_must_fail ipfs repo migrate --offline > offline_out &&
+  grep "not downloading in offline mode" offline_out
+'
+
+test_expect_success "'ipfs repo migrate' runs local migration binaries" '
+  mkdir migrations-bin &&
+  for v in 3 4 5 6 7; do
+    echo "#!/bin/sh" > migrations-bin/fs-repo-$v-to-$((v+1)) &&
+    echo "echo $((v+1)) > \"\$2/version\"" >> migrations-bin/fs-repo-$v-to-$((v+1)) &&
+    chmod +x migrations-bin/fs-repo-$v-to-$((v+1)) || return 1
+  done &&
+  ipfs repo migrate --offline --bin-dir=migrations-bin > bin_out &&
+  grep "Running: .*fs-repo-7-to-8" bin_out
+'
+
+test_expect_success "built-in migrations ran after the binaries" '
+  grep "Migrating fs-repo from version 9 to 10" bin_out &&
+  echo 10 > version_expected &&
+  test_cmp version_expected "$IPFS_PATH"/version
+'
+
 test_done
@@ -290,6 +290,54 @@ test_expect_success "repo version came out correct" '
   egrep "^fs-repo@[0-9]+" repo-version-q >/dev/null
 '
 
+test_expect_success "'ipfs repo gc --incremental' keeps pinned content" '
+  echo "incremental" >ifile &&
+  IHASH=$(ipfs add -q ifile) &&
+  echo "incremental garbage" | ipfs add -q --pin=false >igarbage &&
+  ipfs repo gc --incremental --batch-size=1 >igc_out &&
+  grep "marked" igc_out &&
+  grep "removed $(cat igarbage)" igc_out &&
+  test_expect_code 1 grep "removed $IHASH" igc_out &&
+  ipfs cat "$IHASH" >iout &&
+  test_cmp ifile iout
+'
+
+test_expect_success "'ipfs repo gc --incremental --quiet' only lists removed blocks" '
+  echo "more incremental garbage" | ipfs add -q --pin=false >igarbage &&
+  ipfs repo gc --incremental -q >igc_out &&
+  test_cmp igarbage igc_out
+'
+
+test_expect_success "'ipfs repo gc --dry-run' lists garbage without removing it" '
+  echo "dry run garbage" | ipfs add -q --pin=false >dgarbage &&
+  ipfs repo gc --dry-run >dgc_out &&
+  grep "would remove $(cat dgarbage)" dgc_out &&
+  grep "would remove 1 blocks" dgc_out &&
+  ipfs block stat "$(cat dgarbage)"
+'
+
+test_expect_success "'ipfs repo gc --progress' summarizes what it removed" '
+  ipfs repo gc --progress >dgc_out &&
+  grep "removed $(cat dgarbage)" dgc_out &&
+  grep "removed 1 blocks" dgc_out &&
+  test_must_fail ipfs block stat "$(cat dgarbage)"
+'
+
+test_expect_success "'ipfs repo gc' summarizes what it removed" '
+  echo "plain garbage" | ipfs add -q --pin=false >pgarbage &&
+  ipfs repo gc >pgc_out &&
+  grep "removed $(cat pgarbage)" pgc_out &&
+  grep "removed 1 blocks" pgc_out &&
+  test_must_fail grep "scanned" pgc_out
+'
+
+test_expect_success "'ipfs repo gc --quiet' only lists what it removed" '
+  echo "quiet garbage" | ipfs add -q --pin=false >qgarbage &&
+  ipfs repo gc -q --enc=json >qgc_out &&
+  grep "\"Key\":{\"/\":\"$(cat qgarbage)\"}" qgc_out &&
+  test_must_fail grep "Summary" qgc_out
+'
+
 test_kill_ipfs_daemon
 
 test_expect_success "remove Datastore.StorageMax from config" '
@@ -164,6 +164,179 @@ test_pin_progress() {
   '
 }
 
+test_pin_expiry() {
+  test_expect_success "'ipfs pin add --expire-in' works" '
+    EXPIRING=`echo expiring | ipfs add -q --pin=false` &&
+    ipfs pin add --expire-in=72h $EXPIRING
+  '
+
+  test_expect_success "'ipfs pin ls' shows the expiry" '
+    ipfs pin ls --type=recursive $EXPIRING > expiry_out &&
+    grep -q "^$EXPIRING recursive (expires " expiry_out
+  '
+
+  test_expect_success "'ipfs pin add' without expiry makes the pin permanent" '
+    ipfs pin add $EXPIRING &&
+    echo "$EXPIRING recursive" > expiry_expected &&
+    ipfs pin ls --type=recursive $EXPIRING > expiry_out &&
+    test_cmp expiry_expected expiry_out
+  '
+
+  test_expect_success "'ipfs pin add --expire-in' rejects invalid durations" '
+    test_must_fail ipfs pin add --expire-in=-1h $EXPIRING &&
+    test_must_fail ipfs pin add --expire-in=soon $EXPIRING
+  '
+
+  test_expect_success "unpin the expiring hash" '
+    ipfs pin rm $EXPIRING
+  '
+}
+
+test_pin_names() {
+  test_expect_success "'ipfs pin add --name --label' works" '
+    NAMED=`echo named | ipfs add -q --pin=false` &&
+    OTHER=`echo other | ipfs add -q --pin=false` &&
+    ipfs pin add --name=site --label team=web --label env=prod $NAMED &&
+    ipfs pin add --name=blog --label team=web $OTHER
+  '
+
+  test_expect_success "'ipfs pin ls --name' filters by name" '
+    echo "$NAMED recursive site" > names_expected &&
+    ipfs pin ls --name=site > names_out &&
+    test_cmp names_expected names_out
+  '
+
+  test_expect_success "'ipfs pin ls --label' filters by labels" '
+    ipfs pin ls --label team=web > labels_out &&
+    test_line_count = 2 labels_out &&
+    ipfs pin ls --label team=web --label env=prod > labels_out &&
+    test_cmp names_expected labels_out
+  '
+
+  test_expect_success "'ipfs pin ls' shows the labels as json" '
+    ipfs pin ls --enc=json --name=site > names_json &&
+    grep -q "\"env\":\"prod\"" names_json
+  '
+
+  test_expect_success "'ipfs pin add --label' rejects invalid labels" '
+    test_must_fail ipfs pin add --label team $NAMED 2> err &&
+    grep -q "expected key=value" err
+  '
+
+  test_expect_success "unpin the named hashes" '
+    ipfs pin rm $NAMED $OTHER &&
+    ipfs pin ls --name=site > names_out &&
+    test_must_be_empty names_out
+  '
+}
+
+test_pin_background() {
+  test_expect_success "'ipfs pin add --background' queues the pin" '
+    QUEUED=`echo queued | ipfs add -q --pin=false` &&
+    echo "queued $QUEUED to be pinned recursively" > background_expected &&
+    ipfs pin add --background $QUEUED > background_out &&
+    test_cmp background_expected background_out
+  '
+
+  test_expect_success "'ipfs pin status' reports the pin once done" '
+    test_run_repeat_60_sec "ipfs pin status $QUEUED | grep -q \"^$QUEUED pinned \"" &&
+    ipfs pin ls --type=recursive $QUEUED
+  '
+
+  test_expect_success "'ipfs pin status' fails for hashes which were not queued" '
+    NOT_QUEUED=`echo not-queued | ipfs add -q --pin=false` &&
+    test_must_fail ipfs pin status $NOT_QUEUED 2> err &&
+    grep -q "not in the pin queue" err
+  '
+
+  test_expect_success "unpin the queued hash" '
+    ipfs pin rm $QUEUED
+  '
+}
+
+test_pin_index() {
+  test_expect_success "pins are indexed with Pinning.Index" '
+    mkdir -p indexed/sub &&
+    echo indexed-file > indexed/sub/file &&
+    INDEXED=`ipfs add -r -Q indexed` &&
+    INDEXED_FILE=`ipfs add -q -n indexed/sub/file` &&
+    ipfs pin ls --type=indirect > indirect_before &&
+    ipfs config --json Pinning.Index true &&
+    ipfs pin ls --type=recursive -q > recursive_out &&
+    grep -q $INDEXED recursive_out
+  '
+
+  test_expect_success "'ipfs pin ls --type=indirect' lists the indexed pins" '
+    ipfs pin ls --type=indirect > indirect_after &&
+    sort indirect_before > indirect_expected &&
+    sort indirect_after > indirect_out &&
+    test_cmp indirect_expected indirect_out
+  '
+
+  test_expect_success "'ipfs pin ls' explains indexed indirect pins" '
+    ipfs pin ls $INDEXED_FILE > why_out &&
+    grep -q "indirect through" why_out
+  '
+
+  test_expect_success "unpinning updates the index" '
+    ipfs pin rm $INDEXED &&
+    test_must_fail ipfs pin ls $INDEXED_FILE
+  '
+
+  test_expect_success "disabling Pinning.Index moves the pins back" '
+    ipfs pin add $INDEXED &&
+    ipfs config --json Pinning.Index false &&
+    ipfs pin ls --type=recursive -q > recursive_out &&
+    grep -q $INDEXED recursive_out &&
+    ipfs pin rm $INDEXED
+  '
+}
+
+test_pin_namespaces() {
+  test_expect_success "set the quota of a namespace" '
+    ipfs config --json Pinning.Namespaces "{\"team\": {\"StorageMax\": \"1KB\"}}"
+  '
+
+  test_expect_success "'ipfs add --namespace' accounts the pin to the namespace" '
+    SMALL=`echo small | ipfs add -q --namespace=team` &&
+    ipfs pin ls --label namespace=team -q > ns_out &&
+    echo $SMALL > ns_expected &&
+    test_cmp ns_expected ns_out
+  '
+
+  test_expect_success "'ipfs repo stat --namespace' reports the usage" '
+    ipfs repo stat --namespace=team > ns_stat &&
+    grep -q "NumPins: *1" ns_stat &&
+    grep -q "StorageMax: *1000" ns_stat
+  '
+
+  test_expect_success "'ipfs pin add --namespace' fails over quota" '
+    random 5000 42 > large &&
+    LARGE=`ipfs add -q --pin=false large` &&
+    test_must_fail ipfs pin add --namespace=team $LARGE 2> ns_err &&
+    grep -q "quota exceeded for namespace \"team\"" ns_err &&
+    test_must_fail ipfs pin ls $LARGE
+  '
+
+  test_expect_success "'ipfs add --namespace' fails over quota" '
+    test_must_fail ipfs add -q --namespace=team large 2> ns_err &&
+    grep -q "quota exceeded" ns_err &&
+    test_must_fail ipfs pin ls $LARGE
+  '
+
+  test_expect_success "'ipfs add --namespace' requires pinning" '
+    test_must_fail ipfs add -q --namespace=team --pin=false large 2> ns_err &&
+    grep -q "can.t be used with --pin=false" ns_err
+  '
+
+  test_expect_success "unpinning frees the quota" '
+    ipfs pin rm $SMALL &&
+    ipfs repo stat --namespace=team > ns_stat &&
+    grep -q "RepoSize: *0" ns_stat &&
+    ipfs config --json Pinning.Namespaces "{}"
+  '
+}
+
 test_init_ipfs
 
 test_pins '' '' ''
@@ -180,6 +353,14 @@ test_pin_dag --raw-leaves
 
 test_pin_progress
 
+test_pin_expiry
+
+test_pin_names
+
+test_pin_index
+
+test_pin_namespaces
+
 test_launch_ipfs_daemon --offline
 
 test_pins '' '' ''
@@ -196,6 +377,12 @@ test_pin_dag --raw-leaves
 
 test_pin_progress
 
+test_pin_expiry
+
+test_pin_names
+
+test_pin_background
+
 test_kill_ipfs_daemon
 
 test_done